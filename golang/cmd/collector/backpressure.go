@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"observability_hub/golang/internal/collector/config"
+	"observability_hub/golang/internal/collector/metrics"
+	"observability_hub/golang/internal/collector/storage"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// backpressureCheckInterval is how often the storage state is sampled.
+const backpressureCheckInterval = time.Second
+
+// backpressure pauses the workers' intake while storage cannot keep up:
+// the batch buffer stayed above the high watermark, or flushes stopped
+// succeeding, for the sustain period. Paused workers neither take nor ack
+// deliveries, so once the prefetch window (RABBITMQ_PREFETCH) is used up
+// the broker stops delivering and the queue grows instead of the
+// collector's memory. Intake resumes below the low watermark.
+//
+// This assumes producers react to a growing queue: publisher confirms that
+// slow down when confirms lag, a queue length limit with reject-publish,
+// or RabbitMQ's memory/flow control. Producers that publish fire-and-forget
+// into an unbounded queue are not slowed, only buffered by the broker.
+type backpressure struct {
+	cfg    *config.Config
+	store  storage.EventStore
+	logger *zap.Logger
+	on     atomic.Bool
+}
+
+// active reports whether intake is paused.
+func (b *backpressure) active() bool {
+	return b.on.Load()
+}
+
+// run samples the storage state until ctx is cancelled.
+func (b *backpressure) run(ctx context.Context) {
+	ticker := time.NewTicker(backpressureCheckInterval)
+	defer ticker.Stop()
+
+	var overSince time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			b.on.Store(false)
+			return
+		case now := <-ticker.C:
+			fill := float64(b.store.BufferDepth()) / float64(b.store.BufferCapacity())
+			flushErr := b.store.FlushHealth()
+			metrics.BufferFillRatio.Set(fill)
+
+			over := fill >= b.cfg.BackpressureHighWatermark || flushErr != nil
+			if !over {
+				overSince = time.Time{}
+			} else if overSince.IsZero() {
+				overSince = now
+			}
+
+			switch {
+			case !b.on.Load() && over && now.Sub(overSince) >= b.cfg.BackpressureSustain:
+				b.on.Store(true)
+				metrics.Backpressure.Set(1)
+				metrics.BackpressureActivations.Inc()
+				b.logger.Warn("Backpressure on: pausing intake",
+					zap.Float64("buffer_fill", fill),
+					zap.NamedError("flush_error", flushErr))
+			case b.on.Load() && fill <= b.cfg.BackpressureLowWatermark && flushErr == nil:
+				b.on.Store(false)
+				metrics.Backpressure.Set(0)
+				b.logger.Info("Backpressure off: resuming intake", zap.Float64("buffer_fill", fill))
+			}
+		}
+	}
+}
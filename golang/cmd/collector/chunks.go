@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"observability_hub/golang/internal/collector/config"
+	"observability_hub/golang/internal/collector/metrics"
+	"strconv"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.uber.org/zap"
+)
+
+// Headers of a chunked message. The bodies of all chunks of a group,
+// concatenated in index order, form the body of one message.
+const (
+	chunkGroupHeader = "chunk-group" // Identifies the group
+	chunkIndexHeader = "chunk-index" // Zero-based position of the chunk
+	chunkCountHeader = "chunk-count" // Number of chunks in the group
+)
+
+// maxChunksPerGroup bounds the chunk count a producer may announce.
+const maxChunksPerGroup = 1024
+
+// chunkAssembler reassembles chunked messages. Chunks of one group may
+// reach different workers, so the assembler is shared. The chunks stay
+// unacknowledged until the reassembled event is stored; groups that stay
+// incomplete past the timeout, or would exceed the memory bounds, are
+// rejected to the DLQ. Chunks count against the prefetch limit while
+// buffered, so RABBITMQ_PREFETCH must leave room for complete groups.
+type chunkAssembler struct {
+	cfg    *config.Config
+	logger *zap.Logger
+
+	mu     sync.Mutex
+	groups map[string]*chunkGroup
+	size   int // Bytes buffered over all groups
+}
+
+// chunkGroup is a group waiting for its remaining chunks.
+type chunkGroup struct {
+	bodies     [][]byte
+	deliveries []amqp.Delivery
+	received   int
+	size       int
+	started    time.Time
+}
+
+func newChunkAssembler(cfg *config.Config, logger *zap.Logger) *chunkAssembler {
+	return &chunkAssembler{
+		cfg:    cfg,
+		logger: logger,
+		groups: make(map[string]*chunkGroup),
+	}
+}
+
+// isChunk reports whether a delivery is part of a chunked message.
+func isChunk(d amqp.Delivery) bool {
+	_, ok := d.Headers[chunkGroupHeader]
+	return ok
+}
+
+// add buffers a chunk. Once its group is complete it returns the
+// reassembled delivery, which carries the first chunk's properties and
+// acknowledges it, together with the deliveries of the other chunks. Both
+// are nil while the group is incomplete. On error the returned deliveries,
+// including d, must be rejected.
+func (a *chunkAssembler) add(d amqp.Delivery) (*amqp.Delivery, []amqp.Delivery, error) {
+	id, _ := d.Headers[chunkGroupHeader].(string)
+	index, okIndex := headerInt(d.Headers[chunkIndexHeader])
+	count, okCount := headerInt(d.Headers[chunkCountHeader])
+	if id == "" || !okIndex || !okCount || count < 1 || count > maxChunksPerGroup || index < 0 || index >= count {
+		metrics.ChunkGroups.WithLabelValues("rejected").Inc()
+		return nil, []amqp.Delivery{d}, fmt.Errorf("invalid chunk headers (group %q, index %v, count %v)",
+			id, d.Headers[chunkIndexHeader], d.Headers[chunkCountHeader])
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	group, ok := a.groups[id]
+	if !ok {
+		group = &chunkGroup{bodies: make([][]byte, count), started: time.Now()}
+		a.groups[id] = group
+	}
+	if len(group.bodies) != count {
+		return nil, a.reject(id, d), fmt.Errorf("chunk count of group %q changed from %d to %d", id, len(group.bodies), count)
+	}
+	if group.bodies[index] != nil {
+		// A redelivered chunk; the buffered copy is kept
+		group.deliveries = append(group.deliveries, d)
+		return nil, nil, nil
+	}
+	if group.size+len(d.Body) > a.cfg.ChunkMaxGroupBytes {
+		return nil, a.reject(id, d), fmt.Errorf("group %q exceeds %d bytes", id, a.cfg.ChunkMaxGroupBytes)
+	}
+	if a.size+len(d.Body) > a.cfg.ChunkMaxBufferBytes {
+		return nil, a.reject(id, d), fmt.Errorf("chunk buffer is full (%d bytes)", a.cfg.ChunkMaxBufferBytes)
+	}
+
+	group.bodies[index] = append([]byte{}, d.Body...) // non-nil even when empty
+	group.deliveries = append(group.deliveries, d)
+	group.received++
+	group.size += len(d.Body)
+	a.size += len(d.Body)
+	metrics.ChunkBufferBytes.Set(float64(a.size))
+	if group.received < count {
+		return nil, nil, nil
+	}
+
+	delete(a.groups, id)
+	a.size -= group.size
+	metrics.ChunkBufferBytes.Set(float64(a.size))
+	metrics.ChunkGroups.WithLabelValues("assembled").Inc()
+
+	// The first chunk's delivery stands for the whole message
+	var first amqp.Delivery
+	found := false
+	rest := make([]amqp.Delivery, 0, len(group.deliveries)-1)
+	for _, part := range group.deliveries {
+		if i, _ := headerInt(part.Headers[chunkIndexHeader]); i == 0 && !found {
+			first, found = part, true
+			continue
+		}
+		rest = append(rest, part)
+	}
+	first.Body = bytes.Join(group.bodies, nil)
+	return &first, rest, nil
+}
+
+// reject drops a group and returns its deliveries plus d. The caller must
+// hold the lock.
+func (a *chunkAssembler) reject(id string, d amqp.Delivery) []amqp.Delivery {
+	metrics.ChunkGroups.WithLabelValues("rejected").Inc()
+	group, ok := a.groups[id]
+	if !ok {
+		return []amqp.Delivery{d}
+	}
+	delete(a.groups, id)
+	a.size -= group.size
+	metrics.ChunkBufferBytes.Set(float64(a.size))
+	return append(group.deliveries, d)
+}
+
+// run rejects the groups that stayed incomplete for longer than the chunk
+// timeout, until ctx is cancelled.
+func (a *chunkAssembler) run(ctx context.Context) {
+	ticker := time.NewTicker(max(a.cfg.ChunkTimeout/2, time.Second))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for id, deliveries := range a.expire(time.Now()) {
+				a.logger.Warn("Chunk group timed out incomplete", zap.String("group", id), zap.Int("chunks", len(deliveries)))
+				for _, d := range deliveries {
+					if !a.cfg.AutoAck {
+						d.Nack(false, false)
+						metrics.MessagesNacked.Inc()
+					}
+				}
+			}
+		}
+	}
+}
+
+// expire removes the groups started before now minus the timeout and
+// returns their deliveries.
+func (a *chunkAssembler) expire(now time.Time) map[string][]amqp.Delivery {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	expired := make(map[string][]amqp.Delivery)
+	for id, group := range a.groups {
+		if now.Sub(group.started) < a.cfg.ChunkTimeout {
+			continue
+		}
+		expired[id] = group.deliveries
+		delete(a.groups, id)
+		a.size -= group.size
+		metrics.ChunkGroups.WithLabelValues("expired").Inc()
+	}
+	metrics.ChunkBufferBytes.Set(float64(a.size))
+	return expired
+}
+
+// headerInt converts an AMQP header value to an int.
+func headerInt(value interface{}) (int, bool) {
+	switch v := value.(type) {
+	case int:
+		return v, true
+	case int8:
+		return int(v), true
+	case int16:
+		return int(v), true
+	case int32:
+		return int(v), true
+	case int64:
+		return int(v), true
+	case uint8:
+		return int(v), true
+	case uint16:
+		return int(v), true
+	case uint32:
+		return int(v), true
+	case string:
+		n, err := strconv.Atoi(v)
+		return n, err == nil
+	}
+	return 0, false
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"observability_hub/golang/internal/collector/storage"
+	"observability_hub/golang/internal/types"
+	"strings"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// cloudEventsContentType marks a delivery carrying a structured-mode CloudEvent.
+const cloudEventsContentType = "application/cloudevents+json"
+
+// cloudEventsHeaderPrefix prefixes the CloudEvents attributes of a
+// binary-mode event in the AMQP application properties.
+const cloudEventsHeaderPrefix = "cloudEvents:"
+
+// cloudEvent is a structured-mode CloudEvents envelope. The LogEvent is in
+// data, or base64-encoded in data_base64.
+type cloudEvent struct {
+	SpecVersion string          `json:"specversion"`
+	ID          string          `json:"id"`
+	Type        string          `json:"type"`
+	Source      string          `json:"source"`
+	Time        *time.Time      `json:"time,omitempty"`
+	Data        json.RawMessage `json:"data,omitempty"`
+	DataBase64  []byte          `json:"data_base64,omitempty"`
+}
+
+// check reports the required CloudEvents attributes the envelope lacks.
+func (e cloudEvent) check() error {
+	var missing []string
+	for _, attr := range []struct{ name, value string }{
+		{"specversion", e.SpecVersion},
+		{"id", e.ID},
+		{"source", e.Source},
+		{"type", e.Type},
+	} {
+		if attr.value == "" {
+			missing = append(missing, attr.name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("CloudEvent lacks required attributes: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// decodeEvent decodes the LogEvent carried by a delivery. Plain deliveries
+// hold the event JSON; CloudEvents in structured or binary mode are
+// unwrapped first. Events of at least streamAbove bytes are decoded in
+// streaming mode; 0 disables it.
+func decodeEvent(d amqp.Delivery, streamAbove int) (*storage.LogEvent, error) {
+	if strings.HasPrefix(d.ContentType, cloudEventsContentType) {
+		return decodeStructuredCloudEvent(d.Body, streamAbove)
+	}
+	if _, ok := d.Headers[cloudEventsHeaderPrefix+"specversion"]; ok {
+		return decodeBinaryCloudEvent(d.Headers, d.Body, streamAbove)
+	}
+	return unmarshalEvent(d.Body, streamAbove)
+}
+
+// decodeMetricsEvent decodes the delivery if it carries a metrics event,
+// plain or as a CloudEvent. It reports false for other deliveries, which
+// are left to decodeEvent; so are those it cannot make sense of, for
+// decodeEvent to report.
+func decodeMetricsEvent(d amqp.Delivery) (*types.MetricsEvent, bool, error) {
+	data := d.Body
+	var envelope *cloudEvent
+	if strings.HasPrefix(d.ContentType, cloudEventsContentType) {
+		envelope = &cloudEvent{}
+		if err := json.Unmarshal(d.Body, envelope); err != nil || envelope.check() != nil {
+			return nil, false, nil
+		}
+		data = []byte(envelope.Data)
+		if len(data) == 0 {
+			data = envelope.DataBase64
+		}
+	} else if _, ok := d.Headers[cloudEventsHeaderPrefix+"specversion"]; ok {
+		binary, err := binaryCloudEventEnvelope(d.Headers)
+		if err != nil {
+			return nil, false, nil
+		}
+		envelope = &binary
+	}
+
+	var head struct {
+		EventType string `json:"eventType"`
+	}
+	if err := json.Unmarshal(data, &head); err != nil {
+		return nil, false, nil
+	}
+	eventType := head.EventType
+	if eventType == "" && envelope != nil {
+		eventType = envelope.Type
+	}
+	if !types.IsMetricsEventType(eventType) {
+		return nil, false, nil
+	}
+
+	var event types.MetricsEvent
+	if err := json.Unmarshal(data, &event); err != nil {
+		return nil, true, fmt.Errorf("failed to decode metrics event: %w", err)
+	}
+	if envelope != nil {
+		applyMetricsCloudEventAttributes(&event, *envelope)
+	}
+	return &event, true, nil
+}
+
+// unmarshalEvent decodes event JSON, in streaming mode from streamAbove
+// bytes on.
+func unmarshalEvent(data []byte, streamAbove int) (*storage.LogEvent, error) {
+	if streamAbove > 0 && len(data) >= streamAbove {
+		return streamEvent(data)
+	}
+	var event storage.LogEvent
+	if err := json.Unmarshal(data, &event); err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
+
+func decodeStructuredCloudEvent(body []byte, streamAbove int) (*storage.LogEvent, error) {
+	var envelope cloudEvent
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return nil, fmt.Errorf("failed to decode CloudEvents envelope: %w", err)
+	}
+	if err := envelope.check(); err != nil {
+		return nil, err
+	}
+
+	data := []byte(envelope.Data)
+	if len(data) == 0 {
+		data = envelope.DataBase64
+	}
+	if len(data) == 0 {
+		return nil, errors.New("CloudEvent has no data")
+	}
+
+	event, err := unmarshalEvent(data, streamAbove)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode CloudEvent data: %w", err)
+	}
+	applyCloudEventAttributes(event, envelope)
+	return event, nil
+}
+
+func decodeBinaryCloudEvent(headers amqp.Table, body []byte, streamAbove int) (*storage.LogEvent, error) {
+	envelope, err := binaryCloudEventEnvelope(headers)
+	if err != nil {
+		return nil, err
+	}
+
+	event, err := unmarshalEvent(body, streamAbove)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode CloudEvent data: %w", err)
+	}
+	applyCloudEventAttributes(event, envelope)
+	return event, nil
+}
+
+// binaryCloudEventEnvelope reads the CloudEvents attributes of a
+// binary-mode event from the AMQP application properties. A message
+// missing any of the required attributes is not a CloudEvent.
+func binaryCloudEventEnvelope(headers amqp.Table) (cloudEvent, error) {
+	attr := func(name string) string {
+		value, _ := headers[cloudEventsHeaderPrefix+name].(string)
+		return value
+	}
+
+	envelope := cloudEvent{
+		SpecVersion: attr("specversion"),
+		ID:          attr("id"),
+		Type:        attr("type"),
+		Source:      attr("source"),
+	}
+	switch t := headers[cloudEventsHeaderPrefix+"time"].(type) {
+	case time.Time:
+		envelope.Time = &t
+	case string:
+		parsed, err := time.Parse(time.RFC3339Nano, t)
+		if err != nil {
+			return cloudEvent{}, fmt.Errorf("invalid CloudEvents time attribute: %w", err)
+		}
+		envelope.Time = &parsed
+	}
+	return envelope, envelope.check()
+}
+
+// applyCloudEventAttributes fills the event fields the producer left empty
+// from the CloudEvents attributes. Fields set in the inner event win.
+func applyCloudEventAttributes(event *storage.LogEvent, envelope cloudEvent) {
+	if event.EventID == "" {
+		event.EventID = envelope.ID
+	}
+	if event.EventType == "" {
+		event.EventType = envelope.Type
+	}
+	if event.Source.Service == "" {
+		event.Source.Service = envelope.Source
+	}
+	if event.Timestamp.IsZero() && envelope.Time != nil {
+		event.Timestamp = *envelope.Time
+	}
+}
+
+// applyMetricsCloudEventAttributes is applyCloudEventAttributes for a
+// metrics event.
+func applyMetricsCloudEventAttributes(event *types.MetricsEvent, envelope cloudEvent) {
+	if event.EventID == "" {
+		event.EventID = envelope.ID
+	}
+	if event.EventType == "" {
+		event.EventType = envelope.Type
+	}
+	if event.Source.Service == "" {
+		event.Source.Service = envelope.Source
+	}
+	if event.Timestamp.IsZero() && envelope.Time != nil {
+		event.Timestamp = *envelope.Time
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"observability_hub/golang/internal/types"
+
+	"github.com/google/uuid"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// structuredCloudEvent returns a structured-mode CloudEvent carrying data,
+// with the attributes of attrs.
+func structuredCloudEvent(t *testing.T, attrs map[string]any, data any) amqp.Delivery {
+	t.Helper()
+	envelope := map[string]any{}
+	for name, value := range attrs {
+		envelope[name] = value
+	}
+	if data != nil {
+		envelope["data"] = data
+	}
+	d := delivery(t, nil, 1, envelope)
+	d.ContentType = cloudEventsContentType
+	return d
+}
+
+// binaryCloudEvent returns a binary-mode CloudEvent carrying data, with the
+// attributes of attrs as application properties.
+func binaryCloudEvent(t *testing.T, attrs map[string]any, data any) amqp.Delivery {
+	t.Helper()
+	d := delivery(t, nil, 1, data)
+	d.Headers = amqp.Table{}
+	for name, value := range attrs {
+		d.Headers[cloudEventsHeaderPrefix+name] = value
+	}
+	return d
+}
+
+// cloudEventAttributes returns the required attributes of a CloudEvent.
+func cloudEventAttributes() map[string]any {
+	return map[string]any{
+		"specversion": "1.0",
+		"id":          uuid.NewString(),
+		"type":        "log.message.created",
+		"source":      "inventory",
+	}
+}
+
+// bareLogEvent returns a log event without the fields CloudEvents
+// attributes stand in for.
+func bareLogEvent() map[string]any {
+	doc := logEventDoc()
+	for _, field := range []string{"eventId", "eventType", "timestamp"} {
+		delete(doc, field)
+	}
+	doc["source"] = map[string]any{"version": "1.2.3"}
+	return doc
+}
+
+func TestDecodeCloudEvent(t *testing.T) {
+	at := time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+	tests := []struct {
+		name     string
+		delivery func(t *testing.T, attrs map[string]any, data any) amqp.Delivery
+		time     any
+	}{
+		{"structured", structuredCloudEvent, at.Format(time.RFC3339Nano)},
+		{"binary with a string time", binaryCloudEvent, at.Format(time.RFC3339Nano)},
+		{"binary with a timestamp", binaryCloudEvent, at},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attrs := cloudEventAttributes()
+			attrs["time"] = tt.time
+
+			// The attributes fill in the fields the inner event lacks
+			event, err := decodeEvent(tt.delivery(t, attrs, bareLogEvent()), 0)
+			if err != nil {
+				t.Fatalf("decodeEvent: %v", err)
+			}
+			if event.EventID != attrs["id"] || event.EventType != "log.message.created" || event.Source.Service != "inventory" || !event.Timestamp.Equal(at) {
+				t.Errorf("decoded %s %s from %s at %s, want the CloudEvents attributes", event.EventID, event.EventType, event.Source.Service, event.Timestamp)
+			}
+			if event.Data.Message != "Order placed" {
+				t.Errorf("message = %q, want the inner event's", event.Data.Message)
+			}
+
+			// Those set in the inner event win
+			inner := logEventDoc()
+			event, err = decodeEvent(tt.delivery(t, attrs, inner), 0)
+			if err != nil {
+				t.Fatalf("decodeEvent: %v", err)
+			}
+			if event.EventID != inner["eventId"] || event.Source.Service != "checkout" || event.Timestamp.Equal(at) {
+				t.Errorf("decoded %s from %s at %s, want the inner event's fields", event.EventID, event.Source.Service, event.Timestamp)
+			}
+		})
+	}
+}
+
+func TestDecodeStructuredCloudEventBase64(t *testing.T) {
+	body, err := json.Marshal(bareLogEvent())
+	if err != nil {
+		t.Fatal(err)
+	}
+	attrs := cloudEventAttributes()
+	attrs["data_base64"] = base64.StdEncoding.EncodeToString(body)
+	event, err := decodeEvent(structuredCloudEvent(t, attrs, nil), 0)
+	if err != nil {
+		t.Fatalf("decodeEvent: %v", err)
+	}
+	if event.EventID != attrs["id"] || event.Data.Message != "Order placed" {
+		t.Errorf("decoded %s with message %q", event.EventID, event.Data.Message)
+	}
+}
+
+func TestDecodeCloudEventErrors(t *testing.T) {
+	without := func(name string) map[string]any {
+		attrs := cloudEventAttributes()
+		delete(attrs, name)
+		return attrs
+	}
+	withTime := cloudEventAttributes()
+	withTime["time"] = "yesterday"
+
+	tests := []struct {
+		name     string
+		delivery amqp.Delivery
+		want     string
+	}{
+		{"structured without data", structuredCloudEvent(t, cloudEventAttributes(), nil), "no data"},
+		{"structured without an id", structuredCloudEvent(t, without("id"), bareLogEvent()), "id"},
+		{"structured without a type", structuredCloudEvent(t, without("type"), bareLogEvent()), "type"},
+		{"structured with malformed data", structuredCloudEvent(t, cloudEventAttributes(), "not an event"), "data"},
+		{"binary without an id", binaryCloudEvent(t, without("id"), bareLogEvent()), "id"},
+		{"binary without a source", binaryCloudEvent(t, without("source"), bareLogEvent()), "source"},
+		{"binary without a type", binaryCloudEvent(t, without("type"), bareLogEvent()), "type"},
+		{"binary with only a specversion", binaryCloudEvent(t, map[string]any{"specversion": "1.0"}, bareLogEvent()), "id, source, type"},
+		{"binary with a malformed time", binaryCloudEvent(t, withTime, bareLogEvent()), "time"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decodeEvent(tt.delivery, 0)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("decodeEvent error = %v, want one naming %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleCloudEvents(t *testing.T) {
+	ack := &fakeAcknowledger{}
+	store := &fakeStore{}
+	w := newTestWorker(testConfig(t), store)
+
+	deliveries := []amqp.Delivery{
+		structuredCloudEvent(t, cloudEventAttributes(), bareLogEvent()),
+		binaryCloudEvent(t, cloudEventAttributes(), bareLogEvent()),
+		// Missing ce- headers leave no event ID, type or service
+		binaryCloudEvent(t, map[string]any{"specversion": "1.0"}, bareLogEvent()),
+	}
+	for i := range deliveries {
+		deliveries[i].Acknowledger, deliveries[i].DeliveryTag = ack, uint64(i+1)
+		w.handle(deliveries[i])
+	}
+	w.flush(context.Background())
+
+	for tag, want := range map[uint64]string{1: "ack", 2: "ack", 3: "dead-letter"} {
+		if got := ack.settled(tag); got != want {
+			t.Errorf("delivery %d settled as %q, want %q", tag, got, want)
+		}
+	}
+	if stored := store.stored(); len(stored) != 2 || stored[0].Source.Service != "inventory" || stored[1].Source.Service != "inventory" {
+		t.Errorf("stored %v, want both CloudEvents", stored)
+	}
+}
+
+func TestDecodeMetricsCloudEvent(t *testing.T) {
+	event := newMetricsEvent(types.MetricTypeGauge, metricsEventCases[1].valid)
+	body, err := json.Marshal(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var inner map[string]any
+	if err := json.Unmarshal(body, &inner); err != nil {
+		t.Fatal(err)
+	}
+	delete(inner, "eventId")
+	delete(inner, "eventType")
+
+	attrs := cloudEventAttributes()
+	attrs["type"] = "metrics.gauge.updated"
+	for _, d := range []amqp.Delivery{structuredCloudEvent(t, attrs, inner), binaryCloudEvent(t, attrs, inner)} {
+		decoded, ok, err := decodeMetricsEvent(d)
+		if !ok || err != nil {
+			t.Fatalf("decodeMetricsEvent = %t, %v, want a metrics event", ok, err)
+		}
+		if decoded.EventID != attrs["id"] || decoded.EventType != "metrics.gauge.updated" || *decoded.Data.Value != -3.5 {
+			t.Errorf("decoded %s %s with value %v", decoded.EventID, decoded.EventType, decoded.Data.Value)
+		}
+	}
+
+	// Without its required attributes it is left to decodeEvent, which
+	// rejects it
+	delete(attrs, "id")
+	if _, ok, _ := decodeMetricsEvent(binaryCloudEvent(t, attrs, inner)); ok {
+		t.Error("decodeMetricsEvent took a CloudEvent without an id")
+	}
+}
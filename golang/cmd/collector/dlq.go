@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"observability_hub/golang/internal/collector/consumer"
+	"observability_hub/golang/internal/collector/metrics"
+	"observability_hub/golang/internal/collector/storage"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.uber.org/zap"
+)
+
+// dlqReprocessor retries dead-lettered messages on a slow loop with the
+// current decoding and checks, so that messages rejected by a since-fixed
+// bug drain into storage on their own. Messages that still fail go back
+// to the tail of the DLQ. Every pass covers the messages present when it
+// started, at a bounded rate, followed by a pause.
+//
+// With an archive, messages that are not reprocessed (all of them when
+// reprocessing is off) are stored in the dead letter table and leave the
+// DLQ, unless the configuration keeps them; kept messages are marked so
+// that later passes do not archive them again.
+type dlqReprocessor struct {
+	reader       *consumer.DLQReader
+	worker       *worker
+	archive      *storage.DeadLetterArchive
+	reprocessing bool
+	logger       *zap.Logger
+	rate         float64
+	interval     time.Duration
+}
+
+// archivedHeader marks dead letters that were archived and kept in the DLQ.
+const archivedHeader = "x-collector-archived"
+
+// run reprocesses the DLQ until ctx is cancelled.
+func (p *dlqReprocessor) run(ctx context.Context) {
+	p.logger.Info("DLQ reprocessor started", zap.Float64("rate", p.rate), zap.Duration("interval", p.interval))
+	for {
+		p.pass(ctx)
+		select {
+		case <-ctx.Done():
+			p.logger.Info("DLQ reprocessor stopped")
+			return
+		case <-time.After(p.interval):
+		}
+	}
+}
+
+// pass makes one rate-limited pass over the messages in the DLQ.
+func (p *dlqReprocessor) pass(ctx context.Context) {
+	depth, err := p.reader.Depth()
+	if err != nil {
+		p.logger.Warn("Failed to inspect DLQ", zap.Error(err))
+		return
+	}
+	if depth == 0 {
+		return
+	}
+
+	limiter := time.NewTicker(time.Duration(float64(time.Second) / p.rate))
+	defer limiter.Stop()
+
+	var reprocessed, failed int
+	for i := 0; i < depth; i++ {
+		select {
+		case <-ctx.Done():
+			return
+		case <-limiter.C:
+		}
+
+		d, ok, err := p.reader.Get()
+		if err != nil {
+			p.logger.Warn("Failed to fetch from DLQ", zap.Error(err))
+			return
+		}
+		if !ok {
+			break
+		}
+		if p.reprocess(ctx, d) {
+			reprocessed++
+		} else {
+			failed++
+		}
+	}
+	p.logger.Info("DLQ reprocessing pass completed",
+		zap.Int("depth", depth),
+		zap.Int("reprocessed", reprocessed),
+		zap.Int("failed", failed))
+}
+
+// reprocess stores a dead-lettered message if it now passes, or moves it
+// to the tail of the DLQ.
+func (p *dlqReprocessor) reprocess(ctx context.Context, d amqp.Delivery) bool {
+	if !p.reprocessing {
+		return p.archiveLetter(ctx, d)
+	}
+	event, ok := p.worker.prepare(d)
+	if ok {
+		if err := p.worker.store.AddToBatch(event); err != nil {
+			p.logger.Warn("Failed to enqueue reprocessed event", zap.Error(err), zap.String("eventId", event.EventID))
+			ok = false
+		}
+	}
+	if !ok {
+		metrics.DLQReprocessed.WithLabelValues("failure").Inc()
+		if p.archive != nil {
+			p.archiveLetter(ctx, d)
+			return false
+		}
+		if err := p.reader.Requeue(ctx, d); err != nil {
+			p.logger.Warn("Failed to return message to the DLQ", zap.Error(err))
+		}
+		return false
+	}
+
+	if p.worker.es != nil && !p.worker.cfg.OutboxEnabled {
+		e := *event
+		if err := p.worker.es.Write(ctx, []*storage.LogEvent{&e}); err != nil {
+			p.logger.Error("Failed to index reprocessed event to Elasticsearch", zap.Error(err), zap.String("eventId", e.EventID))
+		}
+	}
+	d.Ack(false)
+	metrics.DLQReprocessed.WithLabelValues("success").Inc()
+	return true
+}
+
+// archiveLetter stores a dead letter in the archive and acknowledges it,
+// or moves it to the tail of the DLQ marked as archived when archived
+// messages are kept. Messages already archived, or failing to archive,
+// return to the tail of the DLQ. It reports whether the message was
+// archived.
+func (p *dlqReprocessor) archiveLetter(ctx context.Context, d amqp.Delivery) bool {
+	if archived, _ := d.Headers[archivedHeader].(bool); archived {
+		if err := p.reader.Requeue(ctx, d); err != nil {
+			p.logger.Warn("Failed to return message to the DLQ", zap.Error(err))
+		}
+		return false
+	}
+
+	if err := p.archive.Store(ctx, deadLetter(d)); err != nil {
+		metrics.DLQArchived.WithLabelValues("error").Inc()
+		p.logger.Warn("Failed to archive dead letter", zap.Error(err), zap.String("messageId", d.MessageId))
+		if err := p.reader.Requeue(ctx, d); err != nil {
+			p.logger.Warn("Failed to return message to the DLQ", zap.Error(err))
+		}
+		return false
+	}
+	metrics.DLQArchived.WithLabelValues("archived").Inc()
+
+	if !p.worker.cfg.DLQArchiveKeep {
+		d.Ack(false)
+		return true
+	}
+	headers := make(amqp.Table, len(d.Headers)+1)
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+	headers[archivedHeader] = true
+	d.Headers = headers
+	if err := p.reader.Requeue(ctx, d); err != nil {
+		// Left unmarked in place; it is archived again on the next pass
+		p.logger.Warn("Failed to return archived message to the DLQ", zap.Error(err))
+	}
+	return true
+}
+
+// deadLetter describes a delivery from the DLQ, with the origin and reason
+// RabbitMQ recorded when dead-lettering it.
+func deadLetter(d amqp.Delivery) *storage.DeadLetter {
+	letter := &storage.DeadLetter{
+		Exchange:      d.Exchange,
+		RoutingKey:    d.RoutingKey,
+		MessageID:     d.MessageId,
+		CorrelationID: d.CorrelationId,
+		Headers:       d.Headers,
+		Body:          d.Body,
+	}
+	if exchange, ok := d.Headers["x-first-death-exchange"].(string); ok {
+		letter.Exchange = exchange
+	}
+	letter.Reason, _ = d.Headers["x-first-death-reason"].(string)
+	if deaths, ok := d.Headers["x-death"].([]interface{}); ok && len(deaths) > 0 {
+		if death, ok := deaths[len(deaths)-1].(amqp.Table); ok {
+			// The oldest death comes last
+			letter.DeadLetteredAt, _ = death["time"].(time.Time)
+			if letter.Reason == "" {
+				letter.Reason, _ = death["reason"].(string)
+			}
+		}
+	}
+	return letter
+}
@@ -0,0 +1,374 @@
+//go:build integration
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"observability_hub/golang/internal/collector/storage"
+	"observability_hub/golang/internal/integration"
+
+	"github.com/google/uuid"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// collectorProcess is a collector binary running against the integration
+// containers.
+type collectorProcess struct {
+	cfg        map[string]string // Its settings, by environment variable
+	metricsURL string
+	db         *sql.DB
+	amqp       *amqp.Connection
+}
+
+// buildCollector builds the collector binary once per test binary.
+var buildCollector = sync.OnceValues(func() (string, error) {
+	dir, err := os.MkdirTemp("", "collector")
+	if err != nil {
+		return "", err
+	}
+	bin := filepath.Join(dir, "collector")
+	if out, err := exec.Command("go", "build", "-o", bin, ".").CombinedOutput(); err != nil {
+		return "", fmt.Errorf("go build: %v\n%s", err, out)
+	}
+	return bin, nil
+})
+
+// freePort returns a TCP port nothing listens on.
+func freePort(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	return port
+}
+
+// startCollector runs the collector on a new database, with queues and
+// Redis keys of its own, and settings overriding the defaults. It returns
+// once the collector consumes the queue, and stops it when the test ends,
+// failing the test unless it shuts down cleanly.
+func startCollector(t *testing.T, settings map[string]string) *collectorProcess {
+	t.Helper()
+	bin, err := buildCollector()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := uuid.NewString()[:8]
+	p := &collectorProcess{cfg: map[string]string{
+		"RABBITMQ_URL":            integration.RabbitMQ(t),
+		"POSTGRES_URL":            integration.Postgres(t),
+		"REDIS_URL":               integration.Redis(t),
+		"STORAGE_BACKEND":         "postgres",
+		"POSTGRES_AUTO_MIGRATE":   "true",
+		"RABBITMQ_EXCHANGE":       "logs.topic." + id,
+		"RABBITMQ_QUEUE_NAME":     "logs.collector." + id,
+		"RABBITMQ_DLX_NAME":       "dlx.logs." + id,
+		"RABBITMQ_DLQ_NAME":       "dlq.logs." + id,
+		"REDIS_KEY_PREFIX":        id,
+		"METRICS_PORT":            freePort(t),
+		"HEALTH_CHECK_PORT":       freePort(t),
+		"COLLECTOR_BATCH_TIMEOUT": "100ms",
+	}}
+	for key, value := range settings {
+		p.cfg[key] = value
+	}
+	p.metricsURL = "http://127.0.0.1:" + p.cfg["METRICS_PORT"]
+
+	cmd := exec.Command(bin)
+	cmd.Env = os.Environ()
+	for key, value := range p.cfg {
+		cmd.Env = append(cmd.Env, key+"="+value)
+	}
+	var output bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &output, &output
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	t.Cleanup(func() {
+		cmd.Process.Signal(syscall.SIGTERM)
+		select {
+		case err := <-exited:
+			if err != nil {
+				t.Errorf("collector exited with %v", err)
+			}
+		case <-time.After(30 * time.Second):
+			cmd.Process.Kill()
+			<-exited
+			t.Error("collector did not shut down within 30s")
+		}
+		if t.Failed() {
+			t.Logf("collector output:\n%s", output.String())
+		}
+	})
+
+	if p.db, err = sql.Open("postgres", p.cfg["POSTGRES_URL"]); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { p.db.Close() })
+	if p.amqp, err = amqp.Dial(p.cfg["RABBITMQ_URL"]); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { p.amqp.Close() })
+
+	eventually(t, 60*time.Second, "the collector to consume its queue", func() (bool, error) {
+		select {
+		case err := <-exited:
+			return false, fmt.Errorf("collector exited with %v:\n%s", err, output.String())
+		default:
+		}
+		queue, err := p.inspect(p.cfg["RABBITMQ_QUEUE_NAME"])
+		return err == nil && queue.Consumers > 0, nil
+	})
+	return p
+}
+
+// inspect returns the state of a queue.
+func (p *collectorProcess) inspect(name string) (amqp.Queue, error) {
+	ch, err := p.amqp.Channel()
+	if err != nil {
+		return amqp.Queue{}, err
+	}
+	defer ch.Close()
+	return ch.QueueDeclarePassive(name, true, false, false, false, nil)
+}
+
+// publish publishes bodies to the collector's exchange, with publisher
+// confirms.
+func (p *collectorProcess) publish(t *testing.T, bodies ...[]byte) {
+	t.Helper()
+	ch, err := p.amqp.Channel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ch.Close()
+	if err := ch.Confirm(false); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, body := range bodies {
+		confirm, err := ch.PublishWithDeferredConfirmWithContext(ctx, p.cfg["RABBITMQ_EXCHANGE"], "logs.checkout", false, false,
+			amqp.Publishing{ContentType: "application/json", DeliveryMode: amqp.Persistent, Body: body})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok, err := confirm.WaitContext(ctx); !ok || err != nil {
+			t.Fatalf("publish not confirmed: %v", err)
+		}
+	}
+}
+
+// metric returns the value of an unlabelled metric the collector exports.
+func (p *collectorProcess) metric(t *testing.T, name string) float64 {
+	t.Helper()
+	resp, err := http.Get(p.metricsURL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		if value, ok := strings.CutPrefix(scanner.Text(), name+" "); ok {
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				t.Fatalf("metric %s: %v", name, err)
+			}
+			return v
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	t.Fatalf("metric %s not exported", name)
+	return 0
+}
+
+// eventIDs returns the event IDs of the stored rows, sorted.
+func (p *collectorProcess) eventIDs(t *testing.T) []string {
+	t.Helper()
+	rows, err := p.db.Query("SELECT event_id FROM logs ORDER BY event_id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return ids
+}
+
+// eventually polls done until it reports true, failing the test on its
+// error or after timeout.
+func eventually(t *testing.T, timeout time.Duration, what string, done func() (bool, error)) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for {
+		ok, err := done()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out after %s waiting for %s", timeout, what)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// marshal returns doc as JSON.
+func marshal(t *testing.T, doc any) []byte {
+	t.Helper()
+	body, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func TestEndToEnd(t *testing.T) {
+	p := startCollector(t, nil)
+
+	var want []string
+	var bodies [][]byte
+	for i := 0; i < 3; i++ {
+		doc := logEventDoc()
+		want = append(want, doc["eventId"].(string))
+		bodies = append(bodies, marshal(t, doc))
+	}
+	sort.Strings(want)
+	malformed := []byte(`{"eventId": "truncated`)
+	// The duplicate is redelivered after the original was stored, as a
+	// producer retry would be
+	p.publish(t, bodies...)
+	eventually(t, 30*time.Second, "the events to be stored", func() (bool, error) {
+		return len(p.eventIDs(t)) >= len(want), nil
+	})
+	p.publish(t, bodies[0], malformed)
+
+	eventually(t, 30*time.Second, "the duplicate to be skipped", func() (bool, error) {
+		return p.metric(t, "collector_messages_skipped_total") == 1, nil
+	})
+	eventually(t, 30*time.Second, "the malformed event to be dead-lettered", func() (bool, error) {
+		dlq, err := p.inspect(p.cfg["RABBITMQ_DLQ_NAME"])
+		return err == nil && dlq.Messages == 1, err
+	})
+	time.Sleep(500 * time.Millisecond) // Longer than a batch timeout
+
+	if got := p.eventIDs(t); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("stored %v, want %v once each", got, want)
+	}
+
+	ch, err := p.amqp.Channel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ch.Close()
+	dead, ok, err := ch.Get(p.cfg["RABBITMQ_DLQ_NAME"], true)
+	if err != nil || !ok {
+		t.Fatalf("DLQ get = %t, %v", ok, err)
+	}
+	if !bytes.Equal(dead.Body, malformed) {
+		t.Errorf("dead-lettered %q, want the malformed event", dead.Body)
+	}
+}
+
+// queryLogs returns the events the query API answers for the query string.
+func (p *collectorProcess) queryLogs(t *testing.T, query string) []storage.LogEvent {
+	t.Helper()
+	resp, err := http.Get(p.metricsURL + "/api/logs?format=ndjson&" + query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		t.Fatalf("GET /api/logs?%s = %s: %s", query, resp.Status, body)
+	}
+	var events []storage.LogEvent
+	decoder := json.NewDecoder(resp.Body)
+	for decoder.More() {
+		var event storage.LogEvent
+		if err := decoder.Decode(&event); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestQueryAPIFiltersByBaggage(t *testing.T) {
+	p := startCollector(t, map[string]string{"QUERY_API_ENABLED": "true", "TRACING_COLUMN": "true"})
+
+	traced := func(baggage map[string]any) map[string]any {
+		doc := logEventDoc()
+		doc["tracing"] = map[string]any{
+			"traceId": strings.ReplaceAll(uuid.NewString(), "-", ""),
+			"baggage": baggage,
+		}
+		return doc
+	}
+	acme := traced(map[string]any{"tenant": "acme", "plan": "gold"})
+	globex := traced(map[string]any{"tenant": "globex"})
+	untraced := logEventDoc()
+	p.publish(t, marshal(t, acme), marshal(t, globex), marshal(t, untraced))
+	eventually(t, 30*time.Second, "the events to be stored", func() (bool, error) {
+		return len(p.eventIDs(t)) == 3, nil
+	})
+
+	events := p.queryLogs(t, "baggage=tenant%3Dacme")
+	if len(events) != 1 || events[0].EventID != acme["eventId"] {
+		t.Fatalf("baggage tenant=acme returned %d events, want the acme event", len(events))
+	}
+	if events[0].Tracing == nil || fmt.Sprint(events[0].Tracing.Baggage) != "map[plan:gold tenant:acme]" {
+		t.Errorf("tracing = %+v, want the published baggage", events[0].Tracing)
+	}
+	if events[0].Tracing.TraceID != acme["tracing"].(map[string]any)["traceId"] {
+		t.Errorf("trace ID = %s, want the published one", events[0].Tracing.TraceID)
+	}
+
+	// Every entry must match
+	if events := p.queryLogs(t, "baggage=tenant%3Dacme&baggage=plan%3Dgold"); len(events) != 1 {
+		t.Errorf("baggage tenant=acme, plan=gold returned %d events, want 1", len(events))
+	}
+	if events := p.queryLogs(t, "baggage=tenant%3Dacme&baggage=plan%3Dsilver"); len(events) != 0 {
+		t.Errorf("baggage tenant=acme, plan=silver returned %d events, want none", len(events))
+	}
+	if events := p.queryLogs(t, ""); len(events) != 3 {
+		t.Errorf("no filter returned %d events, want 3", len(events))
+	}
+}
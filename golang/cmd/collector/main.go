@@ -3,16 +3,23 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"log"
+	"observability_hub/golang/internal/collector/api"
+	"observability_hub/golang/internal/collector/buildinfo"
 	"observability_hub/golang/internal/collector/config"
 	"observability_hub/golang/internal/collector/consumer"
+	"observability_hub/golang/internal/collector/enrich"
 	"observability_hub/golang/internal/collector/metrics"
+	"observability_hub/golang/internal/collector/schema"
 	"observability_hub/golang/internal/collector/storage"
+	"observability_hub/golang/internal/types"
 	"os"
 	"os/signal"
+	"runtime"
 	"sync"
+	"sync/atomic"
 	"syscall"
-	"time"
 
 	"go.uber.org/zap"
 )
@@ -29,9 +36,28 @@ func main() {
 		logger.Fatal("Failed to load configuration", zap.Error(err))
 	}
 
+	if len(os.Args) > 1 && os.Args[1] == "reindex" {
+		if err := runReindex(cfg, logger, os.Args[2:]); err != nil {
+			logger.Fatal("Reindex failed", zap.Error(err))
+		}
+		return
+	}
+
+	// ctx is cancelled to begin shutting down. The stores get appCtx, which
+	// outlives it, so that Redis and Postgres keep working while the
+	// shutdown sequence drains and flushes.
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
+	appCtx, appCancel := context.WithCancel(context.Background())
+	defer appCancel()
 
+	logger.Info("Starting collector",
+		zap.String("version", buildinfo.Version),
+		zap.String("commit", buildinfo.Commit),
+		zap.String("go_version", runtime.Version()))
+
+	metrics.ConfigureBuckets(cfg)
+	metrics.SetBuildInfo(metrics.ServerVersions{})
 	metricsServer := metrics.NewServer(cfg)
 	metricsServer.Start()
 
@@ -40,91 +66,665 @@ func main() {
 	go func() {
 		<-sigChan
 		logger.Info("Shutdown signal received, initiating graceful shutdown...")
-
-		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer shutdownCancel()
-
-		metricsServer.Shutdown(shutdownCtx)
 		cancel()
 	}()
 
 	// Initialize Redis client
-	redisClient, err := storage.NewRedisClient(ctx, cfg, logger)
+	redisClient, err := waitForDependency(ctx, cfg, logger, "redis", cfg.StartupWaitRedis, func() (*storage.RedisClient, error) {
+		return storage.NewRedisClient(appCtx, cfg, logger)
+	})
 	if err != nil {
 		logger.Fatal("Failed to create Redis client", zap.Error(err))
 	}
-	defer redisClient.Close()
 
 	// Set Redis client for health checks
 	metricsServer.SetRedisClient(redisClient)
 
-	dbStorage, err := storage.NewDBStorageWithRedis(ctx, cfg, logger, redisClient)
-	if err != nil {
-		logger.Fatal("Failed to create database storage", zap.Error(err))
+	// dbStorage stays nil when Elasticsearch is the only backend
+	var dbStorage storage.EventStore
+	bufferDepth := func() int { return 0 }
+	if cfg.PostgresEnabled {
+		dbStorage, err = waitForDependency(ctx, cfg, logger, "postgres", cfg.StartupWaitPostgres, func() (storage.EventStore, error) {
+			return storage.NewEventStore(appCtx, cfg, logger, redisClient)
+		})
+		if err != nil {
+			logger.Fatal("Failed to create database storage", zap.Error(err))
+		}
+		bufferDepth = dbStorage.BufferDepth
+
+		// SIGHUP, or POST /admin/reload-config, applies changed batching and
+		// retry settings without a restart
+		reloader := newReloader(cfg, dbStorage, logger.Named("reload"))
+		reloads := make(chan os.Signal, 1)
+		signal.Notify(reloads, syscall.SIGHUP)
+		go reloader.reloadOnSignal(ctx, reloads)
+		if cfg.ConfigReloadToken != "" {
+			metricsServer.Handle("/admin/reload-config", &reloadHandler{cfg: cfg, reloader: reloader})
+		}
+		metricsServer.AddHealthCheck("batch_processor", metrics.HealthCheckFunc(dbStorage.BatchProcessorHealth))
+		metricsServer.SetFlushTracker(dbStorage)
 	}
-	defer dbStorage.Close()
 
-	esStorage, err := storage.NewESStorage(cfg, logger)
-	if err != nil {
-		logger.Fatal("Failed to create Elasticsearch storage", zap.Error(err))
+	if cfg.StatsLogEnabled {
+		go metrics.NewStatsReporter(cfg.StatsLogInterval, logger, bufferDepth).Run(ctx)
 	}
-	defer esStorage.Close()
 
-	rmqConsumer, err := consumer.New(cfg)
+	var logReader *storage.LogReader
+	if cfg.QueryAPIEnabled {
+		logReader, err = storage.NewLogReader(cfg, logger)
+		if err != nil {
+			logger.Fatal("Failed to create log reader", zap.Error(err))
+		}
+		metricsServer.Handle("/api/logs", api.NewLogsHandler(cfg, logReader, logger))
+	}
+
+	var esStorage *storage.ESStorage
+	var esWriter *storage.Destination
+	if cfg.ElasticsearchEnabled {
+		esStorage, err = waitForDependency(ctx, cfg, logger, "elasticsearch", cfg.StartupWaitElasticsearch, func() (*storage.ESStorage, error) {
+			return storage.NewESStorage(cfg, logger)
+		})
+		if err != nil {
+			logger.Fatal("Failed to create Elasticsearch storage", zap.Error(err))
+		}
+	}
+	if esStorage != nil && dbStorage != nil {
+		// Indexing runs beside the Postgres flushes with its own limits, so a
+		// slow cluster does not hold up the database.
+		esWriter = storage.NewDestination(storage.DestinationElasticsearch, cfg.ESFlushConcurrency, cfg.ESFlushTimeout, esStorage.BulkIndexLogEvents, logger)
+		if cfg.OutboxEnabled {
+			// Elasticsearch is fed from the outbox, written in the flush transaction
+			dbStorage.StartOutboxRelay(esWriter.Write)
+			logger.Info("Indexing to Elasticsearch through the Postgres outbox",
+				zap.Int("batch_size", cfg.OutboxBatchSize),
+				zap.Duration("poll_interval", cfg.OutboxPollInterval))
+		}
+		logger.Info("Writing to Postgres and Elasticsearch", zap.String("ack_policy", cfg.StorageAckPolicy))
+	} else if esStorage != nil {
+		logger.Warn("Postgres is not among the storage backends; indexing to Elasticsearch only, without Redis deduplication or metrics events")
+	} else {
+		logger.Info("Elasticsearch is not among the storage backends; writing to Postgres only")
+	}
+
+	var kafkaStorage *storage.KafkaStorage
+	var kafkaWriter *storage.Destination
+	if len(cfg.KafkaBrokers) > 0 {
+		kafkaStorage, err = storage.NewKafkaStorage(ctx, cfg, logger)
+		if err != nil {
+			logger.Fatal("Failed to create Kafka producer", zap.Error(err))
+		}
+		kafkaWriter = storage.NewDestination(storage.DestinationKafka, cfg.KafkaFlushConcurrency, cfg.KafkaFlushTimeout, kafkaStorage.ProduceLogEvents, logger)
+		logger.Info("Producing accepted events to Kafka",
+			zap.Strings("brokers", cfg.KafkaBrokers),
+			zap.String("topic", cfg.KafkaTopic),
+			zap.String("key", cfg.KafkaKey),
+			zap.String("acks", cfg.KafkaAcks))
+	}
+
+	rmqConsumer, err := waitForDependency(ctx, cfg, logger, "rabbitmq", cfg.StartupWaitRabbitMQ, func() (*consumer.Consumer, error) {
+		return consumer.New(cfg)
+	})
 	if err != nil {
 		logger.Fatal("Failed to create RabbitMQ consumer", zap.Error(err))
 	}
-	defer rmqConsumer.Close()
+
+	servers := metrics.ServerVersions{RabbitMQ: rmqConsumer.ServerVersion()}
+	if dbStorage != nil {
+		servers.Postgres = dbStorage.ServerVersion()
+	}
+	if esStorage != nil {
+		servers.Elasticsearch = esStorage.ServerVersion()
+	}
+	metrics.SetBuildInfo(servers)
+	logger.Info("Connected to dependencies",
+		zap.String("postgres_version", servers.Postgres),
+		zap.String("elasticsearch_version", servers.Elasticsearch),
+		zap.String("rabbitmq_version", servers.RabbitMQ))
+
+	if cfg.DLQReplayToken != "" {
+		metricsServer.Handle("/admin/replay-dlq", &replayHandler{cfg: cfg, consumer: rmqConsumer, logger: logger.Named("replay")})
+	}
+
+	if err := types.SetVersionMode(cfg.SourceVersionMode); err != nil {
+		logger.Fatal("Failed to set source version mode", zap.Error(err))
+	}
+
+	for alias, canonical := range cfg.EventTypeAliases {
+		if !types.IsValidEventType(canonical) {
+			logger.Fatal("Event type alias maps to an invalid event type", zap.String("alias", alias), zap.String("event_type", canonical))
+		}
+	}
+
+	if cfg.AutoAck {
+		logger.Warn("AUTO_ACK is enabled: deliveries are acknowledged on receipt and lost on failure. Do not use in production.")
+	}
+
+	var publisher *consumer.Publisher
+	if cfg.RepublishEnabled {
+		publisher, err = consumer.NewPublisher(rmqConsumer, cfg)
+		if err != nil {
+			logger.Fatal("Failed to create re-publisher", zap.Error(err))
+		}
+		logger.Info("Re-publishing enriched events",
+			zap.String("exchange", cfg.RepublishExchange),
+			zap.String("routing_key", cfg.RepublishRoutingKey))
+	}
 
 	deliveries, err := rmqConsumer.Start(ctx)
 	if err != nil {
 		logger.Fatal("Failed to start consuming messages", zap.Error(err))
 	}
 
+	var schemas *schema.Registry
+	if cfg.SchemaValidationEnabled {
+		schemas = schema.NewRegistry(cfg.SchemaAllowedHosts, cfg.SchemaCacheTTL, cfg.SchemaCacheSize, cfg.SchemaFetchTimeout)
+		logger.Info("Validating events against their schemaUrl", zap.Strings("allowed_hosts", cfg.SchemaAllowedHosts))
+	}
+
+	var chunks *chunkAssembler
+	if cfg.ChunkedIngestionEnabled {
+		chunks = newChunkAssembler(cfg, logger.Named("chunks"))
+		go chunks.run(ctx)
+	}
+
+	var enrichers enrich.Chain
+	var geoIP *enrich.GeoIP
+	if cfg.GeoIPDatabasePath != "" {
+		geoIP, err = enrich.NewGeoIP(cfg.GeoIPDatabasePath, cfg.GeoIPSourceField, cfg.GeoIPCacheSize, logger.Named("geoip"))
+		if err != nil {
+			logger.Fatal("Failed to create GeoIP enricher", zap.Error(err))
+		}
+		enrichers = append(enrichers, geoIP)
+		logger.Info("Adding the location of structured."+cfg.GeoIPSourceField+" to events",
+			zap.String("database", cfg.GeoIPDatabasePath))
+	}
+
+	rates := metrics.NewRateTracker(cfg.IngestRateWindow, cfg.IngestRateMaxServices)
+	go rates.Run(ctx)
+	var outcomes *metrics.OutcomeTally
+	if cfg.WebhookURL != "" {
+		outcomes = metrics.NewOutcomeTally()
+		go metrics.NewWebhookReporter(cfg, outcomes, logger).Run(ctx)
+		logger.Info("Reporting ingestion outcomes to a webhook",
+			zap.Duration("interval", cfg.WebhookInterval),
+			zap.String("format", cfg.WebhookFormat))
+	}
+
+	var budgets *metrics.ErrorBudgets
+	if cfg.ErrorBudgetDefault.Threshold > 0 || len(cfg.ErrorBudgetServices) > 0 {
+		budgets = metrics.NewErrorBudgets(cfg, logger)
+		go budgets.Run(ctx)
+		logger.Info("Watching per-service error budgets",
+			zap.Float64("default_threshold", cfg.ErrorBudgetDefault.Threshold),
+			zap.Duration("default_window", cfg.ErrorBudgetDefault.Window),
+			zap.Int("services", len(cfg.ErrorBudgetServices)))
+	}
+
+	versions := metrics.NewVersionTracker(cfg.SchemaVersionMaxLabels)
+	if cfg.SchemaVersionLogInterval > 0 {
+		go versions.Run(ctx, cfg.SchemaVersionLogInterval, logger.Named("versions"))
+	}
+
+	var instances *metrics.InstanceTracker
+	if cfg.InstanceMetricsEnabled {
+		instances = metrics.NewInstanceTracker(cfg.InstanceMetricsMaxLabels)
+	}
+
+	// Without Postgres events are indexed as they arrive; there is no batch
+	// buffer to watch
+	var pressure *backpressure
+	if cfg.BackpressureEnabled && dbStorage != nil {
+		pressure = &backpressure{cfg: cfg, store: dbStorage, logger: logger.Named("backpressure")}
+		if cfg.RabbitMQPrefetch == 0 {
+			logger.Warn("Backpressure is enabled without RABBITMQ_PREFETCH: paused deliveries pile up in the collector instead of the broker")
+		}
+		go pressure.run(ctx)
+	}
+
+	var memory *memoryGovernor
+	if cfg.MemoryLimitBytes > 0 {
+		memory = &memoryGovernor{cfg: cfg, store: dbStorage, consumer: rmqConsumer, logger: logger.Named("memory")}
+		logger.Info("Memory budget enabled",
+			zap.Int64("limit_bytes", cfg.MemoryLimitBytes),
+			zap.Float64("shed_ratio", cfg.MemoryShedRatio),
+			zap.Float64("recover_ratio", cfg.MemoryRecoverRatio))
+		go memory.run(ctx)
+	}
+
+	var processed atomic.Int64
+	if cfg.OneShot {
+		logger.Info("One-shot mode: exiting once the queue is drained",
+			zap.Duration("idle_timeout", cfg.OneShotIdleTimeout),
+			zap.Int("max_messages", cfg.OneShotMaxMessages),
+			zap.Duration("max_duration", cfg.OneShotMaxDuration))
+		go watchOneShot(ctx, cfg, logger, rmqConsumer, &processed, cancel)
+	}
+
+	var dispatcher *priorityDispatcher
+	if len(cfg.WorkerReservations) > 0 {
+		dispatcher = newPriorityDispatcher(cfg)
+		go dispatcher.run(deliveries)
+		logger.Info("Reserving workers per event priority", zap.Any("reservations", cfg.WorkerReservations))
+	}
+
+	// Deliveries are acknowledged once the primary backend accepted them:
+	// Postgres, or Elasticsearch when it is the only backend
+	var primary storage.Storage = esStorage
+	var metricsStore storage.MetricsStore
+	if dbStorage != nil {
+		primary, metricsStore = dbStorage, dbStorage
+	}
+
 	var wg sync.WaitGroup
 	for i := 0; i < cfg.WorkerPoolSize; i++ {
+		w := &worker{
+			id:           i + 1,
+			cfg:          cfg,
+			logger:       logger,
+			store:        primary,
+			metricsStore: metricsStore,
+			es:           esWriter,
+			kafka:        kafkaWriter,
+			schemas:      schemas,
+			chunks:       chunks,
+			enrichers:    enrichers,
+			publisher:    publisher,
+			rates:        rates,
+			versions:     versions,
+			outcomes:     outcomes,
+			budgets:      budgets,
+			instances:    instances,
+			processed:    &processed,
+			pressure:     pressure,
+			memory:       memory,
+		}
+		in := deliveries
+		if dispatcher != nil {
+			in = dispatcher.feed(appCtx, dispatcher.reservation(i))
+		}
 		wg.Add(1)
-		go func(workerID int) {
+		go func() {
 			defer wg.Done()
-			logger.Info("Worker started", zap.Int("workerId", workerID))
-			for {
-				select {
-				case <-ctx.Done():
-					logger.Info("Worker shutting down", zap.Int("workerId", workerID))
-					return
-				case d, ok := <-deliveries:
-					if !ok {
-						logger.Info("Deliveries channel closed, worker shutting down.", zap.Int("workerId", workerID))
-						return
-					}
-					metrics.MessagesProcessed.Inc()
-
-					var event storage.LogEvent
-					if err := json.Unmarshal(d.Body, &event); err != nil {
-						logger.Error("Failed to unmarshal message", zap.Error(err), zap.Int("workerId", workerID), zap.String("body", string(d.Body)))
-						d.Nack(false, false)
-						metrics.MessagesNacked.Inc()
-						continue
-					}
-
-					dbStorage.AddToBatch(&event)
-
-					// Asynchronously send to Elasticsearch
-					go func(e storage.LogEvent) {
-						if err := esStorage.BulkIndexLogEvents(ctx, []*storage.LogEvent{&e}); err != nil {
-							logger.Error("Failed to index log event to Elasticsearch", zap.Error(err), zap.String("eventId", e.EventID))
-							// Here you might want to add metrics for ES failures
-						}
-					}(event)
-
-					d.Ack(false)
-					metrics.MessagesAcked.Inc()
-				}
+			w.run(appCtx, in)
+		}()
+	}
+
+	dlqDone := make(chan struct{})
+	var deadLetters *storage.DeadLetterArchive
+	if cfg.DLQReprocessEnabled || cfg.DLQArchiveEnabled {
+		dlqReader, err := consumer.NewDLQReader(rmqConsumer)
+		if err != nil {
+			logger.Fatal("Failed to create DLQ reader", zap.Error(err))
+		}
+		if cfg.DLQArchiveEnabled {
+			deadLetters, err = storage.NewDeadLetterArchive(ctx, cfg, logger)
+			if err != nil {
+				logger.Fatal("Failed to create dead letter archive", zap.Error(err))
 			}
-		}(i + 1)
+			logger.Info("Archiving dead letters to Postgres",
+				zap.String("table", cfg.DLQArchiveTable),
+				zap.Bool("keep_in_dlq", cfg.DLQArchiveKeep))
+		}
+		reprocessor := &dlqReprocessor{
+			reader:       dlqReader,
+			archive:      deadLetters,
+			reprocessing: cfg.DLQReprocessEnabled,
+			worker: &worker{
+				cfg:       cfg,
+				logger:    logger,
+				store:     primary,
+				es:        esWriter,
+				schemas:   schemas,
+				processed: &processed,
+				rates:     rates,
+				versions:  versions,
+			},
+			logger:   logger.Named("dlq"),
+			rate:     cfg.DLQReprocessRate,
+			interval: cfg.DLQReprocessInterval,
+		}
+		go func() {
+			defer close(dlqDone)
+			defer dlqReader.Close()
+			reprocessor.run(ctx)
+		}()
+	} else {
+		close(dlqDone)
 	}
 
+	workersDone := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(workersDone)
+	}()
+
 	logger.Info("Collector service started successfully. Waiting for messages...")
-	wg.Wait()
+	select {
+	case <-ctx.Done():
+	case <-workersDone:
+		logger.Warn("Deliveries channel closed, shutting down")
+		cancel()
+	}
+
+	// Stop taking work first and release Redis last: the final flush still
+	// looks up and caches metadata.
+	shutdown := &shutdownSequence{logger: logger}
+	shutdown.add("stop consuming", func(context.Context) error {
+		return rmqConsumer.StopConsuming()
+	})
+	shutdown.add("drain workers", func(ctx context.Context) error {
+		select {
+		case <-workersDone:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	shutdown.add("stop dlq reprocessor", func(ctx context.Context) error {
+		select {
+		case <-dlqDone:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	if deadLetters != nil {
+		shutdown.add("close dead letter archive", func(context.Context) error {
+			deadLetters.Close()
+			return nil
+		})
+	}
+	if dbStorage != nil {
+		shutdown.add("flush database", func(context.Context) error {
+			dbStorage.Close()
+			return nil
+		})
+	}
+	if esStorage != nil {
+		shutdown.add("close elasticsearch", func(context.Context) error {
+			if esWriter != nil {
+				esWriter.Wait()
+			}
+			esStorage.Close()
+			return nil
+		})
+	}
+	if kafkaStorage != nil {
+		shutdown.add("close kafka", func(context.Context) error {
+			kafkaWriter.Wait()
+			kafkaStorage.Close()
+			return nil
+		})
+	}
+	shutdown.add("stop http server", metricsServer.Shutdown)
+	if geoIP != nil {
+		shutdown.add("close geoip database", func(context.Context) error {
+			return geoIP.Close()
+		})
+	}
+	if logReader != nil {
+		shutdown.add("close log reader", func(context.Context) error {
+			logReader.Close()
+			return nil
+		})
+	}
+	if publisher != nil {
+		shutdown.add("close publisher", func(context.Context) error {
+			publisher.Close()
+			return nil
+		})
+	}
+	shutdown.add("close rabbitmq", func(context.Context) error {
+		rmqConsumer.Close()
+		return nil
+	})
+	shutdown.add("close redis", func(context.Context) error {
+		return redisClient.Close()
+	})
+	shutdown.run(cfg.ShutdownTimeout)
 	logger.Info("All workers have shut down. Exiting.")
 }
+
+// highRetryTag marks events that were redelivered unusually often.
+const highRetryTag = "high-retry"
+
+// observeRetryCount records the producer-reported retry count of an event.
+// Events at or above the configured threshold are logged and, depending on
+// the high-retry action, tagged so that flapping producers can be found;
+// they are still stored as usual. Elasticsearch routes them by retry count.
+func observeRetryCount(cfg *config.Config, logger *zap.Logger, event *storage.LogEvent) {
+	if event.Metadata.RetryCount == nil {
+		return
+	}
+	retryCount := *event.Metadata.RetryCount
+	metrics.EventsByRetryCount.Observe(float64(retryCount))
+
+	if cfg.HighRetryThreshold <= 0 || retryCount < cfg.HighRetryThreshold {
+		return
+	}
+	metrics.HighRetryEvents.WithLabelValues(event.Source.Service).Inc()
+	event.Metadata.Collector.Note("high_retry")
+	if cfg.HighRetryAction != config.HighRetryActionLog {
+		event.Metadata.Tags = append(event.Metadata.Tags, highRetryTag)
+	}
+	logger.Warn("High-retry event",
+		zap.String("eventId", event.EventID),
+		zap.String("service", event.Source.Service),
+		zap.Int("retry_count", retryCount),
+		zap.Int("threshold", cfg.HighRetryThreshold),
+		zap.String("action", cfg.HighRetryAction))
+}
+
+// captureRawPayload keeps the original message body on the event. Bodies
+// above the size cap are dropped; the parsed event is stored either way.
+func captureRawPayload(cfg *config.Config, logger *zap.Logger, event *storage.LogEvent, body []byte) {
+	if cfg.RawPayloadMaxBytes > 0 && len(body) > cfg.RawPayloadMaxBytes {
+		metrics.RawPayloadsOversized.Inc()
+		event.Metadata.Collector.Note("raw_payload_oversized")
+		return
+	}
+	raw, err := storage.NewRawPayload(body, cfg.RawPayloadCompress)
+	if err != nil {
+		logger.Warn("Failed to capture raw payload", zap.Error(err), zap.String("eventId", event.EventID))
+		return
+	}
+	event.RawPayload = raw
+}
+
+// checkText applies the invalid text policy to fields Postgres would
+// reject, which would otherwise fail the whole batch on every retry.
+func checkText(cfg *config.Config, logger *zap.Logger, event *storage.LogEvent) bool {
+	sanitize := cfg.InvalidTextPolicy == config.InvalidTextSanitize
+	invalid := storage.SanitizeText(event, sanitize)
+	if invalid == 0 {
+		return true
+	}
+	metrics.InvalidTextFields.WithLabelValues(cfg.InvalidTextPolicy).Add(float64(invalid))
+	if !sanitize {
+		logger.Warn("Rejecting event with invalid text",
+			zap.String("eventId", event.EventID),
+			zap.String("service", event.Source.Service),
+			zap.Int("fields", invalid))
+		return false
+	}
+	event.Metadata.Collector.Note("text_sanitized")
+	return true
+}
+
+// normalizeEventType rewrites a known event type variant to its canonical
+// type, so that producers migrating between names are not rejected. Each
+// applied alias is counted so that the producers can be asked to fix it.
+func normalizeEventType(cfg *config.Config, event *storage.LogEvent) {
+	canonical, ok := cfg.EventTypeAliases[event.EventType]
+	if !ok {
+		return
+	}
+	metrics.EventTypeAliasesApplied.WithLabelValues(event.EventType).Inc()
+	event.Metadata.Collector.Note("event_type_aliased")
+	event.EventType = canonical
+}
+
+// checkSourceVersion flags events whose source version is not a semantic
+// version. In strict mode such events are rejected; in relaxed mode they
+// are counted and stored with the version as sent.
+func checkSourceVersion(cfg *config.Config, logger *zap.Logger, event *storage.LogEvent) bool {
+	if types.IsSemver(event.Source.Version) {
+		return true
+	}
+	metrics.NonSemverVersions.WithLabelValues(event.Source.Service).Inc()
+	if cfg.SourceVersionMode == types.VersionModeStrict {
+		logger.Warn("Rejecting event with non-semver source version",
+			zap.String("eventId", event.EventID),
+			zap.String("service", event.Source.Service),
+			zap.String("version", event.Source.Version))
+		return false
+	}
+	event.Metadata.Collector.Note("non_semver_version")
+	return true
+}
+
+// checkEnvelope validates the event envelope against the validate tags of
+// types.BaseEvent when envelope validation is enabled: UUIDs, semantic
+// versions, event type patterns and W3C trace IDs. Invalid events go to
+// the DLQ.
+func checkEnvelope(cfg *config.Config, logger *zap.Logger, event *storage.LogEvent) bool {
+	if !cfg.EventValidationEnabled {
+		return true
+	}
+	result := types.ValidateEvent(baseEvent(event))
+	if result.Valid {
+		return true
+	}
+
+	metrics.MessagesInvalid.WithLabelValues(result.Errors[0].Field).Inc()
+	fields := make([]string, len(result.Errors))
+	for i, e := range result.Errors {
+		fields[i] = e.Field + ": " + e.Message
+	}
+	logger.Warn("Rejecting event with an invalid envelope",
+		zap.String("eventId", event.EventID),
+		zap.String("service", event.Source.Service),
+		zap.Strings("errors", fields))
+	return false
+}
+
+// baseEvent returns the envelope of an event as a types.BaseEvent.
+func baseEvent(event *storage.LogEvent) *types.BaseEvent {
+	base := &types.BaseEvent{
+		EventID:       event.EventID,
+		EventType:     event.EventType,
+		Version:       event.Version,
+		Timestamp:     event.Timestamp,
+		CorrelationID: event.CorrelationID,
+		Source: types.EventSource{
+			Service: event.Source.Service,
+			Version: event.Source.Version,
+		},
+		Metadata: types.EventMetadata{
+			Priority: types.EventPriority(event.Metadata.Priority),
+			Tags:     event.Metadata.Tags,
+		},
+	}
+	if event.CausationID != nil {
+		base.CausationID = *event.CausationID
+	}
+	if event.Source.Instance != nil {
+		base.Source.Instance = *event.Source.Instance
+	}
+	if event.Source.Region != nil {
+		base.Source.Region = *event.Source.Region
+	}
+	if event.Metadata.Environment != nil {
+		base.Metadata.Environment = types.Environment(*event.Metadata.Environment)
+	}
+	if event.Metadata.RetryCount != nil {
+		base.Metadata.RetryCount = *event.Metadata.RetryCount
+	}
+	if event.Metadata.SchemaURL != nil {
+		base.Metadata.SchemaURL = *event.Metadata.SchemaURL
+	}
+	if t := event.Tracing; t != nil {
+		base.Tracing = &types.TracingContext{TraceID: t.TraceID, Flags: t.Flags, Baggage: t.Baggage}
+		if t.SpanID != nil {
+			base.Tracing.SpanID = *t.SpanID
+		}
+		if t.ParentSpanID != nil {
+			base.Tracing.ParentSpanID = *t.ParentSpanID
+		}
+	}
+	return base
+}
+
+// checkFutureTimestamp applies the future timestamp policy to events
+// timestamped beyond the receive time plus the threshold. Such timestamps
+// would otherwise land in index buckets months ahead. Clamped events keep
+// the original in metadata.originalTimestamp; rejected ones go to the DLQ.
+func checkFutureTimestamp(cfg *config.Config, logger *zap.Logger, event *storage.LogEvent) bool {
+	if cfg.FutureTimestampPolicy == config.FutureTimestampAccept || !event.Timestamp.After(event.ReceivedAt.Add(cfg.FutureTimestampThreshold)) {
+		return true
+	}
+
+	metrics.FutureTimestamps.WithLabelValues(cfg.FutureTimestampPolicy).Inc()
+	if cfg.FutureTimestampPolicy == config.FutureTimestampReject {
+		logger.Warn("Rejecting event with future timestamp",
+			zap.String("eventId", event.EventID),
+			zap.String("service", event.Source.Service),
+			zap.Time("timestamp", event.Timestamp))
+		return false
+	}
+
+	original := event.Timestamp
+	event.Metadata.OriginalTimestamp = &original
+	event.Timestamp = event.ReceivedAt.Round(0)
+	event.Metadata.Collector.Note("future_timestamp_clamped")
+	return true
+}
+
+// checkSchema validates an event against the JSON Schema its producer
+// references in metadata.schemaUrl and rejects it on a violation. Schemas
+// that cannot be fetched, or live on hosts outside the allowlist, let the
+// event through.
+func checkSchema(schemas *schema.Registry, logger *zap.Logger, event *storage.LogEvent) bool {
+	if schemas == nil || event.Metadata.SchemaURL == nil || *event.Metadata.SchemaURL == "" {
+		return true
+	}
+	schemaURL := *event.Metadata.SchemaURL
+
+	s, err := schemas.Get(context.Background(), schemaURL)
+	if err != nil {
+		result := "fetch_error"
+		if errors.Is(err, schema.ErrHostNotAllowed) {
+			result = "not_allowed"
+		}
+		metrics.SchemaValidations.WithLabelValues(result).Inc()
+		logger.Debug("Skipping schema validation", zap.Error(err), zap.String("schemaUrl", schemaURL))
+		return true
+	}
+
+	doc, err := documentOf(event)
+	if err == nil {
+		err = s.Validate(doc)
+	}
+	if err != nil {
+		metrics.SchemaValidations.WithLabelValues("invalid").Inc()
+		logger.Warn("Rejecting event violating its schema",
+			zap.Error(err),
+			zap.String("eventId", event.EventID),
+			zap.String("service", event.Source.Service),
+			zap.String("schemaUrl", schemaURL))
+		return false
+	}
+	metrics.SchemaValidations.WithLabelValues("valid").Inc()
+	return true
+}
+
+// documentOf returns the event as a generic JSON document.
+func documentOf(event *storage.LogEvent) (interface{}, error) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	var doc interface{}
+	err = json.Unmarshal(data, &doc)
+	return doc, err
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"observability_hub/golang/internal/collector/config"
+	"observability_hub/golang/internal/collector/consumer"
+	"observability_hub/golang/internal/collector/metrics"
+	"observability_hub/golang/internal/collector/storage"
+	runtimemetrics "runtime/metrics"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// heapObjectsMetric is the runtime metric sampled by the memory governor:
+// the bytes of heap memory occupied by live and not yet swept objects.
+const heapObjectsMetric = "/memory/classes/heap/objects:bytes"
+
+// memoryGovernor sheds load while the heap exceeds its share of the
+// memory budget, until it falls below the recover ratio. While shedding it
+//   - lowers the prefetch limit, so fewer deliveries are held in memory,
+//   - flushes the storage batch early and drops in-memory caches on every
+//     check, and
+//   - has the workers drop low-priority events.
+type memoryGovernor struct {
+	cfg      *config.Config
+	store    storage.EventStore // nil without Postgres
+	consumer *consumer.Consumer
+	logger   *zap.Logger
+	on       atomic.Bool
+}
+
+// shedding reports whether load is being shed.
+func (g *memoryGovernor) shedding() bool {
+	return g.on.Load()
+}
+
+// shedPrefetch is the prefetch limit while shedding: enough to keep every
+// worker busy, but no more.
+func (g *memoryGovernor) shedPrefetch() int {
+	limit := max(1, g.cfg.WorkerPoolSize*g.cfg.WorkerBatchSize)
+	if g.cfg.RabbitMQPrefetch > 0 {
+		limit = min(limit, g.cfg.RabbitMQPrefetch)
+	}
+	return limit
+}
+
+// run samples the heap until ctx is cancelled.
+func (g *memoryGovernor) run(ctx context.Context) {
+	ticker := time.NewTicker(g.cfg.MemoryCheckInterval)
+	defer ticker.Stop()
+
+	sample := []runtimemetrics.Sample{{Name: heapObjectsMetric}}
+	shedAt := int64(float64(g.cfg.MemoryLimitBytes) * g.cfg.MemoryShedRatio)
+	recoverAt := int64(float64(g.cfg.MemoryLimitBytes) * g.cfg.MemoryRecoverRatio)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			runtimemetrics.Read(sample)
+			heap := int64(sample[0].Value.Uint64())
+			metrics.MemoryHeapBytes.Set(float64(heap))
+
+			switch {
+			case heap >= shedAt:
+				if !g.on.Load() {
+					g.on.Store(true)
+					metrics.MemoryShedding.Set(1)
+					g.logger.Warn("Heap above memory budget, shedding load",
+						zap.Int64("heap_bytes", heap),
+						zap.Int64("shed_at_bytes", shedAt),
+						zap.Int64("limit_bytes", g.cfg.MemoryLimitBytes),
+						zap.Int("prefetch", g.shedPrefetch()))
+					if err := g.consumer.SetPrefetch(g.shedPrefetch()); err != nil {
+						g.logger.Warn("Failed to lower prefetch", zap.Error(err))
+					}
+				}
+				if g.store != nil {
+					g.store.Shed()
+				}
+			case heap <= recoverAt && g.on.Load():
+				g.on.Store(false)
+				metrics.MemoryShedding.Set(0)
+				g.logger.Info("Heap back within memory budget, no longer shedding load",
+					zap.Int64("heap_bytes", heap),
+					zap.Int64("recover_at_bytes", recoverAt))
+				if err := g.consumer.SetPrefetch(g.cfg.RabbitMQPrefetch); err != nil {
+					g.logger.Warn("Failed to restore prefetch", zap.Error(err))
+				}
+			}
+		}
+	}
+}
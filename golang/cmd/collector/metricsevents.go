@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"observability_hub/golang/internal/collector/metrics"
+	"observability_hub/golang/internal/types"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.uber.org/zap"
+)
+
+// pendingMetrics is a decoded metrics event waiting in a worker batch,
+// with the deliveries of its chunks after the first, if it was chunked.
+type pendingMetrics struct {
+	delivery amqp.Delivery
+	event    *types.MetricsEvent
+	chunks   []amqp.Delivery
+}
+
+// handleMetrics decodes and checks a delivery carrying a metrics event and
+// adds it to the local batch. Metrics events skip the log event checks and
+// enrichment; they are validated against their validate tags and the
+// values of their metric type. It reports false, leaving the delivery
+// alone, if it does not carry a metrics event.
+func (w *worker) handleMetrics(d amqp.Delivery, chunks []amqp.Delivery) bool {
+	event, ok, err := decodeMetricsEvent(d)
+	if !ok {
+		return false
+	}
+	if err != nil {
+		w.logger.Error("Failed to unmarshal metrics event", zap.Error(err), zap.Int("workerId", w.id))
+		w.rejectMetrics(d, chunks, "")
+		return true
+	}
+	metricType := string(types.GetMetricType(event.EventType))
+	if w.metricsStore == nil {
+		// Kept in the DLQ, to be replayed once Postgres is a backend
+		w.logger.Warn("Rejecting metrics event, which is stored in Postgres only",
+			zap.String("eventId", event.EventID),
+			zap.String("service", event.Source.Service))
+		w.rejectMetrics(d, chunks, metricType)
+		return true
+	}
+	if !checkSignature(w.cfg, w.logger, d, event.EventID, event.Source.Service) {
+		w.rejectMetrics(d, chunks, metricType)
+		return true
+	}
+	err = types.Validate(event)
+	if err == nil {
+		err = event.CheckValues()
+	}
+	if err != nil {
+		w.logger.Warn("Rejecting invalid metrics event",
+			zap.Error(err),
+			zap.String("eventId", event.EventID),
+			zap.String("service", event.Source.Service))
+		w.rejectMetrics(d, chunks, metricType)
+		return true
+	}
+
+	w.rates.Observe(event.Source.Service)
+	w.metricsEvents = append(w.metricsEvents, pendingMetrics{delivery: d, event: event, chunks: chunks})
+	return true
+}
+
+// rejectMetrics dead-letters the deliveries of a metrics event that failed
+// decoding or its checks.
+func (w *worker) rejectMetrics(d amqp.Delivery, chunks []amqp.Delivery, metricType string) {
+	metrics.MetricsEvents.WithLabelValues(metricType, metrics.OutcomeRejected).Inc()
+	for _, part := range append([]amqp.Delivery{d}, chunks...) {
+		w.nack(part, false)
+	}
+}
+
+// flushMetrics writes the metrics events of the local batch and requeues
+// them if that fails. It returns the deliveries to acknowledge. The write
+// outlives ctx, so that the final flush on shutdown still stores them.
+func (w *worker) flushMetrics(ctx context.Context) []amqp.Delivery {
+	if len(w.metricsEvents) == 0 {
+		return nil
+	}
+	pending := w.metricsEvents
+	w.metricsEvents = nil
+
+	events := make([]*types.MetricsEvent, len(pending))
+	for i, p := range pending {
+		events[i] = p.event
+	}
+	err := w.metricsStore.AddMetrics(context.WithoutCancel(ctx), events)
+	outcome := metrics.OutcomeAccepted
+	if err != nil {
+		outcome = metrics.OutcomeRequeued
+		w.logger.Warn("Failed to store metrics events, requeueing", zap.Error(err), zap.Int("events", len(events)))
+	}
+
+	var acks []amqp.Delivery
+	for _, p := range pending {
+		metrics.MetricsEvents.WithLabelValues(string(types.GetMetricType(p.event.EventType)), outcome).Inc()
+		for _, d := range append([]amqp.Delivery{p.delivery}, p.chunks...) {
+			if err != nil {
+				w.nack(d, true)
+			} else if !w.cfg.AutoAck {
+				acks = append(acks, d)
+			}
+		}
+	}
+	return acks
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"observability_hub/golang/internal/types"
+
+	"github.com/google/uuid"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// metricsEventCase is a valid metrics event of one metric type and an
+// invalid variant of it.
+type metricsEventCase struct {
+	metricType types.MetricType
+	valid      func(e *types.MetricsEvent)
+	invalid    func(e *types.MetricsEvent)
+}
+
+func float(v float64) *float64 { return &v }
+func count(v int64) *int64     { return &v }
+
+var metricsEventCases = []metricsEventCase{
+	{
+		metricType: types.MetricTypeCounter,
+		valid:      func(e *types.MetricsEvent) { e.Data.Value = float(42) },
+		invalid:    func(e *types.MetricsEvent) { e.Data.Value = float(-1) },
+	},
+	{
+		metricType: types.MetricTypeGauge,
+		valid:      func(e *types.MetricsEvent) { e.Data.Value = float(-3.5) },
+		invalid:    func(e *types.MetricsEvent) { e.Data.Value = nil },
+	},
+	{
+		metricType: types.MetricTypeHistogram,
+		valid: func(e *types.MetricsEvent) {
+			e.Data.Count, e.Data.Sum = count(10), float(12.5)
+			e.Data.Buckets = []types.HistogramBucket{{UpperBound: 0.5, Count: 4}, {UpperBound: 1, Count: 9}}
+		},
+		invalid: func(e *types.MetricsEvent) {
+			e.Data.Count, e.Data.Sum = count(10), float(12.5)
+			e.Data.Buckets = []types.HistogramBucket{{UpperBound: 0.5, Count: 9}, {UpperBound: 1, Count: 4}}
+		},
+	},
+	{
+		metricType: types.MetricTypeSummary,
+		valid: func(e *types.MetricsEvent) {
+			e.Data.Count, e.Data.Sum = count(10), float(12.5)
+			e.Data.Quantiles = []types.SummaryQuantile{{Quantile: 0.5, Value: 1.1}, {Quantile: 0.99, Value: 2.4}}
+		},
+		invalid: func(e *types.MetricsEvent) {
+			e.Data.Count, e.Data.Sum = count(10), float(12.5)
+			e.Data.Quantiles = []types.SummaryQuantile{{Quantile: 1.5, Value: 1.1}}
+		},
+	},
+}
+
+// newMetricsEvent returns a metrics event of the given type, completed by
+// set.
+func newMetricsEvent(metricType types.MetricType, set func(e *types.MetricsEvent)) *types.MetricsEvent {
+	event := types.NewMetricsEvent(metricType, "http_requests", uuid.NewString(), types.EventSource{Service: "checkout", Version: "1.2.3"})
+	event.EventID = uuid.NewString()
+	event.SetLabel("route", "/orders")
+	set(event)
+	return event
+}
+
+func TestDecodeMetricsEvent(t *testing.T) {
+	for _, tt := range metricsEventCases {
+		t.Run(string(tt.metricType), func(t *testing.T) {
+			want := newMetricsEvent(tt.metricType, tt.valid)
+			event, ok, err := decodeMetricsEvent(delivery(t, nil, 1, want))
+			if !ok || err != nil {
+				t.Fatalf("decodeMetricsEvent = %t, %v, want a metrics event", ok, err)
+			}
+			if event.EventID != want.EventID || event.EventType != want.EventType {
+				t.Errorf("decoded %s %s, want %s %s", event.EventID, event.EventType, want.EventID, want.EventType)
+			}
+			if event.Data.Type != tt.metricType || event.Data.Name != "http_requests" || event.Data.Labels["route"] != "/orders" {
+				t.Errorf("decoded data %+v", event.Data)
+			}
+			if err := types.Validate(event); err != nil {
+				t.Errorf("Validate: %v", err)
+			}
+			if err := event.CheckValues(); err != nil {
+				t.Errorf("CheckValues: %v", err)
+			}
+
+			invalid := newMetricsEvent(tt.metricType, tt.invalid)
+			event, ok, err = decodeMetricsEvent(delivery(t, nil, 1, invalid))
+			if !ok || err != nil {
+				t.Fatalf("decodeMetricsEvent of the invalid event = %t, %v", ok, err)
+			}
+			if types.Validate(event) == nil && event.CheckValues() == nil {
+				t.Error("invalid event passed its checks")
+			}
+		})
+	}
+}
+
+func TestDecodeMetricsEventLeavesLogEvents(t *testing.T) {
+	if _, ok, err := decodeMetricsEvent(delivery(t, nil, 1, logEventDoc())); ok || err != nil {
+		t.Errorf("decodeMetricsEvent of a log event = %t, %v, want it left alone", ok, err)
+	}
+	malformed := amqp.Delivery{Body: []byte(`{"eventType":"metrics.gauge.updated","data":{"value":"high"}}`)}
+	if _, ok, err := decodeMetricsEvent(malformed); !ok || err == nil {
+		t.Errorf("decodeMetricsEvent of a malformed metrics event = %t, %v, want an error", ok, err)
+	}
+}
+
+func TestHandleDispatchesMetricsEvents(t *testing.T) {
+	for _, tt := range metricsEventCases {
+		t.Run(string(tt.metricType), func(t *testing.T) {
+			store := &fakeStore{}
+			w := newTestWorker(testConfig(t), store)
+			ack := &fakeAcknowledger{}
+
+			valid := newMetricsEvent(tt.metricType, tt.valid)
+			// An update of the metric is dispatched the same way
+			valid.EventType = "metrics." + string(tt.metricType) + ".updated"
+			w.handle(delivery(t, ack, 1, valid))
+			w.handle(delivery(t, ack, 2, newMetricsEvent(tt.metricType, tt.invalid)))
+			w.handle(delivery(t, ack, 3, logEventDoc()))
+			if len(w.metricsEvents) != 1 || len(w.pending) != 1 {
+				t.Fatalf("batched %d metrics and %d log events, want 1 of each", len(w.metricsEvents), len(w.pending))
+			}
+			w.flush(context.Background())
+
+			if len(store.metrics) != 1 || store.metrics[0].EventID != valid.EventID {
+				t.Errorf("stored metrics events %v, want the valid one", store.metrics)
+			}
+			if stored := store.stored(); len(stored) != 1 || stored[0].EventType != "log.message.created" {
+				t.Errorf("stored log events %v, want the log event", stored)
+			}
+			for tag, want := range map[uint64]string{1: "ack", 2: "dead-letter", 3: "ack"} {
+				if got := ack.settled(tag); got != want {
+					t.Errorf("delivery %d settled as %q, want %q", tag, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleRejectsMetricsEventsWithoutPostgres(t *testing.T) {
+	store := &fakeStore{}
+	w := newTestWorker(testConfig(t), store)
+	w.metricsStore = nil
+	ack := &fakeAcknowledger{}
+
+	w.handle(delivery(t, ack, 1, newMetricsEvent(types.MetricTypeCounter, metricsEventCases[0].valid)))
+	w.flush(context.Background())
+	if got := ack.settled(1); got != "dead-letter" {
+		t.Errorf("delivery settled as %q, want dead-letter", got)
+	}
+}
+
+// The metrics event marshals its data; check the test input is what the
+// producers send
+func TestMetricsEventDocument(t *testing.T) {
+	body, err := json.Marshal(newMetricsEvent(types.MetricTypeGauge, metricsEventCases[1].valid))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		EventType string `json:"eventType"`
+		Data      struct {
+			Type  string  `json:"type"`
+			Value float64 `json:"value"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.EventType != "metrics.gauge.created" || doc.Data.Type != "gauge" || doc.Data.Value != -3.5 {
+		t.Errorf("document = %s", body)
+	}
+}
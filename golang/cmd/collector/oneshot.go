@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"observability_hub/golang/internal/collector/config"
+	"observability_hub/golang/internal/collector/consumer"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// maxOneShotPoll caps the interval between two queue inspections.
+const maxOneShotPoll = time.Second
+
+// watchOneShot stops the collector once a one-shot run is complete: the
+// queue is empty and no message was processed for the idle timeout, or one
+// of the message/time limits was reached. The idle timeout keeps a momentary
+// lull from ending the run early. Calling stop triggers the regular graceful
+// shutdown, which flushes the pending batch.
+func watchOneShot(ctx context.Context, cfg *config.Config, logger *zap.Logger, rmq *consumer.Consumer, processed *atomic.Int64, stop func()) {
+	poll := cfg.OneShotIdleTimeout / 4
+	if poll <= 0 || poll > maxOneShotPoll {
+		poll = maxOneShotPoll
+	}
+	ticker := time.NewTicker(poll)
+	defer ticker.Stop()
+
+	start := time.Now()
+	lastCount, lastActivity := processed.Load(), start
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			count := processed.Load()
+			if count != lastCount {
+				lastCount, lastActivity = count, now
+			}
+
+			if cfg.OneShotMaxMessages > 0 && count >= int64(cfg.OneShotMaxMessages) {
+				logger.Info("One-shot message limit reached", zap.Int64("processed", count))
+				stop()
+				return
+			}
+			if cfg.OneShotMaxDuration > 0 && now.Sub(start) >= cfg.OneShotMaxDuration {
+				logger.Info("One-shot time limit reached", zap.Int64("processed", count))
+				stop()
+				return
+			}
+			if now.Sub(lastActivity) < cfg.OneShotIdleTimeout {
+				continue
+			}
+
+			depth, err := rmq.QueueDepth()
+			if err != nil {
+				logger.Warn("Failed to inspect queue depth", zap.Error(err))
+				continue
+			}
+			if depth == 0 {
+				logger.Info("Queue drained, finishing one-shot run", zap.Int64("processed", count))
+				stop()
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"observability_hub/golang/internal/collector/config"
+	"observability_hub/golang/internal/collector/metrics"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// eventPriorities are the metadata.priority values, highest first.
+var eventPriorities = []string{"critical", "high", "normal", "low"}
+
+// defaultPriority is assumed for deliveries without a known priority.
+const defaultPriority = "normal"
+
+// priorityDispatcher sorts deliveries by metadata.priority so that workers
+// reserved for a priority serve it first. Reserved workers help with the
+// other priorities while theirs has nothing waiting; the other workers
+// take any priority. Deliveries arrive through the one queue in order, so
+// this only reorders what was already received: RABBITMQ_PREFETCH bounds
+// how far a flood of lower priorities can delay a critical event.
+type priorityDispatcher struct {
+	cfg    *config.Config
+	queues map[string]chan amqp.Delivery
+}
+
+func newPriorityDispatcher(cfg *config.Config) *priorityDispatcher {
+	// Large enough that the unacked deliveries always fit, so that sorting
+	// never blocks on a full priority
+	size := max(1, cfg.RabbitMQPrefetch*max(1, cfg.ConsumerChannels), cfg.WorkerPoolSize*cfg.WorkerBatchSize)
+	queues := make(map[string]chan amqp.Delivery, len(eventPriorities))
+	for _, priority := range eventPriorities {
+		queues[priority] = make(chan amqp.Delivery, size)
+	}
+	return &priorityDispatcher{cfg: cfg, queues: queues}
+}
+
+// run sorts the deliveries until the channel closes, then closes the
+// priority queues.
+func (p *priorityDispatcher) run(deliveries <-chan amqp.Delivery) {
+	defer func() {
+		for _, q := range p.queues {
+			close(q)
+		}
+	}()
+	for d := range deliveries {
+		p.queues[deliveryPriority(d)] <- d
+	}
+}
+
+// reservation returns the priority worker i is reserved for, or "" for a
+// shared worker. Reservations go to the first workers, highest priority
+// first.
+func (p *priorityDispatcher) reservation(i int) string {
+	for _, priority := range eventPriorities {
+		n := p.cfg.WorkerReservations[priority]
+		if i < n {
+			return priority
+		}
+		i -= n
+	}
+	return ""
+}
+
+// feed returns the deliveries for a worker reserved for priority, or for
+// a shared worker when priority is empty. The channel closes once the
+// priority queues are drained and closed, or ctx is cancelled.
+func (p *priorityDispatcher) feed(ctx context.Context, priority string) <-chan amqp.Delivery {
+	out := make(chan amqp.Delivery)
+	go func() {
+		defer close(out)
+		for {
+			d, from, ok := p.next(ctx, priority)
+			if !ok {
+				return
+			}
+			servedBy := "shared"
+			if priority == from {
+				servedBy = "reserved"
+			} else if priority != "" {
+				servedBy = "stolen"
+			}
+			metrics.PriorityDeliveries.WithLabelValues(from, servedBy).Inc()
+
+			select {
+			case out <- d:
+			case <-ctx.Done():
+				// Unacknowledged, so redelivered once the channel closes
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// next takes a delivery of the reserved priority if one is waiting, and
+// otherwise the next delivery of any priority.
+func (p *priorityDispatcher) next(ctx context.Context, priority string) (amqp.Delivery, string, bool) {
+	if q, ok := p.queues[priority]; ok {
+		select {
+		case d, ok := <-q:
+			if ok {
+				return d, priority, true
+			}
+		default:
+		}
+	}
+
+	critical, high, normal, low := p.queues["critical"], p.queues["high"], p.queues["normal"], p.queues["low"]
+	for critical != nil || high != nil || normal != nil || low != nil {
+		select {
+		case <-ctx.Done():
+			return amqp.Delivery{}, "", false
+		case d, ok := <-critical:
+			if ok {
+				return d, "critical", true
+			}
+			critical = nil
+		case d, ok := <-high:
+			if ok {
+				return d, "high", true
+			}
+			high = nil
+		case d, ok := <-normal:
+			if ok {
+				return d, "normal", true
+			}
+			normal = nil
+		case d, ok := <-low:
+			if ok {
+				return d, "low", true
+			}
+			low = nil
+		}
+	}
+	return amqp.Delivery{}, "", false
+}
+
+// deliveryPriority reads metadata.priority from a plain or structured
+// CloudEvents delivery without decoding the whole event. Chunks and bodies
+// that do not parse count as normal; the worker rejects them if need be.
+func deliveryPriority(d amqp.Delivery) string {
+	if isChunk(d) {
+		return defaultPriority
+	}
+	var probe struct {
+		Metadata struct {
+			Priority string `json:"priority"`
+		} `json:"metadata"`
+		Data struct {
+			Metadata struct {
+				Priority string `json:"priority"`
+			} `json:"metadata"`
+		} `json:"data"` // CloudEvents envelope
+	}
+	if err := json.Unmarshal(d.Body, &probe); err != nil {
+		return defaultPriority
+	}
+	priority := probe.Metadata.Priority
+	if priority == "" {
+		priority = probe.Data.Metadata.Priority
+	}
+	switch priority {
+	case "critical", "high", "normal", "low":
+		return priority
+	}
+	return defaultPriority
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"observability_hub/golang/internal/collector/config"
+	"observability_hub/golang/internal/collector/storage"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// runReindex implements the "reindex" subcommand. It streams the stored
+// events from Postgres and indexes them into Elasticsearch through the
+// regular bulk path. Progress is checkpointed after every page, so an
+// interrupted run resumes where it stopped when started again with the
+// same checkpoint file.
+func runReindex(cfg *config.Config, logger *zap.Logger, args []string) error {
+	flags := flag.NewFlagSet("reindex", flag.ContinueOnError)
+	from := flags.String("from", "", "start of the time range (RFC 3339, inclusive)")
+	to := flags.String("to", "", "end of the time range (RFC 3339, exclusive)")
+	service := flags.String("service", "", "only reindex events of this service")
+	pageSize := flags.Int("batch-size", 1000, "number of events per bulk request")
+	checkpointPath := flags.String("checkpoint", "reindex.checkpoint", "file recording progress; empty disables resuming")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *pageSize <= 0 {
+		return fmt.Errorf("batch-size must be positive, got %d", *pageSize)
+	}
+
+	filter := storage.LogFilter{Service: *service}
+	var err error
+	if filter.From, err = parseTimeFlag("from", *from); err != nil {
+		return err
+	}
+	if filter.To, err = parseTimeFlag("to", *to); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	reader, err := storage.NewLogReader(cfg, logger)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	esStorage, err := storage.NewESStorage(cfg, logger)
+	if err != nil {
+		return err
+	}
+	defer esStorage.Close()
+
+	cursor, err := loadCheckpoint(*checkpointPath)
+	if err != nil {
+		return err
+	}
+	if cursor != nil {
+		logger.Info("Resuming reindex from checkpoint",
+			zap.Time("timestamp", cursor.Timestamp),
+			zap.String("event_id", cursor.EventID))
+	}
+
+	total := 0
+	for {
+		events, err := reader.ReadPage(ctx, filter, cursor, *pageSize)
+		if err != nil {
+			return err
+		}
+		if len(events) == 0 {
+			break
+		}
+
+		if err := esStorage.BulkIndexLogEvents(ctx, events); err != nil {
+			return fmt.Errorf("failed to index page after %d events: %w", total, err)
+		}
+
+		last := events[len(events)-1]
+		cursor = &storage.LogCursor{Timestamp: last.Timestamp, EventID: last.EventID}
+		if err := saveCheckpoint(*checkpointPath, cursor); err != nil {
+			return err
+		}
+		total += len(events)
+		logger.Info("Reindexed page", zap.Int("count", len(events)), zap.Int("total", total), zap.Time("up_to", cursor.Timestamp))
+	}
+
+	logger.Info("Reindex completed", zap.Int("total", total))
+	if *checkpointPath != "" {
+		// A finished run must not make the next one start at the end.
+		if err := os.Remove(*checkpointPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove checkpoint: %w", err)
+		}
+	}
+	return nil
+}
+
+func parseTimeFlag(name, value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid -%s: %w", name, err)
+	}
+	return t, nil
+}
+
+// loadCheckpoint returns the cursor stored at path, or nil if there is none.
+func loadCheckpoint(path string) (*storage.LogCursor, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+
+	var cursor storage.LogCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, fmt.Errorf("failed to decode checkpoint %s: %w", path, err)
+	}
+	return &cursor, nil
+}
+
+// saveCheckpoint atomically replaces the checkpoint at path with cursor.
+func saveCheckpoint(path string, cursor *storage.LogCursor) error {
+	if path == "" {
+		return nil
+	}
+	data, err := json.Marshal(cursor)
+	if err != nil {
+		return fmt.Errorf("failed to encode checkpoint: %w", err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	return nil
+}
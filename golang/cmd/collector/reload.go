@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"observability_hub/golang/internal/collector/config"
+	"observability_hub/golang/internal/collector/metrics"
+	"observability_hub/golang/internal/collector/storage"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// reloadable are the settings a reload applies to the running storage, by
+// Config field name.
+var reloadable = map[string]bool{
+	"BatchSize":     true,
+	"BatchTimeout":  true,
+	"RetryMax":      true,
+	"RetryInterval": true,
+}
+
+// redacted stands in for the value of a secret setting in the audit.
+const redacted = "[REDACTED]"
+
+// reloadTarget is the storage a reload reconfigures and, with
+// CONFIG_AUDIT_EVENTS, writes its audit event to.
+type reloadTarget interface {
+	storage.Storage
+	Reconfigure(cfg *config.Config)
+}
+
+// settingChange is one changed setting of a reload, with its values
+// redacted.
+type settingChange struct {
+	Setting string `json:"setting"`
+	Old     string `json:"old"`
+	New     string `json:"new"`
+	Applied bool   `json:"applied"` // False for the settings requiring a restart
+}
+
+// reloadAudit records who reloaded the configuration, when, and what
+// changed.
+type reloadAudit struct {
+	Trigger string          `json:"trigger"` // The signal, e.g. "hangup", or "admin"
+	Actor   string          `json:"actor,omitempty"`
+	Time    time.Time       `json:"time"`
+	Outcome string          `json:"outcome"` // applied, unchanged or failed
+	Error   string          `json:"error,omitempty"`
+	Changes []settingChange `json:"changes,omitempty"`
+}
+
+// reloader re-reads the configuration and applies the reloadable settings
+// to the storage, one reload at a time. Every reload is audited to the
+// audit logger, and with CONFIG_AUDIT_EVENTS also stored as a log event.
+type reloader struct {
+	mu      sync.Mutex
+	current config.Config
+	store   reloadTarget
+	load    func() (*config.Config, error)
+	now     func() time.Time
+	logger  *zap.Logger
+	audit   *zap.Logger
+}
+
+func newReloader(cfg *config.Config, store reloadTarget, logger *zap.Logger) *reloader {
+	return &reloader{
+		current: *cfg,
+		store:   store,
+		load:    config.Load,
+		now:     time.Now,
+		logger:  logger,
+		audit:   logger.Named("audit"),
+	}
+}
+
+// reloadOnSignal reloads the configuration on every signal until ctx is
+// cancelled.
+func (r *reloader) reloadOnSignal(ctx context.Context, signals <-chan os.Signal) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case sig := <-signals:
+			r.reload(sig.String(), "")
+		}
+	}
+}
+
+// reload re-reads the configuration and applies its reloadable settings.
+// Every other changed setting is audited as requiring a restart. A
+// configuration that fails to load leaves the current settings in place.
+// Secrets are redacted from the returned audit record.
+func (r *reloader) reload(trigger, actor string) reloadAudit {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	record := reloadAudit{Trigger: trigger, Actor: actor, Time: r.now().UTC()}
+	next, err := r.load()
+	if err != nil {
+		record.Outcome, record.Error = "failed", err.Error()
+		r.record(record)
+		return record
+	}
+
+	old, new := reflect.ValueOf(r.current), reflect.ValueOf(*next)
+	var restart []string
+	for _, name := range changedSettings(&r.current, next) {
+		record.Changes = append(record.Changes, settingChange{
+			Setting: name,
+			Old:     settingValue(name, old.FieldByName(name)),
+			New:     settingValue(name, new.FieldByName(name)),
+			Applied: reloadable[name],
+		})
+		if !reloadable[name] {
+			restart = append(restart, name)
+		}
+	}
+	if len(restart) > 0 {
+		r.logger.Warn("Changed settings require restart and were not applied",
+			zap.String("trigger", trigger), zap.Strings("settings", restart))
+	}
+
+	record.Outcome = "unchanged"
+	if len(restart) < len(record.Changes) {
+		r.store.Reconfigure(next)
+		r.current.BatchSize, r.current.BatchTimeout = next.BatchSize, next.BatchTimeout
+		r.current.RetryMax, r.current.RetryInterval = next.RetryMax, next.RetryInterval
+		record.Outcome = "applied"
+	}
+	r.record(record)
+	return record
+}
+
+// record counts the reload, logs its audit record and, with
+// CONFIG_AUDIT_EVENTS, stores the changes as a log event.
+func (r *reloader) record(record reloadAudit) {
+	metrics.ConfigReloads.WithLabelValues(record.Outcome).Inc()
+	fields := []zap.Field{
+		zap.String("trigger", record.Trigger),
+		zap.String("actor", record.Actor),
+		zap.Time("time", record.Time),
+		zap.String("outcome", record.Outcome),
+		zap.Any("changes", record.Changes),
+	}
+	if record.Outcome == "failed" {
+		r.audit.Error("Configuration reload failed, keeping the current settings", append(fields, zap.String("error", record.Error))...)
+		return
+	}
+	r.audit.Info("Configuration reloaded", fields...)
+
+	if !r.current.ConfigAuditEvents || len(record.Changes) == 0 {
+		return
+	}
+	if err := r.store.AddBatch([]*storage.LogEvent{r.auditEvent(record)}); err != nil {
+		r.logger.Warn("Failed to store the configuration audit event", zap.Error(err))
+	}
+}
+
+// auditEvent returns the log event storing record.
+func (r *reloader) auditEvent(record reloadAudit) *storage.LogEvent {
+	changes := make([]any, len(record.Changes))
+	for i, change := range record.Changes {
+		changes[i] = map[string]any{"setting": change.Setting, "old": change.Old, "new": change.New, "applied": change.Applied}
+	}
+	structured := storage.JSONB{
+		"trigger": record.Trigger,
+		"actor":   record.Actor,
+		"outcome": record.Outcome,
+		"changes": changes,
+	}
+	instance := r.current.InstanceID
+	return &storage.LogEvent{
+		EventID:       uuid.NewString(),
+		EventType:     "log.info.created",
+		Version:       "1.0.0",
+		Timestamp:     record.Time,
+		CorrelationID: uuid.NewString(),
+		Source:        storage.Source{Service: "collector", Version: r.current.PipelineVersion, Instance: &instance},
+		Data: storage.LogData{
+			Level:      "INFO",
+			Message:    "Configuration changed",
+			Timestamp:  record.Time,
+			Structured: &structured,
+		},
+		Metadata:   storage.Metadata{Priority: "normal", Tags: []string{"config-audit"}},
+		ReceivedAt: time.Now(),
+	}
+}
+
+// settingValue formats the value of the Config field name for the audit.
+// Passwords, tokens and signing keys are redacted unless unset, and URLs
+// are reduced to their scheme and host: credentials may sit in the user
+// info, the query or, for webhooks, the path.
+func settingValue(name string, value reflect.Value) string {
+	switch {
+	case isUnset(value):
+		return fmt.Sprint(value.Interface())
+	case strings.HasSuffix(name, "Password"), strings.HasSuffix(name, "Token"),
+		strings.HasSuffix(name, "Secret"), strings.HasPrefix(name, "SignatureKey"):
+		return redacted
+	case strings.HasSuffix(name, "URL"):
+		return redactURL(value.String())
+	case strings.HasSuffix(name, "URLs"):
+		urls := make([]string, value.Len())
+		for i := range urls {
+			urls[i] = redactURL(value.Index(i).String())
+		}
+		return fmt.Sprint(urls)
+	}
+	return fmt.Sprint(value.Interface())
+}
+
+// isUnset reports whether value is zero or an empty map or slice.
+func isUnset(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Map, reflect.Slice:
+		return value.Len() == 0
+	}
+	return value.IsZero()
+}
+
+// redactURL returns the scheme and host of raw, or the redacted marker if
+// it does not parse as a URL with a host.
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return redacted
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// changedSettings returns the names of the Config fields that differ.
+func changedSettings(old, new *config.Config) []string {
+	a, b := reflect.ValueOf(old).Elem(), reflect.ValueOf(new).Elem()
+	var names []string
+	for i := 0; i < a.NumField(); i++ {
+		if !reflect.DeepEqual(a.Field(i).Interface(), b.Field(i).Interface()) {
+			names = append(names, a.Type().Field(i).Name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"observability_hub/golang/internal/collector/config"
+	"strings"
+)
+
+// reloadHandler serves POST /admin/reload-config, reloading the
+// configuration like a SIGHUP does. Requests must carry the configured
+// token as a bearer token; the X-Requested-By header names the actor in the
+// audit record, which defaults to the client address.
+type reloadHandler struct {
+	cfg      *config.Config
+	reloader *reloader
+}
+
+// ServeHTTP reloads the configuration and responds with the audit record
+// as JSON.
+func (h *reloadHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(h.cfg.ConfigReloadToken)) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	actor := r.Header.Get("X-Requested-By")
+	if actor == "" {
+		actor = r.RemoteAddr
+	}
+	record := h.reloader.reload("admin", actor)
+
+	w.Header().Set("Content-Type", "application/json")
+	if record.Outcome == "failed" {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	}
+	json.NewEncoder(w).Encode(record)
+}
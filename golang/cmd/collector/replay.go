@@ -0,0 +1,83 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"observability_hub/golang/internal/collector/config"
+	"observability_hub/golang/internal/collector/consumer"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+)
+
+// Number of messages one replay request moves by default and at most.
+const (
+	defaultReplayLimit = 100
+	maxReplayLimit     = 10000
+)
+
+// replayHandler serves POST /admin/replay-dlq, republishing dead-lettered
+// messages to the main queue once the bug that rejected them is fixed.
+// Requests must carry the configured token as a bearer token; one replay
+// runs at a time.
+type replayHandler struct {
+	cfg      *config.Config
+	consumer *consumer.Consumer
+	logger   *zap.Logger
+	running  atomic.Bool
+}
+
+// ServeHTTP replays up to the limit query parameter of messages and
+// responds with the counts as JSON.
+func (h *replayHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(h.cfg.DLQReplayToken)) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	limit := defaultReplayLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, maxReplayLimit)
+	}
+
+	if !h.running.CompareAndSwap(false, true) {
+		http.Error(w, "a replay is already running", http.StatusConflict)
+		return
+	}
+	defer h.running.Store(false)
+
+	result, err := h.consumer.ReplayDLQ(r.Context(), limit, h.cfg.DLQReplayMaxReplays)
+	fields := []zap.Field{
+		zap.Int("limit", limit),
+		zap.Int("replayed", result.Replayed),
+		zap.Int("failed", result.Failed),
+		zap.Int("skipped", result.Skipped),
+	}
+	if err != nil {
+		h.logger.Error("DLQ replay failed", append(fields, zap.Error(err))...)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadGateway)
+		json.NewEncoder(w).Encode(struct {
+			consumer.ReplayResult
+			Error string `json:"error"`
+		}{result, err.Error()})
+		return
+	}
+	h.logger.Info("DLQ replay completed", fields...)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
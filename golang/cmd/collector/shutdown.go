@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// shutdownStep is one component's part of the graceful shutdown.
+type shutdownStep struct {
+	name string
+	run  func(ctx context.Context) error
+}
+
+// shutdownSequence stops the collector's components in a fixed order, e.g.
+// the database is flushed only after the workers drained and Redis is
+// closed only after that flush, which still needs it. The whole sequence is
+// bounded by one timeout; a step that overruns it is abandoned and the
+// remaining steps are skipped.
+type shutdownSequence struct {
+	logger *zap.Logger
+	steps  []shutdownStep
+}
+
+// add appends a step. Steps run in the order they were added.
+func (s *shutdownSequence) add(name string, run func(ctx context.Context) error) {
+	s.steps = append(s.steps, shutdownStep{name: name, run: run})
+}
+
+// run executes the steps one after another within timeout.
+func (s *shutdownSequence) run(timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	start := time.Now()
+	s.logger.Info("Starting graceful shutdown", zap.Int("steps", len(s.steps)), zap.Duration("timeout", timeout))
+	for i, step := range s.steps {
+		if ctx.Err() != nil {
+			s.logger.Error("Shutdown timeout exceeded, skipping remaining steps",
+				zap.Strings("skipped", stepNames(s.steps[i:])))
+			return
+		}
+
+		stepStart := time.Now()
+		done := make(chan error, 1)
+		go func() {
+			done <- step.run(ctx)
+		}()
+
+		select {
+		case err := <-done:
+			if err != nil {
+				s.logger.Error("Shutdown step failed", zap.String("step", step.name), zap.Error(err), zap.Duration("duration", time.Since(stepStart)))
+			} else {
+				s.logger.Info("Shutdown step completed", zap.String("step", step.name), zap.Duration("duration", time.Since(stepStart)))
+			}
+		case <-ctx.Done():
+			s.logger.Error("Shutdown step timed out", zap.String("step", step.name), zap.Duration("duration", time.Since(stepStart)))
+		}
+	}
+	s.logger.Info("Graceful shutdown completed", zap.Duration("duration", time.Since(start)))
+}
+
+func stepNames(steps []shutdownStep) []string {
+	names := make([]string, len(steps))
+	for i, step := range steps {
+		names[i] = step.name
+	}
+	return names
+}
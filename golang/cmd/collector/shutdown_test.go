@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// newTestShutdown returns a shutdown sequence logging to the returned logs.
+func newTestShutdown() (*shutdownSequence, *observer.ObservedLogs) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	return &shutdownSequence{logger: zap.New(core)}, logs
+}
+
+// stepRecorder records the steps that ran, in order.
+type stepRecorder struct {
+	mu  sync.Mutex
+	ran []string
+}
+
+func (r *stepRecorder) step(name string, err error) (string, func(context.Context) error) {
+	return name, func(context.Context) error {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.ran = append(r.ran, name)
+		return err
+	}
+}
+
+func (r *stepRecorder) steps() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.ran...)
+}
+
+func TestShutdownSequenceRunsStepsInOrder(t *testing.T) {
+	shutdown, logs := newTestShutdown()
+	var steps stepRecorder
+	shutdown.add(steps.step("stop consuming", nil))
+	shutdown.add(steps.step("drain workers", nil))
+	// A failed step does not stop the shutdown
+	shutdown.add(steps.step("flush database", errors.New("connection refused")))
+	shutdown.add(steps.step("close redis", nil))
+
+	shutdown.run(time.Second)
+
+	if got := fmt.Sprint(steps.steps()); got != "[stop consuming drain workers flush database close redis]" {
+		t.Errorf("steps ran as %s", got)
+	}
+	if failed := logs.FilterMessage("Shutdown step failed").All(); len(failed) != 1 || failed[0].ContextMap()["step"] != "flush database" {
+		t.Errorf("failed steps logged: %v", failed)
+	}
+	if logs.FilterMessage("Shutdown step completed").Len() != 3 || logs.FilterMessage("Graceful shutdown completed").Len() != 1 {
+		t.Errorf("logged %v", logs.All())
+	}
+}
+
+func TestShutdownSequenceAbandonsStepsOverrunningTheTimeout(t *testing.T) {
+	shutdown, logs := newTestShutdown()
+	var steps stepRecorder
+	release := make(chan struct{})
+	defer close(release)
+
+	shutdown.add(steps.step("stop consuming", nil))
+	// Ignores the context, as a stuck flush would
+	shutdown.add("flush database", func(context.Context) error {
+		<-release
+		return nil
+	})
+	shutdown.add(steps.step("close elasticsearch", nil))
+	shutdown.add(steps.step("close redis", nil))
+
+	timeout := 50 * time.Millisecond
+	start := time.Now()
+	shutdown.run(timeout)
+	if elapsed := time.Since(start); elapsed > timeout+time.Second {
+		t.Errorf("run returned after %s, want about the %s timeout", elapsed, timeout)
+	}
+
+	if got := fmt.Sprint(steps.steps()); got != "[stop consuming]" {
+		t.Errorf("steps ran as %s, want the ones after the stuck step skipped", got)
+	}
+	if timedOut := logs.FilterMessage("Shutdown step timed out").All(); len(timedOut) != 1 || timedOut[0].ContextMap()["step"] != "flush database" {
+		t.Errorf("timed out steps logged: %v", timedOut)
+	}
+	skipped := logs.FilterMessage("Shutdown timeout exceeded, skipping remaining steps").All()
+	if len(skipped) != 1 || fmt.Sprint(skipped[0].ContextMap()["skipped"]) != "[close elasticsearch close redis]" || skipped[0].Level != zapcore.ErrorLevel {
+		t.Errorf("skipped steps logged: %v, want one error naming them", skipped)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"observability_hub/golang/internal/collector/config"
+	"observability_hub/golang/internal/collector/storage"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// maxStartupBackoff caps the delay between two connection attempts at startup.
+const maxStartupBackoff = 30 * time.Second
+
+// waitForDependency calls connect until it succeeds, the configured startup
+// wait timeout elapses or ctx is cancelled. Attempts back off exponentially,
+// starting at the configured retry interval. When waiting is disabled for the
+// dependency, connect is attempted exactly once. Errors that retrying cannot
+// fix (see isPermanent) are returned immediately.
+func waitForDependency[T any](ctx context.Context, cfg *config.Config, logger *zap.Logger, name string, enabled bool, connect func() (T, error)) (T, error) {
+	if !enabled {
+		return connect()
+	}
+
+	deadline := time.Now().Add(cfg.StartupWaitTimeout)
+	backoff := cfg.RetryInterval
+	for attempt := 1; ; attempt++ {
+		dep, err := connect()
+		if err == nil {
+			if attempt > 1 {
+				logger.Info("Dependency is ready", zap.String("dependency", name), zap.Int("attempts", attempt))
+			}
+			return dep, nil
+		}
+
+		if isPermanent(err) {
+			var zero T
+			return zero, err
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			var zero T
+			return zero, fmt.Errorf("%s not ready after %s: %w", name, cfg.StartupWaitTimeout, err)
+		}
+		if backoff > remaining {
+			backoff = remaining
+		}
+
+		logger.Warn("Dependency not ready, waiting before retrying",
+			zap.String("dependency", name),
+			zap.Int("attempt", attempt),
+			zap.Duration("backoff", backoff),
+			zap.Duration("remaining", remaining),
+			zap.Error(err))
+
+		select {
+		case <-ctx.Done():
+			var zero T
+			return zero, fmt.Errorf("gave up waiting for %s: %w", name, ctx.Err())
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > maxStartupBackoff {
+			backoff = maxStartupBackoff
+		}
+	}
+}
+
+// isPermanent reports whether a startup error should not be retried.
+// A migration lock timeout under the "fail" policy is meant to fail fast,
+// and an incompatible Elasticsearch version will not fix itself.
+func isPermanent(err error) bool {
+	return errors.Is(err, storage.ErrMigrationLockTimeout) || errors.Is(err, storage.ErrESVersionIncompatible)
+}
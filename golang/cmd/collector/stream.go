@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"observability_hub/golang/internal/collector/metrics"
+	"observability_hub/golang/internal/collector/storage"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// unsafeEscapes matches JSON escapes of text Postgres rejects in JSONB:
+// NUL and UTF-16 surrogates, which are only valid in pairs.
+var unsafeEscapes = regexp.MustCompile(`\\u(0000|[dD][89a-fA-F])`)
+
+// streamEvent decodes a large event token by token. data.structured, where
+// the bulk of a large event is, stays raw JSON in Data.StructuredRaw
+// instead of becoming a map; the other fields are small and decoded with
+// the regular field mapping. Raw payloads skip enrichment and the
+// performance columns, which need the decoded map.
+func streamEvent(body []byte) (*storage.LogEvent, error) {
+	var event storage.LogEvent
+	dec := json.NewDecoder(bytes.NewReader(body))
+	err := streamObject(dec, &event, "data", func(dec *json.Decoder) error {
+		return streamObject(dec, &event.Data, "structured", func(dec *json.Decoder) error {
+			return decodeStructuredRaw(dec, &event.Data)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("invalid character after top-level value")
+	}
+
+	switch {
+	case event.Data.StructuredRaw != nil:
+		metrics.StreamingDecodes.WithLabelValues("raw").Inc()
+	case event.Data.Structured != nil:
+		metrics.StreamingDecodes.WithLabelValues("decoded").Inc()
+	default:
+		metrics.StreamingDecodes.WithLabelValues("absent").Inc()
+	}
+	return &event, nil
+}
+
+// streamObject decodes the JSON object at the decoder's position into v,
+// except for the member named key, which is handed to special. The other
+// members are gathered and decoded together. Like json.Unmarshal, a null
+// leaves v untouched and member names match case-insensitively.
+func streamObject(dec *json.Decoder, v interface{}, key string, special func(*json.Decoder) error) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok == nil {
+		return nil
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '{' {
+		return fmt.Errorf("expected a JSON object, got %v", tok)
+	}
+
+	rest := make(map[string]json.RawMessage)
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		name, ok := tok.(string)
+		if !ok {
+			return fmt.Errorf("expected an object key, got %v", tok)
+		}
+		if strings.EqualFold(name, key) {
+			if err := special(dec); err != nil {
+				return err
+			}
+			continue
+		}
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return err
+		}
+		rest[name] = raw
+	}
+	// Closing brace
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+
+	remaining, err := json.Marshal(rest)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(remaining, v)
+}
+
+// decodeStructuredRaw keeps data.structured as received. Payloads that are
+// not an object, or hold text Postgres would reject, are decoded after all,
+// so that they fail or get sanitized as in the regular path.
+func decodeStructuredRaw(dec *json.Decoder, data *storage.LogData) error {
+	var raw json.RawMessage
+	if err := dec.Decode(&raw); err != nil {
+		return err
+	}
+	data.Structured, data.StructuredRaw = nil, nil
+	if raw[0] == '{' && utf8.Valid(raw) && !unsafeEscapes.Match(raw) {
+		data.StructuredRaw = raw
+		return nil
+	}
+	return json.Unmarshal(raw, &data.Structured)
+}
@@ -0,0 +1,422 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"observability_hub/golang/internal/collector/config"
+	"observability_hub/golang/internal/collector/consumer"
+	"observability_hub/golang/internal/collector/enrich"
+	"observability_hub/golang/internal/collector/metrics"
+	"observability_hub/golang/internal/collector/schema"
+	"observability_hub/golang/internal/collector/storage"
+	"sync/atomic"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.uber.org/zap"
+)
+
+// worker decodes deliveries and hands them to storage in small local
+// batches, so that concurrent workers contend less on the storage buffer.
+// Deliveries are acknowledged only once their batch was accepted.
+type worker struct {
+	id            int
+	cfg           *config.Config
+	logger        *zap.Logger
+	store         storage.Storage      // The primary backend
+	metricsStore  storage.MetricsStore // nil without Postgres
+	es            *storage.Destination // nil unless Elasticsearch is written beside Postgres
+	kafka         *storage.Destination // nil without a Kafka sink
+	schemas       *schema.Registry
+	chunks        *chunkAssembler
+	enrichers     enrich.Chain
+	publisher     *consumer.Publisher
+	rates         *metrics.RateTracker
+	versions      *metrics.VersionTracker
+	outcomes      *metrics.OutcomeTally    // nil without a webhook
+	budgets       *metrics.ErrorBudgets    // nil without error budgets
+	instances     *metrics.InstanceTracker // nil without per-instance metrics
+	pressure      *backpressure
+	memory        *memoryGovernor
+	processed     *atomic.Int64
+	pending       []pendingDelivery
+	metricsEvents []pendingMetrics // Written apart from the log events
+}
+
+// pendingDelivery is a decoded delivery waiting in a worker batch. For a
+// reassembled chunked message, chunks holds the deliveries of the chunks
+// after the first; they are acknowledged together with it.
+type pendingDelivery struct {
+	delivery amqp.Delivery
+	event    *storage.LogEvent
+	chunks   []amqp.Delivery
+}
+
+// run processes deliveries until ctx is cancelled or the channel closes.
+// The local batch is flushed before returning.
+func (w *worker) run(ctx context.Context, deliveries <-chan amqp.Delivery) {
+	w.logger.Info("Worker started", zap.Int("workerId", w.id))
+	ticker := time.NewTicker(w.cfg.WorkerBatchTimeout)
+	defer ticker.Stop()
+
+	for {
+		// While paused the ticker keeps flushing and re-checking.
+		in := deliveries
+		if w.pressure != nil && w.pressure.active() {
+			in = nil
+		}
+		select {
+		case <-ctx.Done():
+			w.flush(ctx)
+			w.logger.Info("Worker shutting down", zap.Int("workerId", w.id))
+			return
+		case d, ok := <-in:
+			if !ok {
+				w.flush(ctx)
+				w.logger.Info("Deliveries channel closed, worker shutting down.", zap.Int("workerId", w.id))
+				return
+			}
+			w.handle(d)
+			if !w.drain(in) {
+				w.flush(ctx)
+				w.logger.Info("Deliveries channel closed, worker shutting down.", zap.Int("workerId", w.id))
+				return
+			}
+			if len(w.pending)+len(w.metricsEvents) >= w.cfg.WorkerBatchSize {
+				w.flush(ctx)
+			}
+		case <-ticker.C:
+			w.flush(ctx)
+		}
+	}
+}
+
+// drain handles up to WORKER_DRAIN_SIZE-1 further deliveries that are
+// already waiting, without blocking for more, so that a burst is processed
+// and acknowledged in one round while a trickle is still handled message
+// by message. It reports false once the deliveries channel was closed.
+func (w *worker) drain(in <-chan amqp.Delivery) bool {
+	if w.cfg.WorkerDrainSize <= 1 {
+		return true
+	}
+	n := 1
+	defer func() { metrics.WorkerDrainSize.Observe(float64(n)) }()
+	for ; n < w.cfg.WorkerDrainSize; n++ {
+		select {
+		case d, ok := <-in:
+			if !ok {
+				return false
+			}
+			w.handle(d)
+		default:
+			return true
+		}
+	}
+	return true
+}
+
+// handle decodes and checks a delivery and adds it to the local batch.
+func (w *worker) handle(d amqp.Delivery) {
+	metrics.MessagesProcessed.Inc()
+	w.processed.Add(1)
+
+	var chunks []amqp.Delivery
+	if w.chunks != nil && isChunk(d) {
+		assembled, parts, err := w.chunks.add(d)
+		if err != nil {
+			w.logger.Warn("Rejecting chunked message", zap.Error(err), zap.Int("chunks", len(parts)))
+			for _, part := range parts {
+				w.nack(part, false)
+			}
+			return
+		}
+		if assembled == nil {
+			// Acknowledged once the rest of the group arrived
+			return
+		}
+		d, chunks = *assembled, parts
+	}
+
+	if w.cfg.MetricsEventsEnabled && w.handleMetrics(d, chunks) {
+		return
+	}
+
+	event, ok := w.prepare(d)
+	if !ok {
+		w.recordOutcome(nil, metrics.OutcomeRejected)
+		w.nack(d, false)
+		for _, part := range chunks {
+			w.nack(part, false)
+		}
+		return
+	}
+	if w.memory != nil && w.memory.shedding() && event.Metadata.Priority == "low" {
+		// Dropped on purpose, so acknowledged rather than dead-lettered
+		metrics.MemoryShedEvents.Inc()
+		w.recordOutcome(event, metrics.OutcomeDropped)
+		w.logger.Debug("Dropping low-priority event while shedding load", zap.String("eventId", event.EventID))
+		if !w.cfg.AutoAck {
+			for _, part := range append([]amqp.Delivery{d}, chunks...) {
+				part.Ack(false)
+				metrics.MessagesAcked.Inc()
+			}
+		}
+		return
+	}
+	w.pending = append(w.pending, pendingDelivery{delivery: d, event: event, chunks: chunks})
+}
+
+// prepare decodes a delivery and applies the collector's checks and
+// enrichment. It reports false, after logging why, for deliveries that
+// must be rejected.
+func (w *worker) prepare(d amqp.Delivery) (*storage.LogEvent, bool) {
+	receivedAt := time.Now()
+	event, err := decodeEvent(d, w.cfg.StreamingDecodeThreshold)
+	if err != nil {
+		w.logger.Error("Failed to unmarshal message", zap.Error(err), zap.Int("workerId", w.id), zap.String("body", string(d.Body)))
+		return nil, false
+	}
+
+	if !checkSignature(w.cfg, w.logger, d, event.EventID, event.Source.Service) || !checkSchema(w.schemas, w.logger, event) {
+		return nil, false
+	}
+
+	event.ReceivedAt = receivedAt
+	// Quorum queues count earlier deliveries in x-delivery-count
+	deliveries, _ := headerInt(d.Headers["x-delivery-count"])
+	event.Redelivered = d.Redelivered || deliveries > 0
+	// Never trust a producer-supplied _collector object, pipeline version
+	// or original timestamp
+	event.Metadata.Collector = nil
+	event.Metadata.PipelineVersion = ""
+	event.Metadata.OriginalTimestamp = nil
+	if w.cfg.CollectorMetadata != config.CollectorMetadataOff {
+		event.Metadata.Collector = storage.NewCollectorInfo(w.cfg.InstanceID, receivedAt, w.id, w.cfg.CollectorMetadata == config.CollectorMetadataFull)
+	}
+	w.rates.Observe(event.Source.Service)
+	w.versions.Observe(event.Version)
+	if w.budgets != nil {
+		w.budgets.Observe(event.Source.Service, event.Data.Level)
+	}
+	if w.instances != nil {
+		w.instances.Observe(event.Source.Service, event.Source.Instance, event.Data.Level)
+	}
+	normalizeEventType(w.cfg, event)
+	if !checkText(w.cfg, w.logger, event) || !checkSourceVersion(w.cfg, w.logger, event) || !checkFutureTimestamp(w.cfg, w.logger, event) ||
+		!checkEnvelope(w.cfg, w.logger, event) {
+		return nil, false
+	}
+	w.enrichers.Enrich(event)
+	if w.cfg.RawPayloadEnabled {
+		captureRawPayload(w.cfg, w.logger, event, d.Body)
+	}
+	observeRetryCount(w.cfg, w.logger, event)
+	return event, true
+}
+
+// flush writes the local batch, then acknowledges the accepted deliveries
+// and requeues the rejected ones. Every delivery is settled before the
+// acks are sent, as a multiple ack covers all earlier deliveries.
+func (w *worker) flush(ctx context.Context) {
+	acks := w.flushMetrics(ctx)
+	acks = append(acks, w.flushLogs(ctx)...)
+	w.ack(acks)
+}
+
+// flushLogs hands the log events of the local batch to storage in one
+// operation and requeues the rejected ones. It returns the deliveries to
+// acknowledge.
+func (w *worker) flushLogs(ctx context.Context) []amqp.Delivery {
+	if len(w.pending) == 0 {
+		return nil
+	}
+	pending := w.pending
+	w.pending = nil
+
+	events := make([]*storage.LogEvent, len(pending))
+	for i, p := range pending {
+		events[i] = p.event
+	}
+	metrics.WorkerBatchSize.Observe(float64(len(events)))
+
+	// Under the all policy Elasticsearch is written first, so that a batch
+	// it fails is requeued before Postgres or the dedup keys saw it. It
+	// receives the events as decoded, ahead of the storage stages; the
+	// document IDs keep redelivered events from being indexed twice.
+	indexFirst := w.es != nil && w.cfg.StorageAckPolicy == config.StorageAckAll
+	if indexFirst {
+		if err := w.es.Write(ctx, events); err != nil {
+			pending = w.dropUnindexed(pending, err)
+			if len(pending) == 0 {
+				return nil
+			}
+			events = events[:0]
+			for _, p := range pending {
+				events = append(events, p.event)
+			}
+		}
+	}
+
+	rejected := make(map[*storage.LogEvent]bool)
+	if err := w.store.AddBatch(events); err != nil {
+		// A shard stayed full, or Elasticsearch as the only backend failed
+		// to index some events; hand their messages back for redelivery
+		var rejectedErr *storage.RejectedError
+		var bulkErr *storage.BulkError
+		if errors.As(err, &bulkErr) {
+			pending = w.dropUnindexed(pending, err)
+			events = events[:0]
+			for _, p := range pending {
+				events = append(events, p.event)
+			}
+		} else if errors.As(err, &rejectedErr) {
+			for _, event := range rejectedErr.Events {
+				rejected[event] = true
+			}
+		} else {
+			for _, event := range events {
+				rejected[event] = true
+			}
+		}
+		if len(rejected) > 0 {
+			w.logger.Warn("Failed to enqueue events, requeueing", zap.Error(err), zap.Int("events", len(rejected)))
+		}
+	}
+
+	// Under the primary policy index in the background; Elasticsearch never
+	// holds up the acks. With the outbox the relay indexes the events once
+	// they are committed.
+	accepted := make([]*storage.LogEvent, 0, len(events))
+	for _, event := range events {
+		if !rejected[event] {
+			e := *event
+			accepted = append(accepted, &e)
+		}
+	}
+	if len(accepted) > 0 && w.es != nil && !indexFirst && !w.cfg.OutboxEnabled {
+		w.es.Go(ctx, accepted)
+	}
+	if len(accepted) > 0 && w.kafka != nil {
+		w.kafka.Go(ctx, accepted)
+	}
+
+	if w.publisher != nil {
+		for _, event := range accepted {
+			w.republish(ctx, event)
+		}
+	}
+
+	var acks []amqp.Delivery
+	for _, p := range pending {
+		if rejected[p.event] {
+			w.recordOutcome(p.event, metrics.OutcomeRequeued)
+		} else {
+			w.recordOutcome(p.event, metrics.OutcomeAccepted)
+		}
+		for _, d := range append([]amqp.Delivery{p.delivery}, p.chunks...) {
+			if rejected[p.event] {
+				w.nack(d, true)
+			} else if !w.cfg.AutoAck {
+				acks = append(acks, d)
+			}
+		}
+	}
+	return acks
+}
+
+// ack acknowledges deliveries one by one, or with WORKER_ACK_MULTIPLE by
+// a single multiple ack of the highest delivery tag of each channel.
+func (w *worker) ack(deliveries []amqp.Delivery) {
+	if !w.cfg.WorkerAckMultiple {
+		for _, d := range deliveries {
+			d.Ack(false)
+		}
+		metrics.MessagesAcked.Add(float64(len(deliveries)))
+		return
+	}
+
+	last := make(map[amqp.Acknowledger]amqp.Delivery)
+	for _, d := range deliveries {
+		if prev, ok := last[d.Acknowledger]; !ok || d.DeliveryTag > prev.DeliveryTag {
+			last[d.Acknowledger] = d
+		}
+	}
+	for _, d := range last {
+		d.Ack(true)
+	}
+	metrics.MessagesAcked.Add(float64(len(deliveries)))
+}
+
+// dropUnindexed settles the deliveries of the events Elasticsearch failed
+// to index and returns the pending deliveries whose events were indexed.
+// Events it refused as invalid are dead-lettered, the others requeued;
+// without a *storage.BulkError that is the whole batch.
+func (w *worker) dropUnindexed(pending []pendingDelivery, err error) []pendingDelivery {
+	failed := make(map[*storage.LogEvent]bool)
+	invalid := make(map[*storage.LogEvent]bool)
+	var bulkErr *storage.BulkError
+	if errors.As(err, &bulkErr) {
+		for _, event := range bulkErr.Events {
+			failed[event] = true
+		}
+		for _, event := range bulkErr.Invalid {
+			invalid[event] = true
+		}
+	} else {
+		for _, p := range pending {
+			failed[p.event] = true
+		}
+	}
+	w.logger.Warn("Failed to index events in Elasticsearch, requeueing",
+		zap.Error(err), zap.Int("events", len(failed)-len(invalid)), zap.Int("dead_lettered", len(invalid)))
+
+	indexed := pending[:0:0]
+	for _, p := range pending {
+		if !failed[p.event] {
+			indexed = append(indexed, p)
+			continue
+		}
+		outcome := metrics.OutcomeRequeued
+		if invalid[p.event] {
+			outcome = metrics.OutcomeRejected
+		}
+		w.recordOutcome(p.event, outcome)
+		for _, d := range append([]amqp.Delivery{p.delivery}, p.chunks...) {
+			w.nack(d, !invalid[p.event])
+		}
+	}
+	return indexed
+}
+
+// republish forwards an accepted event to the republish exchange. Failures
+// are logged and counted; the event is stored either way.
+func (w *worker) republish(ctx context.Context, event *storage.LogEvent) {
+	if err := w.publisher.Publish(ctx, event); err != nil {
+		metrics.EventsRepublished.WithLabelValues("error").Inc()
+		w.logger.Warn("Failed to re-publish event", zap.Error(err), zap.String("eventId", event.EventID))
+		return
+	}
+	metrics.EventsRepublished.WithLabelValues("success").Inc()
+}
+
+// recordOutcome counts the outcome of an event for the webhook reports;
+// event is nil when the delivery could not be decoded.
+func (w *worker) recordOutcome(event *storage.LogEvent, outcome string) {
+	if w.outcomes == nil {
+		return
+	}
+	if event == nil {
+		w.outcomes.Record("", "", outcome)
+		return
+	}
+	w.outcomes.Record(event.Source.Service, event.Data.Level, outcome)
+}
+
+// nack rejects a delivery unless deliveries are auto-acknowledged.
+func (w *worker) nack(d amqp.Delivery, requeue bool) {
+	if w.cfg.AutoAck {
+		return
+	}
+	d.Nack(false, requeue)
+	metrics.MessagesNacked.Inc()
+}
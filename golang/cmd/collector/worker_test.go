@@ -0,0 +1,489 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"observability_hub/golang/internal/collector/config"
+	"observability_hub/golang/internal/collector/metrics"
+	"observability_hub/golang/internal/collector/storage"
+	"observability_hub/golang/internal/types"
+
+	"github.com/google/uuid"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.uber.org/zap"
+)
+
+// fakeAcknowledger records how deliveries were settled, by delivery tag.
+type fakeAcknowledger struct {
+	mu      sync.Mutex
+	acked   []uint64
+	nacked  []uint64
+	requeue map[uint64]bool
+}
+
+func (a *fakeAcknowledger) Ack(tag uint64, multiple bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.acked = append(a.acked, tag)
+	return nil
+}
+
+func (a *fakeAcknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.nacked = append(a.nacked, tag)
+	if a.requeue == nil {
+		a.requeue = make(map[uint64]bool)
+	}
+	a.requeue[tag] = requeue
+	return nil
+}
+
+func (a *fakeAcknowledger) Reject(tag uint64, requeue bool) error {
+	return a.Nack(tag, false, requeue)
+}
+
+// settled returns how the delivery with tag was settled: "ack", "requeue",
+// "dead-letter" or "" if it was not.
+func (a *fakeAcknowledger) settled(tag uint64) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, acked := range a.acked {
+		if acked == tag {
+			return "ack"
+		}
+	}
+	requeue, ok := a.requeue[tag]
+	switch {
+	case !ok:
+		return ""
+	case requeue:
+		return "requeue"
+	}
+	return "dead-letter"
+}
+
+// fakeStore is a primary storage backend recording what it accepts. err,
+// if set, decides the outcome of every AddBatch call.
+type fakeStore struct {
+	mu      sync.Mutex
+	batches [][]*storage.LogEvent
+	metrics []*types.MetricsEvent
+	err     func(events []*storage.LogEvent) error
+	// reconfigured holds the settings of every Reconfigure call
+	reconfigured []*config.Config
+}
+
+func (s *fakeStore) AddToBatch(event *storage.LogEvent) error {
+	return s.AddBatch([]*storage.LogEvent{event})
+}
+
+func (s *fakeStore) AddBatch(events []*storage.LogEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batches = append(s.batches, append([]*storage.LogEvent(nil), events...))
+	if s.err != nil {
+		return s.err(events)
+	}
+	return nil
+}
+
+func (s *fakeStore) AddMetrics(ctx context.Context, events []*types.MetricsEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.metrics = append(s.metrics, events...)
+	return nil
+}
+
+func (s *fakeStore) Close() {}
+
+func (s *fakeStore) Reconfigure(cfg *config.Config) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reconfigured = append(s.reconfigured, cfg)
+}
+
+// stored returns the events of every AddBatch call so far.
+func (s *fakeStore) stored() []*storage.LogEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var events []*storage.LogEvent
+	for _, batch := range s.batches {
+		events = append(events, batch...)
+	}
+	return events
+}
+
+// testConfig returns the default configuration, as loaded without any
+// settings in the environment.
+func testConfig(t *testing.T) *config.Config {
+	t.Helper()
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("load default configuration: %v", err)
+	}
+	return cfg
+}
+
+// newTestWorker returns a worker writing to store.
+func newTestWorker(cfg *config.Config, store *fakeStore) *worker {
+	return &worker{
+		id:           1,
+		cfg:          cfg,
+		logger:       zap.NewNop(),
+		store:        store,
+		metricsStore: store,
+		rates:        metrics.NewRateTracker(time.Minute, 10),
+		versions:     metrics.NewVersionTracker(10),
+		processed:    new(atomic.Int64),
+	}
+}
+
+// logEventDoc returns a valid log event as a JSON document.
+func logEventDoc() map[string]any {
+	return map[string]any{
+		"eventId":       uuid.NewString(),
+		"eventType":     "log.message.created",
+		"version":       "1.0.0",
+		"timestamp":     time.Now().UTC().Format(time.RFC3339Nano),
+		"correlationId": uuid.NewString(),
+		"source": map[string]any{
+			"service": "checkout",
+			"version": "1.2.3",
+		},
+		"metadata": map[string]any{
+			"priority": "normal",
+		},
+		"data": map[string]any{
+			"level":     "INFO",
+			"message":   "Order placed",
+			"timestamp": time.Now().UTC().Format(time.RFC3339Nano),
+		},
+	}
+}
+
+// delivery returns a delivery of doc marshaled to JSON.
+func delivery(t *testing.T, ack amqp.Acknowledger, tag uint64, doc any) amqp.Delivery {
+	t.Helper()
+	body, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return amqp.Delivery{Acknowledger: ack, DeliveryTag: tag, ContentType: "application/json", Body: body}
+}
+
+func TestFlushLogsSettlesEventsThePrimaryDidNotIndex(t *testing.T) {
+	// Elasticsearch as the only backend reports the events it did not
+	// index in a *storage.BulkError: the refused ones are dead-lettered,
+	// those failing transiently requeued
+	store := &fakeStore{}
+	store.err = func(events []*storage.LogEvent) error {
+		return &storage.BulkError{Events: events[1:], Invalid: events[2:], Total: len(events)}
+	}
+	w := newTestWorker(testConfig(t), store)
+
+	ack := &fakeAcknowledger{}
+	for tag := uint64(1); tag <= 3; tag++ {
+		w.handle(delivery(t, ack, tag, logEventDoc()))
+	}
+	w.flush(context.Background())
+
+	for tag, want := range map[uint64]string{1: "ack", 2: "requeue", 3: "dead-letter"} {
+		if got := ack.settled(tag); got != want {
+			t.Errorf("delivery %d settled as %q, want %q", tag, got, want)
+		}
+	}
+}
+
+func TestHandleDeadLettersInvalidEnvelopes(t *testing.T) {
+	tests := []struct {
+		name string
+		set  func(doc map[string]any)
+		want string
+	}{
+		{"valid", func(doc map[string]any) {}, "ack"},
+		{"malformed event ID", func(doc map[string]any) { doc["eventId"] = "not-a-uuid" }, "dead-letter"},
+		{"truncated event ID", func(doc map[string]any) { doc["eventId"] = uuid.NewString()[:35] }, "dead-letter"},
+		{"unknown event type", func(doc map[string]any) { doc["eventType"] = "audit.entry.created" }, "dead-letter"},
+		{"event type without an action", func(doc map[string]any) { doc["eventType"] = "log." }, "dead-letter"},
+		{"malformed correlation ID", func(doc map[string]any) { doc["correlationId"] = "1234" }, "dead-letter"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig(t)
+			cfg.EventValidationEnabled = true
+			store := &fakeStore{}
+			w := newTestWorker(cfg, store)
+			ack := &fakeAcknowledger{}
+
+			doc := logEventDoc()
+			tt.set(doc)
+			w.handle(delivery(t, ack, 1, doc))
+			w.flush(context.Background())
+
+			if got := ack.settled(1); got != tt.want {
+				t.Errorf("delivery settled as %q, want %q", got, tt.want)
+			}
+			if stored := len(store.stored()); (tt.want == "ack") != (stored == 1) {
+				t.Errorf("stored %d events", stored)
+			}
+		})
+	}
+}
+
+func TestFlushLogsAllPolicyIndexesTheBatchPostgresStores(t *testing.T) {
+	tests := []struct {
+		name string
+		// es decides the outcome of the Elasticsearch write of events
+		es   func(events []*storage.LogEvent) error
+		want map[uint64]string
+	}{
+		{
+			name: "indexed",
+			es:   func(events []*storage.LogEvent) error { return nil },
+			want: map[uint64]string{1: "ack", 2: "ack", 3: "ack"},
+		},
+		{
+			name: "partly indexed",
+			es: func(events []*storage.LogEvent) error {
+				return &storage.BulkError{Events: events[1:], Invalid: events[2:], Total: len(events)}
+			},
+			want: map[uint64]string{1: "ack", 2: "requeue", 3: "dead-letter"},
+		},
+		{
+			name: "unavailable",
+			es:   func(events []*storage.LogEvent) error { return errors.New("connection refused") },
+			want: map[uint64]string{1: "requeue", 2: "requeue", 3: "requeue"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig(t)
+			cfg.StorageAckPolicy = config.StorageAckAll
+			store := &fakeStore{}
+			w := newTestWorker(cfg, store)
+
+			var mu sync.Mutex
+			var indexed []string
+			w.es = storage.NewDestination("elasticsearch", 1, time.Second, func(ctx context.Context, events []*storage.LogEvent) error {
+				err := tt.es(events)
+				var bulkErr *storage.BulkError
+				failed := make(map[*storage.LogEvent]bool)
+				if errors.As(err, &bulkErr) {
+					for _, event := range bulkErr.Events {
+						failed[event] = true
+					}
+				} else if err != nil {
+					return err
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				for _, event := range events {
+					if !failed[event] {
+						indexed = append(indexed, event.EventID)
+					}
+				}
+				return err
+			}, zap.NewNop())
+
+			ack := &fakeAcknowledger{}
+			for tag := uint64(1); tag <= 3; tag++ {
+				w.handle(delivery(t, ack, tag, logEventDoc()))
+			}
+			w.flush(context.Background())
+			w.es.Wait()
+
+			var stored []string
+			for _, event := range store.stored() {
+				stored = append(stored, event.EventID)
+			}
+			if fmt.Sprint(indexed) != fmt.Sprint(stored) {
+				t.Errorf("Elasticsearch indexed %v, Postgres stored %v", indexed, stored)
+			}
+			for tag, want := range tt.want {
+				if got := ack.settled(tag); got != want {
+					t.Errorf("delivery %d settled as %q, want %q", tag, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRunFlushesLocalBatchOnShutdown(t *testing.T) {
+	for _, stop := range []string{"cancelled", "channel closed"} {
+		t.Run(stop, func(t *testing.T) {
+			cfg := testConfig(t)
+			cfg.WorkerBatchSize = 100
+			cfg.WorkerBatchTimeout = time.Hour
+			store := &fakeStore{}
+			w := newTestWorker(cfg, store)
+
+			ack := &fakeAcknowledger{}
+			deliveries := make(chan amqp.Delivery, 5)
+			var want []string
+			for tag := uint64(1); tag <= 5; tag++ {
+				doc := logEventDoc()
+				want = append(want, doc["eventId"].(string))
+				deliveries <- delivery(t, ack, tag, doc)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				w.run(ctx, deliveries)
+				close(done)
+			}()
+			// Neither the batch size nor the timeout is reached; only
+			// stopping the worker flushes the batch
+			for len(deliveries) > 0 {
+				time.Sleep(time.Millisecond)
+			}
+			if stop == "cancelled" {
+				cancel()
+			} else {
+				close(deliveries)
+			}
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("worker did not stop")
+			}
+			cancel()
+
+			if len(store.batches) != 1 {
+				t.Fatalf("stored %d batches, want the local batch as one", len(store.batches))
+			}
+			var got []string
+			for _, event := range store.batches[0] {
+				got = append(got, event.EventID)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Errorf("stored %v, want %v in delivery order", got, want)
+			}
+			for tag := uint64(1); tag <= 5; tag++ {
+				if got := ack.settled(tag); got != "ack" {
+					t.Errorf("delivery %d settled as %q, want ack", tag, got)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleAppliesInvalidTextPolicy(t *testing.T) {
+	// Invalid UTF-8 in the body is replaced by the JSON decoder; NUL
+	// escapes decode to a NUL Postgres rejects
+	bodies := [][]byte{
+		bytes.Replace(delivery(t, nil, 1, logEventDoc()).Body, []byte("Order placed"), []byte("Order \xff\xfe placed"), 1),
+		delivery(t, nil, 2, logEventDoc()).Body,
+	}
+	bodies[1] = bytes.Replace(bodies[1], []byte("Order placed"), []byte(`Order \u0000 placed`), 1)
+
+	for _, tt := range []struct {
+		policy string
+		want   map[uint64]string
+	}{
+		{config.InvalidTextSanitize, map[uint64]string{1: "ack", 2: "ack"}},
+		{config.InvalidTextReject, map[uint64]string{1: "ack", 2: "dead-letter"}},
+	} {
+		t.Run(tt.policy, func(t *testing.T) {
+			cfg := testConfig(t)
+			cfg.InvalidTextPolicy = tt.policy
+			store := &fakeStore{}
+			w := newTestWorker(cfg, store)
+			ack := &fakeAcknowledger{}
+			for i, body := range bodies {
+				w.handle(amqp.Delivery{Acknowledger: ack, DeliveryTag: uint64(i + 1), ContentType: "application/json", Body: body})
+			}
+			w.flush(context.Background())
+
+			acked := 0
+			for tag, want := range tt.want {
+				if got := ack.settled(tag); got != want {
+					t.Errorf("delivery %d settled as %q, want %q", tag, got, want)
+				}
+				if want == "ack" {
+					acked++
+				}
+			}
+			if stored := store.stored(); len(stored) != acked {
+				t.Errorf("stored %d events, want %d", len(stored), acked)
+			}
+			for _, event := range store.stored() {
+				if !utf8.ValidString(event.Data.Message) || strings.Contains(event.Data.Message, "\x00") || !strings.Contains(event.Data.Message, "\uFFFD") {
+					t.Errorf("stored message %q, want the invalid text replaced by U+FFFD", event.Data.Message)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleClampsFutureTimestamps(t *testing.T) {
+	future := time.Now().Add(72 * time.Hour).UTC().Truncate(time.Millisecond)
+	forged := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	clamped := logEventDoc()
+	clamped["timestamp"] = future.Format(time.RFC3339Nano)
+	// A producer cannot pass off a timestamp as the clamped original
+	current := logEventDoc()
+	current["metadata"].(map[string]any)["originalTimestamp"] = forged.Format(time.RFC3339Nano)
+
+	cfg := testConfig(t)
+	store := &fakeStore{}
+	w := newTestWorker(cfg, store)
+	ack := &fakeAcknowledger{}
+	w.handle(delivery(t, ack, 1, clamped))
+	w.handle(delivery(t, ack, 2, current))
+	w.flush(context.Background())
+
+	stored := store.stored()
+	if len(stored) != 2 {
+		t.Fatalf("stored %d events, want 2", len(stored))
+	}
+	if original := stored[0].Metadata.OriginalTimestamp; original == nil || !original.Equal(future) {
+		t.Errorf("clamped event originalTimestamp = %v, want %s", original, future)
+	}
+	if !stored[0].Timestamp.Before(future) {
+		t.Errorf("clamped event timestamp = %s, want the receive time", stored[0].Timestamp)
+	}
+	if original := stored[1].Metadata.OriginalTimestamp; original != nil {
+		t.Errorf("producer-supplied originalTimestamp %s was kept", original)
+	}
+}
+
+func TestPrepareMarksRedeliveries(t *testing.T) {
+	tests := []struct {
+		name        string
+		redelivered bool
+		headers     amqp.Table
+		want        bool
+	}{
+		{"first delivery", false, nil, false},
+		{"redelivered", true, nil, true},
+		{"quorum queue redelivery", false, amqp.Table{"x-delivery-count": int64(1)}, true},
+		{"quorum queue first delivery", false, amqp.Table{"x-delivery-count": int64(0)}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newTestWorker(testConfig(t), &fakeStore{})
+			d := delivery(t, &fakeAcknowledger{}, 1, logEventDoc())
+			d.Redelivered, d.Headers = tt.redelivered, tt.headers
+			event, ok := w.prepare(d)
+			if !ok {
+				t.Fatal("prepare rejected the delivery")
+			}
+			if event.Redelivered != tt.want {
+				t.Errorf("Redelivered = %t, want %t", event.Redelivered, tt.want)
+			}
+		})
+	}
+}
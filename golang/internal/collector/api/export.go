@@ -0,0 +1,189 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"observability_hub/golang/internal/collector/storage"
+	"strings"
+	"time"
+)
+
+// Export formats.
+const (
+	formatJSON   = "json"
+	formatNDJSON = "ndjson"
+	formatCSV    = "csv"
+)
+
+// csvBaseColumns are always exported; configured fields are appended.
+var csvBaseColumns = []string{"event_id", "correlation_id", "timestamp", "level", "service", "message"}
+
+// encoder streams events in one export format.
+type encoder interface {
+	contentType() string
+	begin() error
+	write(event *storage.LogEvent) error
+	end() error
+	flush()
+}
+
+// negotiateFormat picks the format from the query parameter, falling back to
+// the Accept header and then JSON.
+func negotiateFormat(param, accept string) string {
+	if param != "" {
+		return param
+	}
+	switch {
+	case strings.Contains(accept, "text/csv"):
+		return formatCSV
+	case strings.Contains(accept, "application/x-ndjson"):
+		return formatNDJSON
+	default:
+		return formatJSON
+	}
+}
+
+func newEncoder(format string, w http.ResponseWriter, fields []string) (encoder, error) {
+	flusher, _ := w.(http.Flusher)
+	switch format {
+	case formatJSON:
+		return &jsonEncoder{w: w, flusher: flusher, enc: json.NewEncoder(w)}, nil
+	case formatNDJSON:
+		return &ndjsonEncoder{flusher: flusher, enc: json.NewEncoder(w)}, nil
+	case formatCSV:
+		return &csvEncoder{w: csv.NewWriter(w), flusher: flusher, fields: fields}, nil
+	default:
+		return nil, fmt.Errorf("unsupported format %q", format)
+	}
+}
+
+// jsonEncoder writes a single JSON array, one element at a time.
+type jsonEncoder struct {
+	w       io.Writer
+	flusher http.Flusher
+	enc     *json.Encoder
+	started bool
+}
+
+func (e *jsonEncoder) contentType() string { return "application/json" }
+
+func (e *jsonEncoder) begin() error {
+	_, err := io.WriteString(e.w, "[")
+	return err
+}
+
+func (e *jsonEncoder) write(event *storage.LogEvent) error {
+	if e.started {
+		if _, err := io.WriteString(e.w, ","); err != nil {
+			return err
+		}
+	}
+	e.started = true
+	return e.enc.Encode(event)
+}
+
+func (e *jsonEncoder) end() error {
+	_, err := io.WriteString(e.w, "]\n")
+	return err
+}
+
+func (e *jsonEncoder) flush() { flush(e.flusher) }
+
+// ndjsonEncoder writes one JSON document per line.
+type ndjsonEncoder struct {
+	flusher http.Flusher
+	enc     *json.Encoder
+}
+
+func (e *ndjsonEncoder) contentType() string                 { return "application/x-ndjson" }
+func (e *ndjsonEncoder) begin() error                        { return nil }
+func (e *ndjsonEncoder) write(event *storage.LogEvent) error { return e.enc.Encode(event) }
+func (e *ndjsonEncoder) end() error                          { return nil }
+func (e *ndjsonEncoder) flush()                              { flush(e.flusher) }
+
+// csvEncoder writes the base columns plus one column per configured field.
+// Fields are dotted paths into the event's JSON form; nested objects and
+// arrays found at a path are written as JSON.
+type csvEncoder struct {
+	w       *csv.Writer
+	flusher http.Flusher
+	fields  []string
+}
+
+func (e *csvEncoder) contentType() string { return "text/csv" }
+
+func (e *csvEncoder) begin() error {
+	return e.w.Write(append(append([]string{}, csvBaseColumns...), e.fields...))
+}
+
+func (e *csvEncoder) write(event *storage.LogEvent) error {
+	row := []string{
+		event.EventID,
+		event.CorrelationID,
+		event.Timestamp.Format(time.RFC3339Nano),
+		event.Data.Level,
+		event.Source.Service,
+		event.Data.Message,
+	}
+
+	if len(e.fields) > 0 {
+		var doc map[string]interface{}
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return err
+		}
+		for _, field := range e.fields {
+			row = append(row, lookupField(doc, field))
+		}
+	}
+	return e.w.Write(row)
+}
+
+func (e *csvEncoder) end() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func (e *csvEncoder) flush() {
+	e.w.Flush()
+	flush(e.flusher)
+}
+
+// lookupField resolves a dotted path in a decoded JSON document and renders
+// the value as a CSV cell. Missing paths yield an empty cell.
+func lookupField(doc map[string]interface{}, path string) string {
+	var value interface{} = doc
+	for _, key := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return ""
+		}
+		if value, ok = object[key]; !ok {
+			return ""
+		}
+	}
+
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case map[string]interface{}, []interface{}:
+		data, _ := json.Marshal(v)
+		return string(data)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func flush(f http.Flusher) {
+	if f != nil {
+		f.Flush()
+	}
+}
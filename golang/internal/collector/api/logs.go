@@ -0,0 +1,139 @@
+// Package api serves read access to the stored events.
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"observability_hub/golang/internal/collector/config"
+	"observability_hub/golang/internal/collector/storage"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// pageSize is the number of rows read from the database at a time. Results
+// are streamed page by page, so memory use does not grow with the export.
+const pageSize = 500
+
+// LogsHandler serves GET /api/logs, querying stored events by time range
+// and service and streaming them as JSON, NDJSON or CSV.
+type LogsHandler struct {
+	cfg    *config.Config
+	reader *storage.LogReader
+	logger *zap.Logger
+}
+
+// NewLogsHandler creates a handler reading from reader.
+func NewLogsHandler(cfg *config.Config, reader *storage.LogReader, logger *zap.Logger) *LogsHandler {
+	return &LogsHandler{
+		cfg:    cfg,
+		reader: reader,
+		logger: logger.Named("api"),
+	}
+}
+
+// ServeHTTP handles a query. Supported parameters: from and to (RFC 3339),
+// service, baggage (key=value, repeatable; needs the tracing column), limit (capped by the configured export limit), format
+// (json, ndjson or csv; otherwise taken from the Accept header) and, for
+// CSV, fields (dotted paths into the event, e.g. data.structured.http_status).
+func (h *LogsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	filter, err := parseFilter(query.Get("from"), query.Get("to"), query.Get("service"), query["baggage"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(filter.Baggage) > 0 && !h.cfg.TracingColumnEnabled {
+		http.Error(w, "filtering by baggage needs TRACING_COLUMN", http.StatusBadRequest)
+		return
+	}
+
+	limit := h.cfg.ExportMaxRows
+	if value := query.Get("limit"); value != "" {
+		requested, err := strconv.Atoi(value)
+		if err != nil || requested <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		if requested < limit {
+			limit = requested
+		}
+	}
+
+	fields := h.cfg.ExportCSVFields
+	if value := query.Get("fields"); value != "" {
+		fields = strings.Split(value, ",")
+	}
+
+	enc, err := newEncoder(negotiateFormat(query.Get("format"), r.Header.Get("Accept")), w, fields)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotAcceptable)
+		return
+	}
+
+	w.Header().Set("Content-Type", enc.contentType())
+	if err := enc.begin(); err != nil {
+		return
+	}
+
+	var cursor *storage.LogCursor
+	written := 0
+	for written < limit {
+		events, err := h.reader.ReadPage(r.Context(), filter, cursor, min(pageSize, limit-written))
+		if err != nil {
+			// The status line is already sent; a truncated body is all we can signal.
+			h.logger.Error("Failed to read logs for export", zap.Error(err), zap.Int("written", written))
+			return
+		}
+		for _, event := range events {
+			if err := enc.write(event); err != nil {
+				h.logger.Warn("Failed to write export row", zap.Error(err))
+				return
+			}
+		}
+		written += len(events)
+		if len(events) == 0 || written >= limit {
+			break
+		}
+		last := events[len(events)-1]
+		cursor = &storage.LogCursor{Timestamp: last.Timestamp, EventID: last.EventID}
+		enc.flush()
+	}
+
+	if err := enc.end(); err != nil {
+		h.logger.Warn("Failed to finish export", zap.Error(err))
+	}
+}
+
+func parseFilter(from, to, service string, baggage []string) (storage.LogFilter, error) {
+	filter := storage.LogFilter{Service: service}
+	for _, entry := range baggage {
+		key, value, ok := strings.Cut(entry, "=")
+		if !ok || key == "" {
+			return filter, fmt.Errorf("invalid baggage %q, want key=value", entry)
+		}
+		if filter.Baggage == nil {
+			filter.Baggage = make(map[string]string)
+		}
+		filter.Baggage[key] = value
+	}
+	var err error
+	if from != "" {
+		if filter.From, err = time.Parse(time.RFC3339, from); err != nil {
+			return filter, fmt.Errorf("invalid from: %w", err)
+		}
+	}
+	if to != "" {
+		if filter.To, err = time.Parse(time.RFC3339, to); err != nil {
+			return filter, fmt.Errorf("invalid to: %w", err)
+		}
+	}
+	return filter, nil
+}
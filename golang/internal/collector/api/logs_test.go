@@ -0,0 +1,93 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"observability_hub/golang/internal/collector/config"
+
+	"go.uber.org/zap"
+)
+
+func TestParseFilterBaggage(t *testing.T) {
+	tests := []struct {
+		name    string
+		baggage []string
+		want    map[string]string
+	}{
+		{"none", nil, nil},
+		{"one entry", []string{"tenant=acme"}, map[string]string{"tenant": "acme"}},
+		{"several entries", []string{"tenant=acme", "plan=gold"}, map[string]string{"tenant": "acme", "plan": "gold"}},
+		// Only the first = separates the key; values may contain more
+		{"value with =", []string{"query=a=b"}, map[string]string{"query": "a=b"}},
+		{"empty value", []string{"tenant="}, map[string]string{"tenant": ""}},
+		{"repeated key", []string{"tenant=acme", "tenant=globex"}, map[string]string{"tenant": "globex"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := parseFilter("", "", "", tt.baggage)
+			if err != nil {
+				t.Fatalf("parseFilter: %v", err)
+			}
+			if fmt.Sprint(filter.Baggage) != fmt.Sprint(tt.want) || (tt.want == nil) != (filter.Baggage == nil) {
+				t.Errorf("baggage = %v, want %v", filter.Baggage, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFilterRejectsInvalidBaggage(t *testing.T) {
+	for _, entry := range []string{"tenant", "=acme", ""} {
+		if _, err := parseFilter("", "", "", []string{"plan=gold", entry}); err == nil || !strings.Contains(err.Error(), fmt.Sprintf("%q", entry)) {
+			t.Errorf("parseFilter(%q) = %v, want an error naming it", entry, err)
+		}
+	}
+}
+
+func TestParseFilter(t *testing.T) {
+	filter, err := parseFilter("2024-07-01T00:00:00Z", "2024-08-01T00:00:00+03:00", "checkout", []string{"tenant=acme"})
+	if err != nil {
+		t.Fatalf("parseFilter: %v", err)
+	}
+	if !filter.From.Equal(time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)) || !filter.To.Equal(time.Date(2024, 7, 31, 21, 0, 0, 0, time.UTC)) {
+		t.Errorf("range = [%s, %s)", filter.From, filter.To)
+	}
+	if filter.Service != "checkout" || filter.Baggage["tenant"] != "acme" {
+		t.Errorf("filter = %+v", filter)
+	}
+
+	for _, bound := range [][2]string{{"yesterday", ""}, {"", "2024-08-01"}} {
+		if _, err := parseFilter(bound[0], bound[1], "", nil); err == nil {
+			t.Errorf("parseFilter(%q, %q) succeeded", bound[0], bound[1])
+		}
+	}
+}
+
+func TestLogsHandlerRejectsInvalidBaggage(t *testing.T) {
+	tests := []struct {
+		name    string
+		tracing bool
+		query   string
+		want    string
+	}{
+		{"without the tracing column", false, "baggage=tenant%3Dacme", "needs TRACING_COLUMN"},
+		{"malformed", true, "baggage=tenant", "invalid baggage"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Rejected before the database is read, so no reader is needed
+			cfg := &config.Config{TracingColumnEnabled: tt.tracing, ExportMaxRows: 100}
+			h := NewLogsHandler(cfg, nil, zap.NewNop())
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/logs?"+tt.query, nil))
+			if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), tt.want) {
+				t.Errorf("got %d %q, want 400 %q", rec.Code, rec.Body.String(), tt.want)
+			}
+		})
+	}
+}
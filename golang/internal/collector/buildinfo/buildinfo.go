@@ -0,0 +1,32 @@
+// Package buildinfo identifies the running collector build. Version and
+// Commit are injected at build time:
+//
+//	go build -ldflags "-X observability_hub/golang/internal/collector/buildinfo.Version=v1.4.0 \
+//	    -X observability_hub/golang/internal/collector/buildinfo.Commit=$(git rev-parse --short HEAD)"
+//
+// Without ldflags the commit is taken from the VCS information the Go
+// toolchain embeds, when available.
+package buildinfo
+
+import "runtime/debug"
+
+var (
+	// Version is the release version of the build.
+	Version = "dev"
+	// Commit is the source revision of the build.
+	Commit = ""
+)
+
+func init() {
+	if Commit != "" {
+		return
+	}
+	Commit = "unknown"
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				Commit = setting.Value
+			}
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package config
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// lookupMap returns a settings lookup over settings.
+func lookupMap(settings map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		value, ok := settings[key]
+		return value, ok
+	}
+}
+
+func TestLoadNamesInvalidSettings(t *testing.T) {
+	tests := []struct {
+		key, value string
+		want       error
+	}{
+		{"COLLECTOR_BATCH_SIZE", "ten", strconv.ErrSyntax},
+		{"COLLECTOR_BATCH_TIMEOUT", "5 seconds", nil},
+		{"ES_INDEX_CLAMP_ENABLED", "maybe", strconv.ErrSyntax},
+		{"BACKPRESSURE_HIGH_WATERMARK", "high", strconv.ErrSyntax},
+		{"MEMORY_LIMIT_BYTES", "1GiB", strconv.ErrSyntax},
+		{"RETENTION_DEFAULT", "30 days", nil},
+		{"DB_FLUSH_BUCKETS", "0.1,fast", strconv.ErrSyntax},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			_, err := load(lookupMap(map[string]string{tt.key: tt.value}))
+			if err == nil {
+				t.Fatalf("load accepted %s=%q", tt.key, tt.value)
+			}
+			prefix := "invalid " + tt.key + " " + strconv.Quote(tt.value) + ": "
+			if !strings.HasPrefix(err.Error(), prefix) {
+				t.Errorf("error %q does not start with %q", err, prefix)
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("error %q does not wrap %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadDefaults(t *testing.T) {
+	cfg, err := load(lookupMap(nil))
+	if err != nil {
+		t.Fatalf("load with defaults: %v", err)
+	}
+	if cfg.RetryMax != 3 || cfg.RetryInterval != 2*time.Second {
+		t.Errorf("retry settings = %d, %s", cfg.RetryMax, cfg.RetryInterval)
+	}
+}
+
+func TestValidateRequiresOneAttempt(t *testing.T) {
+	for _, retryMax := range []string{"0", "-1"} {
+		_, err := load(lookupMap(map[string]string{"COLLECTOR_RETRY_MAX": retryMax}))
+		if err == nil || !strings.Contains(err.Error(), "COLLECTOR_RETRY_MAX must be at least 1") {
+			t.Errorf("COLLECTOR_RETRY_MAX=%s: error = %v", retryMax, err)
+		}
+	}
+	if _, err := load(lookupMap(map[string]string{"COLLECTOR_RETRY_MAX": "1"})); err != nil {
+		t.Errorf("COLLECTOR_RETRY_MAX=1: %v", err)
+	}
+}
+
+func TestLoadStorageBackends(t *testing.T) {
+	tests := []struct {
+		name                  string
+		settings              map[string]string
+		postgres, es, invalid bool
+	}{
+		{"default", nil, true, true, false},
+		{"postgres", map[string]string{"STORAGE_BACKEND": "postgres"}, true, false, false},
+		{"both", map[string]string{"STORAGE_BACKEND": "both"}, true, true, false},
+		{"elasticsearch", map[string]string{"STORAGE_BACKEND": "elasticsearch"}, false, true, false},
+		{"elasticsearch listed", map[string]string{"STORAGE_BACKENDS": "elasticsearch"}, false, true, false},
+		{"list wins", map[string]string{"STORAGE_BACKEND": "postgres", "STORAGE_BACKENDS": "elasticsearch"}, false, true, false},
+		{"unknown", map[string]string{"STORAGE_BACKEND": "mongodb"}, false, false, true},
+		{"none listed", map[string]string{"STORAGE_BACKENDS": " , "}, false, false, true},
+		{"outbox without postgres", map[string]string{"STORAGE_BACKEND": "elasticsearch", "OUTBOX_ENABLED": "true"}, false, false, true},
+		{"dedup on insert without postgres", map[string]string{"STORAGE_BACKEND": "elasticsearch", "POSTGRES_DEDUP_ON_INSERT": "true"}, false, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := load(lookupMap(tt.settings))
+			if tt.invalid {
+				if err == nil {
+					t.Fatal("load accepted the settings")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.PostgresEnabled != tt.postgres || cfg.ElasticsearchEnabled != tt.es {
+				t.Errorf("postgres, elasticsearch = %t, %t, want %t, %t", cfg.PostgresEnabled, cfg.ElasticsearchEnabled, tt.postgres, tt.es)
+			}
+		})
+	}
+}
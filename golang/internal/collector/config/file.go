@@ -0,0 +1,107 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// LoadFromFile reads configuration from a YAML or JSON file alone, with
+// the defaults for the settings it leaves out; the environment is ignored.
+//
+// The file maps environment variable names, in any case, to values:
+//
+//	COLLECTOR_BATCH_SIZE: 500
+//	KAFKA_BROKERS: [kafka-1:9092, kafka-2:9092]
+//
+// Lists are joined with commas. Unknown names are rejected, so that a
+// misspelt setting does not silently keep its default.
+func LoadFromFile(path string) (*Config, error) {
+	return loadWithFile(path, func(string) (string, bool) { return "", false })
+}
+
+// loadWithFile loads the settings of the file at path, overridden by those
+// env returns.
+func loadWithFile(path string, env func(key string) (string, bool)) (*Config, error) {
+	file, err := readConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	known := make(map[string]bool)
+	cfg, err := load(func(key string) (string, bool) {
+		known[key] = true
+		if value, ok := env(key); ok {
+			return value, true
+		}
+		value, ok := file[key]
+		return value, ok
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var unknown []string
+	for key := range file {
+		if !known[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("config file %s: unknown settings %s", path, strings.Join(unknown, ", "))
+	}
+	return cfg, nil
+}
+
+// readConfigFile parses a configuration file into setting values as they
+// would appear in the environment. JSON is read as the YAML subset it is.
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var nodes map[string]yaml.Node
+	if err := yaml.Unmarshal(data, &nodes); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	values := make(map[string]string, len(nodes))
+	for key, node := range nodes {
+		value, err := settingValue(&node)
+		if err != nil {
+			return nil, fmt.Errorf("config file %s: %s %w", path, key, err)
+		}
+		values[strings.ToUpper(key)] = value
+	}
+	return values, nil
+}
+
+// settingValue returns the text of a scalar as written, so that values
+// parse exactly as from the environment, or a list of scalars joined with
+// commas.
+func settingValue(node *yaml.Node) (string, error) {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		if node.Tag == "!!null" {
+			return "", nil
+		}
+		return node.Value, nil
+	case yaml.SequenceNode:
+		items := make([]string, 0, len(node.Content))
+		for _, item := range node.Content {
+			if item.Kind != yaml.ScalarNode {
+				return "", errors.New("must be a list of scalars")
+			}
+			items = append(items, item.Value)
+		}
+		return strings.Join(items, ","), nil
+	default:
+		return "", errors.New("must be a scalar or a list")
+	}
+}
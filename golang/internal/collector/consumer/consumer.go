@@ -2,27 +2,83 @@ package consumer
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"observability_hub/golang/internal/collector/config"
+	"observability_hub/golang/internal/collector/metrics"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
+// consumerTag prefixes the tags of this process's subscriptions, so that
+// they can be cancelled without closing their channels.
+const consumerTag = "collector"
+
+// Backoff between attempts to resubscribe after a channel or the
+// connection was lost.
+const (
+	minResubscribeBackoff = time.Second
+	maxResubscribeBackoff = 30 * time.Second
+)
+
+// idleProbeTimeout bounds the broker round trip that confirms an idle
+// connection is alive, and closing a connection found dead.
+const idleProbeTimeout = 10 * time.Second
+
 // Consumer holds the necessary components for a RabbitMQ consumer.
 type Consumer struct {
-	conn    *amqp.Connection
-	channel *amqp.Channel
-	cfg     *config.Config
+	mu       sync.Mutex
+	conn     *amqp.Connection
+	channel  *amqp.Channel   // Topology and queue inspection
+	channels []*amqp.Channel // One per subscription, indexed like the subscriptions
+	cfg      *config.Config
+	prefetch int // Current prefetch limit, applied to new subscriptions too
+	stopping atomic.Bool
+	// lastActivity is when a delivery last arrived or the broker last
+	// answered an idle probe, in Unix nanoseconds
+	lastActivity atomic.Int64
 }
 
 // New creates a new RabbitMQ consumer.
 func New(cfg *config.Config) (*Consumer, error) {
-	conn, err := amqp.Dial(cfg.RabbitMQURL)
+	conn, err := dial(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	ch, err := openChannel(conn, cfg)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &Consumer{
+		conn:     conn,
+		channel:  ch,
+		cfg:      cfg,
+		prefetch: cfg.RabbitMQPrefetch,
+	}, nil
+}
+
+// dial connects to RabbitMQ with the configured heartbeat. A connection
+// missing the broker's heartbeats is closed by the client.
+func dial(cfg *config.Config) (*amqp.Connection, error) {
+	conn, err := amqp.DialConfig(cfg.RabbitMQURL, amqp.Config{
+		Heartbeat: cfg.RabbitMQHeartbeat,
+		Locale:    "en_US",
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to RabbitMQ: %w", err)
 	}
+	return conn, nil
+}
 
+// openChannel opens a channel and declares the exchanges, queues and bindings the consumer relies on.
+func openChannel(conn *amqp.Connection, cfg *config.Config) (*amqp.Channel, error) {
 	ch, err := conn.Channel()
 	if err != nil {
 		return nil, fmt.Errorf("failed to open a channel: %w", err)
@@ -58,14 +114,20 @@ func New(cfg *config.Config) (*Consumer, error) {
 
 	// Declare the Dead Letter Queue
 	_, err = ch.QueueDeclare(
-		cfg.DLQName, // name
-		true,        // durable
-		false,       // delete when unused
-		false,       // exclusive
-		false,       // no-wait
-		nil,         // arguments
+		cfg.DLQName,  // name
+		true,         // durable
+		false,        // delete when unused
+		false,        // exclusive
+		false,        // no-wait
+		dlqArgs(cfg), // arguments
 	)
 	if err != nil {
+		var amqpErr *amqp.Error
+		if errors.As(err, &amqpErr) && amqpErr.Code == amqp.PreconditionFailed {
+			// Queue arguments cannot be changed once declared
+			return nil, fmt.Errorf("DLQ %q exists with different arguments than DLQ_MESSAGE_TTL, DLQ_MAX_LENGTH and DLQ_OVERFLOW ask for; "+
+				"delete the queue or apply the limits with a policy instead: %w", cfg.DLQName, err)
+		}
 		return nil, fmt.Errorf("failed to declare DLQ: %w", err)
 	}
 
@@ -109,41 +171,347 @@ func New(cfg *config.Config) (*Consumer, error) {
 		return nil, fmt.Errorf("failed to bind main queue to exchange: %w", err)
 	}
 
-	return &Consumer{
-		conn:    conn,
-		channel: ch,
-		cfg:     cfg,
-	}, nil
+	return ch, nil
+}
+
+// dlqArgs returns the DLQ arguments for the configured limits, nil when
+// there are none so that existing queues keep matching.
+func dlqArgs(cfg *config.Config) amqp.Table {
+	args := amqp.Table{}
+	if cfg.DLQMessageTTL > 0 {
+		args["x-message-ttl"] = cfg.DLQMessageTTL.Milliseconds()
+	}
+	if cfg.DLQMaxLength > 0 {
+		args["x-max-length"] = int64(cfg.DLQMaxLength)
+		args["x-overflow"] = cfg.DLQOverflow
+	}
+	if len(args) == 0 {
+		return nil
+	}
+	return args
 }
 
 // Start consuming messages from RabbitMQ.
-// It returns a channel of deliveries for workers to process.
+// It returns a channel of deliveries for workers to process, fed by
+// cfg.ConsumerChannels subscriptions on channels of their own. With more
+// than one the queue is consumed in parallel, so deliveries are no longer
+// received in queue order, not even per producer. A subscription whose
+// channel or connection is lost is re-established with backoff, after
+// redialing and redeclaring the topology if the connection went down; the
+// deliveries channel survives that and is closed only after StopConsuming.
 func (c *Consumer) Start(ctx context.Context) (<-chan amqp.Delivery, error) {
-	msgs, err := c.channel.Consume(
-		c.cfg.QueueName, // queue
-		"",              // consumer
-		false,           // auto-ack is false. We will manually ack messages.
-		false,           // exclusive
-		false,           // no-local
-		false,           // no-wait
-		nil,             // args
+	n := max(1, c.cfg.ConsumerChannels)
+	c.channels = make([]*amqp.Channel, n)
+	subscriptions := make([]subscription, n)
+	for i := range subscriptions {
+		sub, err := c.subscribe(i)
+		if err != nil {
+			return nil, err
+		}
+		subscriptions[i] = sub
+	}
+
+	out := make(chan amqp.Delivery)
+	var wg sync.WaitGroup
+	for i, sub := range subscriptions {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.forward(ctx, i, sub, out)
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+
+	c.lastActivity.Store(time.Now().UnixNano())
+	if c.cfg.ConsumerIdleTimeout > 0 {
+		go c.watchIdle(ctx)
+	}
+	return out, nil
+}
+
+// subscription is the deliveries of one consumer channel together with
+// the reason the channel was closed by the broker or the connection, if
+// it was.
+type subscription struct {
+	msgs   <-chan amqp.Delivery
+	closed <-chan *amqp.Error
+}
+
+// subscribe opens the channel of subscription i and starts consuming on it.
+func (c *Consumer) subscribe(i int) (subscription, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch, err := c.conn.Channel()
+	if err != nil {
+		return subscription{}, fmt.Errorf("failed to open a channel: %w", err)
+	}
+	if c.prefetch > 0 {
+		if err := ch.Qos(c.prefetch, 0, false); err != nil {
+			ch.Close()
+			return subscription{}, fmt.Errorf("failed to set prefetch: %w", err)
+		}
+	}
+	// Buffered, so that the close is never blocked on a reader
+	closed := ch.NotifyClose(make(chan *amqp.Error, 1))
+
+	msgs, err := ch.Consume(
+		c.cfg.QueueName,                      // queue
+		fmt.Sprintf("%s-%d", consumerTag, i), // consumer
+		c.cfg.AutoAck,                        // auto-ack is false by default. We manually ack messages.
+		false,                                // exclusive
+		false,                                // no-local
+		false,                                // no-wait
+		nil,                                  // args
 	)
 	if err != nil {
-		return nil, fmt.Errorf("failed to register a consumer: %w", err)
+		ch.Close()
+		return subscription{}, fmt.Errorf("failed to register a consumer: %w", err)
 	}
+	c.channels[i] = ch
+	return subscription{msgs: msgs, closed: closed}, nil
+}
 
-	// Reconnect logic
+// SetPrefetch changes the prefetch limit of all subscriptions; 0 removes
+// the limit. Deliveries already received are not affected.
+func (c *Consumer) SetPrefetch(n int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.prefetch = n
+	var errs []error
+	for _, ch := range c.channels {
+		if ch == nil || ch.IsClosed() {
+			continue
+		}
+		if err := ch.Qos(n, 0, false); err != nil {
+			errs = append(errs, fmt.Errorf("failed to set prefetch: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// forward passes the deliveries of subscription i on to out, resubscribing
+// whenever they end for any reason other than StopConsuming.
+func (c *Consumer) forward(ctx context.Context, i int, sub subscription, out chan<- amqp.Delivery) {
+	backoff := minResubscribeBackoff
+	for {
+		for d := range sub.msgs {
+			c.lastActivity.Store(time.Now().UnixNano())
+			out <- d
+		}
+		if c.stopping.Load() {
+			return
+		}
+
+		// The reason is sent before the deliveries end; nil for a close
+		// without an error
+		select {
+		case reason := <-sub.closed:
+			if reason != nil {
+				log.Printf("Consumer channel %d closed unexpectedly (%d %s, server: %t), resubscribing...", i, reason.Code, reason.Reason, reason.Server)
+				break
+			}
+			log.Printf("Consumer channel %d closed unexpectedly, resubscribing...", i)
+		default:
+			log.Printf("Consumer channel %d closed unexpectedly, resubscribing...", i)
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			if c.stopping.Load() {
+				return
+			}
+			if err := c.reconnect(); err != nil {
+				log.Printf("Failed to reconnect to RabbitMQ: %v", err)
+			} else if resubscribed, err := c.subscribe(i); err != nil {
+				log.Printf("Failed to resubscribe consumer channel %d: %v", i, err)
+			} else {
+				sub = resubscribed
+				backoff = minResubscribeBackoff
+				log.Printf("Consumer channel %d resubscribed", i)
+				break
+			}
+			backoff = min(backoff*2, maxResubscribeBackoff)
+		}
+	}
+}
+
+// watchIdle catches half-open connections, dropped by an intermediary
+// without a close frame, on which nothing would arrive anymore. Once no
+// delivery arrived for the idle timeout it probes the broker with a round
+// trip rather than trusting the silence: a quiet but healthy queue answers
+// and is left alone. A connection whose probe goes unanswered is closed,
+// so that the subscriptions reconnect and resubscribe.
+func (c *Consumer) watchIdle(ctx context.Context) {
+	timeout := c.cfg.ConsumerIdleTimeout
+	ticker := time.NewTicker(max(timeout/2, time.Second))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if c.stopping.Load() {
+			return
+		}
+		idle := time.Since(time.Unix(0, c.lastActivity.Load()))
+		conn := c.connection()
+		if idle < timeout || conn.IsClosed() {
+			// Active, or already being reconnected
+			continue
+		}
+
+		if c.probe() {
+			metrics.ConsumerIdleProbes.WithLabelValues("alive").Inc()
+			c.lastActivity.Store(time.Now().UnixNano())
+			continue
+		}
+		metrics.ConsumerIdleProbes.WithLabelValues("recycled").Inc()
+		log.Printf("No deliveries for %s and the broker did not answer a probe, recycling the RabbitMQ connection", idle.Round(time.Second))
+		conn.CloseDeadline(time.Now().Add(idleProbeTimeout))
+		c.lastActivity.Store(time.Now().UnixNano())
+	}
+}
+
+// probe reports whether the broker answers a passive queue declaration
+// within idleProbeTimeout.
+func (c *Consumer) probe() bool {
+	done := make(chan error, 1)
 	go func() {
-		<-ctx.Done()
-		log.Println("Shutting down consumer...")
-		c.Close()
+		// Returns once the connection closes if the broker never answers
+		_, err := c.QueueDepth()
+		done <- err
 	}()
+	select {
+	case err := <-done:
+		return err == nil
+	case <-time.After(idleProbeTimeout):
+		return false
+	}
+}
+
+// reconnect re-dials RabbitMQ and redeclares the topology if the
+// connection was lost. It is a no-op while the connection is up.
+func (c *Consumer) reconnect() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	return msgs, nil
+	if !c.conn.IsClosed() {
+		return nil
+	}
+	conn, err := dial(c.cfg)
+	if err != nil {
+		metrics.ConsumerReconnects.WithLabelValues("failed").Inc()
+		return err
+	}
+	ch, err := openChannel(conn, c.cfg)
+	if err != nil {
+		metrics.ConsumerReconnects.WithLabelValues("failed").Inc()
+		conn.Close()
+		return err
+	}
+	c.conn, c.channel = conn, ch
+	metrics.ConsumerReconnects.WithLabelValues("success").Inc()
+	log.Println("Reconnected to RabbitMQ")
+	return nil
 }
 
-// Close gracefully shuts down the connection and channel.
+// topologyChannel returns the channel for topology and queue inspection.
+// A channel error closes only the channel, not the connection, and no
+// subscription notices; the channel is then reopened on the connection.
+func (c *Consumer) topologyChannel() (*amqp.Channel, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.channel.IsClosed() || c.conn.IsClosed() {
+		// Open, or reopened by the next reconnect
+		return c.channel, nil
+	}
+	ch, err := openChannel(c.conn, c.cfg)
+	if err != nil {
+		return nil, err
+	}
+	c.channel = ch
+	log.Println("Reopened the RabbitMQ topology channel")
+	return ch, nil
+}
+
+// connection returns the current connection.
+func (c *Consumer) connection() *amqp.Connection {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn
+}
+
+// ServerVersion returns the RabbitMQ version the broker announced on the
+// current connection, empty if it announced none.
+func (c *Consumer) ServerVersion() string {
+	version, _ := c.connection().Properties["version"].(string)
+	return version
+}
+
+// StopConsuming asks the broker to stop sending deliveries. Deliveries
+// already in flight are still delivered, after which the deliveries channel
+// closes. The channels stay open, so they can still be acknowledged.
+func (c *Consumer) StopConsuming() error {
+	c.stopping.Store(true)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, ch := range c.channels {
+		if ch == nil || ch.IsClosed() {
+			continue
+		}
+		if err := ch.Cancel(fmt.Sprintf("%s-%d", consumerTag, i), false); err != nil {
+			return fmt.Errorf("failed to cancel consumer %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// QueueDepth returns the number of messages ready for delivery in the main
+// queue. Messages delivered to this consumer but not yet acked are not
+// included.
+func (c *Consumer) QueueDepth() (int, error) {
+	ch, err := c.topologyChannel()
+	if err != nil {
+		return 0, fmt.Errorf("failed to inspect queue: %w", err)
+	}
+
+	q, err := ch.QueueDeclarePassive(
+		c.cfg.QueueName, // name
+		true,            // durable
+		false,           // delete when unused
+		false,           // exclusive
+		false,           // no-wait
+		amqp.Table{"x-dead-letter-exchange": c.cfg.DLXName},
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to inspect queue: %w", err)
+	}
+	return q.Messages, nil
+}
+
+// Close gracefully shuts down the connection and channels.
 func (c *Consumer) Close() {
+	c.stopping.Store(true)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, ch := range c.channels {
+		if ch != nil {
+			ch.Close()
+		}
+	}
 	if c.channel != nil {
 		c.channel.Close()
 	}
@@ -0,0 +1,230 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"observability_hub/golang/internal/collector/metrics"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// ReplayCountHeader counts how often a message was replayed from the DLQ.
+// Messages that reached the replay limit stay in the DLQ, so that one
+// that keeps failing does not cycle between the queues forever.
+const ReplayCountHeader = "x-collector-replays"
+
+// ReplayResult counts the outcome of a DLQ replay.
+type ReplayResult struct {
+	Replayed int `json:"replayed"` // Republished to the main queue
+	Failed   int `json:"failed"`   // Could not be republished; left in the DLQ
+	Skipped  int `json:"skipped"`  // Reached the replay limit; moved to the tail of the DLQ
+}
+
+// DLQReader fetches dead-lettered messages one at a time, for reprocessing
+// them after a fix was deployed. It uses its own channel on the consumer's
+// connection.
+type DLQReader struct {
+	channel *amqp.Channel
+	queue   string
+	dlx     string
+}
+
+// NewDLQReader opens a channel for reading the dead letter queue.
+func NewDLQReader(c *Consumer) (*DLQReader, error) {
+	ch, err := c.connection().Channel()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open a DLQ channel: %w", err)
+	}
+	return &DLQReader{
+		channel: ch,
+		queue:   c.cfg.DLQName,
+		dlx:     c.cfg.DLXName,
+	}, nil
+}
+
+// Depth returns the number of messages in the dead letter queue.
+func (r *DLQReader) Depth() (int, error) {
+	q, err := r.channel.QueueDeclarePassive(
+		r.queue, // name
+		true,    // durable
+		false,   // delete when unused
+		false,   // exclusive
+		false,   // no-wait
+		nil,     // arguments
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to inspect DLQ: %w", err)
+	}
+	return q.Messages, nil
+}
+
+// Get fetches the next message. ok is false when the queue is empty. The
+// message must be settled with Ack or Requeue.
+func (r *DLQReader) Get() (d amqp.Delivery, ok bool, err error) {
+	d, ok, err = r.channel.Get(r.queue, false)
+	if err != nil {
+		return d, false, fmt.Errorf("failed to get from DLQ: %w", err)
+	}
+	return d, ok, nil
+}
+
+// Requeue moves a message to the tail of the dead letter queue, keeping
+// its headers, so that one pass over the queue does not fetch it again.
+func (r *DLQReader) Requeue(ctx context.Context, d amqp.Delivery) error {
+	err := r.channel.PublishWithContext(ctx,
+		r.dlx, // exchange
+		"",    // routing key
+		false, // mandatory
+		false, // immediate
+		amqp.Publishing{
+			Headers:         d.Headers,
+			ContentType:     d.ContentType,
+			ContentEncoding: d.ContentEncoding,
+			DeliveryMode:    amqp.Persistent,
+			CorrelationId:   d.CorrelationId,
+			MessageId:       d.MessageId,
+			Timestamp:       d.Timestamp,
+			Type:            d.Type,
+			Body:            d.Body,
+		})
+	if err != nil {
+		// Leave the message where it is
+		d.Nack(false, true)
+		return fmt.Errorf("failed to requeue to DLQ: %w", err)
+	}
+	return d.Ack(false)
+}
+
+// ReplayDLQ republishes up to limit dead-lettered messages to the main
+// queue, for reprocessing them after a fix was deployed, and counts the
+// outcome. Messages keep their headers and properties and are published
+// through the default exchange, so that they reach the main queue
+// whatever their routing key. At most the messages present when the
+// replay started are fetched, and messages replayed maxReplays times
+// already are moved to the tail of the DLQ instead. Each message leaves
+// the DLQ only once the broker confirmed its republication.
+func (c *Consumer) ReplayDLQ(ctx context.Context, limit, maxReplays int) (ReplayResult, error) {
+	var result ReplayResult
+	ch, err := c.connection().Channel()
+	if err != nil {
+		return result, fmt.Errorf("failed to open a replay channel: %w", err)
+	}
+	defer ch.Close()
+	if err := ch.Confirm(false); err != nil {
+		return result, fmt.Errorf("failed to enable publisher confirms: %w", err)
+	}
+
+	q, err := ch.QueueDeclarePassive(c.cfg.DLQName, true, false, false, false, nil)
+	if err != nil {
+		return result, fmt.Errorf("failed to inspect DLQ: %w", err)
+	}
+	for i := 0; i < min(limit, q.Messages); i++ {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		d, ok, err := ch.Get(c.cfg.DLQName, false)
+		if err != nil {
+			return result, fmt.Errorf("failed to get from DLQ: %w", err)
+		}
+		if !ok {
+			break
+		}
+
+		replays, _ := headerCount(d.Headers[ReplayCountHeader])
+		exchange, key := "", c.cfg.QueueName
+		if replays >= maxReplays {
+			exchange, key = c.cfg.DLXName, ""
+		}
+		headers := make(amqp.Table, len(d.Headers)+1)
+		for k, v := range d.Headers {
+			headers[k] = v
+		}
+		if exchange == "" {
+			headers[ReplayCountHeader] = int64(replays + 1)
+		}
+
+		if err := republish(ctx, ch, exchange, key, d, headers); err != nil {
+			result.Failed++
+			metrics.DLQReplayed.WithLabelValues("failed").Inc()
+			d.Nack(false, true)
+			// A closed channel fails every further message too
+			if ch.IsClosed() {
+				return result, err
+			}
+			continue
+		}
+		d.Ack(false)
+		if exchange == "" {
+			result.Replayed++
+			metrics.DLQReplayed.WithLabelValues("replayed").Inc()
+		} else {
+			result.Skipped++
+			metrics.DLQReplayed.WithLabelValues("skipped").Inc()
+		}
+	}
+	return result, nil
+}
+
+// republish publishes a copy of d with the given headers and waits for
+// the broker to confirm it.
+func republish(ctx context.Context, ch *amqp.Channel, exchange, key string, d amqp.Delivery, headers amqp.Table) error {
+	confirm, err := ch.PublishWithDeferredConfirmWithContext(ctx,
+		exchange, // exchange
+		key,      // routing key
+		false,    // mandatory
+		false,    // immediate
+		amqp.Publishing{
+			Headers:         headers,
+			ContentType:     d.ContentType,
+			ContentEncoding: d.ContentEncoding,
+			DeliveryMode:    amqp.Persistent,
+			Priority:        d.Priority,
+			CorrelationId:   d.CorrelationId,
+			MessageId:       d.MessageId,
+			Timestamp:       d.Timestamp,
+			Type:            d.Type,
+			AppId:           d.AppId,
+			Body:            d.Body,
+		})
+	if err != nil {
+		return fmt.Errorf("failed to republish: %w", err)
+	}
+	acked, err := confirm.WaitContext(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to confirm republication: %w", err)
+	}
+	if !acked {
+		return errors.New("broker rejected the republished message")
+	}
+	return nil
+}
+
+// headerCount reads a counter header, which clients may have encoded with
+// any integer type.
+func headerCount(value interface{}) (int, bool) {
+	switch v := value.(type) {
+	case int:
+		return v, true
+	case int8:
+		return int(v), true
+	case int16:
+		return int(v), true
+	case int32:
+		return int(v), true
+	case int64:
+		return int(v), true
+	case uint8:
+		return int(v), true
+	case uint16:
+		return int(v), true
+	case uint32:
+		return int(v), true
+	}
+	return 0, false
+}
+
+// Close closes the DLQ channel.
+func (r *DLQReader) Close() {
+	r.channel.Close()
+}
@@ -0,0 +1,87 @@
+package consumer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"observability_hub/golang/internal/collector/config"
+	"observability_hub/golang/internal/collector/storage"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Publisher re-publishes enriched events to a topic exchange, making the
+// collector one stage of a multi-stage pipeline. Routing keys come from
+// the configured template, so downstream queues can bind by service,
+// level and so on.
+type Publisher struct {
+	channel    *amqp.Channel
+	exchange   string
+	routingKey *RoutingKeyTemplate
+}
+
+// NewPublisher opens a channel on the consumer's connection and declares
+// the republish exchange.
+func NewPublisher(c *Consumer, cfg *config.Config) (*Publisher, error) {
+	routingKey, err := NewRoutingKeyTemplate(cfg.RepublishRoutingKey)
+	if err != nil {
+		return nil, err
+	}
+
+	ch, err := c.connection().Channel()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open a publish channel: %w", err)
+	}
+
+	// Declare the republish exchange (topic)
+	err = ch.ExchangeDeclare(
+		cfg.RepublishExchange, // name
+		"topic",               // type
+		true,                  // durable
+		false,                 // auto-deleted
+		false,                 // internal
+		false,                 // no-wait
+		nil,                   // arguments
+	)
+	if err != nil {
+		ch.Close()
+		return nil, fmt.Errorf("failed to declare republish exchange: %w", err)
+	}
+
+	return &Publisher{
+		channel:    ch,
+		exchange:   cfg.RepublishExchange,
+		routingKey: routingKey,
+	}, nil
+}
+
+// Publish sends an event to the republish exchange.
+func (p *Publisher) Publish(ctx context.Context, event *storage.LogEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	err = p.channel.PublishWithContext(ctx,
+		p.exchange,                 // exchange
+		p.routingKey.Render(event), // routing key
+		false,                      // mandatory
+		false,                      // immediate
+		amqp.Publishing{
+			ContentType:   "application/json",
+			DeliveryMode:  amqp.Persistent,
+			MessageId:     event.EventID,
+			CorrelationId: event.CorrelationID,
+			Type:          event.EventType,
+			Body:          body,
+		})
+	if err != nil {
+		return fmt.Errorf("failed to publish event: %w", err)
+	}
+	return nil
+}
+
+// Close closes the publish channel.
+func (p *Publisher) Close() {
+	p.channel.Close()
+}
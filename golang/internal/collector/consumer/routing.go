@@ -0,0 +1,82 @@
+package consumer
+
+import (
+	"fmt"
+	"observability_hub/golang/internal/collector/storage"
+	"regexp"
+	"strings"
+)
+
+// routingKeyFields are the event fields usable in a routing key template.
+var routingKeyFields = map[string]func(*storage.LogEvent) string{
+	"eventType":         func(e *storage.LogEvent) string { return e.EventType },
+	"source.service":    func(e *storage.LogEvent) string { return e.Source.Service },
+	"source.version":    func(e *storage.LogEvent) string { return e.Source.Version },
+	"data.level":        func(e *storage.LogEvent) string { return e.Data.Level },
+	"metadata.priority": func(e *storage.LogEvent) string { return e.Metadata.Priority },
+	"metadata.environment": func(e *storage.LogEvent) string {
+		if e.Metadata.Environment == nil {
+			return ""
+		}
+		return *e.Metadata.Environment
+	},
+}
+
+var placeholderPattern = regexp.MustCompile(`\{([^{}]*)\}`)
+
+// unknownRoutingWord replaces empty field values in a routing key.
+const unknownRoutingWord = "unknown"
+
+// RoutingKeyTemplate derives topic routing keys from events, e.g.
+// "logs.{source.service}.{data.level}" yields "logs.checkout.error".
+// Dots in field values are replaced by underscores so that every field is
+// exactly one word for topic bindings.
+type RoutingKeyTemplate struct {
+	parts []routingKeyPart
+}
+
+// routingKeyPart is either literal text or a field lookup.
+type routingKeyPart struct {
+	literal string
+	field   func(*storage.LogEvent) string
+}
+
+// NewRoutingKeyTemplate parses a routing key template. Unknown fields are
+// an error.
+func NewRoutingKeyTemplate(template string) (*RoutingKeyTemplate, error) {
+	t := &RoutingKeyTemplate{}
+	last := 0
+	for _, loc := range placeholderPattern.FindAllStringSubmatchIndex(template, -1) {
+		if loc[0] > last {
+			t.parts = append(t.parts, routingKeyPart{literal: template[last:loc[0]]})
+		}
+		name := template[loc[2]:loc[3]]
+		field, ok := routingKeyFields[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown routing key field %q", name)
+		}
+		t.parts = append(t.parts, routingKeyPart{field: field})
+		last = loc[1]
+	}
+	if last < len(template) {
+		t.parts = append(t.parts, routingKeyPart{literal: template[last:]})
+	}
+	return t, nil
+}
+
+// Render returns the routing key of event.
+func (t *RoutingKeyTemplate) Render(event *storage.LogEvent) string {
+	var b strings.Builder
+	for _, part := range t.parts {
+		if part.field == nil {
+			b.WriteString(part.literal)
+			continue
+		}
+		value := strings.ReplaceAll(part.field(event), ".", "_")
+		if value == "" {
+			value = unknownRoutingWord
+		}
+		b.WriteString(value)
+	}
+	return b.String()
+}
@@ -0,0 +1,21 @@
+// Package enrich adds collector-side data to events after they passed the
+// checks and before they are stored.
+package enrich
+
+import "observability_hub/golang/internal/collector/storage"
+
+// Enricher adds data to an event. Enrichment is best effort: an enricher
+// that cannot help leaves the event as it is and never rejects it.
+type Enricher interface {
+	Enrich(event *storage.LogEvent)
+}
+
+// Chain runs enrichers in order.
+type Chain []Enricher
+
+// Enrich applies every enricher of the chain to the event.
+func (c Chain) Enrich(event *storage.LogEvent) {
+	for _, e := range c {
+		e.Enrich(event)
+	}
+}
@@ -0,0 +1,188 @@
+package enrich
+
+import (
+	"container/list"
+	"fmt"
+	"maps"
+	"net"
+	"observability_hub/golang/internal/collector/metrics"
+	"observability_hub/golang/internal/collector/storage"
+	"strings"
+	"sync"
+
+	"github.com/oschwald/maxminddb-golang"
+	"go.uber.org/zap"
+)
+
+// geoKey is the structured key receiving the location.
+const geoKey = "geo"
+
+// geoRecord is the part of a GeoIP2/GeoLite2 City or Country record that
+// is copied into events.
+type geoRecord struct {
+	Country struct {
+		ISOCode string            `maxminddb:"iso_code"`
+		Names   map[string]string `maxminddb:"names"`
+	} `maxminddb:"country"`
+	Subdivisions []struct {
+		ISOCode string            `maxminddb:"iso_code"`
+		Names   map[string]string `maxminddb:"names"`
+	} `maxminddb:"subdivisions"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+}
+
+// GeoIP adds the location of a client IP, read from a structured field, to
+// the structured data under "geo". Lookups are cached, including misses;
+// the cache holds at most size addresses and evicts the least recently
+// used one.
+type GeoIP struct {
+	db     *maxminddb.Reader
+	path   []string // Dotted path of the IP within the structured data
+	size   int
+	logger *zap.Logger
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // Front is the most recently used address
+}
+
+// geoEntry is a cached lookup; geo is nil when the address is unknown.
+type geoEntry struct {
+	ip  string
+	geo map[string]interface{}
+}
+
+// NewGeoIP opens the MaxMind database at dbPath. field is the dotted path
+// of the client IP within data.structured, e.g. "http.clientIp".
+func NewGeoIP(dbPath, field string, size int, logger *zap.Logger) (*GeoIP, error) {
+	db, err := maxminddb.Open(dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open GeoIP database: %w", err)
+	}
+	return &GeoIP{
+		db:      db,
+		path:    strings.Split(field, "."),
+		size:    max(1, size),
+		logger:  logger,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}, nil
+}
+
+// Enrich adds the location of the event's client IP, if it has one and
+// the database knows it.
+func (g *GeoIP) Enrich(event *storage.LogEvent) {
+	if event.Data.Structured == nil {
+		return
+	}
+	raw, ok := lookupPath(*event.Data.Structured, g.path)
+	if !ok {
+		return
+	}
+	ip := net.ParseIP(strings.TrimSpace(raw))
+	if ip == nil {
+		metrics.GeoIPLookups.WithLabelValues("invalid").Inc()
+		return
+	}
+
+	geo, ok := g.cached(ip.String())
+	if ok {
+		metrics.GeoIPLookups.WithLabelValues("cached").Inc()
+	} else {
+		var err error
+		geo, err = g.lookup(ip)
+		if err != nil {
+			// Not cached, the next event tries again
+			metrics.GeoIPLookups.WithLabelValues("error").Inc()
+			g.logger.Debug("GeoIP lookup failed", zap.String("ip", ip.String()), zap.Error(err))
+			return
+		}
+		g.store(ip.String(), geo)
+	}
+	if geo == nil {
+		return
+	}
+	(*event.Data.Structured)[geoKey] = geo
+}
+
+// Close closes the database.
+func (g *GeoIP) Close() error {
+	return g.db.Close()
+}
+
+// lookup reads the location of ip from the database; nil when unknown.
+func (g *GeoIP) lookup(ip net.IP) (map[string]interface{}, error) {
+	var record geoRecord
+	_, found, err := g.db.LookupNetwork(ip, &record)
+	if err != nil {
+		return nil, err
+	}
+	if !found || record.Country.ISOCode == "" {
+		metrics.GeoIPLookups.WithLabelValues("not_found").Inc()
+		return nil, nil
+	}
+	metrics.GeoIPLookups.WithLabelValues("found").Inc()
+
+	geo := map[string]interface{}{
+		"country":     record.Country.ISOCode,
+		"countryName": record.Country.Names["en"],
+	}
+	if len(record.Subdivisions) > 0 {
+		geo["region"] = record.Subdivisions[0].ISOCode
+		geo["regionName"] = record.Subdivisions[0].Names["en"]
+	}
+	if city := record.City.Names["en"]; city != "" {
+		geo["city"] = city
+	}
+	return geo, nil
+}
+
+// cached returns a copy of the cached location, so that events never
+// share a map.
+func (g *GeoIP) cached(ip string) (map[string]interface{}, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	elem, ok := g.entries[ip]
+	if !ok {
+		return nil, false
+	}
+	g.lru.MoveToFront(elem)
+	return maps.Clone(elem.Value.(*geoEntry).geo), true
+}
+
+func (g *GeoIP) store(ip string, geo map[string]interface{}) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if _, ok := g.entries[ip]; ok {
+		return
+	}
+	if len(g.entries) >= g.size {
+		oldest := g.lru.Back()
+		g.lru.Remove(oldest)
+		delete(g.entries, oldest.Value.(*geoEntry).ip)
+	}
+	// The event owns geo; the cache keeps its own copy
+	g.entries[ip] = g.lru.PushFront(&geoEntry{ip: ip, geo: maps.Clone(geo)})
+}
+
+// lookupPath returns the string at a dotted path of nested objects.
+func lookupPath(m map[string]interface{}, path []string) (string, bool) {
+	for i, key := range path {
+		v, ok := m[key]
+		if !ok {
+			return "", false
+		}
+		if i == len(path)-1 {
+			s, ok := v.(string)
+			return s, ok
+		}
+		if m, ok = v.(map[string]interface{}); !ok {
+			return "", false
+		}
+	}
+	return "", false
+}
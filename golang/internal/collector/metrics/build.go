@@ -0,0 +1,22 @@
+package metrics
+
+import (
+	"observability_hub/golang/internal/collector/buildinfo"
+	"runtime"
+)
+
+// ServerVersions are the versions the collector's dependencies reported;
+// empty when unknown, e.g. before connecting or with Elasticsearch off.
+type ServerVersions struct {
+	Postgres      string
+	Elasticsearch string
+	RabbitMQ      string
+}
+
+// SetBuildInfo sets collector_build_info to 1 for the running build and the
+// given server versions, replacing the series set before.
+func SetBuildInfo(servers ServerVersions) {
+	BuildInfo.Reset()
+	BuildInfo.WithLabelValues(buildinfo.Version, buildinfo.Commit, runtime.Version(),
+		servers.Postgres, servers.Elasticsearch, servers.RabbitMQ).Set(1)
+}
@@ -0,0 +1,213 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"observability_hub/golang/internal/collector/config"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// maxBudgetServices bounds the services tracked without a budget of their
+// own; later ones are not watched.
+const maxBudgetServices = 1000
+
+// ErrorBudgets watches the share of ERROR and FATAL events of every service
+// against its error budget. Like the RateTracker it keeps exponentially
+// weighted moving averages, of the error and the total rate, updated every
+// rate tick and averaged over the budget window. A service breaches its
+// budget when its error ratio exceeds the threshold and recovers once the
+// ratio falls below ERROR_BUDGET_RECOVERY times the threshold, so that a
+// ratio hovering around the threshold does not flap. Breaches and
+// recoveries are logged and, with a webhook, posted.
+type ErrorBudgets struct {
+	mu       sync.Mutex
+	cfg      *config.Config
+	services map[string]*budgetWindow
+	client   *http.Client
+	logger   *zap.Logger
+}
+
+// budgetWindow is the error budget state of one service.
+type budgetWindow struct {
+	budget    config.ErrorBudget
+	alpha     float64
+	errors    int64   // ERROR and FATAL events since the last tick
+	total     int64   // Events since the last tick
+	errorRate float64 // Events per second
+	totalRate float64 // Events per second
+	primed    bool    // Whether the rates hold a measurement yet
+	breached  bool
+}
+
+// budgetAlert is the webhook payload of a breach or recovery. Text makes
+// it readable in Slack-compatible webhooks.
+type budgetAlert struct {
+	Service    string    `json:"service"`
+	State      string    `json:"state"` // breached or recovered
+	ErrorRatio float64   `json:"errorRatio"`
+	Threshold  float64   `json:"threshold"`
+	Window     string    `json:"window"`
+	At         time.Time `json:"at"`
+	Text       string    `json:"text"`
+}
+
+// NewErrorBudgets creates a watcher for the configured budgets.
+func NewErrorBudgets(cfg *config.Config, logger *zap.Logger) *ErrorBudgets {
+	return &ErrorBudgets{
+		cfg:      cfg,
+		services: make(map[string]*budgetWindow),
+		client:   &http.Client{Timeout: cfg.WebhookTimeout},
+		logger:   logger.Named("error_budgets"),
+	}
+}
+
+// Observe records one event of service at level.
+func (b *ErrorBudgets) Observe(service, level string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	w, ok := b.services[service]
+	if !ok {
+		budget, own := b.cfg.ErrorBudgetServices[service]
+		if !own {
+			if len(b.services) >= maxBudgetServices {
+				return
+			}
+			budget = b.cfg.ErrorBudgetDefault
+		}
+		if budget.Threshold <= 0 {
+			return
+		}
+		w = &budgetWindow{
+			budget: budget,
+			alpha:  1 - math.Exp(-rateTick.Seconds()/budget.Window.Seconds()),
+		}
+		b.services[service] = w
+	}
+	w.total++
+	if strings.EqualFold(level, "ERROR") || strings.EqualFold(level, "FATAL") {
+		w.errors++
+	}
+}
+
+// Run updates the error ratios and raises alerts until ctx is cancelled.
+func (b *ErrorBudgets) Run(ctx context.Context) {
+	ticker := time.NewTicker(rateTick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, alert := range b.tick() {
+				b.alert(ctx, alert)
+			}
+		}
+	}
+}
+
+// tick folds the counts since the previous tick into the averages and
+// returns the budgets that changed state.
+func (b *ErrorBudgets) tick() []budgetAlert {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var alerts []budgetAlert
+	for service, w := range b.services {
+		errorRate := float64(w.errors) / rateTick.Seconds()
+		totalRate := float64(w.total) / rateTick.Seconds()
+		if w.primed {
+			w.errorRate += w.alpha * (errorRate - w.errorRate)
+			w.totalRate += w.alpha * (totalRate - w.totalRate)
+		} else {
+			w.errorRate, w.totalRate, w.primed = errorRate, totalRate, true
+		}
+		w.errors, w.total = 0, 0
+
+		var ratio float64
+		if w.totalRate > 0 {
+			ratio = w.errorRate / w.totalRate
+		}
+		ServiceErrorRatio.WithLabelValues(service).Set(ratio)
+
+		// Too few events in the window for the ratio to mean much
+		enough := w.totalRate*w.budget.Window.Seconds() >= float64(b.cfg.ErrorBudgetMinEvents)
+		switch {
+		case !w.breached && enough && ratio > w.budget.Threshold:
+			w.breached = true
+			alerts = append(alerts, newBudgetAlert(service, "breached", ratio, w.budget))
+		case w.breached && ratio < w.budget.Threshold*b.cfg.ErrorBudgetRecovery:
+			w.breached = false
+			alerts = append(alerts, newBudgetAlert(service, "recovered", ratio, w.budget))
+		}
+	}
+	return alerts
+}
+
+func newBudgetAlert(service, state string, ratio float64, budget config.ErrorBudget) budgetAlert {
+	return budgetAlert{
+		Service:    service,
+		State:      state,
+		ErrorRatio: ratio,
+		Threshold:  budget.Threshold,
+		Window:     budget.Window.String(),
+		At:         time.Now().UTC(),
+		Text: fmt.Sprintf("Error budget %s for %s: %.1f%% ERROR/FATAL events over %s (budget %.1f%%)",
+			state, service, ratio*100, budget.Window, budget.Threshold*100),
+	}
+}
+
+// alert logs a state change and posts it to the webhook, if one is set.
+// Alerts are best effort and not retried; the gauge keeps the ratio.
+func (b *ErrorBudgets) alert(ctx context.Context, alert budgetAlert) {
+	ErrorBudgetAlerts.WithLabelValues(alert.State).Inc()
+	fields := []zap.Field{
+		zap.String("service", alert.Service),
+		zap.Float64("error_ratio", alert.ErrorRatio),
+		zap.Float64("threshold", alert.Threshold),
+		zap.String("window", alert.Window),
+	}
+	if alert.State == "breached" {
+		b.logger.Warn("Error budget breached", fields...)
+	} else {
+		b.logger.Info("Error budget recovered", fields...)
+	}
+
+	if b.cfg.ErrorBudgetWebhookURL == "" {
+		return
+	}
+	if err := b.post(ctx, alert); err != nil {
+		b.logger.Warn("Failed to post error budget alert", zap.Error(err), zap.String("service", alert.Service))
+	}
+}
+
+func (b *ErrorBudgets) post(ctx context.Context, alert budgetAlert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.cfg.ErrorBudgetWebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"strings"
+	"sync"
+)
+
+// Instance labels that do not name an instance.
+const (
+	instanceNone  = "none"  // The event carried no source.instance
+	instanceOther = "other" // Seen after the label limit was reached
+)
+
+// InstanceTracker counts events and errors per service instance, so that a
+// single noisy or failing pod stands out during an incident. Labels are
+// bounded: the first maxInstances distinct service/instance pairs get
+// their own label, instances seen later count as "other" of their service.
+type InstanceTracker struct {
+	mu           sync.Mutex
+	maxInstances int
+	labels       map[instanceKey]bool
+}
+
+type instanceKey struct {
+	service  string
+	instance string
+}
+
+// NewInstanceTracker creates a tracker labelling at most maxInstances
+// instances.
+func NewInstanceTracker(maxInstances int) *InstanceTracker {
+	return &InstanceTracker{
+		maxInstances: maxInstances,
+		labels:       make(map[instanceKey]bool),
+	}
+}
+
+// Observe records one event of the given service instance at level.
+func (t *InstanceTracker) Observe(service string, instance *string, level string) {
+	label := instanceNone
+	if instance != nil {
+		if trimmed := strings.TrimSpace(*instance); trimmed != "" {
+			label = trimmed
+		}
+	}
+
+	if label != instanceNone {
+		key := instanceKey{service: service, instance: label}
+		t.mu.Lock()
+		if !t.labels[key] {
+			if len(t.labels) < t.maxInstances {
+				t.labels[key] = true
+			} else {
+				label = instanceOther
+			}
+		}
+		t.mu.Unlock()
+	}
+
+	EventsByInstance.WithLabelValues(service, label).Inc()
+	if strings.EqualFold(level, "ERROR") || strings.EqualFold(level, "FATAL") {
+		ErrorsByInstance.WithLabelValues(service, label).Inc()
+	}
+}
@@ -0,0 +1,111 @@
+package metrics
+
+import (
+	"container/list"
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// rateTick is how often the per-service rates are updated.
+const rateTick = 5 * time.Second
+
+// RateTracker keeps an exponentially weighted moving average of the ingest
+// rate of every service, so that other components can act on current
+// rates (rate limiting, auto-tuning) rather than cumulative counters. The
+// number of tracked services is bounded; the least recently seen service
+// is evicted when a new one arrives at the limit.
+type RateTracker struct {
+	mu          sync.Mutex
+	alpha       float64
+	maxServices int
+	services    map[string]*list.Element
+	lru         *list.List // Front is the most recently seen service
+}
+
+// serviceRate is the rate state of one service.
+type serviceRate struct {
+	service string
+	count   int64   // Events since the last tick
+	rate    float64 // Events per second
+	primed  bool    // Whether rate holds a measurement yet
+}
+
+// NewRateTracker creates a tracker averaging over roughly window and
+// tracking at most maxServices services.
+func NewRateTracker(window time.Duration, maxServices int) *RateTracker {
+	return &RateTracker{
+		alpha:       1 - math.Exp(-rateTick.Seconds()/window.Seconds()),
+		maxServices: maxServices,
+		services:    make(map[string]*list.Element),
+		lru:         list.New(),
+	}
+}
+
+// Observe records one event of service.
+func (t *RateTracker) Observe(service string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if elem, ok := t.services[service]; ok {
+		elem.Value.(*serviceRate).count++
+		t.lru.MoveToFront(elem)
+		return
+	}
+
+	if len(t.services) >= t.maxServices {
+		oldest := t.lru.Back()
+		evicted := t.lru.Remove(oldest).(*serviceRate)
+		delete(t.services, evicted.service)
+		ServiceIngestRate.DeleteLabelValues(evicted.service)
+	}
+	t.services[service] = t.lru.PushFront(&serviceRate{service: service, count: 1})
+}
+
+// Rate returns the current ingest rate of service in events per second,
+// or 0 for an untracked service.
+func (t *RateTracker) Rate(service string) float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if elem, ok := t.services[service]; ok {
+		return elem.Value.(*serviceRate).rate
+	}
+	return 0
+}
+
+// Run updates the rates and the ingest rate gauge until ctx is cancelled.
+func (t *RateTracker) Run(ctx context.Context) {
+	ticker := time.NewTicker(rateTick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			t.tick()
+		}
+	}
+}
+
+// tick folds the counts since the previous tick into the averages. The
+// first measurement of a service is taken as is, so that new services do
+// not start from zero.
+func (t *RateTracker) tick() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for elem := t.lru.Front(); elem != nil; elem = elem.Next() {
+		sr := elem.Value.(*serviceRate)
+		current := float64(sr.count) / rateTick.Seconds()
+		if sr.primed {
+			sr.rate += t.alpha * (current - sr.rate)
+		} else {
+			sr.rate, sr.primed = current, true
+		}
+		sr.count = 0
+		ServiceIngestRate.WithLabelValues(sr.service).Set(sr.rate)
+	}
+}
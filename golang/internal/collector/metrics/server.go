@@ -6,12 +6,24 @@ import (
 	"log"
 	"net/http"
 	"observability_hub/golang/internal/collector/config"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+// Native histogram settings for the duration and size histograms. Native
+// histograms are only exposed to scrapers that negotiate the protobuf format;
+// classic scrapers keep receiving the regular buckets.
+const (
+	nativeHistogramBucketFactor = 1.1
+	nativeHistogramMaxBuckets   = 160
+	nativeHistogramMinReset     = time.Hour
+)
+
 var (
 	MessagesProcessed = promauto.NewCounter(prometheus.CounterOpts{
 		Name: "collector_messages_processed_total",
@@ -29,6 +41,10 @@ var (
 		Name: "collector_messages_skipped_total",
 		Help: "The total number of skipped duplicate messages",
 	})
+	DedupClaimedBy = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "collector_dedup_claimed_by_total",
+		Help: "The total number of duplicates skipped with DEDUP_REGION set, by the region that stored the event; the own region means a redelivery",
+	}, []string{"region"})
 	DBFlushSuccess = promauto.NewCounter(prometheus.CounterOpts{
 		Name: "collector_db_flush_success_total",
 		Help: "The total number of successful database flushes",
@@ -37,12 +53,78 @@ var (
 		Name: "collector_db_flush_errors_total",
 		Help: "The total number of failed database flushes after retries",
 	})
-	DBFlushDuration = promauto.NewHistogram(prometheus.HistogramOpts{
-		Name:    "collector_db_flush_duration_seconds",
-		Help:    "The duration of database flush operations.",
-		Buckets: prometheus.LinearBuckets(0.1, 0.1, 10), // 0.1s to 1s
+	DBConnectionLimitErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "collector_db_connection_limit_errors_total",
+		Help: "The total number of database operations refused because Postgres ran out of connections",
+	})
+	DBDeadlocks = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "collector_db_deadlocks_total",
+		Help: "The total number of flush attempts aborted by a deadlock or serialization conflict and retried at once",
+	})
+	DBCommitVerifications = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "collector_db_commit_verifications_total",
+		Help: "The total number of failed COMMITs whose outcome was checked, by result (committed, aborted, unknown)",
+	}, []string{"result"})
+	DBFlushDuration = newDBFlushDuration(defaultDBFlushBuckets, writeMethodCopy)
+	ShardFlushes    = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "collector_shard_flushes_total",
+		Help: "The total number of batch flushes per Postgres shard, by result",
+	}, []string{"shard", "result"})
+	ShardFlushDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "collector_shard_flush_duration_seconds",
+		Help:    "The duration of successful batch flushes per Postgres shard",
+		Buckets: prometheus.LinearBuckets(0.1, 0.1, 10),
+	}, []string{"shard"})
+	ShardUp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "collector_shard_up",
+		Help: "Whether a Postgres shard is connected (1) or unavailable and being reconnected (0)",
+	}, []string{"shard"})
+	DestinationWrites = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "collector_destination_writes_total",
+		Help: "The total number of batch writes per storage destination, by result (success, error, timeout, skipped)",
+	}, []string{"destination", "result"})
+	DestinationWriteDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "collector_destination_write_duration_seconds",
+		Help:    "The duration of batch writes per storage destination",
+		Buckets: prometheus.LinearBuckets(0.1, 0.1, 10),
+	}, []string{"destination"})
+	DestinationWritesInFlight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "collector_destination_writes_in_flight",
+		Help: "The number of batch writes currently in progress per storage destination",
+	}, []string{"destination"})
+	OutboxEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "collector_outbox_events_total",
+		Help: "The total number of outbox events handled by the Elasticsearch relay, by result (relayed, failed, malformed)",
+	}, []string{"result"})
+	PartitionsCreated = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "collector_postgres_partitions_created_total",
+		Help: "The total number of monthly logs partitions created by the collector, by result",
+	}, []string{"result"})
+	DedupBloomChecks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "collector_dedup_bloom_checks_total",
+		Help: "The total number of events the dedup Bloom filter let skip the Redis check, or sent on to it, by result",
+	}, []string{"result"})
+	MetricsEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "collector_metrics_events_total",
+		Help: "The total number of metrics events by metric type and outcome: accepted, rejected or requeued",
+	}, []string{"type", "outcome"})
+	DBInsertConflicts = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "collector_db_insert_conflicts_total",
+		Help: "The total number of rows the deduplicating insert skipped because their event_id was already stored",
+	})
+	RetentionDeleted = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "collector_retention_deleted_total",
+		Help: "The total number of events deleted by the retention sweep once past their expires_at",
+	})
+	OutboxLag = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "collector_outbox_lag_seconds",
+		Help: "The age of the oldest outbox row in the last batch relayed to Elasticsearch, 0 when the outbox is empty",
 	})
 	// Redis-related metrics
+	MetadataCacheFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "collector_metadata_cache_failures_total",
+		Help: "The total number of flushes whose metadata caching failed, by outcome (degraded: flushed without it, failed: flush retried)",
+	}, []string{"outcome"})
 	RedisCacheHits = promauto.NewCounter(prometheus.CounterOpts{
 		Name: "collector_redis_cache_hits_total",
 		Help: "The total number of Redis cache hits",
@@ -60,22 +142,356 @@ var (
 		Name:    "collector_batch_size_optimized",
 		Help:    "The optimized batch sizes used for processing",
 		Buckets: prometheus.LinearBuckets(100, 100, 10), // 100 to 1000
+
+		NativeHistogramBucketFactor:     nativeHistogramBucketFactor,
+		NativeHistogramMaxBucketNumber:  nativeHistogramMaxBuckets,
+		NativeHistogramMinResetDuration: nativeHistogramMinReset,
+	})
+	BatchFlushes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "collector_batch_flushes_total",
+		Help: "The total number of batch flushes by trigger reason",
+	}, []string{"reason"})
+	BatchIdle = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "collector_batch_idle",
+		Help: "1 when the last batch timeout passed without any events to flush, 0 once events arrive",
+	})
+	BatchDistinctCorrelations = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "collector_batch_distinct_correlations",
+		Help: "The number of distinct correlation IDs in the pending batch",
+	})
+	BatchProcessorHeartbeatAge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "collector_batch_processor_heartbeat_age_seconds",
+		Help: "Time since the batch processor last completed a loop iteration",
+	})
+	BatchProcessorStalls = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "collector_batch_processor_stalls_total",
+		Help: "The total number of times the batch processor exceeded the stall threshold",
+	})
+	BatchTargetSize = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "collector_batch_target_size",
+		Help: "The batch size the optimizer currently targets; compare with collector_batch_size_optimized",
 	})
 	CacheHitRatio = promauto.NewGauge(prometheus.GaugeOpts{
 		Name: "collector_cache_hit_ratio",
 		Help: "The current cache hit ratio for metadata",
 	})
-	BatchProcessingTime = promauto.NewHistogram(prometheus.HistogramOpts{
+	BatchProcessingTime = newBatchProcessingTime(prometheus.ExponentialBuckets(0.001, 2, 15)) // 1ms to ~30s
+	StructuredTruncated = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "collector_structured_truncated_total",
+		Help: "The total number of structured payloads trimmed to the configured size limit",
+	})
+	ColumnBytes = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "collector_column_bytes",
+		Help:    "The serialized size of each JSONB column written per event",
+		Buckets: prometheus.ExponentialBuckets(16, 4, 10), // 16B to 4MiB
+	}, []string{"column"})
+	GeoIPLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "collector_geoip_lookups_total",
+		Help: "The total number of client IP geo lookups, by result (found, not_found, cached, invalid, error)",
+	}, []string{"result"})
+	SyntheticIDs = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "collector_synthetic_ids_total",
+		Help: "The total number of identifiers generated for events that arrived without them",
+	}, []string{"field"})
+	ESIndexTimestampClamped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "collector_es_index_timestamp_clamped_total",
+		Help: "The total number of events whose timestamp was clamped when choosing an Elasticsearch index",
+	}, []string{"direction"})
+	EventLag = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "collector_event_lag_seconds",
+		Help:    "Time from event production to being stored in the database",
+		Buckets: prometheus.ExponentialBuckets(0.01, 2, 16), // 10ms to ~5.5m
+	})
+	EventNegativeLag = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "collector_event_negative_lag_total",
+		Help: "The total number of events timestamped after they were stored, i.e. with producer clock skew",
+	})
+	ChunkGroups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "collector_chunk_groups_total",
+		Help: "The total number of chunked message groups, by result (assembled, expired, rejected)",
+	}, []string{"result"})
+	ChunkBufferBytes = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "collector_chunk_buffer_bytes",
+		Help: "The bytes of incomplete chunked messages buffered for reassembly",
+	})
+	SchemaValidations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "collector_schema_validations_total",
+		Help: "The total number of events checked against their schemaUrl, by result (valid, invalid, fetch_error, not_allowed)",
+	}, []string{"result"})
+	SignatureFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "collector_signature_failures_total",
+		Help: "The total number of messages rejected by signature verification, by reason (missing, malformed, unknown_key, mismatch)",
+	}, []string{"reason"})
+	InvalidTextFields = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "collector_invalid_text_fields_total",
+		Help: "The total number of event fields with invalid UTF-8 or NUL characters, by action taken",
+	}, []string{"action"})
+	Backpressure = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "collector_backpressure",
+		Help: "1 while intake is paused because storage cannot keep up, 0 otherwise",
+	})
+	BackpressureActivations = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "collector_backpressure_activations_total",
+		Help: "The total number of times intake was paused for backpressure",
+	})
+	MemoryShedding = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "collector_memory_shedding",
+		Help: "1 while the memory governor sheds load because the heap exceeds its budget, 0 otherwise",
+	})
+	MemoryHeapBytes = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "collector_memory_heap_bytes",
+		Help: "The heap in use as last sampled by the memory governor",
+	})
+	MemoryShedEvents = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "collector_memory_shed_events_total",
+		Help: "The total number of low-priority events dropped while shedding load",
+	})
+	BufferFull = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "collector_buffer_full_total",
+		Help: "The total number of events handed back for redelivery because the batch buffer stayed full for the enqueue timeout",
+	})
+	BufferFillRatio = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "collector_buffer_fill_ratio",
+		Help: "The fraction of the batch buffer in use",
+	})
+	DLQReprocessed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "collector_dlq_reprocessed_total",
+		Help: "The total number of dead-lettered messages reprocessed, by result",
+	}, []string{"result"})
+	DLQReplayed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "collector_dlq_replayed_total",
+		Help: "The total number of dead-lettered messages handled by an admin replay, by result",
+	}, []string{"result"})
+	PriorityDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "collector_priority_deliveries_total",
+		Help: "The total number of deliveries handed to workers with WORKER_RESERVATIONS, by priority and worker kind (reserved, stolen: a reserved worker helping another priority, shared)",
+	}, []string{"priority", "served_by"})
+	DLQArchived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "collector_dlq_archived_total",
+		Help: "The total number of dead-lettered messages written to the dead letter table, by result (archived, error)",
+	}, []string{"result"})
+	EventsBySchemaVersion = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "collector_events_by_schema_version_total",
+		Help: "The total number of events by schema version (event.version); invalid for malformed versions, other beyond SCHEMA_VERSION_MAX_LABELS",
+	}, []string{"version"})
+	KafkaMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "collector_kafka_messages_total",
+		Help: "The total number of events produced to the Kafka sink, by delivery report (delivered, failed)",
+	}, []string{"result"})
+	ConsumerIdleProbes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "collector_consumer_idle_probes_total",
+		Help: "The total number of broker probes after CONSUMER_IDLE_TIMEOUT without deliveries, by result (alive, recycled)",
+	}, []string{"result"})
+	ConsumerReconnects = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "collector_consumer_reconnects_total",
+		Help: "The total number of attempts to re-dial RabbitMQ after the consumer connection was lost, by result (success, failed)",
+	}, []string{"result"})
+	WebhookReports = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "collector_webhook_reports_total",
+		Help: "The total number of ingestion reports for WEBHOOK_URL, by result (sent, failed: dropped after the retries)",
+	}, []string{"result"})
+	ConfigReloads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "collector_config_reloads_total",
+		Help: "The total number of configuration reloads on SIGHUP, by result (applied, unchanged, failed)",
+	}, []string{"result"})
+	StreamingDecodes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "collector_streaming_decodes_total",
+		Help: "The total number of events decoded in streaming mode, by how data.structured was kept (raw, decoded: it needed repairs, absent)",
+	}, []string{"structured"})
+	ServiceIngestRate = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "collector_service_ingest_rate",
+		Help: "The moving average ingest rate per service, in events per second",
+	}, []string{"service"})
+	ServiceErrorRatio = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "collector_service_error_ratio",
+		Help: "The moving average share of ERROR and FATAL events per service with an error budget, over its window",
+	}, []string{"service"})
+	ErrorBudgetAlerts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "collector_error_budget_alerts_total",
+		Help: "The total number of error budget state changes, by state (breached, recovered)",
+	}, []string{"state"})
+	EventsRepublished = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "collector_events_republished_total",
+		Help: "The total number of enriched events re-published to the republish exchange, by result",
+	}, []string{"result"})
+	EventTypeAliasesApplied = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "collector_event_type_aliases_applied_total",
+		Help: "The total number of event types rewritten to their canonical type, by alias",
+	}, []string{"alias"})
+	FutureTimestamps = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "collector_future_timestamps_total",
+		Help: "The total number of events timestamped beyond the future threshold, by action taken",
+	}, []string{"action"})
+	WorkerDrainSize = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "collector_worker_drain_size",
+		Help:    "The number of deliveries a worker took from the deliveries channel per receive, with WORKER_DRAIN_SIZE above 1",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 8), // 1 to 128
+	})
+	WorkerBatchSize = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "collector_worker_batch_size",
+		Help:    "The number of events handed to storage per buffer operation",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 8), // 1 to 128
+	})
+	NonSemverVersions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "collector_non_semver_versions_total",
+		Help: "The total number of events whose source version is not a semantic version, by service",
+	}, []string{"service"})
+	EventsByInstance = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "collector_events_by_instance_total",
+		Help: "The total number of events received per service instance; instances beyond the label limit count as other",
+	}, []string{"service", "instance"})
+	ErrorsByInstance = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "collector_errors_by_instance_total",
+		Help: "The total number of ERROR and FATAL events received per service instance; instances beyond the label limit count as other",
+	}, []string{"service", "instance"})
+	MessagesInvalid = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "collector_messages_invalid_total",
+		Help: "The total number of messages dead-lettered because their envelope failed validation, by first invalid field",
+	}, []string{"field"})
+	RawPayloadsOversized = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "collector_raw_payloads_oversized_total",
+		Help: "The total number of raw payloads not retained because they exceeded the size cap",
+	})
+	GoldenEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "collector_golden_events_total",
+		Help: "The total number of stored events sampled into the golden verification set, by result (stored, failed)",
+	}, []string{"result"})
+	RepeatsSuppressed = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "collector_repeats_suppressed_total",
+		Help: "The total number of repeated consecutive messages collapsed into an earlier event",
+	})
+	EventsByRetryCount = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "collector_events_by_retry_count",
+		Help:    "The producer-reported retry count of received events",
+		Buckets: []float64{0, 1, 2, 3, 5, 8, 13, 21},
+	})
+	HighRetryEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "collector_events_high_retry_total",
+		Help: "The total number of events at or above the high-retry threshold",
+	}, []string{"service"})
+	ESBulkItemRetries = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "collector_es_bulk_item_retries_total",
+		Help: "The total number of documents resent after a bulk request failed to index them transiently",
+	})
+	ESBulkItemFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "collector_es_bulk_item_failures_total",
+		Help: "The total number of documents a bulk write failed to index for good, other documents of their batch being indexed",
+	})
+	ESIndexOverflow = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "collector_es_index_overflow_total",
+		Help: "The total number of events routed to the overflow index because the index cap was reached",
+	})
+	BuildInfo = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "collector_build_info",
+		Help: "Collector build and the server versions of its dependencies, always 1",
+	}, []string{"version", "commit", "go_version", "postgres_version", "elasticsearch_version", "rabbitmq_version"})
+	ESInfo = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "collector_elasticsearch_info",
+		Help: "Elasticsearch client and server versions negotiated at startup, always 1",
+	}, []string{"client_version", "server_version", "compatibility_mode"})
+	MigrationLockWait = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "collector_migration_lock_wait_seconds",
+		Help:    "Time spent waiting for the Postgres schema migration lock",
+		Buckets: prometheus.ExponentialBuckets(0.01, 2, 14), // 10ms to ~80s
+	})
+)
+
+// defaultDBFlushBuckets are the flush duration buckets without
+// DB_FLUSH_BUCKETS: 0.1s to 1s.
+var defaultDBFlushBuckets = prometheus.LinearBuckets(0.1, 0.1, 10)
+
+// Postgres write methods labelling collector_db_flush_duration_seconds, so
+// that the cost of the deduplicating insert can be compared with COPY.
+const (
+	writeMethodCopy   = "copy"
+	writeMethodInsert = "insert"
+)
+
+func newDBFlushDuration(buckets []float64, writeMethod string) prometheus.Histogram {
+	return promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:        "collector_db_flush_duration_seconds",
+		Help:        "The duration of database flush operations.",
+		Buckets:     buckets,
+		ConstLabels: prometheus.Labels{"write_method": writeMethod},
+
+		NativeHistogramBucketFactor:     nativeHistogramBucketFactor,
+		NativeHistogramMaxBucketNumber:  nativeHistogramMaxBuckets,
+		NativeHistogramMinResetDuration: nativeHistogramMinReset,
+	})
+}
+
+func newBatchProcessingTime(buckets []float64) prometheus.Histogram {
+	return promauto.NewHistogram(prometheus.HistogramOpts{
 		Name:    "collector_batch_processing_time_seconds",
 		Help:    "Time spent processing batches including Redis operations",
-		Buckets: prometheus.ExponentialBuckets(0.001, 2, 15), // 1ms to ~30s
+		Buckets: buckets,
+
+		NativeHistogramBucketFactor:     nativeHistogramBucketFactor,
+		NativeHistogramMaxBucketNumber:  nativeHistogramMaxBuckets,
+		NativeHistogramMinResetDuration: nativeHistogramMinReset,
 	})
-)
+}
+
+// ConfigureBuckets replaces the duration histograms with ones using the
+// configured buckets, and the Postgres write method label. Both are fixed
+// at registration, so this must be called at startup before anything
+// observes the histograms.
+func ConfigureBuckets(cfg *config.Config) {
+	if cfg.DBFlushBuckets != nil || cfg.PostgresDedupOnInsert {
+		buckets, writeMethod := defaultDBFlushBuckets, writeMethodCopy
+		if cfg.DBFlushBuckets != nil {
+			buckets = cfg.DBFlushBuckets
+		}
+		if cfg.PostgresDedupOnInsert {
+			writeMethod = writeMethodInsert
+		}
+		prometheus.DefaultRegisterer.Unregister(DBFlushDuration)
+		DBFlushDuration = newDBFlushDuration(buckets, writeMethod)
+	}
+	if cfg.BatchProcessingBuckets != nil {
+		prometheus.DefaultRegisterer.Unregister(BatchProcessingTime)
+		BatchProcessingTime = newBatchProcessingTime(cfg.BatchProcessingBuckets)
+	}
+}
+
+// exemplarsEnabled is set when the metrics are served in the OpenMetrics
+// format, the only one exposing exemplars.
+var exemplarsEnabled atomic.Bool
+
+// ObserveWithTraceID records v on h and attaches traceID as an exemplar when
+// exemplars are exposed and traceID is a W3C trace ID. Trace IDs come from
+// producers; the client library panics on exemplar labels longer than 128
+// runes or not valid UTF-8, so anything else is observed without one.
+func ObserveWithTraceID(h prometheus.Histogram, v float64, traceID string) {
+	if observer, ok := h.(prometheus.ExemplarObserver); ok && exemplarsEnabled.Load() && isTraceID(traceID) {
+		observer.ObserveWithExemplar(v, prometheus.Labels{"trace_id": traceID})
+		return
+	}
+	h.Observe(v)
+}
+
+// isTraceID reports whether s is a trace ID as W3C Trace Context writes it:
+// 32 lowercase hex digits.
+func isTraceID(s string) bool {
+	if len(s) != 32 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
 
 // Server is the metrics and health check server.
 type Server struct {
 	httpServer *http.Server
+	mux        *http.ServeMux
 	redis      HealthChecker
+	flush      FlushTracker
+	checksMu   sync.RWMutex
+	checks     []namedCheck
 }
 
 // HealthChecker interface for checking component health
@@ -83,14 +499,45 @@ type HealthChecker interface {
 	HealthCheck() error
 }
 
+// HealthCheckFunc adapts a function to the HealthChecker interface.
+type HealthCheckFunc func() error
+
+// HealthCheck calls f.
+func (f HealthCheckFunc) HealthCheck() error {
+	return f()
+}
+
+// FlushTracker reports the progress of the database flushes.
+type FlushTracker interface {
+	LastFlush() time.Time
+	FlushHealth() error
+	BufferDepth() int
+}
+
+type namedCheck struct {
+	name  string
+	check HealthChecker
+}
+
 // NewServer creates a new metrics server.
 func NewServer(cfg *config.Config) *Server {
 	server := &Server{}
+	exemplarsEnabled.Store(cfg.MetricsOpenMetrics)
 
 	mux := http.NewServeMux()
-	mux.Handle("/metrics", promhttp.Handler())
+	// The format is negotiated from the scraper's Accept header, so classic
+	// Prometheus text scrapers are unaffected when OpenMetrics is enabled.
+	mux.Handle("/metrics", promhttp.InstrumentMetricHandler(
+		prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{
+			EnableOpenMetrics: cfg.MetricsOpenMetrics,
+		}),
+	))
 	mux.HandleFunc("/health", server.healthHandler)
+	mux.HandleFunc("/ready", server.readyHandler)
+	mux.HandleFunc("/stats", server.statsHandler)
 
+	server.mux = mux
 	server.httpServer = &http.Server{
 		Addr:    ":" + cfg.MetricsPort,
 		Handler: mux,
@@ -104,18 +551,46 @@ func (s *Server) SetRedisClient(redis HealthChecker) {
 	s.redis = redis
 }
 
+// SetFlushTracker sets the storage whose flush progress is reported by the
+// readiness and stats endpoints, and whose buffer depth is exported as
+// collector_buffer_depth. It is called once.
+func (s *Server) SetFlushTracker(flush FlushTracker) {
+	s.flush = flush
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "collector_buffer_depth",
+		Help: "The number of events waiting in the batch buffer",
+	}, func() float64 {
+		return float64(flush.BufferDepth())
+	})
+}
+
+// Handle registers an additional handler, e.g. the query API, on the
+// metrics port. It is safe to call after the server has started.
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// AddHealthCheck registers an additional component whose failure makes the
+// health endpoint report the service as unavailable.
+func (s *Server) AddHealthCheck(name string, check HealthChecker) {
+	s.checksMu.Lock()
+	defer s.checksMu.Unlock()
+	s.checks = append(s.checks, namedCheck{name: name, check: check})
+}
+
 // healthHandler handles health check requests
 func (s *Server) healthHandler(w http.ResponseWriter, r *http.Request) {
 	status := map[string]string{
 		"status":  "OK",
 		"service": "collector",
 	}
+	healthy := true
 
 	// Check Redis health if available
 	if s.redis != nil {
 		if err := s.redis.HealthCheck(); err != nil {
 			status["redis"] = "ERROR: " + err.Error()
-			w.WriteHeader(http.StatusServiceUnavailable)
+			healthy = false
 		} else {
 			status["redis"] = "OK"
 		}
@@ -123,10 +598,75 @@ func (s *Server) healthHandler(w http.ResponseWriter, r *http.Request) {
 		status["redis"] = "DISABLED"
 	}
 
+	s.checksMu.RLock()
+	checks := s.checks
+	s.checksMu.RUnlock()
+	for _, c := range checks {
+		if err := c.check.HealthCheck(); err != nil {
+			status[c.name] = "ERROR: " + err.Error()
+			healthy = false
+		} else {
+			status[c.name] = "OK"
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(status)
+}
+
+// readyHandler reports whether the collector is making progress, i.e. not
+// consuming events without persisting them.
+func (s *Server) readyHandler(w http.ResponseWriter, r *http.Request) {
+	status := map[string]interface{}{
+		"status":  "READY",
+		"service": "collector",
+	}
+	ready := true
+
+	if s.flush != nil {
+		addFlushStatus(status, s.flush)
+		if err := s.flush.FlushHealth(); err != nil {
+			status["status"] = "NOT_READY"
+			status["flush"] = "ERROR: " + err.Error()
+			ready = false
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
+	if !ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
 	json.NewEncoder(w).Encode(status)
 }
 
+// statsHandler reports processing progress.
+func (s *Server) statsHandler(w http.ResponseWriter, r *http.Request) {
+	stats := map[string]interface{}{
+		"service": "collector",
+	}
+	if s.flush != nil {
+		addFlushStatus(stats, s.flush)
+		stats["buffer_depth"] = s.flush.BufferDepth()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// addFlushStatus adds the time and age of the last successful flush.
+func addFlushStatus(status map[string]interface{}, flush FlushTracker) {
+	last := flush.LastFlush()
+	if last.IsZero() {
+		status["last_flush"] = nil
+		return
+	}
+	status["last_flush"] = last.UTC().Format(time.RFC3339Nano)
+	status["last_flush_age_seconds"] = time.Since(last).Seconds()
+}
+
 // Start runs the HTTP server in a new goroutine.
 func (s *Server) Start() {
 	log.Printf("Metrics and health server starting on %s", s.httpServer.Addr)
@@ -0,0 +1,62 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// exemplarTraceIDs returns the trace IDs of the exemplars h holds.
+func exemplarTraceIDs(t *testing.T, h prometheus.Histogram) []string {
+	t.Helper()
+	var m dto.Metric
+	if err := h.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, bucket := range m.GetHistogram().GetBucket() {
+		for _, label := range bucket.GetExemplar().GetLabel() {
+			ids = append(ids, label.GetValue())
+		}
+	}
+	return ids
+}
+
+func TestObserveWithTraceID(t *testing.T) {
+	const valid = "4bf92f3577b34da6a3ce929d0e0e4736"
+	tests := []struct {
+		name     string
+		traceID  string
+		exemplar bool
+	}{
+		{"W3C trace ID", valid, true},
+		{"none", "", false},
+		{"oversized", strings.Repeat("a", 200), false},
+		{"invalid UTF-8", strings.Repeat("\xff", 32), false},
+		{"uppercase", strings.ToUpper(valid), false},
+		{"too short", valid[:16], false},
+	}
+	for _, openMetrics := range []bool{true, false} {
+		exemplarsEnabled.Store(openMetrics)
+		for _, tt := range tests {
+			h := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "test_seconds", Buckets: []float64{1}})
+			// Panics on exemplar labels the client library rejects
+			ObserveWithTraceID(h, 0.5, tt.traceID)
+
+			var m dto.Metric
+			if err := h.Write(&m); err != nil {
+				t.Fatal(err)
+			}
+			if n := m.GetHistogram().GetSampleCount(); n != 1 {
+				t.Errorf("%s, OpenMetrics %t: %d observations, want 1", tt.name, openMetrics, n)
+			}
+			ids := exemplarTraceIDs(t, h)
+			if want := tt.exemplar && openMetrics; want != (len(ids) == 1) {
+				t.Errorf("%s, OpenMetrics %t: exemplars %q, want one: %t", tt.name, openMetrics, ids, want)
+			}
+		}
+	}
+	exemplarsEnabled.Store(false)
+}
@@ -0,0 +1,143 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"go.uber.org/zap"
+)
+
+// StatsReporter periodically logs a summary of the processing metrics, so
+// that deployments monitored only through their logs still get a pulse.
+type StatsReporter struct {
+	interval    time.Duration
+	logger      *zap.Logger
+	bufferDepth func() int
+}
+
+// statsSnapshot holds the cumulative metric values at one point in time.
+// Rates and averages are derived from the difference of two snapshots.
+type statsSnapshot struct {
+	at        time.Time
+	processed float64
+	batches   *dto.Histogram
+	flushes   *dto.Histogram
+}
+
+// NewStatsReporter creates a reporter logging every interval. bufferDepth
+// reports the number of events waiting to be batched and may be nil.
+func NewStatsReporter(interval time.Duration, logger *zap.Logger, bufferDepth func() int) *StatsReporter {
+	return &StatsReporter{
+		interval:    interval,
+		logger:      logger.Named("stats"),
+		bufferDepth: bufferDepth,
+	}
+}
+
+// Run logs a summary every interval until ctx is cancelled.
+func (r *StatsReporter) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	last := takeSnapshot()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			current := takeSnapshot()
+			r.report(last, current)
+			last = current
+		}
+	}
+}
+
+func (r *StatsReporter) report(prev, cur statsSnapshot) {
+	elapsed := cur.at.Sub(prev.at).Seconds()
+	batches := cur.batches.GetSampleCount() - prev.batches.GetSampleCount()
+
+	var avgBatchSize float64
+	if batches > 0 {
+		avgBatchSize = (cur.batches.GetSampleSum() - prev.batches.GetSampleSum()) / float64(batches)
+	}
+
+	fields := []zap.Field{
+		zap.Float64("events_per_sec", (cur.processed-prev.processed)/elapsed),
+		zap.Uint64("batches", batches),
+		zap.Float64("avg_batch_size", avgBatchSize),
+		zap.Float64("flush_p50_seconds", histogramQuantile(0.5, prev.flushes, cur.flushes)),
+		zap.Float64("flush_p99_seconds", histogramQuantile(0.99, prev.flushes, cur.flushes)),
+		zap.Float64("cache_hit_ratio", gaugeValue(CacheHitRatio)),
+	}
+	if r.bufferDepth != nil {
+		fields = append(fields, zap.Int("buffer_depth", r.bufferDepth()))
+	}
+	r.logger.Info("Processing stats", fields...)
+}
+
+func takeSnapshot() statsSnapshot {
+	return statsSnapshot{
+		at:        time.Now(),
+		processed: counterValue(MessagesProcessed),
+		batches:   histogramValue(BatchSizeOptimized),
+		flushes:   histogramValue(DBFlushDuration),
+	}
+}
+
+func counterValue(c prometheus.Counter) float64 {
+	var m dto.Metric
+	if err := c.Write(&m); err != nil {
+		return 0
+	}
+	return m.GetCounter().GetValue()
+}
+
+func gaugeValue(g prometheus.Gauge) float64 {
+	var m dto.Metric
+	if err := g.Write(&m); err != nil {
+		return 0
+	}
+	return m.GetGauge().GetValue()
+}
+
+func histogramValue(h prometheus.Histogram) *dto.Histogram {
+	var m dto.Metric
+	if err := h.Write(&m); err != nil {
+		return &dto.Histogram{}
+	}
+	return m.GetHistogram()
+}
+
+// histogramQuantile estimates the q-quantile of the observations made
+// between two snapshots of a classic histogram, interpolating linearly
+// inside the bucket like PromQL's histogram_quantile. It returns 0 when
+// nothing was observed.
+func histogramQuantile(q float64, prev, cur *dto.Histogram) float64 {
+	total := cur.GetSampleCount() - prev.GetSampleCount()
+	if total == 0 {
+		return 0
+	}
+
+	prevCounts := make(map[float64]uint64, len(prev.GetBucket()))
+	for _, b := range prev.GetBucket() {
+		prevCounts[b.GetUpperBound()] = b.GetCumulativeCount()
+	}
+
+	rank := q * float64(total)
+	lowerBound, lowerCount := 0.0, 0.0
+	for _, b := range cur.GetBucket() {
+		count := float64(b.GetCumulativeCount() - prevCounts[b.GetUpperBound()])
+		if count >= rank {
+			if count == lowerCount {
+				return b.GetUpperBound()
+			}
+			return lowerBound + (b.GetUpperBound()-lowerBound)*(rank-lowerCount)/(count-lowerCount)
+		}
+		lowerBound, lowerCount = b.GetUpperBound(), count
+	}
+	// The quantile falls into the implicit +Inf bucket; like PromQL, report
+	// the highest finite bound.
+	return lowerBound
+}
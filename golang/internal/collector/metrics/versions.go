@@ -0,0 +1,91 @@
+package metrics
+
+import (
+	"context"
+	"observability_hub/golang/internal/types"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Schema version labels that do not name a version.
+const (
+	versionInvalid = "invalid" // Empty or not a semantic version
+	versionOther   = "other"   // Seen after the label limit was reached
+)
+
+// VersionTracker counts events per schema version, so that the versions
+// still live in production are known before support for one is dropped.
+// Versions are normalized and the number of labels is bounded: the first
+// maxVersions distinct versions get their own label, later ones count as
+// "other".
+type VersionTracker struct {
+	mu          sync.Mutex
+	maxVersions int
+	labels      map[string]bool
+	counts      map[string]int64 // Events per label since the last log
+}
+
+// NewVersionTracker creates a tracker labelling at most maxVersions
+// versions.
+func NewVersionTracker(maxVersions int) *VersionTracker {
+	return &VersionTracker{
+		maxVersions: maxVersions,
+		labels:      make(map[string]bool),
+		counts:      make(map[string]int64),
+	}
+}
+
+// Observe records one event of the given schema version.
+func (t *VersionTracker) Observe(version string) {
+	label := normalizeVersion(version)
+
+	t.mu.Lock()
+	if label != versionInvalid && !t.labels[label] {
+		if len(t.labels) < t.maxVersions {
+			t.labels[label] = true
+		} else {
+			label = versionOther
+		}
+	}
+	t.counts[label]++
+	t.mu.Unlock()
+
+	EventsBySchemaVersion.WithLabelValues(label).Inc()
+}
+
+// Run logs the version distribution of the events seen in each interval
+// until ctx is cancelled.
+func (t *VersionTracker) Run(ctx context.Context, interval time.Duration, logger *zap.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			t.mu.Lock()
+			counts := t.counts
+			t.counts = make(map[string]int64, len(counts))
+			t.mu.Unlock()
+
+			if len(counts) > 0 {
+				logger.Info("Schema version distribution", zap.Any("versions", counts), zap.Duration("interval", interval))
+			}
+		}
+	}
+}
+
+// normalizeVersion trims a version and drops a "v" prefix; anything that
+// is then not a semantic version is invalid.
+func normalizeVersion(version string) string {
+	version = strings.TrimSpace(version)
+	version = strings.TrimPrefix(strings.TrimPrefix(version, "v"), "V")
+	if !types.IsSemver(version) {
+		return versionInvalid
+	}
+	return version
+}
@@ -0,0 +1,226 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"observability_hub/golang/internal/collector/config"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Ingestion outcomes counted for the webhook.
+const (
+	OutcomeAccepted = "accepted" // Handed to storage
+	OutcomeRejected = "rejected" // Failed decoding or checks, dead-lettered
+	OutcomeRequeued = "requeued" // Storage was full, returned to the queue
+	OutcomeDropped  = "dropped"  // Discarded while shedding load
+)
+
+// maxOutcomeServices bounds the services tallied per interval; further
+// services are counted as "other".
+const maxOutcomeServices = 1000
+
+// outcomeKey identifies one tally entry.
+type outcomeKey struct {
+	service, level string
+}
+
+// OutcomeTally counts ingestion outcomes by service and level between two
+// webhook reports.
+type OutcomeTally struct {
+	mu       sync.Mutex
+	counts   map[outcomeKey]map[string]int64
+	services map[string]bool
+}
+
+// NewOutcomeTally creates an empty tally.
+func NewOutcomeTally() *OutcomeTally {
+	return &OutcomeTally{
+		counts:   make(map[outcomeKey]map[string]int64),
+		services: make(map[string]bool),
+	}
+}
+
+// Record counts one event with the given outcome. Events that were never
+// decoded have an empty service and level, counted as "unknown".
+func (t *OutcomeTally) Record(service, level, outcome string) {
+	if service == "" {
+		service = "unknown"
+	}
+	if level == "" {
+		level = "unknown"
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.services[service] {
+		if len(t.services) >= maxOutcomeServices {
+			service = "other"
+		}
+		t.services[service] = true
+	}
+	key := outcomeKey{service, level}
+	if t.counts[key] == nil {
+		t.counts[key] = make(map[string]int64)
+	}
+	t.counts[key][outcome]++
+}
+
+// take returns the counts since the previous call and resets the tally.
+func (t *OutcomeTally) take() map[outcomeKey]map[string]int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	counts := t.counts
+	t.counts = make(map[outcomeKey]map[string]int64, len(counts))
+	clear(t.services)
+	return counts
+}
+
+// WebhookReporter periodically POSTs a JSON summary of the ingestion
+// outcomes, so that small deployments can alert on them without a
+// monitoring stack. Reports are best effort: they run apart from
+// ingestion, each attempt is bounded by the timeout and a report that
+// still fails after the retries is dropped.
+type WebhookReporter struct {
+	cfg    *config.Config
+	tally  *OutcomeTally
+	client *http.Client
+	logger *zap.Logger
+}
+
+// webhookService is the outcome counts of one service and level.
+type webhookService struct {
+	Service  string           `json:"service"`
+	Level    string           `json:"level"`
+	Outcomes map[string]int64 `json:"outcomes"`
+}
+
+// webhookPayload is the full report; the summary omits Services.
+type webhookPayload struct {
+	From         time.Time        `json:"from"`
+	To           time.Time        `json:"to"`
+	Total        int64            `json:"total"`
+	Outcomes     map[string]int64 `json:"outcomes"`
+	ErrorRate    float64          `json:"errorRate"` // Share of events not accepted
+	EventsPerSec float64          `json:"eventsPerSec"`
+	FlushP99     float64          `json:"flushP99Seconds"`
+	Services     []webhookService `json:"services,omitempty"`
+}
+
+// NewWebhookReporter creates a reporter sending the outcomes of tally.
+func NewWebhookReporter(cfg *config.Config, tally *OutcomeTally, logger *zap.Logger) *WebhookReporter {
+	return &WebhookReporter{
+		cfg:    cfg,
+		tally:  tally,
+		client: &http.Client{Timeout: cfg.WebhookTimeout},
+		logger: logger.Named("webhook"),
+	}
+}
+
+// Run sends a report every interval until ctx is cancelled.
+func (r *WebhookReporter) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.cfg.WebhookInterval)
+	defer ticker.Stop()
+
+	last := takeSnapshot()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			current := takeSnapshot()
+			r.send(ctx, r.payload(last, current))
+			last = current
+		}
+	}
+}
+
+func (r *WebhookReporter) payload(prev, cur statsSnapshot) interface{} {
+	p := webhookPayload{
+		From:         prev.at.UTC(),
+		To:           cur.at.UTC(),
+		Outcomes:     make(map[string]int64),
+		EventsPerSec: (cur.processed - prev.processed) / cur.at.Sub(prev.at).Seconds(),
+		FlushP99:     histogramQuantile(0.99, prev.flushes, cur.flushes),
+	}
+	for key, outcomes := range r.tally.take() {
+		for outcome, n := range outcomes {
+			p.Outcomes[outcome] += n
+			p.Total += n
+		}
+		if r.cfg.WebhookFormat == config.WebhookFormatFull {
+			p.Services = append(p.Services, webhookService{Service: key.service, Level: key.level, Outcomes: outcomes})
+		}
+	}
+	if p.Total > 0 {
+		p.ErrorRate = float64(p.Total-p.Outcomes[OutcomeAccepted]) / float64(p.Total)
+	}
+	sort.Slice(p.Services, func(i, j int) bool {
+		if p.Services[i].Service != p.Services[j].Service {
+			return p.Services[i].Service < p.Services[j].Service
+		}
+		return p.Services[i].Level < p.Services[j].Level
+	})
+
+	if r.cfg.WebhookFormat == config.WebhookFormatSlack {
+		return map[string]string{
+			"text": fmt.Sprintf("Collector: %d events in the last %s, %d accepted, %d rejected, %d requeued, %d dropped (error rate %.1f%%)",
+				p.Total, r.cfg.WebhookInterval, p.Outcomes[OutcomeAccepted], p.Outcomes[OutcomeRejected],
+				p.Outcomes[OutcomeRequeued], p.Outcomes[OutcomeDropped], p.ErrorRate*100),
+		}
+	}
+	return p
+}
+
+// send POSTs a report, retrying failures with a doubling backoff capped
+// at the interval.
+func (r *WebhookReporter) send(ctx context.Context, payload interface{}) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		r.logger.Error("Failed to encode webhook report", zap.Error(err))
+		return
+	}
+
+	backoff := time.Second
+	for attempt := 0; ; attempt++ {
+		err = r.post(ctx, body)
+		if err == nil {
+			WebhookReports.WithLabelValues("sent").Inc()
+			return
+		}
+		if attempt >= r.cfg.WebhookMaxRetries || ctx.Err() != nil {
+			break
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, r.cfg.WebhookInterval)
+	}
+	WebhookReports.WithLabelValues("failed").Inc()
+	r.logger.Warn("Dropping webhook report", zap.Error(err), zap.Int("attempts", r.cfg.WebhookMaxRetries+1))
+}
+
+func (r *WebhookReporter) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.cfg.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
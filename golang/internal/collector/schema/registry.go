@@ -0,0 +1,164 @@
+package schema
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxSchemaBytes bounds the size of a fetched schema document.
+const maxSchemaBytes = 1 << 20
+
+// failureTTL is how long a failed fetch is remembered, so that an
+// unreachable schema host is not asked again for every event.
+const failureTTL = 30 * time.Second
+
+// ErrHostNotAllowed is returned for schema URLs outside the host allowlist.
+var ErrHostNotAllowed = errors.New("schema host is not allowed")
+
+// Registry fetches schemas over HTTP(S) and caches them. Only hosts on the
+// allowlist are contacted. The cache holds at most size schemas; the least
+// recently used one is evicted when a new one arrives at the limit.
+type Registry struct {
+	client  *http.Client
+	allowed map[string]bool
+	ttl     time.Duration
+	size    int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // Front is the most recently used schema
+}
+
+// cacheEntry is a cached fetch result, successful or not.
+type cacheEntry struct {
+	url     string
+	schema  *Schema
+	err     error
+	expires time.Time
+}
+
+// NewRegistry creates a registry fetching from the allowed hosts, caching
+// up to size schemas for ttl each.
+func NewRegistry(allowedHosts []string, ttl time.Duration, size int, timeout time.Duration) *Registry {
+	allowed := make(map[string]bool, len(allowedHosts))
+	for _, host := range allowedHosts {
+		allowed[strings.ToLower(host)] = true
+	}
+	r := &Registry{
+		allowed: allowed,
+		ttl:     ttl,
+		size:    max(1, size),
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+	r.client = &http.Client{
+		Timeout: timeout,
+		// Redirects must not lead off the allowlist
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return errors.New("too many redirects")
+			}
+			if !r.allowed[strings.ToLower(req.URL.Hostname())] {
+				return fmt.Errorf("%w: redirect to %s", ErrHostNotAllowed, req.URL.Hostname())
+			}
+			return nil
+		},
+	}
+	return r
+}
+
+// Get returns the schema at rawURL, fetching it unless a cached copy is
+// still fresh. Failed fetches are cached briefly as well.
+func (r *Registry) Get(ctx context.Context, rawURL string) (*Schema, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schema URL: %w", err)
+	}
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return nil, fmt.Errorf("unsupported schema URL scheme %q", u.Scheme)
+	}
+	if !r.allowed[strings.ToLower(u.Hostname())] {
+		return nil, fmt.Errorf("%w: %s", ErrHostNotAllowed, u.Hostname())
+	}
+
+	if entry, ok := r.lookup(rawURL); ok {
+		return entry.schema, entry.err
+	}
+
+	schema, err := r.fetch(ctx, rawURL)
+	ttl := r.ttl
+	if err != nil {
+		ttl = min(ttl, failureTTL)
+	}
+	r.store(&cacheEntry{url: rawURL, schema: schema, err: err, expires: time.Now().Add(ttl)})
+	return schema, err
+}
+
+func (r *Registry) lookup(rawURL string) (*cacheEntry, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	elem, ok := r.entries[rawURL]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*cacheEntry)
+	if time.Now().After(entry.expires) {
+		r.lru.Remove(elem)
+		delete(r.entries, rawURL)
+		return nil, false
+	}
+	r.lru.MoveToFront(elem)
+	return entry, true
+}
+
+func (r *Registry) store(entry *cacheEntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if elem, ok := r.entries[entry.url]; ok {
+		elem.Value = entry
+		r.lru.MoveToFront(elem)
+		return
+	}
+	if len(r.entries) >= r.size {
+		oldest := r.lru.Back()
+		r.lru.Remove(oldest)
+		delete(r.entries, oldest.Value.(*cacheEntry).url)
+	}
+	r.entries[entry.url] = r.lru.PushFront(entry)
+}
+
+func (r *Registry) fetch(ctx context.Context, rawURL string) (*Schema, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create schema request: %w", err)
+	}
+	req.Header.Set("Accept", "application/schema+json, application/json")
+
+	res, err := r.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch schema: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch schema: %s", res.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(res.Body, maxSchemaBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema: %w", err)
+	}
+	if len(data) > maxSchemaBytes {
+		return nil, fmt.Errorf("schema exceeds %d bytes", maxSchemaBytes)
+	}
+	return Parse(data)
+}
@@ -0,0 +1,323 @@
+// Package schema validates events against the JSON Schemas their producers
+// reference in metadata.schemaUrl.
+//
+// Only the validation keywords of JSON Schema that describe the shape of a
+// document are implemented: type, enum, const, the numeric, string, array
+// and object constraints, and the allOf, anyOf, oneOf and not combinators.
+// Other keywords, including $ref and format, are ignored.
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Schema is a parsed JSON Schema.
+type Schema struct {
+	always *bool // Set for the boolean schemas true and false
+
+	Type  typeList      `json:"type"`
+	Enum  []interface{} `json:"enum"`
+	Const *constValue   `json:"const"`
+
+	Minimum          *float64 `json:"minimum"`
+	Maximum          *float64 `json:"maximum"`
+	ExclusiveMinimum *float64 `json:"exclusiveMinimum"`
+	ExclusiveMaximum *float64 `json:"exclusiveMaximum"`
+	MultipleOf       *float64 `json:"multipleOf"`
+
+	MinLength *int   `json:"minLength"`
+	MaxLength *int   `json:"maxLength"`
+	Pattern   string `json:"pattern"`
+	pattern   *regexp.Regexp
+
+	Items    *Schema `json:"items"`
+	MinItems *int    `json:"minItems"`
+	MaxItems *int    `json:"maxItems"`
+
+	Required             []string           `json:"required"`
+	Properties           map[string]*Schema `json:"properties"`
+	AdditionalProperties *Schema            `json:"additionalProperties"`
+	MinProperties        *int               `json:"minProperties"`
+	MaxProperties        *int               `json:"maxProperties"`
+
+	AllOf []*Schema `json:"allOf"`
+	AnyOf []*Schema `json:"anyOf"`
+	OneOf []*Schema `json:"oneOf"`
+	Not   *Schema   `json:"not"`
+}
+
+// Parse parses and compiles a JSON Schema document.
+func Parse(data []byte) (*Schema, error) {
+	var s Schema
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("failed to parse schema: %w", err)
+	}
+	return &s, nil
+}
+
+// UnmarshalJSON accepts both object and boolean schemas and compiles the
+// pattern.
+func (s *Schema) UnmarshalJSON(data []byte) error {
+	var always bool
+	if err := json.Unmarshal(data, &always); err == nil {
+		s.always = &always
+		return nil
+	}
+
+	type plain Schema
+	if err := json.Unmarshal(data, (*plain)(s)); err != nil {
+		return err
+	}
+	if s.Pattern != "" {
+		re, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", s.Pattern, err)
+		}
+		s.pattern = re
+	}
+	return nil
+}
+
+// typeList holds the allowed types; the keyword is a string or an array.
+type typeList []string
+
+func (t *typeList) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*t = typeList{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*t = list
+	return nil
+}
+
+// constValue holds the value of the const keyword, whatever its type.
+type constValue struct {
+	value interface{}
+}
+
+func (c *constValue) UnmarshalJSON(data []byte) error {
+	return json.Unmarshal(data, &c.value)
+}
+
+// ValidationError describes where and why a document violates a schema.
+type ValidationError struct {
+	Path   string // JSON Pointer of the offending value
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	path := e.Path
+	if path == "" {
+		path = "/"
+	}
+	return fmt.Sprintf("%s: %s", path, e.Reason)
+}
+
+// Validate checks a document decoded by encoding/json against the schema
+// and returns the first violation found.
+func (s *Schema) Validate(doc interface{}) error {
+	return s.validate("", doc)
+}
+
+func (s *Schema) validate(path string, v interface{}) error {
+	if s.always != nil {
+		if !*s.always {
+			return &ValidationError{Path: path, Reason: "no value is allowed"}
+		}
+		return nil
+	}
+	fail := func(format string, args ...interface{}) error {
+		return &ValidationError{Path: path, Reason: fmt.Sprintf(format, args...)}
+	}
+
+	if len(s.Type) > 0 && !s.Type.matches(v) {
+		return fail("expected %s, got %s", strings.Join(s.Type, " or "), typeOf(v))
+	}
+	if len(s.Enum) > 0 && !containsValue(s.Enum, v) {
+		return fail("value is not one of the allowed values")
+	}
+	if s.Const != nil && !reflect.DeepEqual(s.Const.value, v) {
+		return fail("value does not equal the constant")
+	}
+
+	switch v := v.(type) {
+	case float64:
+		if err := s.validateNumber(v, fail); err != nil {
+			return err
+		}
+	case string:
+		length := utf8.RuneCountInString(v)
+		if s.MinLength != nil && length < *s.MinLength {
+			return fail("string shorter than %d characters", *s.MinLength)
+		}
+		if s.MaxLength != nil && length > *s.MaxLength {
+			return fail("string longer than %d characters", *s.MaxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			return fail("string does not match pattern %q", s.Pattern)
+		}
+	case []interface{}:
+		if s.MinItems != nil && len(v) < *s.MinItems {
+			return fail("fewer than %d items", *s.MinItems)
+		}
+		if s.MaxItems != nil && len(v) > *s.MaxItems {
+			return fail("more than %d items", *s.MaxItems)
+		}
+		if s.Items != nil {
+			for i, item := range v {
+				if err := s.Items.validate(fmt.Sprintf("%s/%d", path, i), item); err != nil {
+					return err
+				}
+			}
+		}
+	case map[string]interface{}:
+		if err := s.validateObject(path, v, fail); err != nil {
+			return err
+		}
+	}
+
+	return s.validateCombinators(path, v, fail)
+}
+
+func (s *Schema) validateNumber(v float64, fail func(string, ...interface{}) error) error {
+	if s.Minimum != nil && v < *s.Minimum {
+		return fail("%g is less than the minimum %g", v, *s.Minimum)
+	}
+	if s.Maximum != nil && v > *s.Maximum {
+		return fail("%g is greater than the maximum %g", v, *s.Maximum)
+	}
+	if s.ExclusiveMinimum != nil && v <= *s.ExclusiveMinimum {
+		return fail("%g is not greater than %g", v, *s.ExclusiveMinimum)
+	}
+	if s.ExclusiveMaximum != nil && v >= *s.ExclusiveMaximum {
+		return fail("%g is not less than %g", v, *s.ExclusiveMaximum)
+	}
+	if s.MultipleOf != nil && *s.MultipleOf > 0 {
+		if q := v / *s.MultipleOf; q != math.Trunc(q) {
+			return fail("%g is not a multiple of %g", v, *s.MultipleOf)
+		}
+	}
+	return nil
+}
+
+func (s *Schema) validateObject(path string, v map[string]interface{}, fail func(string, ...interface{}) error) error {
+	for _, name := range s.Required {
+		if _, ok := v[name]; !ok {
+			return fail("missing required property %q", name)
+		}
+	}
+	if s.MinProperties != nil && len(v) < *s.MinProperties {
+		return fail("fewer than %d properties", *s.MinProperties)
+	}
+	if s.MaxProperties != nil && len(v) > *s.MaxProperties {
+		return fail("more than %d properties", *s.MaxProperties)
+	}
+	for name, value := range v {
+		child := path + "/" + escapePointer(name)
+		if prop, ok := s.Properties[name]; ok {
+			if err := prop.validate(child, value); err != nil {
+				return err
+			}
+		} else if s.AdditionalProperties != nil {
+			if err := s.AdditionalProperties.validate(child, value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Schema) validateCombinators(path string, v interface{}, fail func(string, ...interface{}) error) error {
+	for _, sub := range s.AllOf {
+		if err := sub.validate(path, v); err != nil {
+			return err
+		}
+	}
+	if len(s.AnyOf) > 0 {
+		matched := false
+		for _, sub := range s.AnyOf {
+			if sub.validate(path, v) == nil {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fail("value matches none of the anyOf schemas")
+		}
+	}
+	if len(s.OneOf) > 0 {
+		matches := 0
+		for _, sub := range s.OneOf {
+			if sub.validate(path, v) == nil {
+				matches++
+			}
+		}
+		if matches != 1 {
+			return fail("value matches %d of the oneOf schemas, want exactly 1", matches)
+		}
+	}
+	if s.Not != nil && s.Not.validate(path, v) == nil {
+		return fail("value matches the schema it must not match")
+	}
+	return nil
+}
+
+// matches reports whether v has one of the listed types.
+func (t typeList) matches(v interface{}) bool {
+	actual := typeOf(v)
+	for _, want := range t {
+		if want == actual || (want == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// typeOf returns the JSON Schema type of a decoded value. Numbers without
+// a fractional part are integers.
+func typeOf(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+func containsValue(values []interface{}, v interface{}) bool {
+	for _, candidate := range values {
+		if reflect.DeepEqual(candidate, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// escapePointer escapes a property name for use in a JSON Pointer.
+func escapePointer(name string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(name, "~", "~0"), "/", "~1")
+}
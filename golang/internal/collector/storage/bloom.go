@@ -0,0 +1,141 @@
+package storage
+
+import (
+	"hash/maphash"
+	"math"
+	"sync"
+	"time"
+)
+
+// bloomFilter is a fixed-size Bloom filter of strings. It may report a
+// string it never saw as present, but never misses one it saw.
+type bloomFilter struct {
+	bits  []uint64
+	m     uint64 // Number of bits
+	k     int    // Bits set per string
+	seed1 maphash.Seed
+	seed2 maphash.Seed
+}
+
+// newBloomFilter sizes a filter for n strings at false positive rate p.
+func newBloomFilter(n int, p float64, seed1, seed2 maphash.Seed) *bloomFilter {
+	m := uint64(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	m = max(m, 64)
+	k := max(1, int(math.Round(float64(m)/float64(n)*math.Ln2)))
+	return &bloomFilter{
+		bits:  make([]uint64, (m+63)/64),
+		m:     m,
+		k:     k,
+		seed1: seed1,
+		seed2: seed2,
+	}
+}
+
+// positions calls fn with the k bit positions of s, derived from two
+// hashes by double hashing.
+func (f *bloomFilter) positions(s string, fn func(bit uint64) bool) {
+	h1 := maphash.String(f.seed1, s)
+	h2 := maphash.String(f.seed2, s) | 1
+	for i := 0; i < f.k; i++ {
+		if !fn((h1 + uint64(i)*h2) % f.m) {
+			return
+		}
+	}
+}
+
+func (f *bloomFilter) add(s string) {
+	f.positions(s, func(bit uint64) bool {
+		f.bits[bit/64] |= 1 << (bit % 64)
+		return true
+	})
+}
+
+func (f *bloomFilter) test(s string) bool {
+	found := true
+	f.positions(s, func(bit uint64) bool {
+		found = f.bits[bit/64]&(1<<(bit%64)) != 0
+		return found
+	})
+	return found
+}
+
+// seenFilter remembers the event IDs this collector marked as processed,
+// so that events it certainly never saw skip the Redis duplicate check.
+// Two filters rotate every window: IDs are remembered for at least one
+// window, and a filter is emptied before its false positive rate grows.
+//
+// An ID marked in Redis by an earlier run of the collector is unknown to
+// the filter, so it answers nothing until it has run for a full window,
+// which must cover the dedup TTL; until then every event is checked.
+type seenFilter struct {
+	mu       sync.Mutex
+	current  *bloomFilter
+	previous *bloomFilter
+	window   time.Duration
+	size     int
+	fpRate   float64
+	rotateAt time.Time
+	warmAt   time.Time
+	seed1    maphash.Seed
+	seed2    maphash.Seed
+}
+
+// newSeenFilter creates a filter holding about size IDs per window at the
+// false positive rate fpRate.
+func newSeenFilter(size int, fpRate float64, window time.Duration, now time.Time) *seenFilter {
+	f := &seenFilter{
+		window:   window,
+		size:     size,
+		fpRate:   fpRate,
+		rotateAt: now.Add(window),
+		warmAt:   now.Add(window),
+		seed1:    maphash.MakeSeed(),
+		seed2:    maphash.MakeSeed(),
+	}
+	f.current = newBloomFilter(size, fpRate, f.seed1, f.seed2)
+	f.previous = newBloomFilter(size, fpRate, f.seed1, f.seed2)
+	return f
+}
+
+// unseen reports for each ID whether it was certainly not marked by this
+// collector within the last window. It reports false for all of them
+// while the filter is warming up.
+func (f *seenFilter) unseen(ids []string, now time.Time) []bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rotate(now)
+
+	result := make([]bool, len(ids))
+	if now.Before(f.warmAt) {
+		return result
+	}
+	for i, id := range ids {
+		result[i] = !f.current.test(id) && !f.previous.test(id)
+	}
+	return result
+}
+
+// add records IDs marked as processed.
+func (f *seenFilter) add(ids []string, now time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rotate(now)
+	for _, id := range ids {
+		f.current.add(id)
+	}
+}
+
+// rotate starts a new filter once the window elapsed, keeping the last
+// one; after more than a window without activity both are dropped.
+func (f *seenFilter) rotate(now time.Time) {
+	if now.Before(f.rotateAt) {
+		return
+	}
+	if now.Before(f.rotateAt.Add(f.window)) {
+		f.previous = f.current
+	} else {
+		f.previous = newBloomFilter(f.size, f.fpRate, f.seed1, f.seed2)
+	}
+	f.current = newBloomFilter(f.size, f.fpRate, f.seed1, f.seed2)
+	f.rotateAt = now.Add(f.window)
+}
@@ -0,0 +1,27 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCertainlyNewChecksRedeliveries(t *testing.T) {
+	start := time.Now().Add(-2 * time.Hour)
+	s := newTestStorage(t, nil, testConfig())
+	// Warmed up: the filter answers for IDs it never saw
+	s.seen = newSeenFilter(1000, 0.01, time.Hour, start)
+	s.seen.add([]string{"marked"}, time.Now())
+
+	events := []*LogEvent{
+		{EventID: "first-delivery"},
+		{EventID: "marked"},
+		// Another collector may have stored it before crashing
+		{EventID: "redelivered", Redelivered: true},
+	}
+	got := s.certainlyNew(events)
+	for i, want := range []bool{true, false, false} {
+		if got[i] != want {
+			t.Errorf("%s: certainly new = %t, want %t", events[i].EventID, got[i], want)
+		}
+	}
+}
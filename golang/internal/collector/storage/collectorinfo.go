@@ -0,0 +1,45 @@
+package storage
+
+import "time"
+
+// CollectorInfo is the processing metadata the collector attaches to an
+// event under metadata._collector when enabled. Minimal mode records where
+// and when the event was received; full mode adds the worker, the time spent
+// in the collector before batching and the processing decisions applied.
+type CollectorInfo struct {
+	Instance     string    `json:"instance"`
+	ReceivedAt   time.Time `json:"receivedAt"`
+	WorkerID     int       `json:"workerId,omitempty"`
+	ProcessingMs float64   `json:"processingMs,omitempty"`
+	Decisions    []string  `json:"decisions,omitempty"`
+
+	full bool
+}
+
+// NewCollectorInfo creates the processing metadata of a received event.
+func NewCollectorInfo(instance string, receivedAt time.Time, workerID int, full bool) *CollectorInfo {
+	info := &CollectorInfo{
+		Instance:   instance,
+		ReceivedAt: receivedAt,
+		full:       full,
+	}
+	if full {
+		info.WorkerID = workerID
+	}
+	return info
+}
+
+// Note records a processing decision in full mode. It is safe to call on a
+// nil CollectorInfo.
+func (c *CollectorInfo) Note(decision string) {
+	if c != nil && c.full {
+		c.Decisions = append(c.Decisions, decision)
+	}
+}
+
+// finish records the time spent in the collector before batching.
+func (c *CollectorInfo) finish() {
+	if c != nil && c.full {
+		c.ProcessingMs = float64(time.Since(c.ReceivedAt).Microseconds()) / 1000
+	}
+}
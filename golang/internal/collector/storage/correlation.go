@@ -0,0 +1,56 @@
+package storage
+
+// correlationGroups tracks the distinct correlation IDs of the pending batch
+// in the order they were first seen.
+type correlationGroups struct {
+	order []string
+	seen  map[string]struct{}
+}
+
+func newCorrelationGroups() *correlationGroups {
+	return &correlationGroups{seen: make(map[string]struct{})}
+}
+
+// add records the correlation ID of a newly batched event.
+func (g *correlationGroups) add(id string) {
+	if _, ok := g.seen[id]; ok {
+		return
+	}
+	g.seen[id] = struct{}{}
+	g.order = append(g.order, id)
+}
+
+// len returns the number of distinct correlation IDs in the pending batch.
+func (g *correlationGroups) len() int {
+	return len(g.order)
+}
+
+// reset forgets all groups, e.g. after the whole batch was flushed.
+func (g *correlationGroups) reset() {
+	g.order = g.order[:0]
+	clear(g.seen)
+}
+
+// splitOldest removes the n oldest groups and partitions batch into the
+// events belonging to them and the remaining events, preserving order.
+func (g *correlationGroups) splitOldest(batch []*LogEvent, n int) (oldest, rest []*LogEvent) {
+	if n > len(g.order) {
+		n = len(g.order)
+	}
+	flushed := make(map[string]struct{}, n)
+	for _, id := range g.order[:n] {
+		flushed[id] = struct{}{}
+		delete(g.seen, id)
+	}
+	g.order = append(g.order[:0], g.order[n:]...)
+
+	rest = make([]*LogEvent, 0, len(batch))
+	for _, event := range batch {
+		if _, ok := flushed[event.CorrelationID]; ok {
+			oldest = append(oldest, event)
+		} else {
+			rest = append(rest, event)
+		}
+	}
+	return oldest, rest
+}
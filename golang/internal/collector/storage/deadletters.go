@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"observability_hub/golang/internal/collector/config"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+)
+
+// tableNamePattern matches a table name, optionally schema-qualified.
+var tableNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// DeadLetter is a dead-lettered message with the broker's failure headers.
+type DeadLetter struct {
+	Exchange       string
+	RoutingKey     string
+	MessageID      string
+	CorrelationID  string
+	Reason         string // Why the broker dead-lettered it, e.g. rejected or expired
+	Headers        map[string]interface{}
+	Body           []byte
+	DeadLetteredAt time.Time // Zero when the broker did not record it
+}
+
+// DeadLetterArchive stores dead-lettered messages in a Postgres table, so
+// that failure patterns can be queried with SQL once the messages left
+// the broker.
+type DeadLetterArchive struct {
+	db     *sql.DB
+	name   string
+	table  string // name quoted for use in statements
+	logger *zap.Logger
+}
+
+// NewDeadLetterArchive opens a connection to the logs database for the
+// configured dead letter table, creating the table when auto-migrate is
+// enabled.
+func NewDeadLetterArchive(ctx context.Context, cfg *config.Config, logger *zap.Logger) (*DeadLetterArchive, error) {
+	if !tableNamePattern.MatchString(cfg.DLQArchiveTable) {
+		return nil, fmt.Errorf("invalid dead letter table name %q", cfg.DLQArchiveTable)
+	}
+	parts := strings.Split(cfg.DLQArchiveTable, ".")
+	for i, part := range parts {
+		parts[i] = pq.QuoteIdentifier(part)
+	}
+
+	db, err := sql.Open("postgres", cfg.PostgresURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to postgres: %w", err)
+	}
+	if err = db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to ping postgres: %w", err)
+	}
+	// Dead letters are written one at a time
+	db.SetMaxOpenConns(2)
+
+	a := &DeadLetterArchive{
+		db:     db,
+		name:   cfg.DLQArchiveTable,
+		table:  strings.Join(parts, "."),
+		logger: logger.Named("dead_letters"),
+	}
+	if cfg.PostgresAutoMigrate {
+		if err := a.migrate(ctx); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+func (a *DeadLetterArchive) migrate(ctx context.Context) error {
+	stmts := []string{
+		`CREATE TABLE IF NOT EXISTS ` + a.table + ` (
+			id               BIGSERIAL PRIMARY KEY,
+			archived_at      TIMESTAMPTZ NOT NULL DEFAULT now(),
+			dead_lettered_at TIMESTAMPTZ,
+			exchange         TEXT,
+			routing_key      TEXT,
+			message_id       TEXT,
+			correlation_id   TEXT,
+			reason           TEXT,
+			headers          JSONB,
+			body             BYTEA
+		)`,
+		`CREATE INDEX IF NOT EXISTS ` + pq.QuoteIdentifier("idx_"+strings.ReplaceAll(a.name, ".", "_")+"_archived_at") +
+			` ON ` + a.table + ` (archived_at)`,
+	}
+	for _, stmt := range stmts {
+		if _, err := a.db.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("failed to create dead letter table: %w", err)
+		}
+	}
+	return nil
+}
+
+// Store writes one dead letter.
+func (a *DeadLetterArchive) Store(ctx context.Context, letter *DeadLetter) error {
+	headers, err := json.Marshal(letter.Headers)
+	if err != nil {
+		return fmt.Errorf("failed to marshal dead letter headers: %w", err)
+	}
+	var deadLetteredAt interface{}
+	if !letter.DeadLetteredAt.IsZero() {
+		deadLetteredAt = letter.DeadLetteredAt
+	}
+
+	_, err = a.db.ExecContext(ctx,
+		`INSERT INTO `+a.table+` (dead_lettered_at, exchange, routing_key, message_id, correlation_id, reason, headers, body)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		deadLetteredAt, letter.Exchange, letter.RoutingKey, letter.MessageID, letter.CorrelationID,
+		letter.Reason, string(headers), letter.Body)
+	if err != nil {
+		return fmt.Errorf("failed to store dead letter: %w", err)
+	}
+	return nil
+}
+
+// Close closes the database connection.
+func (a *DeadLetterArchive) Close() {
+	a.db.Close()
+	a.logger.Info("Dead letter archive closed.")
+}
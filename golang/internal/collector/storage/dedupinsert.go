@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"observability_hub/golang/internal/collector/metrics"
+	"strings"
+)
+
+// uniqueEventIDIndex lets the deduplicating insert skip stored event IDs.
+// Creating it fails while logs holds duplicate event IDs; they must be
+// removed first.
+const uniqueEventIDIndex = `CREATE UNIQUE INDEX IF NOT EXISTS idx_logs_event_id_unique ON logs (event_id)`
+
+// maxInsertRows bounds the rows of one INSERT statement; the parameters of
+// a statement are also kept below the Postgres limit of 65535.
+const (
+	maxInsertRows   = 1000
+	maxInsertParams = 65535
+)
+
+// insertRowsPerStatement returns how many rows of the given number of
+// columns one INSERT statement holds.
+func insertRowsPerStatement(columns int) int {
+	return min(maxInsertRows, maxInsertParams/columns)
+}
+
+// insertIn writes a batch in one transaction with multi-row INSERT
+// statements that skip the events whose event_id is already stored. It is
+// slower than COPY, but duplicates that outlived the Redis dedup window,
+// e.g. after a Redis restart, are not stored twice.
+func (s *DBStorage) insertIn(ctx context.Context, parts []partitionBatch) error {
+	skipped := 0
+	err := s.writeIn(ctx, parts, func(ctx context.Context, txn *sql.Tx, part partitionBatch) error {
+		n, err := s.insertTable(ctx, txn, part)
+		skipped += n
+		return err
+	})
+	if err == nil {
+		metrics.DBInsertConflicts.Add(float64(skipped))
+	}
+	return err
+}
+
+// insertTable inserts the events of part into its table within txn and
+// returns how many were skipped as already stored.
+func (s *DBStorage) insertTable(ctx context.Context, txn *sql.Tx, part partitionBatch) (int, error) {
+	columns := s.copyColumns()
+	prefix := "INSERT INTO " + part.table + " (" + strings.Join(columns, ", ") + ") VALUES "
+	rowsPerStatement := insertRowsPerStatement(len(columns))
+
+	skipped := 0
+	for start := 0; start < len(part.events); start += rowsPerStatement {
+		events := part.events[start:min(start+rowsPerStatement, len(part.events))]
+
+		var query strings.Builder
+		query.WriteString(prefix)
+		args := make([]interface{}, 0, len(events)*len(columns))
+		for i, event := range events {
+			if i > 0 {
+				query.WriteString(", ")
+			}
+			query.WriteString("(")
+			for j := range columns {
+				if j > 0 {
+					query.WriteString(", ")
+				}
+				fmt.Fprintf(&query, "$%d", len(args)+j+1)
+			}
+			query.WriteString(")")
+			args = append(args, s.copyRow(event)...)
+		}
+		query.WriteString(" ON CONFLICT (event_id) DO NOTHING")
+
+		result, err := txn.ExecContext(ctx, query.String(), args...)
+		if err != nil {
+			// The entire transaction will be rolled back.
+			return 0, fmt.Errorf("failed to insert: %w", err)
+		}
+		if inserted, err := result.RowsAffected(); err == nil {
+			skipped += len(events) - int(inserted)
+		}
+	}
+	return skipped, nil
+}
+
+// checkUniqueEventID verifies that logs has the unique index on event_id
+// the deduplicating insert relies on; without it every flush would fail.
+func (s *DBStorage) checkUniqueEventID(ctx context.Context) error {
+	var unique bool
+	err := s.db.QueryRowContext(ctx, `SELECT EXISTS (
+		SELECT 1 FROM pg_index i
+		JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = i.indkey[0]
+		WHERE i.indrelid = to_regclass('logs') AND i.indisunique AND i.indisvalid
+			AND i.indnatts = 1 AND i.indpred IS NULL AND a.attname = 'event_id'
+	)`).Scan(&unique)
+	if err != nil {
+		return fmt.Errorf("failed to inspect the logs indexes: %w", err)
+	}
+	if !unique {
+		return errors.New("POSTGRES_DEDUP_ON_INSERT needs a unique index on logs (event_id); " +
+			"enable POSTGRES_AUTO_MIGRATE or create it, after removing duplicate event IDs")
+	}
+	return nil
+}
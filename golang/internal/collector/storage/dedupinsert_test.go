@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"observability_hub/golang/internal/collector/config"
+)
+
+func TestInsertRowsPerStatement(t *testing.T) {
+	tests := []struct {
+		columns int
+		want    int
+	}{
+		{1, 1000},
+		{10, 1000},
+		{65, 1000},
+		{66, 992},
+		{100, 655},
+		{65535, 1},
+	}
+	for _, tt := range tests {
+		if got := insertRowsPerStatement(tt.columns); got != tt.want {
+			t.Errorf("insertRowsPerStatement(%d) = %d, want %d", tt.columns, got, tt.want)
+		}
+		if got := insertRowsPerStatement(tt.columns); got*tt.columns > maxInsertParams {
+			t.Errorf("%d rows of %d columns exceed %d parameters", got, tt.columns, maxInsertParams)
+		}
+	}
+}
+
+// insertBatch returns n events of one partition.
+func insertBatch(n int) partitionBatch {
+	part := partitionBatch{table: "logs"}
+	for i := 0; i < n; i++ {
+		event := &LogEvent{EventID: fmt.Sprintf("e-%d", i), Timestamp: time.Unix(int64(i), 0)}
+		part.events = append(part.events, event)
+	}
+	return part
+}
+
+// insertDedupConfig returns the settings of a storage writing with the
+// deduplicating INSERT.
+func insertDedupConfig() *config.Config {
+	cfg := testConfig()
+	cfg.PostgresDedupOnInsert = true
+	return cfg
+}
+
+func TestInsertTableSplitsStatements(t *testing.T) {
+	fake := &fakeDB{affected: func(exec fakeExec) (int64, error) {
+		// Every statement hits one stored event ID
+		return int64(len(exec.args)/10) - 1, nil
+	}}
+	s := newTestStorage(t, fake.open(t), insertDedupConfig())
+	columns := len(s.copyColumns())
+	if columns != 10 {
+		t.Fatalf("%d columns, want the 10 of the default settings", columns)
+	}
+
+	part := insertBatch(2500)
+	if err := s.insertIn(context.Background(), []partitionBatch{part}); err != nil {
+		t.Fatalf("insertIn: %v", err)
+	}
+
+	execs := fake.committed()
+	if len(execs) != 3 {
+		t.Fatalf("%d statements, want 3", len(execs))
+	}
+	next := 0
+	for i, want := range []int{1000, 1000, 500} {
+		exec := execs[i]
+		if rows := len(exec.args) / columns; rows != want {
+			t.Errorf("statement %d inserts %d rows, want %d", i, rows, want)
+		}
+		if !strings.HasPrefix(exec.query, "INSERT INTO logs (event_id, ") || !strings.HasSuffix(exec.query, " ON CONFLICT (event_id) DO NOTHING") {
+			t.Errorf("statement %d = %.60s...", i, exec.query)
+		}
+		if n := strings.Count(exec.query, "$"); n != len(exec.args) {
+			t.Errorf("statement %d has %d placeholders for %d arguments", i, n, len(exec.args))
+		}
+		if last := fmt.Sprintf("$%d)", len(exec.args)); !strings.Contains(exec.query, last) {
+			t.Errorf("statement %d does not end its placeholders at %s", i, last)
+		}
+		// The rows follow the batch, event_id first
+		for row := 0; row < len(exec.args)/columns; row++ {
+			if id := exec.args[row*columns].Value; id != part.events[next].EventID {
+				t.Fatalf("statement %d row %d is %v, want %s", i, row, id, part.events[next].EventID)
+			}
+			next++
+		}
+	}
+}
+
+func TestInsertTableCountsSkipped(t *testing.T) {
+	tests := []struct {
+		name     string
+		affected func(exec fakeExec) (int64, error)
+		want     int
+	}{
+		{"none stored", func(exec fakeExec) (int64, error) { return int64(len(exec.args) / 10), nil }, 0},
+		{"one stored per statement", func(exec fakeExec) (int64, error) { return int64(len(exec.args)/10) - 1, nil }, 2},
+		{"all stored", func(exec fakeExec) (int64, error) { return 0, nil }, 1500},
+		// Unknown counts are not reported as skipped
+		{"rows affected unknown", func(exec fakeExec) (int64, error) { return 0, errors.New("not supported") }, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeDB{affected: tt.affected}
+			db := fake.open(t)
+			s := newTestStorage(t, db, insertDedupConfig())
+
+			txn, err := db.Begin()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer txn.Rollback()
+			skipped, err := s.insertTable(context.Background(), txn, insertBatch(1500))
+			if err != nil {
+				t.Fatalf("insertTable: %v", err)
+			}
+			if skipped != tt.want {
+				t.Errorf("skipped = %d, want %d", skipped, tt.want)
+			}
+		})
+	}
+}
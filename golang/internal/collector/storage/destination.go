@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"observability_hub/golang/internal/collector/metrics"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Destination names used in the per-destination metrics.
+const (
+	DestinationPostgres      = "postgres"
+	DestinationElasticsearch = "elasticsearch"
+	DestinationKafka         = "kafka"
+)
+
+// WriteFunc writes one batch of events to a destination.
+type WriteFunc func(ctx context.Context, events []*LogEvent) error
+
+// Destination writes batches to one storage destination independently of
+// the others. Writes are bounded in number and each gets its own timeout,
+// so that a slow destination neither piles up requests nor holds up the
+// writes to the other destinations.
+type Destination struct {
+	name    string
+	timeout time.Duration
+	write   WriteFunc
+	slots   chan struct{}
+	logger  *zap.Logger
+	wg      sync.WaitGroup
+}
+
+// NewDestination creates a destination running at most concurrency writes
+// at once, each limited to timeout. A zero timeout disables the limit.
+func NewDestination(name string, concurrency int, timeout time.Duration, write WriteFunc, logger *zap.Logger) *Destination {
+	return &Destination{
+		name:    name,
+		timeout: timeout,
+		write:   write,
+		slots:   make(chan struct{}, max(1, concurrency)),
+		logger:  logger.Named(name),
+	}
+}
+
+// Write writes a batch once a write slot is free and returns the outcome.
+func (d *Destination) Write(ctx context.Context, events []*LogEvent) error {
+	select {
+	case d.slots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-d.slots }()
+	return d.do(ctx, events)
+}
+
+// Go writes a batch in the background. When all write slots are taken the
+// batch is skipped rather than waited for, so that callers never block on
+// a slow destination; it reports whether the write was started. Failures
+// are logged and counted.
+func (d *Destination) Go(ctx context.Context, events []*LogEvent) bool {
+	select {
+	case d.slots <- struct{}{}:
+	default:
+		metrics.DestinationWrites.WithLabelValues(d.name, "skipped").Inc()
+		d.logger.Warn("All writes in flight, skipping batch", zap.Int("events", len(events)))
+		return false
+	}
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		defer func() { <-d.slots }()
+		if err := d.do(ctx, events); err != nil {
+			d.logger.Error("Failed to write batch", zap.Error(err), zap.Int("events", len(events)))
+		}
+	}()
+	return true
+}
+
+// Wait blocks until the background writes have finished.
+func (d *Destination) Wait() {
+	d.wg.Wait()
+}
+
+func (d *Destination) do(ctx context.Context, events []*LogEvent) error {
+	if d.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.timeout)
+		defer cancel()
+	}
+
+	metrics.DestinationWritesInFlight.WithLabelValues(d.name).Inc()
+	defer metrics.DestinationWritesInFlight.WithLabelValues(d.name).Dec()
+
+	start := time.Now()
+	err := d.write(ctx, events)
+	observeDestinationWrite(d.name, start, err)
+	return err
+}
+
+// observeDestinationWrite records the outcome of one batch write.
+func observeDestinationWrite(name string, start time.Time, err error) {
+	result := "success"
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		result = "timeout"
+	case err != nil:
+		result = "error"
+	default:
+		metrics.DestinationWriteDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+	}
+	metrics.DestinationWrites.WithLabelValues(name, result).Inc()
+}
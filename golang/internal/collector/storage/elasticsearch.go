@@ -7,7 +7,10 @@ import (
 	"fmt"
 	"io"
 	"observability_hub/golang/internal/collector/config"
+	"observability_hub/golang/internal/collector/metrics"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/elastic/go-elasticsearch/v8"
 	"github.com/elastic/go-elasticsearch/v8/esapi"
@@ -20,8 +23,12 @@ const (
 
 // ESStorage handles Elasticsearch operations.
 type ESStorage struct {
-	client *elasticsearch.Client
-	logger *zap.Logger
+	client  *elasticsearch.Client
+	cfg     *config.Config
+	logger  *zap.Logger
+	indices *indexTracker
+
+	serverVersion string
 }
 
 // NewESStorage creates a new ESStorage instance.
@@ -35,6 +42,28 @@ func NewESStorage(cfg *config.Config, logger *zap.Logger) (*ESStorage, error) {
 		return nil, fmt.Errorf("failed to create elasticsearch client: %w", err)
 	}
 
+	serverVersion, err := detectESVersion(esClient)
+	if err != nil {
+		return nil, err
+	}
+
+	compatibilityMode := false
+	if cfg.ESSkipVersionCheck {
+		logger.Warn("Skipping Elasticsearch version check",
+			zap.String("server_version", serverVersion),
+			zap.String("client_version", elasticsearch.Version))
+	} else {
+		if compatibilityMode, err = negotiateESVersion(serverVersion); err != nil {
+			return nil, err
+		}
+		if compatibilityMode {
+			esCfg.EnableCompatibilityMode = true
+			if esClient, err = elasticsearch.NewClient(esCfg); err != nil {
+				return nil, fmt.Errorf("failed to create elasticsearch client: %w", err)
+			}
+		}
+	}
+
 	// Test the connection
 	res, err := esClient.Info()
 	if err != nil {
@@ -46,45 +75,182 @@ func NewESStorage(cfg *config.Config, logger *zap.Logger) (*ESStorage, error) {
 		return nil, fmt.Errorf("elasticsearch info response error: %s", res.String())
 	}
 
-	logger.Info("Successfully connected to Elasticsearch", zap.String("version", elasticsearch.Version))
+	logger.Info("Successfully connected to Elasticsearch",
+		zap.String("version", elasticsearch.Version),
+		zap.String("server_version", serverVersion),
+		zap.Bool("compatibility_mode", compatibilityMode))
+	metrics.ESInfo.WithLabelValues(elasticsearch.Version, serverVersion, strconv.FormatBool(compatibilityMode)).Set(1)
 
 	return &ESStorage{
-		client: esClient,
-		logger: logger.Named("es_storage"),
+		client:  esClient,
+		cfg:     cfg,
+		logger:  logger.Named("es_storage"),
+		indices: newIndexTracker(cfg.ESMaxIndicesPerMonth),
+
+		serverVersion: serverVersion,
 	}, nil
 }
 
+// ServerVersion returns the Elasticsearch version detected at startup.
+func (s *ESStorage) ServerVersion() string {
+	return s.serverVersion
+}
+
+// BulkError reports the events of a bulk request that Elasticsearch did
+// not index, after documents failing transiently were retried. The other
+// events of the batch were indexed and must not be sent again.
+type BulkError struct {
+	Events  []*LogEvent // Every event that was not indexed
+	Invalid []*LogEvent // Those Elasticsearch refused as invalid, e.g. on a mapping conflict; retrying cannot help
+	Total   int         // Events in the batch
+	Reasons []string
+}
+
+func (e *BulkError) Error() string {
+	return fmt.Sprintf("%d of %d documents failed to index: %s", len(e.Events), e.Total, strings.Join(e.Reasons, "; "))
+}
+
+// Failed returns the number of events that were not indexed.
+func (e *BulkError) Failed() int {
+	return len(e.Events)
+}
+
+// bulkFailure is a document the bulk API did not index.
+type bulkFailure struct {
+	event  *LogEvent
+	status int
+	reason string
+}
+
+// retryable reports whether indexing the document may succeed later:
+// rejections under load and server errors, as opposed to documents the
+// cluster refuses, such as mapping conflicts.
+func (f bulkFailure) retryable() bool {
+	return f.status == 429 || f.status >= 500
+}
+
 // BulkIndexLogEvents indexes a batch of log events to Elasticsearch.
+// Documents failing transiently are sent again on their own, with
+// backoff, up to ES_BULK_ITEM_RETRIES times. If some documents still
+// failed the error is a *BulkError listing them; an error of any other
+// type means no document of the batch is known to be indexed.
 func (s *ESStorage) BulkIndexLogEvents(ctx context.Context, events []*LogEvent) error {
 	if len(events) == 0 {
 		return nil
 	}
 
+	var failed []bulkFailure
+	pending := events
+	backoff := s.cfg.ESBulkRetryBackoff
+	for attempt := 0; ; attempt++ {
+		failures, err := s.bulk(ctx, pending)
+		if err != nil {
+			if attempt == 0 {
+				return err
+			}
+			// The earlier attempts indexed the rest of the batch
+			for _, event := range pending {
+				failed = append(failed, bulkFailure{event: event, reason: err.Error()})
+			}
+			break
+		}
+
+		var retry []*LogEvent
+		var last []bulkFailure
+		for _, failure := range failures {
+			if failure.retryable() && attempt < s.cfg.ESBulkItemRetries {
+				retry = append(retry, failure.event)
+				last = append(last, failure)
+				continue
+			}
+			failed = append(failed, failure)
+		}
+		if len(retry) == 0 {
+			break
+		}
+
+		metrics.ESBulkItemRetries.Add(float64(len(retry)))
+		s.logger.Warn("Retrying documents that failed to index",
+			zap.Int("documents", len(retry)),
+			zap.Int("attempt", attempt+1),
+			zap.Duration("backoff", backoff),
+			zap.String("first_reason", last[0].reason))
+		select {
+		case <-ctx.Done():
+			for _, failure := range last {
+				failure.reason = ctx.Err().Error()
+				failed = append(failed, failure)
+			}
+			return s.bulkError(events, failed)
+		case <-time.After(backoff):
+		}
+		pending = retry
+		backoff *= 2
+	}
+
+	if len(failed) > 0 {
+		return s.bulkError(events, failed)
+	}
+	s.logger.Info("Successfully indexed batch of logs", zap.Int("count", len(events)))
+	return nil
+}
+
+// bulkError describes the documents of a batch that were not indexed.
+func (s *ESStorage) bulkError(events []*LogEvent, failures []bulkFailure) *BulkError {
+	err := &BulkError{Total: len(events)}
+	reasons := make(map[string]bool)
+	for _, failure := range failures {
+		err.Events = append(err.Events, failure.event)
+		if failure.status >= 400 && !failure.retryable() {
+			err.Invalid = append(err.Invalid, failure.event)
+		}
+		if !reasons[failure.reason] {
+			reasons[failure.reason] = true
+			err.Reasons = append(err.Reasons, failure.reason)
+		}
+	}
+	metrics.ESBulkItemFailures.Add(float64(len(err.Events)))
+	return err
+}
+
+// bulk sends one bulk request and returns the documents that were not
+// indexed. An error means the request as a whole failed.
+func (s *ESStorage) bulk(ctx context.Context, events []*LogEvent) ([]bulkFailure, error) {
+	var failures []bulkFailure
+	// The response items follow the order of the documents sent
+	sent := make([]*LogEvent, 0, len(events))
 	var buf bytes.Buffer
 	for _, event := range events {
 		// Meta line for bulk API
 		meta := map[string]interface{}{
 			"index": map[string]interface{}{
-				"_index": getIndexName(event),
+				"_index": s.getIndexName(event),
 				"_id":    event.EventID,
 			},
 		}
 		metaBytes, err := json.Marshal(meta)
 		if err != nil {
 			s.logger.Error("Failed to marshal bulk meta", zap.Error(err))
+			failures = append(failures, bulkFailure{event: event, status: 400, reason: err.Error()})
 			continue
 		}
-		buf.Write(metaBytes)
-		buf.WriteByte('\n')
 
 		// Event source line
+		stampPipelineVersion(event, s.cfg.PipelineVersion)
 		eventBytes, err := json.Marshal(event)
 		if err != nil {
 			s.logger.Error("Failed to marshal event source", zap.Error(err))
+			failures = append(failures, bulkFailure{event: event, status: 400, reason: err.Error()})
 			continue
 		}
+		buf.Write(metaBytes)
+		buf.WriteByte('\n')
 		buf.Write(eventBytes)
 		buf.WriteByte('\n')
+		sent = append(sent, event)
+	}
+	if len(sent) == 0 {
+		return failures, nil
 	}
 
 	req := esapi.BulkRequest{
@@ -94,13 +260,13 @@ func (s *ESStorage) BulkIndexLogEvents(ctx context.Context, events []*LogEvent)
 
 	res, err := req.Do(ctx, s.client)
 	if err != nil {
-		return fmt.Errorf("bulk request failed: %w", err)
+		return nil, fmt.Errorf("bulk request failed: %w", err)
 	}
 	defer res.Body.Close()
 
 	if res.IsError() {
 		bodyBytes, _ := io.ReadAll(res.Body)
-		return fmt.Errorf("bulk request returned an error: %s, body: %s", res.Status(), string(bodyBytes))
+		return nil, fmt.Errorf("bulk request returned an error: %s, body: %s", res.Status(), string(bodyBytes))
 	}
 
 	// Check for errors in the response body
@@ -118,33 +284,128 @@ func (s *ESStorage) BulkIndexLogEvents(ctx context.Context, events []*LogEvent)
 	}
 
 	if err := json.NewDecoder(res.Body).Decode(&bulkResponse); err != nil {
-		return fmt.Errorf("failed to decode bulk response: %w", err)
+		return nil, fmt.Errorf("failed to decode bulk response: %w", err)
+	}
+	if !bulkResponse.Errors {
+		return failures, nil
+	}
+	if len(bulkResponse.Items) != len(sent) {
+		return nil, fmt.Errorf("bulk response has %d items for %d documents", len(bulkResponse.Items), len(sent))
 	}
 
-	if bulkResponse.Errors {
-		var errorReasons []string
-		for _, item := range bulkResponse.Items {
-			if item.Index.Error.Type != "" {
-				errorReasons = append(errorReasons, fmt.Sprintf("type: %s, reason: %s", item.Index.Error.Type, item.Index.Error.Reason))
-			}
+	for i, item := range bulkResponse.Items {
+		if item.Index.Error.Type != "" {
+			failures = append(failures, bulkFailure{
+				event:  sent[i],
+				status: item.Index.Status,
+				reason: fmt.Sprintf("type: %s, reason: %s", item.Index.Error.Type, item.Index.Error.Reason),
+			})
 		}
-		return fmt.Errorf("bulk indexing had errors: %s", strings.Join(errorReasons, "; "))
 	}
-
-	s.logger.Info("Successfully indexed batch of logs", zap.Int("count", len(events)))
-	return nil
+	return failures, nil
 }
 
-// getIndexName determines the index name based on the event source.
-func getIndexName(event *LogEvent) string {
+// getIndexName determines the index name based on the event source. With
+// level separation the level part is added to every scheme, so that e.g.
+// errors of overflowed services still land apart from their other logs.
+func (s *ESStorage) getIndexName(event *LogEvent) string {
+	if s.highRetry(event) {
+		// e.g., logs-high-retry-2024-07, one per month over all services
+		return fmt.Sprintf("%s-%s", s.cfg.HighRetryIndex, s.indexTimestamp(event).Format("2006-01"))
+	}
+	level := s.indexLevel(event)
 	if event.Source.Service != "" {
-		// e.g., logs-user-service-2024-07
-		return fmt.Sprintf("logs-%s-%s",
-			strings.ToLower(event.Source.Service),
-			event.Timestamp.Format("2006-01"),
-		)
+		// e.g., logs-user-service-2024-07 or logs-user-service-errors-2024-07
+		month := s.indexTimestamp(event).Format("2006-01")
+		index := fmt.Sprintf("logs-%s%s-%s", strings.ToLower(event.Source.Service), level, month)
+		if s.indices.admit(month, index) {
+			return index
+		}
+
+		metrics.ESIndexOverflow.Inc()
+		s.logger.Debug("Index cap reached, routing event to the overflow index",
+			zap.String("event_id", event.EventID),
+			zap.String("service", event.Source.Service))
+		return fmt.Sprintf("%s%s-%s", s.cfg.ESOverflowIndexPrefix, level, month)
+	}
+	return defaultIndexName + level
+}
+
+// highRetry reports whether the event goes to the high-retry index.
+func (s *ESStorage) highRetry(event *LogEvent) bool {
+	return s.cfg.HighRetryAction == config.HighRetryActionIndex &&
+		s.cfg.HighRetryThreshold > 0 &&
+		event.Metadata.RetryCount != nil &&
+		*event.Metadata.RetryCount >= s.cfg.HighRetryThreshold
+}
+
+// indexLevel returns the index name part for the event's level: empty
+// without level separation and for non-error levels in errors mode.
+// Unknown levels share one index so that they cannot multiply indices.
+func (s *ESStorage) indexLevel(event *LogEvent) string {
+	level := strings.ToUpper(event.Data.Level)
+	switch s.cfg.ESIndexLevels {
+	case config.ESIndexLevelsErrors:
+		if level == "ERROR" || level == "FATAL" {
+			return "-errors"
+		}
+	case config.ESIndexLevelsAll:
+		switch level {
+		case "TRACE", "DEBUG", "INFO", "WARN", "ERROR", "FATAL":
+			return "-" + strings.ToLower(level)
+		}
+		return "-other"
+	}
+	return ""
+}
+
+// indexTimestamp returns the timestamp used for monthly index bucketing.
+// Producers with skewed clocks would otherwise create indices far in the
+// past or future (e.g. logs-svc-2099-01), so the timestamp is clamped to
+// the configured window. The stored event keeps its original timestamp.
+func (s *ESStorage) indexTimestamp(event *LogEvent) time.Time {
+	if !s.cfg.ESIndexClampEnabled {
+		return event.Timestamp
+	}
+	ts, clamped := clampTimestamp(event.Timestamp, time.Now().UTC(), s.cfg.ESIndexMaxPast, s.cfg.ESIndexMaxFuture)
+	if clamped != "" {
+		metrics.ESIndexTimestampClamped.WithLabelValues(clamped).Inc()
+		s.logger.Debug("Clamped skewed event timestamp for index selection",
+			zap.String("event_id", event.EventID),
+			zap.Time("timestamp", event.Timestamp),
+			zap.String("direction", clamped))
+	}
+	return ts
+}
+
+// AddToBatch indexes a log event. See AddBatch.
+func (s *ESStorage) AddToBatch(event *LogEvent) error {
+	return s.AddBatch([]*LogEvent{event})
+}
+
+// AddBatch indexes log events at once when Elasticsearch is the primary
+// backend. There is no Postgres batch to wait for, and the document IDs
+// keep redelivered events from being indexed twice. Events without
+// identifiers get generated ones first, as in DBStorage. The events that
+// were not indexed are listed in a *BulkError.
+func (s *ESStorage) AddBatch(events []*LogEvent) error {
+	for _, event := range events {
+		assignSyntheticIDs(UUIDGenerator{}, event)
+		event.Metadata.Collector.finish()
+	}
+
+	ctx := context.Background()
+	if s.cfg.ESFlushTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.cfg.ESFlushTimeout)
+		defer cancel()
 	}
-	return defaultIndexName
+	metrics.DestinationWritesInFlight.WithLabelValues(DestinationElasticsearch).Inc()
+	defer metrics.DestinationWritesInFlight.WithLabelValues(DestinationElasticsearch).Dec()
+	start := time.Now()
+	err := s.BulkIndexLogEvents(ctx, events)
+	observeDestinationWrite(DestinationElasticsearch, start, err)
+	return err
 }
 
 // Close is a placeholder for any cleanup logic.
@@ -0,0 +1,184 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"observability_hub/golang/internal/collector/config"
+
+	"go.uber.org/zap"
+)
+
+// fakeES is an Elasticsearch stand-in serving the root and bulk endpoints.
+// The documents of every bulk request are recorded; status decides each
+// document's item status, 201 when nil.
+type fakeES struct {
+	mu     sync.Mutex
+	docs   []fakeESDoc
+	status func(doc fakeESDoc) int
+}
+
+// fakeESDoc is a document of a bulk request.
+type fakeESDoc struct {
+	Index  string
+	ID     string
+	Source LogEvent
+}
+
+func (f *fakeES) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Elastic-Product", "Elasticsearch")
+	w.Header().Set("Content-Type", "application/json")
+	if r.URL.Path == "/" {
+		w.Write([]byte(`{"version":{"number":"8.15.0"},"tagline":"You Know, for Search"}`))
+		return
+	}
+
+	type item struct {
+		Index struct {
+			Status int `json:"status"`
+			Error  *struct {
+				Type   string `json:"type"`
+				Reason string `json:"reason"`
+			} `json:"error,omitempty"`
+		} `json:"index"`
+	}
+	var response struct {
+		Errors bool   `json:"errors"`
+		Items  []item `json:"items"`
+	}
+	scanner := bufio.NewScanner(r.Body)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var meta struct {
+			Index struct {
+				Index string `json:"_index"`
+				ID    string `json:"_id"`
+			} `json:"index"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &meta); err != nil || !scanner.Scan() {
+			http.Error(w, "malformed bulk body", http.StatusBadRequest)
+			return
+		}
+		doc := fakeESDoc{Index: meta.Index.Index, ID: meta.Index.ID}
+		if err := json.Unmarshal(scanner.Bytes(), &doc.Source); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var it item
+		it.Index.Status = http.StatusCreated
+		f.mu.Lock()
+		if f.status != nil {
+			it.Index.Status = f.status(doc)
+		}
+		if it.Index.Status < 300 {
+			f.docs = append(f.docs, doc)
+		}
+		f.mu.Unlock()
+		if it.Index.Status >= 300 {
+			response.Errors = true
+			it.Index.Error = &struct {
+				Type   string `json:"type"`
+				Reason string `json:"reason"`
+			}{Type: "mapper_parsing_exception", Reason: "failed to parse"}
+		}
+		response.Items = append(response.Items, it)
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// indexed returns the documents indexed so far.
+func (f *fakeES) indexed() []fakeESDoc {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]fakeESDoc(nil), f.docs...)
+}
+
+// newFakeESStorage returns an ESStorage writing to a fakeES.
+func newFakeESStorage(t *testing.T, cfg *config.Config, es *fakeES) *ESStorage {
+	t.Helper()
+	server := httptest.NewServer(es)
+	t.Cleanup(server.Close)
+	cfg.ElasticsearchURL = server.URL
+	s, err := NewESStorage(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestESStorageAddBatch(t *testing.T) {
+	es := &fakeES{status: func(doc fakeESDoc) int {
+		if doc.Source.Data.Message == "refused" {
+			return http.StatusBadRequest
+		}
+		return http.StatusCreated
+	}}
+	cfg := &config.Config{ESFlushTimeout: time.Second, PipelineVersion: "test"}
+	var s Storage = newFakeESStorage(t, cfg, es)
+
+	now := time.Now().UTC()
+	stored := &LogEvent{EventID: "8d0c2cf4-5a2f-4f43-9a8b-3f0a3a7b2f10", Timestamp: now, Source: Source{Service: "checkout"}}
+	synthetic := &LogEvent{Timestamp: now, Source: Source{Service: "checkout"}}
+	refused := &LogEvent{EventID: "0b7e1d0e-9c47-4a43-8a57-2b0c7c9f7f21", Timestamp: now, Source: Source{Service: "checkout"}}
+	refused.Data.Message = "refused"
+
+	err := s.AddBatch([]*LogEvent{stored, synthetic, refused})
+	var bulkErr *BulkError
+	if !errors.As(err, &bulkErr) {
+		t.Fatalf("AddBatch error = %v, want a *BulkError", err)
+	}
+	if len(bulkErr.Events) != 1 || bulkErr.Events[0] != refused || len(bulkErr.Invalid) != 1 || bulkErr.Invalid[0] != refused {
+		t.Errorf("BulkError lists %d failed and %d invalid events, want the refused one as both", len(bulkErr.Events), len(bulkErr.Invalid))
+	}
+
+	if synthetic.EventID == "" {
+		t.Fatal("event without an ID was indexed without a synthetic one")
+	}
+	docs := es.indexed()
+	if len(docs) != 2 || docs[0].ID != stored.EventID || docs[1].ID != synthetic.EventID {
+		t.Fatalf("indexed %v, want the stored and the synthetic event", docs)
+	}
+	if want := "logs-checkout-" + now.Format("2006-01"); docs[0].Index != want {
+		t.Errorf("index = %s, want %s", docs[0].Index, want)
+	}
+	if docs[0].Source.Metadata.PipelineVersion != "test" {
+		t.Errorf("pipeline version = %q, want test", docs[0].Source.Metadata.PipelineVersion)
+	}
+
+	if err := s.AddToBatch(stored); err != nil {
+		t.Errorf("AddToBatch: %v", err)
+	}
+	s.Close()
+}
+
+func TestGetIndexNameClampsSkewedTimestamps(t *testing.T) {
+	cfg := &config.Config{ESIndexMaxPast: 30 * 24 * time.Hour, ESIndexMaxFuture: time.Second}
+	s := &ESStorage{cfg: cfg, logger: zap.NewNop(), indices: newIndexTracker(0)}
+	future := &LogEvent{Timestamp: time.Date(2099, 1, 15, 0, 0, 0, 0, time.UTC), Source: Source{Service: "Checkout"}}
+	past := &LogEvent{Timestamp: time.Date(2001, 1, 15, 0, 0, 0, 0, time.UTC), Source: Source{Service: "checkout"}}
+
+	if got := s.getIndexName(future); got != "logs-checkout-2099-01" {
+		t.Errorf("index without clamping = %s, want logs-checkout-2099-01", got)
+	}
+
+	cfg.ESIndexClampEnabled = true
+	before := time.Now().UTC().Format("2006-01")
+	futureIndex, pastIndex := s.getIndexName(future), s.getIndexName(past)
+	after := time.Now().UTC().Add(cfg.ESIndexMaxFuture).Format("2006-01")
+	if futureIndex != "logs-checkout-"+before && futureIndex != "logs-checkout-"+after {
+		t.Errorf("index of a 2099 event = %s, want logs-checkout-%s", futureIndex, before)
+	}
+	if want := "logs-checkout-" + time.Now().UTC().Add(-cfg.ESIndexMaxPast).Format("2006-01"); pastIndex != want {
+		t.Errorf("index of a 2001 event = %s, want %s", pastIndex, want)
+	}
+	if future.Timestamp.Year() != 2099 {
+		t.Errorf("event timestamp changed to %s", future.Timestamp)
+	}
+}
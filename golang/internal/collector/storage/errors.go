@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"database/sql/driver"
+	"errors"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/lib/pq"
+)
+
+// ErrorClass groups storage errors by how they should be handled.
+type ErrorClass string
+
+const (
+	// ErrorClassConnectionLimit means the server refused a connection
+	// because it is out of connection slots. Retrying quickly makes it worse.
+	ErrorClassConnectionLimit ErrorClass = "connection_limit"
+	// ErrorClassDeadlock means the transaction was aborted to break a
+	// deadlock or a serialization conflict with a concurrent one. The
+	// conflict is usually over by the time the transaction is retried.
+	ErrorClassDeadlock ErrorClass = "deadlock"
+	// ErrorClassTransient errors are expected to go away on retry.
+	ErrorClassTransient ErrorClass = "transient"
+	// ErrorClassPermanent errors will fail again with the same input.
+	ErrorClassPermanent ErrorClass = "permanent"
+	// ErrorClassUnknown errors are retried like transient ones.
+	ErrorClassUnknown ErrorClass = "unknown"
+)
+
+// ClassifyError maps a storage error onto the error taxonomy, mostly by
+// Postgres SQLSTATE code as reported by either driver.
+func ClassifyError(err error) ErrorClass {
+	if err == nil {
+		return ""
+	}
+
+	if code, ok := sqlState(err); ok {
+		class := code[:2]
+		switch {
+		case code == "53300": // too_many_connections
+			return ErrorClassConnectionLimit
+		case code == "40001", // serialization_failure
+			code == "40P01": // deadlock_detected
+			return ErrorClassDeadlock
+		case class == "08", // connection_exception
+			class == "57", // operator_intervention, e.g. admin shutdown
+			class == "53": // insufficient_resources
+			return ErrorClassTransient
+		case class == "22", // data_exception
+			class == "23", // integrity_constraint_violation
+			class == "42": // syntax_error_or_access_rule_violation
+			return ErrorClassPermanent
+		}
+		return ErrorClassUnknown
+	}
+
+	if errors.Is(err, driver.ErrBadConn) {
+		return ErrorClassTransient
+	}
+	// Connection-time failures may not surface as *pq.Error.
+	if strings.Contains(err.Error(), "too many connections") {
+		return ErrorClassConnectionLimit
+	}
+	return ErrorClassUnknown
+}
+
+// sqlState returns the SQLSTATE code of a Postgres error from lib/pq or
+// pgx.
+func sqlState(err error) (string, bool) {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && len(pqErr.Code) == 5 {
+		return string(pqErr.Code), true
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && len(pgErr.Code) == 5 {
+		return pgErr.Code, true
+	}
+	return "", false
+}
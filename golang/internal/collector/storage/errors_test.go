@@ -0,0 +1,44 @@
+package storage
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/lib/pq"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want ErrorClass
+	}{
+		{"nil", nil, ""},
+		{"too many connections", &pq.Error{Code: "53300"}, ErrorClassConnectionLimit},
+		{"too many connections from pgx", &pgconn.PgError{Code: "53300"}, ErrorClassConnectionLimit},
+		{"too many connections wrapped", fmt.Errorf("flush: %w", &pq.Error{Code: "53300"}), ErrorClassConnectionLimit},
+		{"too many connections at connect", errors.New("pq: sorry, too many connections for role \"collector\""), ErrorClassConnectionLimit},
+		{"serialization failure", &pq.Error{Code: "40001"}, ErrorClassDeadlock},
+		{"deadlock", &pq.Error{Code: "40P01"}, ErrorClassDeadlock},
+		{"deadlock from pgx", &pgconn.PgError{Code: "40P01"}, ErrorClassDeadlock},
+		{"out of memory", &pq.Error{Code: "53200"}, ErrorClassTransient},
+		{"connection failure", &pq.Error{Code: "08006"}, ErrorClassTransient},
+		{"admin shutdown", &pq.Error{Code: "57P01"}, ErrorClassTransient},
+		{"bad connection", driver.ErrBadConn, ErrorClassTransient},
+		{"invalid text", &pq.Error{Code: "22021"}, ErrorClassPermanent},
+		{"not null violation", &pgconn.PgError{Code: "23502"}, ErrorClassPermanent},
+		{"undefined table", &pq.Error{Code: "42P01"}, ErrorClassPermanent},
+		{"lock timeout", &pq.Error{Code: "55P03"}, ErrorClassUnknown},
+		{"other", errors.New("something went wrong"), ErrorClassUnknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ClassifyError(tt.err); got != tt.want {
+				t.Errorf("ClassifyError(%v) = %q, want %q", tt.err, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package storage
+
+import "sync"
+
+// indexTracker bounds the number of distinct per-service indices created
+// per month. Services beyond the cap share the overflow index, so bad
+// service names (e.g. containing request IDs) cannot explode the cluster's
+// index count.
+type indexTracker struct {
+	mu      sync.Mutex
+	max     int
+	byMonth map[string]map[string]struct{}
+}
+
+func newIndexTracker(max int) *indexTracker {
+	return &indexTracker{
+		max:     max,
+		byMonth: make(map[string]map[string]struct{}),
+	}
+}
+
+// admit reports whether the index may be used for the month, registering
+// it if there is room. A max of zero or less disables the cap.
+func (t *indexTracker) admit(month, index string) bool {
+	if t.max <= 0 {
+		return true
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	indices, ok := t.byMonth[month]
+	if !ok {
+		indices = make(map[string]struct{})
+		t.byMonth[month] = indices
+	}
+	if _, ok := indices[index]; ok {
+		return true
+	}
+	if len(indices) >= t.max {
+		return false
+	}
+	indices[index] = struct{}{}
+	return true
+}
@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// ErrESVersionIncompatible is returned when the Elasticsearch server major
+// version cannot be served by the bundled client.
+var ErrESVersionIncompatible = errors.New("incompatible elasticsearch version")
+
+// esClientMajor is the major version of the bundled go-elasticsearch client.
+const esClientMajor = 8
+
+// detectESVersion returns the server version reported by the root endpoint.
+// The request goes through the raw transport so that the client's product
+// check and compatibility headers cannot mask a version mismatch.
+func detectESVersion(client *elasticsearch.Client) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, "/", nil)
+	if err != nil {
+		return "", err
+	}
+	res, err := client.Transport.Perform(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to get elasticsearch info: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= http.StatusMultipleChoices {
+		return "", fmt.Errorf("elasticsearch info response error: %s", res.Status)
+	}
+
+	var info struct {
+		Version struct {
+			Number string `json:"number"`
+		} `json:"version"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&info); err != nil {
+		return "", fmt.Errorf("failed to decode elasticsearch info: %w", err)
+	}
+	if info.Version.Number == "" {
+		return "", errors.New("elasticsearch info response has no version number")
+	}
+	return info.Version.Number, nil
+}
+
+// negotiateESVersion decides how the client talks to a server of the given
+// version. 7.x servers accept the typeless bulk requests the collector sends,
+// 9.x servers are reached through the v8 compatibility headers, and anything
+// else is rejected. The result reports whether compatibility mode is needed.
+func negotiateESVersion(serverVersion string) (bool, error) {
+	major, err := strconv.Atoi(strings.SplitN(serverVersion, ".", 2)[0])
+	if err != nil {
+		return false, fmt.Errorf("failed to parse elasticsearch version %q: %w", serverVersion, err)
+	}
+
+	switch major {
+	case esClientMajor - 1, esClientMajor:
+		return false, nil
+	case esClientMajor + 1:
+		return true, nil
+	default:
+		return false, fmt.Errorf("%w: server %s, client %s (set ES_SKIP_VERSION_CHECK=true to override)",
+			ErrESVersionIncompatible, serverVersion, elasticsearch.Version)
+	}
+}
@@ -0,0 +1,143 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeTxid is the transaction ID the fake database reports.
+const fakeTxid = 42
+
+// fakeDB is a database/sql driver recording the statements executed on it,
+// for tests of the SQL the storage sends and of how it handles results no
+// real server can be made to return on demand.
+type fakeDB struct {
+	mu    sync.Mutex
+	execs []fakeExec // Statements executed in committed transactions
+	// affected returns the RowsAffected of an executed statement; with
+	// nil, RowsAffected fails
+	affected func(exec fakeExec) (int64, error)
+	// commitErr is returned by Commit after the transaction took effect,
+	// as when the connection fails before the server's reply arrives
+	commitErr  error
+	txidStatus string // Result of txid_status
+	commits    int
+}
+
+// fakeExec is a statement executed on a fakeDB.
+type fakeExec struct {
+	query string
+	args  []driver.NamedValue
+}
+
+// open returns a *sql.DB on the fake database, closed when the test ends.
+func (f *fakeDB) open(t testing.TB) *sql.DB {
+	t.Helper()
+	db := sql.OpenDB(fakeConnector{f})
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// committed returns the statements of the committed transactions.
+func (f *fakeDB) committed() []fakeExec {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]fakeExec(nil), f.execs...)
+}
+
+type fakeConnector struct{ db *fakeDB }
+
+func (c fakeConnector) Connect(context.Context) (driver.Conn, error) {
+	return &fakeConn{db: c.db}, nil
+}
+
+func (c fakeConnector) Driver() driver.Driver { return nil }
+
+type fakeConn struct {
+	db  *fakeDB
+	txn []fakeExec // Statements of the open transaction
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("fakedb: prepared statements are not supported")
+}
+
+func (c *fakeConn) Close() error { return nil }
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	c.txn = []fakeExec{}
+	return c, nil
+}
+
+func (c *fakeConn) Commit() error {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	c.db.execs = append(c.db.execs, c.txn...)
+	c.db.commits++
+	c.txn = nil
+	return c.db.commitErr
+}
+
+func (c *fakeConn) Rollback() error {
+	c.txn = nil
+	return nil
+}
+
+func (c *fakeConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	exec := fakeExec{query: query, args: args}
+	if c.txn != nil {
+		c.txn = append(c.txn, exec)
+	}
+	if c.db.affected == nil {
+		return fakeResult{0, errors.New("fakedb: rows affected unknown")}, nil
+	}
+	n, err := c.db.affected(exec)
+	return fakeResult{n, err}, nil
+}
+
+func (c *fakeConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	switch {
+	case query == "SELECT txid_current()":
+		return &fakeRows{values: []driver.Value{int64(fakeTxid)}}, nil
+	case strings.HasPrefix(query, "SELECT txid_status("):
+		c.db.mu.Lock()
+		defer c.db.mu.Unlock()
+		return &fakeRows{values: []driver.Value{c.db.txidStatus}}, nil
+	}
+	return nil, errors.New("fakedb: unexpected query " + query)
+}
+
+type fakeResult struct {
+	affected int64
+	err      error
+}
+
+func (r fakeResult) LastInsertId() (int64, error) { return 0, errors.New("fakedb: no insert ID") }
+func (r fakeResult) RowsAffected() (int64, error) { return r.affected, r.err }
+
+// fakeRows is a result of one row.
+type fakeRows struct {
+	values []driver.Value
+	done   bool
+}
+
+func (r *fakeRows) Columns() []string {
+	return make([]string, len(r.values))
+}
+
+func (r *fakeRows) Close() error { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	copy(dest, r.values)
+	return nil
+}
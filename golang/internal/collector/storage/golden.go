@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"observability_hub/golang/internal/collector/metrics"
+
+	"go.uber.org/zap"
+)
+
+// sampleGolden copies a random GOLDEN_SAMPLE_RATE share of a committed
+// batch to golden_events. Each row holds the raw payload as received, the
+// event as parsed and the column values as sent to logs, so that a
+// verifier can replay the raw payload and compare both forms with the
+// stored row. The set is capped at GOLDEN_MAX_ROWS by deleting the oldest
+// rows. Failures are logged and counted; they never fail the flush.
+func (s *DBStorage) sampleGolden(ctx context.Context, batch []*LogEvent) {
+	columns := s.copyColumns()
+	for _, event := range batch {
+		if rand.Float64() >= s.cfg.GoldenSampleRate {
+			continue
+		}
+		if err := s.storeGolden(ctx, columns, event); err != nil {
+			metrics.GoldenEvents.WithLabelValues("failed").Inc()
+			s.logger.Warn("Failed to store golden event", zap.Error(err), zap.String("event_id", event.EventID))
+			continue
+		}
+		metrics.GoldenEvents.WithLabelValues("stored").Inc()
+	}
+
+	_, err := s.db.ExecContext(ctx,
+		`DELETE FROM golden_events WHERE id <= (SELECT max(id) FROM golden_events) - $1`,
+		s.cfg.GoldenMaxRows)
+	if err != nil {
+		s.logger.Warn("Failed to rotate golden events", zap.Error(err))
+	}
+}
+
+func (s *DBStorage) storeGolden(ctx context.Context, columns []string, event *LogEvent) error {
+	parsed, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	row := s.copyRow(event)
+	values := make(map[string]interface{}, len(columns))
+	for i, column := range columns {
+		values[column] = row[i]
+	}
+	stored, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+
+	var raw []byte
+	var encoding interface{}
+	if event.RawPayload != nil {
+		raw, encoding = event.RawPayload.Data, event.RawPayload.Encoding
+	}
+	_, err = s.db.ExecContext(ctx,
+		`INSERT INTO golden_events (event_id, raw_payload, raw_payload_encoding, parsed, stored)
+		VALUES ($1, $2, $3, $4, $5)`,
+		event.EventID, raw, encoding, string(parsed), string(stored))
+	return err
+}
@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"math/rand"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// IDGenerator creates the identifiers the collector assigns on its own,
+// such as synthetic event and correlation IDs.
+type IDGenerator interface {
+	NewID() string
+}
+
+// UUIDGenerator generates random UUIDv4 identifiers. It is the default generator.
+type UUIDGenerator struct{}
+
+// NewID returns a new random UUIDv4 string.
+func (UUIDGenerator) NewID() string {
+	return uuid.NewString()
+}
+
+// SeededIDGenerator generates a deterministic sequence of UUIDv4-formatted
+// identifiers from a seed, so that generated IDs are reproducible in tests.
+type SeededIDGenerator struct {
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+// NewSeededIDGenerator creates a deterministic generator for the given seed.
+func NewSeededIDGenerator(seed int64) *SeededIDGenerator {
+	return &SeededIDGenerator{rng: rand.New(rand.NewSource(seed))}
+}
+
+// NewID returns the next identifier in the seeded sequence.
+func (g *SeededIDGenerator) NewID() string {
+	var id uuid.UUID
+	g.mu.Lock()
+	g.rng.Read(id[:])
+	g.mu.Unlock()
+
+	// Set the version (4) and variant (RFC 4122) bits
+	id[6] = (id[6] & 0x0f) | 0x40
+	id[8] = (id[8] & 0x3f) | 0x80
+	return id.String()
+}
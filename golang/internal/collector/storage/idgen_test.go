@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestSeededIDGeneratorIsReproducible(t *testing.T) {
+	a := NewSeededIDGenerator(42)
+	b := NewSeededIDGenerator(42)
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		id := a.NewID()
+		if other := b.NewID(); id != other {
+			t.Fatalf("id %d differs between generators with the same seed: %s and %s", i, id, other)
+		}
+		parsed, err := uuid.Parse(id)
+		if err != nil {
+			t.Fatalf("id %q is not a UUID: %v", id, err)
+		}
+		if parsed.Version() != 4 || parsed.Variant() != uuid.RFC4122 {
+			t.Fatalf("id %s is not an RFC 4122 UUIDv4", id)
+		}
+		if seen[id] {
+			t.Fatalf("id %s repeated", id)
+		}
+		seen[id] = true
+	}
+
+	if NewSeededIDGenerator(42).NewID() == NewSeededIDGenerator(43).NewID() {
+		t.Error("different seeds generated the same first id")
+	}
+}
+
+func TestAssignSyntheticIDsUsesGenerator(t *testing.T) {
+	want := NewSeededIDGenerator(7)
+	wantEventID, wantCorrelationID := want.NewID(), want.NewID()
+
+	event := &LogEvent{}
+	event.Metadata.Collector = NewCollectorInfo("test", time.Now(), 1, true)
+	assignSyntheticIDs(NewSeededIDGenerator(7), event)
+
+	if event.EventID != wantEventID {
+		t.Errorf("event id = %s, want %s", event.EventID, wantEventID)
+	}
+	if event.CorrelationID != wantCorrelationID {
+		t.Errorf("correlation id = %s, want %s", event.CorrelationID, wantCorrelationID)
+	}
+	decisions := event.Metadata.Collector.Decisions
+	if len(decisions) != 2 || decisions[0] != "synthetic_event_id" || decisions[1] != "synthetic_correlation_id" {
+		t.Errorf("decisions = %v", decisions)
+	}
+
+	// Producer-supplied identifiers are kept
+	assignSyntheticIDs(NewSeededIDGenerator(8), event)
+	if event.EventID != wantEventID || event.CorrelationID != wantCorrelationID {
+		t.Errorf("existing ids were replaced: %s, %s", event.EventID, event.CorrelationID)
+	}
+}
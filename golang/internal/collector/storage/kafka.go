@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"observability_hub/golang/internal/collector/config"
+	"observability_hub/golang/internal/collector/metrics"
+
+	"github.com/segmentio/kafka-go"
+	"go.uber.org/zap"
+)
+
+// KafkaStorage produces accepted events to a Kafka topic, so that the
+// collector can serve as the normalization and enrichment stage of a
+// larger pipeline. Events are JSON encoded as stored; the key decides the
+// partition, so events sharing a correlation ID stay in order by default.
+type KafkaStorage struct {
+	writer *kafka.Writer
+	cfg    *config.Config
+	logger *zap.Logger
+}
+
+// NewKafkaStorage creates a producer for the configured brokers and topic.
+// It dials the brokers once so that a wrong address fails at startup.
+func NewKafkaStorage(ctx context.Context, cfg *config.Config, logger *zap.Logger) (*KafkaStorage, error) {
+	if err := pingKafka(ctx, cfg.KafkaBrokers); err != nil {
+		return nil, err
+	}
+
+	acks := kafka.RequireAll
+	switch cfg.KafkaAcks {
+	case config.KafkaAcksOne:
+		acks = kafka.RequireOne
+	case config.KafkaAcksNone:
+		acks = kafka.RequireNone
+	}
+
+	k := &KafkaStorage{
+		cfg:    cfg,
+		logger: logger.Named("kafka"),
+	}
+	k.writer = &kafka.Writer{
+		Addr:         kafka.TCP(cfg.KafkaBrokers...),
+		Topic:        cfg.KafkaTopic,
+		Balancer:     &kafka.Hash{}, // Keyless messages go round robin
+		RequiredAcks: acks,
+		BatchSize:    cfg.KafkaBatchSize,
+		BatchTimeout: cfg.KafkaBatchTimeout,
+		Completion:   k.report,
+	}
+	return k, nil
+}
+
+// pingKafka connects to the first reachable broker.
+func pingKafka(ctx context.Context, brokers []string) error {
+	var err error
+	for _, broker := range brokers {
+		var conn *kafka.Conn
+		if conn, err = kafka.DialContext(ctx, "tcp", broker); err == nil {
+			conn.Close()
+			return nil
+		}
+	}
+	return fmt.Errorf("failed to connect to kafka: %w", err)
+}
+
+// ProduceLogEvents produces a batch of events and waits for the
+// acknowledgements. It fails when any event was not delivered.
+func (k *KafkaStorage) ProduceLogEvents(ctx context.Context, events []*LogEvent) error {
+	messages := make([]kafka.Message, 0, len(events))
+	for _, event := range events {
+		value, err := json.Marshal(event)
+		if err != nil {
+			metrics.KafkaMessages.WithLabelValues("failed").Inc()
+			k.logger.Error("Failed to encode event for Kafka", zap.Error(err), zap.String("eventId", event.EventID))
+			continue
+		}
+		messages = append(messages, kafka.Message{
+			Key:   k.key(event),
+			Value: value,
+			Headers: []kafka.Header{
+				{Key: "content-type", Value: []byte("application/json")},
+			},
+		})
+	}
+	if len(messages) == 0 {
+		return nil
+	}
+
+	err := k.writer.WriteMessages(ctx, messages...)
+	var writeErrs kafka.WriteErrors
+	if errors.As(err, &writeErrs) {
+		return fmt.Errorf("failed to produce %d of %d events: %w", writeErrs.Count(), len(messages), err)
+	}
+	return err
+}
+
+// key returns the message key of an event; nil when the strategy yields
+// nothing to partition by.
+func (k *KafkaStorage) key(event *LogEvent) []byte {
+	var key string
+	switch k.cfg.KafkaKey {
+	case config.KafkaKeyCorrelationID:
+		key = event.CorrelationID
+	case config.KafkaKeyEventID:
+		key = event.EventID
+	case config.KafkaKeyService:
+		key = event.Source.Service
+	}
+	if key == "" {
+		return nil
+	}
+	return []byte(key)
+}
+
+// report counts the delivery reports of one produce request.
+func (k *KafkaStorage) report(messages []kafka.Message, err error) {
+	result := "delivered"
+	if err != nil {
+		result = "failed"
+	}
+	metrics.KafkaMessages.WithLabelValues(result).Add(float64(len(messages)))
+}
+
+// Close flushes pending messages and closes the producer.
+func (k *KafkaStorage) Close() {
+	if err := k.writer.Close(); err != nil {
+		k.logger.Warn("Failed to close Kafka producer", zap.Error(err))
+	}
+	k.logger.Info("Kafka producer closed.")
+}
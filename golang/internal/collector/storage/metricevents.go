@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"observability_hub/golang/internal/types"
+	"strings"
+)
+
+// metricsColumns are the columns of the metrics table written per event.
+var metricsColumns = []string{
+	"event_id", "correlation_id", "timestamp", "service", "name", "type", "unit",
+	"labels", "value", "count", "sum", "buckets", "quantiles", "metadata",
+}
+
+// maxMetricsRows bounds the rows of one INSERT, keeping its parameters far
+// below the Postgres limit of 65535.
+const maxMetricsRows = 1000
+
+// AddMetrics writes metrics events to the metrics table. Unlike log events
+// they bypass the buffer and are written before AddMetrics returns, in one
+// transaction, so the caller can acknowledge them afterwards. Events whose
+// ID is already stored are skipped, which makes redeliveries harmless.
+func (s *DBStorage) AddMetrics(ctx context.Context, events []*types.MetricsEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	if s.cfg.PostgresFlushTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.cfg.PostgresFlushTimeout)
+		defer cancel()
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback() // Rollback is a no-op if Commit succeeds.
+
+	for start := 0; start < len(events); start += maxMetricsRows {
+		chunk := events[start:min(start+maxMetricsRows, len(events))]
+		query, args, err := insertMetricsQuery(chunk)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+			return fmt.Errorf("failed to insert metrics events: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// insertMetricsQuery builds one multi-row INSERT of events.
+func insertMetricsQuery(events []*types.MetricsEvent) (string, []any, error) {
+	var query strings.Builder
+	query.WriteString("INSERT INTO metrics (" + strings.Join(metricsColumns, ", ") + ") VALUES ")
+	args := make([]any, 0, len(events)*len(metricsColumns))
+	for i, event := range events {
+		row, err := metricsRow(event)
+		if err != nil {
+			return "", nil, fmt.Errorf("event %s: %w", event.EventID, err)
+		}
+		if i > 0 {
+			query.WriteString(", ")
+		}
+		query.WriteString("(")
+		for j := range row {
+			if j > 0 {
+				query.WriteString(", ")
+			}
+			fmt.Fprintf(&query, "$%d", len(args)+j+1)
+		}
+		query.WriteString(")")
+		args = append(args, row...)
+	}
+	query.WriteString(" ON CONFLICT (event_id) DO NOTHING")
+	return query.String(), args, nil
+}
+
+// metricsRow returns the column values of an event in metricsColumns
+// order. The observation time of the data is stored, falling back to the
+// event timestamp.
+func metricsRow(event *types.MetricsEvent) ([]any, error) {
+	d := &event.Data
+	timestamp := d.Timestamp
+	if timestamp.IsZero() {
+		timestamp = event.Timestamp
+	}
+
+	// Absent labels, buckets and quantiles marshal to null and become NULL
+	documents := []any{d.Labels, d.Buckets, d.Quantiles, event.Metadata}
+	columns := make([]any, len(documents))
+	for i, document := range documents {
+		data, err := json.Marshal(document)
+		if err != nil {
+			return nil, err
+		}
+		columns[i] = jsonColumn(data)
+	}
+
+	return []any{
+		event.EventID, event.CorrelationID, timestamp, event.Source.Service, d.Name, string(d.Type), nullString(d.Unit),
+		columns[0], d.Value, d.Count, d.Sum, columns[1], columns[2], columns[3],
+	}, nil
+}
+
+// nullString returns nil for NULL in place of an empty string.
+func nullString(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
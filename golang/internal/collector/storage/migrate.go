@@ -0,0 +1,165 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"observability_hub/golang/internal/collector/config"
+	"observability_hub/golang/internal/collector/metrics"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// migrationLockKey is the Postgres advisory lock key that serialises schema
+// changes across collector instances.
+const migrationLockKey int64 = 0x6f62735f6d6967 // "obs_mig"
+
+// lockPollInterval is how often a contended advisory lock is retried.
+const lockPollInterval = 250 * time.Millisecond
+
+// ErrMigrationLockTimeout is returned when another instance held the
+// migration lock for longer than the configured timeout.
+var ErrMigrationLockTimeout = errors.New("timed out waiting for migration lock")
+
+// migrations are applied in order on every start when auto-migrate is
+// enabled. Each statement must be idempotent.
+var migrations = []string{
+	`CREATE TABLE IF NOT EXISTS logs (
+		event_id       TEXT NOT NULL,
+		correlation_id TEXT,
+		timestamp      TIMESTAMPTZ NOT NULL,
+		level          TEXT,
+		service        TEXT,
+		message        TEXT,
+		context        JSONB,
+		error          JSONB,
+		structured     JSONB,
+		metadata       JSONB
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_logs_timestamp ON logs (timestamp)`,
+	`CREATE INDEX IF NOT EXISTS idx_logs_service_timestamp ON logs (service, timestamp)`,
+	`CREATE INDEX IF NOT EXISTS idx_logs_correlation_id ON logs (correlation_id)`,
+	`ALTER TABLE logs ADD COLUMN IF NOT EXISTS message_template TEXT`,
+	`CREATE INDEX IF NOT EXISTS idx_logs_service_message_template ON logs (service, message_template)`,
+	`ALTER TABLE logs ADD COLUMN IF NOT EXISTS repeat_count INTEGER`,
+	`ALTER TABLE logs ADD COLUMN IF NOT EXISTS repeat_last_timestamp TIMESTAMPTZ`,
+	`ALTER TABLE logs ADD COLUMN IF NOT EXISTS raw_payload BYTEA`,
+	`ALTER TABLE logs ADD COLUMN IF NOT EXISTS raw_payload_encoding TEXT`,
+	`ALTER TABLE logs ADD COLUMN IF NOT EXISTS collector_instance TEXT`,
+	`ALTER TABLE logs ADD COLUMN IF NOT EXISTS duration_ms DOUBLE PRECISION`,
+	`ALTER TABLE logs ADD COLUMN IF NOT EXISTS memory_bytes BIGINT`,
+	`ALTER TABLE logs ADD COLUMN IF NOT EXISTS cpu_pct DOUBLE PRECISION`,
+	// Percentile queries per service over a time range read only this index
+	`CREATE INDEX IF NOT EXISTS idx_logs_service_timestamp_duration ON logs (service, timestamp, duration_ms) WHERE duration_ms IS NOT NULL`,
+	// Per-event expiry; the retention sweep reads only this index
+	`ALTER TABLE logs ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ`,
+	`CREATE INDEX IF NOT EXISTS idx_logs_expires_at ON logs (expires_at) WHERE expires_at IS NOT NULL`,
+	// Tracing context; the index serves baggage containment queries
+	`ALTER TABLE logs ADD COLUMN IF NOT EXISTS tracing JSONB`,
+	`CREATE INDEX IF NOT EXISTS idx_logs_tracing_baggage ON logs USING GIN ((tracing->'baggage') jsonb_path_ops)`,
+	// Events waiting to be relayed to Elasticsearch when the outbox is enabled
+	`CREATE TABLE IF NOT EXISTS logs_outbox (
+		id         BIGSERIAL PRIMARY KEY,
+		event      JSONB NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	// Sampled events with their raw payload, for end-to-end fidelity checks
+	`CREATE TABLE IF NOT EXISTS golden_events (
+		id                   BIGSERIAL PRIMARY KEY,
+		sampled_at           TIMESTAMPTZ NOT NULL DEFAULT now(),
+		event_id             TEXT NOT NULL,
+		raw_payload          BYTEA,
+		raw_payload_encoding TEXT,
+		parsed               JSONB NOT NULL,
+		stored               JSONB NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_golden_events_event_id ON golden_events (event_id)`,
+	// Metrics events; the key makes redelivered events no-ops
+	`CREATE TABLE IF NOT EXISTS metrics (
+		event_id       TEXT PRIMARY KEY,
+		correlation_id TEXT,
+		timestamp      TIMESTAMPTZ NOT NULL,
+		service        TEXT,
+		name           TEXT NOT NULL,
+		type           TEXT NOT NULL,
+		unit           TEXT,
+		labels         JSONB,
+		value          DOUBLE PRECISION,
+		count          BIGINT,
+		sum            DOUBLE PRECISION,
+		buckets        JSONB,
+		quantiles      JSONB,
+		metadata       JSONB
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_metrics_service_name_timestamp ON metrics (service, name, timestamp)`,
+}
+
+// migrate applies the schema migrations while holding the migration lock.
+// If the lock cannot be acquired in time the configured policy decides
+// whether to continue with the existing schema or to fail startup.
+func (s *DBStorage) migrate(ctx context.Context) error {
+	err := withAdvisoryLock(ctx, s.db, migrationLockKey, s.cfg.MigrationLockTimeout, func(conn *sql.Conn) error {
+		statements := migrationStatements(s.cfg.PostgresPartitioning)
+		if s.cfg.PostgresDedupOnInsert {
+			statements = append(statements, uniqueEventIDIndex)
+		}
+		for i, stmt := range statements {
+			if _, err := conn.ExecContext(ctx, stmt); err != nil {
+				return fmt.Errorf("failed to apply migration %d: %w", i+1, err)
+			}
+		}
+		return nil
+	})
+
+	if errors.Is(err, ErrMigrationLockTimeout) && s.cfg.MigrationLockTimeoutPolicy == config.MigrationLockPolicyProceed {
+		s.logger.Error("Migration lock is held by another instance; proceeding with the existing schema",
+			zap.Duration("lock_timeout", s.cfg.MigrationLockTimeout))
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	s.logger.Info("Database schema is up to date", zap.Int("migrations", len(migrations)))
+	return nil
+}
+
+// withAdvisoryLock runs fn on a dedicated connection while holding the
+// session-level advisory lock identified by key. The lock is polled with
+// pg_try_advisory_lock so that an instance stuck while holding it cannot
+// block the caller for longer than timeout.
+func withAdvisoryLock(ctx context.Context, db *sql.DB, key int64, timeout time.Duration, fn func(conn *sql.Conn) error) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection for advisory lock: %w", err)
+	}
+	defer conn.Close()
+
+	start := time.Now()
+	deadline := start.Add(timeout)
+	for {
+		var acquired bool
+		if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", key).Scan(&acquired); err != nil {
+			return fmt.Errorf("failed to acquire advisory lock: %w", err)
+		}
+		if acquired {
+			break
+		}
+		if time.Now().After(deadline) {
+			metrics.MigrationLockWait.Observe(time.Since(start).Seconds())
+			return fmt.Errorf("%w after %s", ErrMigrationLockTimeout, timeout)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(lockPollInterval):
+		}
+	}
+	metrics.MigrationLockWait.Observe(time.Since(start).Seconds())
+
+	defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", key)
+	return fn(conn)
+}
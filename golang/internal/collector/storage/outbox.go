@@ -0,0 +1,147 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"observability_hub/golang/internal/collector/metrics"
+	"time"
+
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+)
+
+// writeOutbox adds the events of a batch to the outbox within the flush
+// transaction, so that exactly the committed events are relayed to
+// Elasticsearch.
+func writeOutbox(ctx context.Context, txn *sql.Tx, batch []*LogEvent) error {
+	stmt, err := txn.PrepareContext(ctx, pq.CopyIn("logs_outbox", "event"))
+	if err != nil {
+		return fmt.Errorf("failed to prepare outbox copy in statement: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, event := range batch {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return fmt.Errorf("failed to marshal outbox event: %w", err)
+		}
+		if _, err := stmt.ExecContext(ctx, string(data)); err != nil {
+			return fmt.Errorf("failed to exec outbox copy in statement: %w", err)
+		}
+	}
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		return fmt.Errorf("failed to finalize outbox copy in: %w", err)
+	}
+	return stmt.Close()
+}
+
+// StartOutboxRelay starts relaying the outbox to write, typically the
+// Elasticsearch destination, until the storage is closed. Rows are deleted
+// only once write succeeded, so events committed while Elasticsearch is
+// down are indexed when it returns. Rows are locked with SKIP LOCKED, so
+// several collectors can relay the same outbox.
+func (s *DBStorage) StartOutboxRelay(write WriteFunc) {
+	s.wg.Add(1)
+	go s.relayOutbox(write)
+}
+
+func (s *DBStorage) relayOutbox(write WriteFunc) {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.cfg.OutboxPollInterval)
+	defer ticker.Stop()
+
+	for {
+		// Keep going without waiting while full batches come back
+		for {
+			n, err := s.relayOutboxBatch(s.ctx, write)
+			if err != nil {
+				if s.ctx.Err() == nil {
+					s.logger.Warn("Failed to relay outbox to Elasticsearch", zap.Error(err))
+				}
+				break
+			}
+			if n < s.cfg.OutboxBatchSize {
+				break
+			}
+		}
+
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// relayOutboxBatch writes the oldest outbox rows and deletes them in one
+// transaction, returning the number of rows handled.
+func (s *DBStorage) relayOutboxBatch(ctx context.Context, write WriteFunc) (int, error) {
+	txn, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer txn.Rollback() // Rollback is a no-op if Commit succeeds.
+
+	rows, err := txn.QueryContext(ctx,
+		`SELECT id, event, created_at FROM logs_outbox ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED`,
+		s.cfg.OutboxBatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read outbox: %w", err)
+	}
+
+	var ids []int64
+	var events []*LogEvent
+	var oldest time.Time
+	for rows.Next() {
+		var id int64
+		var data []byte
+		var createdAt time.Time
+		if err := rows.Scan(&id, &data, &createdAt); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan outbox row: %w", err)
+		}
+		if oldest.IsZero() {
+			oldest = createdAt
+		}
+		ids = append(ids, id)
+
+		var event LogEvent
+		if err := json.Unmarshal(data, &event); err != nil {
+			// Retrying cannot help; the row is dropped with the batch
+			s.logger.Error("Dropping malformed outbox row", zap.Int64("id", id), zap.Error(err))
+			metrics.OutboxEvents.WithLabelValues("malformed").Inc()
+			continue
+		}
+		events = append(events, &event)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read outbox: %w", err)
+	}
+	if len(ids) == 0 {
+		metrics.OutboxLag.Set(0)
+		return 0, nil
+	}
+	metrics.OutboxLag.Set(time.Since(oldest).Seconds())
+
+	if len(events) > 0 {
+		if err := write(ctx, events); err != nil {
+			metrics.OutboxEvents.WithLabelValues("failed").Add(float64(len(events)))
+			return 0, fmt.Errorf("failed to index %d outbox events: %w", len(events), err)
+		}
+	}
+
+	if _, err := txn.ExecContext(ctx, `DELETE FROM logs_outbox WHERE id = ANY($1)`, pq.Array(ids)); err != nil {
+		return 0, fmt.Errorf("failed to prune outbox: %w", err)
+	}
+	if err := txn.Commit(); err != nil {
+		// The events are indexed again on the next attempt, under the
+		// same document IDs
+		return 0, fmt.Errorf("failed to commit outbox prune: %w", err)
+	}
+
+	metrics.OutboxEvents.WithLabelValues("relayed").Add(float64(len(events)))
+	s.logger.Debug("Relayed outbox to Elasticsearch", zap.Int("count", len(events)))
+	return len(ids), nil
+}
@@ -0,0 +1,178 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"observability_hub/golang/internal/collector/config"
+	"observability_hub/golang/internal/collector/metrics"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+)
+
+// partitionedLogsTable replaces the first migration when logs is
+// partitioned by month. An existing plain logs table is left as it is and
+// fails the startup check instead.
+const partitionedLogsTable = `CREATE TABLE IF NOT EXISTS logs (
+		event_id       TEXT NOT NULL,
+		correlation_id TEXT,
+		timestamp      TIMESTAMPTZ NOT NULL,
+		level          TEXT,
+		service        TEXT,
+		message        TEXT,
+		context        JSONB,
+		error          JSONB,
+		structured     JSONB,
+		metadata       JSONB
+	) PARTITION BY RANGE (timestamp)`
+
+// partitionBatch is the part of a batch that goes to one table.
+type partitionBatch struct {
+	table  string
+	month  time.Time // First instant of the partition's month, zero for logs
+	events []*LogEvent
+}
+
+// partitionName returns the monthly partition of logs holding events of
+// timestamp t, e.g. logs_2024_07. Months are in UTC.
+func partitionName(t time.Time) string {
+	t = t.UTC()
+	return fmt.Sprintf("logs_%04d_%02d", t.Year(), int(t.Month()))
+}
+
+// monthStart returns the first instant of the UTC month of t.
+func monthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// splitByPartition groups a batch by the table its events are written to:
+// logs itself without partitioning, otherwise one group per month in the
+// order the months first appear, keeping the batch order within each.
+func splitByPartition(batch []*LogEvent, partitioning string) []partitionBatch {
+	if partitioning != config.PostgresPartitioningMonthly {
+		return []partitionBatch{{table: "logs", events: batch}}
+	}
+
+	var parts []partitionBatch
+	index := make(map[string]int)
+	for _, event := range batch {
+		table := partitionName(event.Timestamp)
+		i, ok := index[table]
+		if !ok {
+			i = len(parts)
+			index[table] = i
+			parts = append(parts, partitionBatch{table: table, month: monthStart(event.Timestamp)})
+		}
+		parts[i].events = append(parts[i].events, event)
+	}
+	return parts
+}
+
+// flattenParts returns the events of all parts in one slice.
+func flattenParts(parts []partitionBatch) []*LogEvent {
+	if len(parts) == 1 {
+		return parts[0].events
+	}
+	var events []*LogEvent
+	for _, part := range parts {
+		events = append(events, part.events...)
+	}
+	return events
+}
+
+// isUndefinedTable reports whether err is Postgres' undefined_table error,
+// as returned by either driver.
+func isUndefinedTable(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "42P01"
+	}
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "42P01"
+}
+
+// createPartitions creates the monthly partitions of the given parts that
+// do not exist yet. Another collector may create the same partition
+// concurrently; that is not an error as long as the partition exists
+// afterwards.
+func (s *DBStorage) createPartitions(ctx context.Context, parts []partitionBatch) error {
+	for _, part := range parts {
+		if part.month.IsZero() {
+			continue
+		}
+		exists, err := s.tableExists(ctx, part.table)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+
+		stmt := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s PARTITION OF logs FOR VALUES FROM ('%s') TO ('%s')",
+			pq.QuoteIdentifier(part.table),
+			part.month.Format(time.RFC3339),
+			part.month.AddDate(0, 1, 0).Format(time.RFC3339))
+		if _, err := s.db.ExecContext(ctx, stmt); err != nil {
+			if exists, _ := s.tableExists(ctx, part.table); !exists {
+				metrics.PartitionsCreated.WithLabelValues("failed").Inc()
+				return fmt.Errorf("failed to create partition %s: %w", part.table, err)
+			}
+			// Created concurrently by another collector
+			continue
+		}
+		metrics.PartitionsCreated.WithLabelValues("success").Inc()
+		s.logger.Info("Created logs partition",
+			zap.String("partition", part.table),
+			zap.Time("from", part.month))
+	}
+	return nil
+}
+
+// tableExists reports whether the table exists.
+func (s *DBStorage) tableExists(ctx context.Context, table string) (bool, error) {
+	var exists bool
+	if err := s.db.QueryRowContext(ctx, "SELECT to_regclass($1) IS NOT NULL", table).Scan(&exists); err != nil {
+		return false, fmt.Errorf("failed to look up table %s: %w", table, err)
+	}
+	return exists, nil
+}
+
+// checkPartitioned fails unless logs is a partitioned table, so that
+// monthly partitioning is not enabled against a plain logs table that
+// partitions cannot be attached to.
+func (s *DBStorage) checkPartitioned(ctx context.Context) error {
+	var kind string
+	err := s.db.QueryRowContext(ctx, "SELECT relkind::text FROM pg_class WHERE oid = to_regclass('logs')").Scan(&kind)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("failed to inspect the logs table: %w", err)
+	}
+	if kind != "p" {
+		return errors.New("POSTGRES_PARTITIONING=" + config.PostgresPartitioningMonthly +
+			" needs logs to be partitioned by range on timestamp; migrate the existing table first")
+	}
+	return nil
+}
+
+// migrationStatements returns the migrations for the configured
+// partitioning.
+func migrationStatements(partitioning string) []string {
+	if partitioning != config.PostgresPartitioningMonthly {
+		return migrations
+	}
+	return append([]string{partitionedLogsTable}, migrations[1:]...)
+}
+
+// partitionTables lists the tables of parts, for logging.
+func partitionTables(parts []partitionBatch) string {
+	tables := make([]string, len(parts))
+	for i, part := range parts {
+		tables[i] = part.table
+	}
+	return strings.Join(tables, ",")
+}
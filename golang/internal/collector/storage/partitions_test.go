@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"observability_hub/golang/internal/collector/config"
+)
+
+// parseTime parses an RFC 3339 time.
+func parseTime(t *testing.T, value string) time.Time {
+	t.Helper()
+	parsed, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed
+}
+
+func TestPartitionName(t *testing.T) {
+	tests := []struct {
+		time  string
+		table string
+		month string
+	}{
+		{"2024-07-15T12:00:00Z", "logs_2024_07", "2024-07-01T00:00:00Z"},
+		{"2024-07-01T00:00:00Z", "logs_2024_07", "2024-07-01T00:00:00Z"},
+		{"2024-07-31T23:59:59.999999999Z", "logs_2024_07", "2024-07-01T00:00:00Z"},
+		{"2023-12-31T23:59:59Z", "logs_2023_12", "2023-12-01T00:00:00Z"},
+		{"2024-01-01T00:00:00Z", "logs_2024_01", "2024-01-01T00:00:00Z"},
+		// Months are UTC months: late on July 31 west of UTC is August
+		{"2024-07-31T23:30:00-02:00", "logs_2024_08", "2024-08-01T00:00:00Z"},
+		// and early on August 1 east of UTC is still July
+		{"2024-08-01T00:30:00+02:00", "logs_2024_07", "2024-07-01T00:00:00Z"},
+		{"2024-02-29T23:00:00-05:00", "logs_2024_03", "2024-03-01T00:00:00Z"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.time, func(t *testing.T) {
+			ts := parseTime(t, tt.time)
+			if table := partitionName(ts); table != tt.table {
+				t.Errorf("partitionName = %s, want %s", table, tt.table)
+			}
+			month := monthStart(ts)
+			if !month.Equal(parseTime(t, tt.month)) || month.Location() != time.UTC {
+				t.Errorf("monthStart = %s, want %s", month, tt.month)
+			}
+			// The partition's range holds the event
+			if ts.Before(month) || !ts.Before(month.AddDate(0, 1, 0)) {
+				t.Errorf("%s is outside [%s, %s)", ts, month, month.AddDate(0, 1, 0))
+			}
+		})
+	}
+}
+
+func TestSplitByPartition(t *testing.T) {
+	july := &LogEvent{EventID: "july", Timestamp: parseTime(t, "2024-07-10T08:00:00Z")}
+	august := &LogEvent{EventID: "august", Timestamp: parseTime(t, "2024-08-02T08:00:00Z")}
+	lateJuly := &LogEvent{EventID: "late-july", Timestamp: parseTime(t, "2024-08-01T01:00:00+03:00")}
+	september := &LogEvent{EventID: "september", Timestamp: parseTime(t, "2024-08-31T23:30:00-01:00")}
+	batch := []*LogEvent{july, august, lateJuly, september}
+
+	parts := splitByPartition(batch, config.PostgresPartitioningMonthly)
+	want := []struct {
+		table  string
+		month  string
+		events []*LogEvent
+	}{
+		{"logs_2024_07", "2024-07-01T00:00:00Z", []*LogEvent{july, lateJuly}},
+		{"logs_2024_08", "2024-08-01T00:00:00Z", []*LogEvent{august}},
+		{"logs_2024_09", "2024-09-01T00:00:00Z", []*LogEvent{september}},
+	}
+	if len(parts) != len(want) {
+		t.Fatalf("split into %s, want %d partitions", partitionTables(parts), len(want))
+	}
+	for i, part := range parts {
+		if part.table != want[i].table || !part.month.Equal(parseTime(t, want[i].month)) {
+			t.Errorf("part %d = %s from %s, want %s from %s", i, part.table, part.month, want[i].table, want[i].month)
+		}
+		if len(part.events) != len(want[i].events) {
+			t.Errorf("%s holds %d events, want %d", part.table, len(part.events), len(want[i].events))
+			continue
+		}
+		for j, event := range part.events {
+			if event != want[i].events[j] {
+				t.Errorf("%s event %d = %s, want %s", part.table, j, event.EventID, want[i].events[j].EventID)
+			}
+		}
+	}
+	if flat := flattenParts(parts); len(flat) != len(batch) {
+		t.Errorf("flattenParts returned %d events, want %d", len(flat), len(batch))
+	}
+
+	// Without partitioning the batch goes to logs as it is
+	parts = splitByPartition(batch, config.PostgresPartitioningNone)
+	if len(parts) != 1 || parts[0].table != "logs" || !parts[0].month.IsZero() || len(parts[0].events) != len(batch) {
+		t.Errorf("unpartitioned split = %+v, want the batch in logs", parts)
+	}
+}
@@ -0,0 +1,40 @@
+package storage
+
+import "math"
+
+// perfMetricsKey is the structured key holding performance metrics.
+const perfMetricsKey = "metrics"
+
+// perfMetrics returns the performance metrics of a structured payload as
+// COPY values for duration_ms, memory_bytes and cpu_pct. Metrics that are
+// absent, not numeric or out of range become NULL, so the columns can be
+// aggregated without filtering garbage.
+func perfMetrics(structured *JSONB) (duration, memory, cpu interface{}) {
+	if structured == nil {
+		return nil, nil, nil
+	}
+	m, ok := (*structured)[perfMetricsKey].(map[string]interface{})
+	if !ok {
+		return nil, nil, nil
+	}
+
+	if v, ok := metricValue(m, "duration"); ok && v >= 0 {
+		duration = v
+	}
+	if v, ok := metricValue(m, "memoryUsage"); ok && v >= 0 && v <= math.MaxInt64 {
+		memory = int64(v)
+	}
+	if v, ok := metricValue(m, "cpuUsage"); ok && v >= 0 && v <= 100 {
+		cpu = v
+	}
+	return duration, memory, cpu
+}
+
+// metricValue returns a finite numeric metric.
+func metricValue(m map[string]interface{}, key string) (float64, bool) {
+	v, ok := m[key].(float64)
+	if !ok || math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, false
+	}
+	return v, true
+}
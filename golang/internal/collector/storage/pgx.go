@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+// copyFrom writes a batch in one transaction with pgx's CopyFrom, which
+// streams the rows in the binary COPY format instead of one statement
+// execution per row. It borrows the pool's underlying pgx connection, so
+// the storage keeps a single database/sql pool whichever driver is used.
+func (s *DBStorage) copyFrom(ctx context.Context, parts []partitionBatch) error {
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer conn.Close()
+
+	var txid int64
+	var commitErr error
+	err = conn.Raw(func(driverConn any) error {
+		dc, ok := driverConn.(*stdlib.Conn)
+		if !ok {
+			return errors.New("connection is not a pgx connection")
+		}
+		tx, err := dc.Conn().Begin(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to begin transaction: %w", err)
+		}
+		defer tx.Rollback(ctx) // Rollback is a no-op if Commit succeeds.
+
+		// The transaction ID lets a failed COMMIT be checked afterwards
+		if s.cfg.FlushCommitVerify {
+			if err := tx.QueryRow(ctx, "SELECT txid_current()").Scan(&txid); err != nil {
+				return fmt.Errorf("failed to get transaction id: %w", err)
+			}
+		}
+
+		for _, part := range parts {
+			rows := pgx.CopyFromSlice(len(part.events), func(i int) ([]any, error) {
+				return s.copyRow(part.events[i]), nil
+			})
+			if _, err := tx.CopyFrom(ctx, pgx.Identifier{part.table}, s.copyColumns(), rows); err != nil {
+				// The entire COPY operation will be rolled back.
+				return fmt.Errorf("failed to copy from: %w", err)
+			}
+		}
+
+		if s.cfg.OutboxEnabled {
+			batch := flattenParts(parts)
+			outbox := pgx.CopyFromSlice(len(batch), func(i int) ([]any, error) {
+				data, err := json.Marshal(batch[i])
+				if err != nil {
+					return nil, fmt.Errorf("failed to marshal outbox event: %w", err)
+				}
+				return []any{string(data)}, nil
+			})
+			if _, err := tx.CopyFrom(ctx, pgx.Identifier{"logs_outbox"}, []string{"event"}, outbox); err != nil {
+				return fmt.Errorf("failed to copy outbox from: %w", err)
+			}
+		}
+
+		commitErr = tx.Commit(ctx)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if commitErr != nil {
+		// The connection may have failed after the server committed, in
+		// which case retrying the batch would insert it twice.
+		if !s.cfg.FlushCommitVerify || !s.committed(txid, commitErr) {
+			return fmt.Errorf("failed to commit transaction: %w", commitErr)
+		}
+	}
+	return nil
+}
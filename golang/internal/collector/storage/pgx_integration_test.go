@@ -0,0 +1,64 @@
+//go:build integration
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"observability_hub/golang/internal/collector/config"
+)
+
+// benchmarkBatch returns a batch of events shaped like production logs,
+// with context, metadata and structured fields.
+func benchmarkBatch(size int) []*LogEvent {
+	batch := make([]*LogEvent, size)
+	for i := range batch {
+		event := integrationEvent(fmt.Sprintf("service-%d", i%8), time.Now())
+		event.Data.Message = fmt.Sprintf("Order %d placed for customer %d", i, i%97)
+		requestID, operation := event.CorrelationID, "POST /api/orders"
+		event.Data.Context = &LogContext{RequestID: &requestID, Operation: &operation}
+		structured := JSONB{"orderId": float64(i), "items": []interface{}{"sku-1", "sku-2"}, "total": 99.9}
+		event.Data.Structured = &structured
+		event.Metadata.Tags = []string{"orders", "checkout"}
+		batch[i] = event
+	}
+	return batch
+}
+
+// benchmarkCopy measures writing batches of events with the given write
+// path, as flush calls it.
+func benchmarkCopy(b *testing.B, driver string, write func(*DBStorage, context.Context, []partitionBatch) error) {
+	for _, size := range []int{100, 1000, 5000} {
+		b.Run(fmt.Sprintf("batch=%d", size), func(b *testing.B) {
+			cfg := integrationConfig(b)
+			cfg.PostgresDriver = driver
+			s := newIntegrationStorage(b, cfg)
+			parts := splitByPartition(benchmarkBatch(size), config.PostgresPartitioningNone)
+			ctx := context.Background()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := write(s, ctx, parts); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.StopTimer()
+			b.ReportMetric(float64(size*b.N)/b.Elapsed().Seconds(), "rows/s")
+		})
+	}
+}
+
+// BenchmarkCopyIn and BenchmarkCopyFrom compare lib/pq's COPY, one
+// statement execution per row, with pgx's binary CopyFrom:
+//
+//	go test -tags integration -run '^$' -bench 'Copy(In|From)' ./internal/collector/storage/
+func BenchmarkCopyIn(b *testing.B) {
+	benchmarkCopy(b, config.PostgresDriverPQ, (*DBStorage).copyIn)
+}
+
+func BenchmarkCopyFrom(b *testing.B) {
+	benchmarkCopy(b, config.PostgresDriverPGX, (*DBStorage).copyFrom)
+}
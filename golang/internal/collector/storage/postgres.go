@@ -1,13 +1,18 @@
 package storage
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math/rand"
 	"observability_hub/golang/internal/collector/config"
 	"observability_hub/golang/internal/collector/metrics"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"database/sql/driver"
@@ -30,6 +35,16 @@ type LogEvent struct {
 	// Optional fields
 	CausationID *string  `json:"causationId,omitempty"`
 	Tracing     *Tracing `json:"tracing,omitempty"`
+	// ReceivedAt is when the collector received the delivery. It carries a
+	// monotonic clock reading, so durations measured from it are immune to
+	// wall clock jumps.
+	ReceivedAt time.Time `json:"-"`
+	// RawPayload is the original message body when raw payload retention
+	// is enabled.
+	RawPayload *RawPayload `json:"-"`
+	// Redelivered is set when the broker delivered the event before, to
+	// this collector or another one, which may have stored it.
+	Redelivered bool `json:"-"`
 }
 
 type Source struct {
@@ -54,6 +69,15 @@ type Metadata struct {
 	RetryCount  *int           `json:"retryCount,omitempty"`
 	SchemaURL   *string        `json:"schemaUrl,omitempty"`
 	Extra       map[string]any `json:"-"` // For additional properties
+	// Collector is set by the collector only; a producer-supplied
+	// _collector key is discarded on receipt.
+	Collector *CollectorInfo `json:"_collector,omitempty"`
+	// OriginalTimestamp is the producer's timestamp when the collector
+	// clamped a future timestamp to the receive time.
+	OriginalTimestamp *time.Time `json:"originalTimestamp,omitempty"`
+	// PipelineVersion identifies the collector build or pipeline revision
+	// that processed the event. It is stamped when the event is flushed.
+	PipelineVersion string `json:"pipelineVersion,omitempty"`
 }
 
 type LogData struct {
@@ -63,6 +87,24 @@ type LogData struct {
 	Context    *LogContext `json:"context,omitempty"`
 	Structured *JSONB      `json:"structured,omitempty"`
 	Error      *LogError   `json:"error,omitempty"`
+	// Derived by the collector, not part of the incoming schema
+	MessageTemplate string      `json:"messageTemplate,omitempty"`
+	Repeat          *RepeatInfo `json:"repeat,omitempty"`
+	// StructuredRaw holds data.structured undecoded, in place of
+	// Structured, for events decoded in streaming mode
+	StructuredRaw json.RawMessage `json:"-"`
+}
+
+// MarshalJSON writes StructuredRaw, when set, as the structured field.
+func (d LogData) MarshalJSON() ([]byte, error) {
+	type plain LogData
+	if d.StructuredRaw == nil {
+		return json.Marshal(plain(d))
+	}
+	return json.Marshal(struct {
+		plain
+		Structured json.RawMessage `json:"structured"`
+	}{plain(d), d.StructuredRaw})
 }
 
 type LogContext struct {
@@ -105,19 +147,59 @@ func (j *JSONB) Scan(value interface{}) error {
 	return json.Unmarshal(bytes, j)
 }
 
+// Connection pool limits of the DBStorage.
+const (
+	maxOpenConns = 25
+	maxIdleConns = 25
+)
+
+// Checking the outcome of a failed COMMIT.
+const (
+	commitVerifyAttempts = 3
+	commitVerifyInterval = 500 * time.Millisecond
+	commitVerifyTimeout  = 5 * time.Second
+)
+
+// deadlockBackoff is the wait, jittered, before retrying a flush aborted
+// by a deadlock or serialization conflict. Such conflicts are over once
+// the other transaction finished, so the retry does not wait out the
+// regular backoff.
+const deadlockBackoff = 20 * time.Millisecond
+
 // DBStorage handles database operations.
 type DBStorage struct {
-	db          *sql.DB
-	cfg         *config.Config
-	redis       *RedisClient
-	buffer      chan *LogEvent
-	wg          sync.WaitGroup
-	mu          sync.Mutex
-	ticker      *time.Ticker
-	ctx         context.Context
-	cancel      context.CancelFunc
-	logger      *zap.Logger
-	metadataMap sync.Map // In-memory cache for frequently accessed metadata
+	db             *sql.DB
+	cfg            *config.Config
+	redis          *RedisClient
+	buffer         atomic.Pointer[eventBuffer]
+	tuning         tuning        // Settings Reconfigure changes, guarded by mu
+	reconfigured   chan struct{} // Tells the batch processor to apply the tuning
+	depth          atomic.Int64
+	late           []*LogEvent // Completed repeats that arrived after shutdown began
+	wg             sync.WaitGroup
+	mu             sync.Mutex
+	closing        sync.RWMutex // Held for reading by AddBatch calls in progress
+	closed         bool         // Set by Close, guarded by closing
+	ticker         *time.Ticker
+	ctx            context.Context
+	cancel         context.CancelFunc
+	logger         *zap.Logger
+	ids            IDGenerator
+	templater      *MessageTemplater
+	repeats        *repeatSuppressor
+	seen           *seenFilter // nil without the Bloom filter pre-check
+	cacheStats     *cacheStats // Metadata cache lookups, read by the batch optimizer
+	poolRestore    *time.Timer // Pending pool restore after a connection-limit error
+	shard          string      // Shard name for per-shard metrics, empty when not sharded
+	serverVersion  string      // Reported by the server at startup, empty if unknown
+	enqueueTimeout time.Duration
+	shed           chan struct{}  // Requests an early flush of the current batch
+	flushSlots     chan struct{}  // Bounds the flushes running at once
+	flushes        sync.WaitGroup // Flushes running in the background
+	heartbeat      atomic.Int64
+	lastFlush      atomic.Int64 // Unix nanoseconds of the last successful flush, 0 if none yet
+	pendingSince   atomic.Int64 // Unix nanoseconds since events await a successful flush, 0 if none
+	metadataMap    sync.Map     // In-memory cache for frequently accessed metadata
 }
 
 // NewDBStorage creates a new DBStorage instance without Redis.
@@ -127,78 +209,426 @@ func NewDBStorage(ctx context.Context, cfg *config.Config, logger *zap.Logger) (
 
 // NewDBStorageWithRedis creates a new DBStorage instance with Redis support.
 func NewDBStorageWithRedis(ctx context.Context, cfg *config.Config, logger *zap.Logger, redis *RedisClient) (*DBStorage, error) {
-	db, err := sql.Open("postgres", cfg.PostgresURL)
+	// The pgx driver is registered by the stdlib import in pgx.go
+	driverName := "postgres"
+	if cfg.PostgresDriver == config.PostgresDriverPGX {
+		driverName = "pgx"
+	}
+	db, err := sql.Open(driverName, cfg.PostgresURL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to postgres: %w", err)
 	}
 
-	if err = db.Ping(); err != nil {
+	if err = db.PingContext(ctx); err != nil {
+		db.Close()
 		return nil, fmt.Errorf("failed to ping postgres: %w", err)
 	}
 
-	db.SetMaxOpenConns(25)
-	db.SetMaxIdleConns(25)
+	// The version only labels metrics, so failing to read it is not fatal
+	var serverVersion string
+	if err := db.QueryRowContext(ctx, "SHOW server_version").Scan(&serverVersion); err != nil {
+		logger.Warn("Failed to get Postgres server version", zap.Error(err))
+	}
+
+	db.SetMaxOpenConns(maxOpenConns)
+	db.SetMaxIdleConns(maxIdleConns)
 	db.SetConnMaxLifetime(5 * time.Minute)
 
+	var templater *MessageTemplater
+	if cfg.MessageTemplateEnabled {
+		if templater, err = NewMessageTemplater(cfg.MessageTemplateRules); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
 	childCtx, cancel := context.WithCancel(ctx)
 
 	storage := &DBStorage{
-		db:     db,
-		cfg:    cfg,
-		redis:  redis,
-		buffer: make(chan *LogEvent, cfg.BatchSize*2),
-		ticker: time.NewTicker(cfg.BatchTimeout),
-		ctx:    childCtx,
-		cancel: cancel,
-		logger: logger.Named("storage"),
+		db:             db,
+		cfg:            cfg,
+		redis:          redis,
+		tuning:         newTuning(cfg),
+		reconfigured:   make(chan struct{}, 1),
+		ticker:         time.NewTicker(cfg.BatchTimeout),
+		ctx:            childCtx,
+		cancel:         cancel,
+		logger:         logger.Named("storage"),
+		ids:            UUIDGenerator{},
+		flushSlots:     make(chan struct{}, max(1, cfg.PostgresFlushConcurrency)),
+		shed:           make(chan struct{}, 1),
+		cacheStats:     newCacheStats(),
+		enqueueTimeout: cfg.BufferEnqueueTimeout,
+		serverVersion:  serverVersion,
+	}
+	storage.buffer.Store(&eventBuffer{ch: make(chan []*LogEvent, bufferCapacity(cfg.BatchSize, cfg.WorkerBatchSize))})
+	storage.templater = templater
+	if cfg.RepeatSuppressionEnabled {
+		storage.repeats = newRepeatSuppressor(cfg.RepeatSuppressionWindow, cfg.RepeatSuppressionKey)
+	}
+	if cfg.DedupBloomEnabled {
+		storage.seen = newSeenFilter(cfg.DedupBloomSize, cfg.DedupBloomFPRate, cfg.DedupBloomWindow, time.Now())
+	}
+
+	if cfg.PostgresAutoMigrate {
+		if err := storage.migrate(ctx); err != nil {
+			cancel()
+			storage.ticker.Stop()
+			db.Close()
+			return nil, fmt.Errorf("failed to migrate database schema: %w", err)
+		}
+	}
+	if cfg.PostgresPartitioning == config.PostgresPartitioningMonthly {
+		if err := storage.checkPartitioned(ctx); err != nil {
+			cancel()
+			storage.ticker.Stop()
+			db.Close()
+			return nil, err
+		}
+	}
+	if cfg.PostgresDedupOnInsert {
+		if err := storage.checkUniqueEventID(ctx); err != nil {
+			cancel()
+			storage.ticker.Stop()
+			db.Close()
+			return nil, err
+		}
 	}
 
+	storage.heartbeat.Store(time.Now().UnixNano())
 	storage.wg.Add(1)
 	go storage.batchProcessor()
+	go storage.watchBatchProcessor()
+	if cfg.RetentionEnabled {
+		storage.wg.Add(1)
+		go storage.sweepExpired()
+	}
 
 	return storage, nil
 }
 
-// AddToBatch adds a log event to the processing buffer.
-func (s *DBStorage) AddToBatch(event *LogEvent) {
+// SetIDGenerator replaces the generator used for synthetic IDs.
+func (s *DBStorage) SetIDGenerator(ids IDGenerator) {
+	s.ids = ids
+}
+
+// Shed releases memory under memory pressure: the batch being collected is
+// flushed early and the in-memory metadata cache is dropped, to be refilled
+// from Redis.
+func (s *DBStorage) Shed() {
+	select {
+	case s.shed <- struct{}{}:
+	default:
+		// An early flush is already pending
+	}
+	s.metadataMap.Range(func(key, _ any) bool {
+		s.metadataMap.Delete(key)
+		return true
+	})
+}
+
+// ServerVersion returns the Postgres version reported at startup, empty if
+// it could not be read.
+func (s *DBStorage) ServerVersion() string {
+	return s.serverVersion
+}
+
+// BufferDepth returns the number of events waiting to be batched.
+func (s *DBStorage) BufferDepth() int {
+	return int(s.depth.Load())
+}
+
+// BufferCapacity returns roughly how many events the buffer holds when full.
+func (s *DBStorage) BufferCapacity() int {
+	return cap(s.buffer.Load().ch) * s.cfg.WorkerBatchSize
+}
+
+// assignSyntheticIDs gives events without identifiers generated ones. They
+// would otherwise all share one deduplication key, so this must happen
+// before anything else looks at the IDs.
+func assignSyntheticIDs(ids IDGenerator, event *LogEvent) {
+	if event.EventID == "" {
+		event.EventID = ids.NewID()
+		metrics.SyntheticIDs.WithLabelValues("event_id").Inc()
+		event.Metadata.Collector.Note("synthetic_event_id")
+	}
+	if event.CorrelationID == "" {
+		event.CorrelationID = ids.NewID()
+		metrics.SyntheticIDs.WithLabelValues("correlation_id").Inc()
+		event.Metadata.Collector.Note("synthetic_correlation_id")
+	}
+}
+
+// AddToBatch adds a log event to the processing buffer. See AddBatch.
+func (s *DBStorage) AddToBatch(event *LogEvent) error {
+	return s.AddBatch([]*LogEvent{event})
+}
+
+// AddBatch adds several log events to the processing buffer in one channel
+// operation, with one pipelined Redis round-trip per step of the duplicate
+// check. Events that could not be enqueued, because the buffer stayed full
+// for the enqueue timeout or the storage is shutting down, are listed in a
+// *RejectedError; they were not stored and should be redelivered.
+func (s *DBStorage) AddBatch(events []*LogEvent) error {
+	// Close waits for the calls in progress before it drains the buffer
+	s.closing.RLock()
+	defer s.closing.RUnlock()
+	if s.closed {
+		return &RejectedError{Events: events, Err: ErrStorageClosed}
+	}
+
+	for _, event := range events {
+		assignSyntheticIDs(s.ids, event)
+		if s.templater != nil {
+			event.Data.MessageTemplate = s.templater.Template(event.Data.Message)
+			event.Metadata.Collector.Note("templated")
+		}
+	}
+
 	// Check for deduplication if Redis is available
 	if s.redis != nil {
-		isDuplicate, err := s.redis.CheckDuplication(event)
-		if err != nil {
-			s.logger.Warn("Failed to check duplication, proceeding with event",
-				zap.Error(err),
-				zap.String("event_id", event.EventID))
-		} else if isDuplicate {
+		events = s.dropDuplicates(events)
+	}
+
+	var completed []*LogEvent
+	fresh := make([]*LogEvent, 0, len(events))
+	for _, event := range events {
+		event.Metadata.Collector.finish()
+		if s.repeats == nil {
+			fresh = append(fresh, event)
+			continue
+		}
+		// The event is held until its run of repeats ends
+		if done := s.repeats.add(event, time.Now()); done != nil {
+			completed = append(completed, done)
+		}
+	}
+
+	// Completed runs were acknowledged long ago and must not be rejected.
+	if len(completed) > 0 {
+		s.depth.Add(int64(len(completed)))
+		buffer := s.sendBuffer()
+		select {
+		case buffer.ch <- completed:
+		case <-s.ctx.Done():
+			// The batch processor is gone; Close flushes them.
+			s.depth.Add(-int64(len(completed)))
+			s.mu.Lock()
+			s.late = append(s.late, completed...)
+			s.mu.Unlock()
+		}
+		buffer.mu.RUnlock()
+	}
+	if len(fresh) == 0 {
+		return nil
+	}
+	return s.enqueue(fresh)
+}
+
+// dropDuplicates returns the events that were not processed before and
+// marks them as processed. If Redis fails the events are kept.
+func (s *DBStorage) dropDuplicates(events []*LogEvent) []*LogEvent {
+	if s.cfg.DedupRegion != "" {
+		return s.claimEvents(events)
+	}
+	duplicates, err := s.checkDuplicates(events)
+	if err != nil {
+		s.logger.Warn("Failed to check duplication, proceeding with events",
+			zap.Error(err),
+			zap.Int("events", len(events)))
+		duplicates = make([]bool, len(events))
+	}
+
+	fresh := events[:0:0]
+	for i, event := range events {
+		if duplicates[i] {
 			s.logger.Debug("Duplicate event detected, skipping",
 				zap.String("event_id", event.EventID),
 				zap.String("service", event.Source.Service))
 			metrics.MessagesSkipped.Inc()
-			return
+			continue
 		}
+		event.Metadata.Collector.Note("dedup_checked")
+		fresh = append(fresh, event)
+	}
 
-		// Mark as processed immediately to prevent race conditions
-		if err := s.redis.MarkAsProcessed(event); err != nil {
-			s.logger.Warn("Failed to mark event as processed",
-				zap.Error(err),
-				zap.String("event_id", event.EventID))
+	// Mark as processed immediately to prevent race conditions
+	if err := s.redis.MarkAsProcessedMulti(fresh); err != nil {
+		s.logger.Warn("Failed to mark events as processed",
+			zap.Error(err),
+			zap.Int("events", len(fresh)))
+	}
+	if s.seen != nil {
+		ids := make([]string, len(fresh))
+		for i, event := range fresh {
+			ids[i] = event.EventID
+		}
+		s.seen.add(ids, time.Now())
+	}
+	return fresh
+}
+
+// checkDuplicates reports which events were processed before. With the
+// Bloom filter pre-check only the events the filter may have seen are
+// looked up in Redis; the others are certainly new. Redis stays
+// authoritative for every possible duplicate.
+func (s *DBStorage) checkDuplicates(events []*LogEvent) ([]bool, error) {
+	if s.seen == nil {
+		return s.redis.CheckDuplicationMulti(events)
+	}
+
+	unseen := s.certainlyNew(events)
+	candidates := make([]*LogEvent, 0, len(events))
+	for i, event := range events {
+		if !unseen[i] {
+			candidates = append(candidates, event)
+		}
+	}
+	metrics.DedupBloomChecks.WithLabelValues("skipped").Add(float64(len(events) - len(candidates)))
+	metrics.DedupBloomChecks.WithLabelValues("checked").Add(float64(len(candidates)))
+
+	duplicates := make([]bool, len(events))
+	if len(candidates) == 0 {
+		return duplicates, nil
+	}
+	found, err := s.redis.CheckDuplicationMulti(candidates)
+	if err != nil {
+		return nil, err
+	}
+	j := 0
+	for i := range events {
+		if !unseen[i] {
+			duplicates[i] = found[j]
+			j++
+		}
+	}
+	return duplicates, nil
+}
+
+// certainlyNew reports for each event whether the Bloom filter shows it
+// was never processed. The filter knows only the IDs this collector marked,
+// so a redelivery, which another collector may have processed before it
+// crashed, is never reported new.
+func (s *DBStorage) certainlyNew(events []*LogEvent) []bool {
+	ids := make([]string, len(events))
+	for i, event := range events {
+		ids[i] = event.EventID
+	}
+	unseen := s.seen.unseen(ids, time.Now())
+	for i, event := range events {
+		unseen[i] = unseen[i] && !event.Redelivered
+	}
+	return unseen
+}
+
+// claimEvents returns the events this region claimed, dropping those
+// another collector claimed first. If Redis fails the events are kept.
+func (s *DBStorage) claimEvents(events []*LogEvent) []*LogEvent {
+	claimedBy, err := s.redis.ClaimMulti(events)
+	if err != nil {
+		s.logger.Warn("Failed to claim events, proceeding with events",
+			zap.Error(err),
+			zap.Int("events", len(events)))
+		return events
+	}
+
+	fresh := events[:0:0]
+	for i, event := range events {
+		if claimedBy[i] != "" {
+			s.logger.Debug("Duplicate event detected, skipping",
+				zap.String("event_id", event.EventID),
+				zap.String("service", event.Source.Service),
+				zap.String("claimed_by", claimedBy[i]))
+			metrics.MessagesSkipped.Inc()
+			metrics.DedupClaimedBy.WithLabelValues(claimedBy[i]).Inc()
+			continue
 		}
+		event.Metadata.Collector.Note("dedup_checked")
+		fresh = append(fresh, event)
+	}
+	return fresh
+}
+
+// enqueue hands events to the batch processor. It waits for room in the
+// buffer until the enqueue timeout, if one is set, or until the storage is
+// shutting down.
+func (s *DBStorage) enqueue(events []*LogEvent) error {
+	s.depth.Add(int64(len(events)))
+	s.pendingSince.CompareAndSwap(0, time.Now().UnixNano())
+	buffer := s.sendBuffer()
+	defer buffer.mu.RUnlock()
+	select {
+	case buffer.ch <- events:
+		return nil
+	default:
 	}
 
-	s.buffer <- event
+	var timeout <-chan time.Time
+	if s.enqueueTimeout > 0 {
+		timer := time.NewTimer(s.enqueueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case buffer.ch <- events:
+		return nil
+	case <-timeout:
+		metrics.BufferFull.Add(float64(len(events)))
+		return s.reject(events, ErrBufferFull)
+	case <-s.ctx.Done():
+		return s.reject(events, ErrStorageClosed)
+	}
+}
+
+// reject gives back events that could not be enqueued. They are unmarked
+// as processed so that their redeliveries pass the duplicate check.
+func (s *DBStorage) reject(events []*LogEvent, cause error) error {
+	s.depth.Add(-int64(len(events)))
+	if s.redis != nil {
+		for _, event := range events {
+			if err := s.redis.UnmarkProcessed(event); err != nil {
+				s.logger.Warn("Failed to unmark rejected event",
+					zap.Error(err),
+					zap.String("event_id", event.EventID))
+			}
+		}
+	}
+	return &RejectedError{Events: events, Err: cause}
 }
 
 func (s *DBStorage) batchProcessor() {
 	defer s.wg.Done()
-	batch := make([]*LogEvent, 0, s.cfg.BatchSize)
-	batchOptimizer := s.createBatchOptimizer()
+	batchSize := s.currentTuning().batchSize
+	batch := make([]*LogEvent, 0, batchSize)
+	batchOptimizer := s.createBatchOptimizer(batchSize)
+	correlations := newCorrelationGroups()
+	buffer := s.buffer.Load()
 
 	for {
+		// The ticker wakes the loop at least every batch timeout, so an old
+		// heartbeat means the processor is stuck or gone.
+		s.heartbeat.Store(time.Now().UnixNano())
+		if len(batch) > 0 {
+			s.pendingSince.CompareAndSwap(0, time.Now().UnixNano())
+		}
 		select {
 		case <-s.ctx.Done():
+			if s.repeats != nil {
+				batch = append(batch, s.repeats.drain()...)
+			}
 			s.logger.Info("Batch processor shutting down. Flushing remaining logs...", zap.Int("batch_size", len(batch)))
+			if len(batch) > 0 {
+				metrics.BatchFlushes.WithLabelValues("shutdown").Inc()
+			}
 			s.flushWithRetry(batch)
 			return
 		case <-s.ticker.C:
+			if s.repeats != nil {
+				for _, event := range s.repeats.expired(time.Now()) {
+					batch = append(batch, event)
+					correlations.add(event.CorrelationID)
+				}
+			}
 			if len(batch) > 0 {
 				optimizedSize := batchOptimizer.getOptimalBatchSize(batch)
 				s.logger.Info("Batch timeout reached. Flushing logs.",
@@ -208,12 +638,59 @@ func (s *DBStorage) batchProcessor() {
 				// Record metrics
 				metrics.BatchSizeOptimized.Observe(float64(len(batch)))
 				metrics.CacheHitRatio.Set(batchOptimizer.cacheHitRatio)
+				metrics.BatchFlushes.WithLabelValues("timeout").Inc()
+
+				s.dispatch(batch)
+				batch = make([]*LogEvent, 0, batchSize)
+				correlations.reset()
+			} else {
+				// Nothing arrived: refresh the gauges alerts rely on, but
+				// observe no batch, which would drag the size histogram to 0
+				metrics.BatchIdle.Set(1)
+				metrics.CacheHitRatio.Set(batchOptimizer.cacheHitRatio)
+			}
+		case <-s.shed:
+			if len(batch) > 0 {
+				s.logger.Info("Flushing early to release memory", zap.Int("batch_size", len(batch)))
+				metrics.BatchSizeOptimized.Observe(float64(len(batch)))
+				metrics.BatchFlushes.WithLabelValues("shed").Inc()
 
-				s.flushWithRetry(batch)
-				batch = make([]*LogEvent, 0, s.cfg.BatchSize)
+				s.dispatch(batch)
+				batch = make([]*LogEvent, 0, batchSize)
+				correlations.reset()
+			}
+		case <-s.reconfigured:
+			t := s.currentTuning()
+			batchSize = t.batchSize
+			batchOptimizer.baseBatchSize, batchOptimizer.maxBatchSize = batchSize, batchSize*2
+			s.ticker.Reset(t.batchTimeout)
+			for _, event := range s.swapBuffer(bufferCapacity(batchSize, s.cfg.WorkerBatchSize)) {
+				batch = append(batch, event)
+				correlations.add(event.CorrelationID)
+			}
+			buffer = s.buffer.Load()
+			s.logger.Info("Batch settings reconfigured",
+				zap.Int("batch_size", batchSize),
+				zap.Duration("batch_timeout", t.batchTimeout),
+				zap.Int("buffer_capacity", cap(buffer.ch)))
+
+			// A smaller batch size may already be reached
+			if targetBatchSize := batchOptimizer.getOptimalBatchSize(batch); len(batch) >= targetBatchSize {
+				metrics.BatchSizeOptimized.Observe(float64(len(batch)))
+				metrics.BatchFlushes.WithLabelValues("size").Inc()
+
+				s.dispatch(batch)
+				batch = make([]*LogEvent, 0, batchSize)
+				correlations.reset()
+			}
+			metrics.BatchDistinctCorrelations.Set(float64(correlations.len()))
+		case events := <-buffer.ch:
+			s.depth.Add(-int64(len(events)))
+			metrics.BatchIdle.Set(0)
+			for _, event := range events {
+				batch = append(batch, event)
+				correlations.add(event.CorrelationID)
 			}
-		case event := <-s.buffer:
-			batch = append(batch, event)
 
 			// Use dynamic batch sizing based on Redis cache effectiveness
 			targetBatchSize := batchOptimizer.getOptimalBatchSize(batch)
@@ -225,14 +702,117 @@ func (s *DBStorage) batchProcessor() {
 				// Record metrics
 				metrics.BatchSizeOptimized.Observe(float64(len(batch)))
 				metrics.CacheHitRatio.Set(batchOptimizer.cacheHitRatio)
+				metrics.BatchFlushes.WithLabelValues("size").Inc()
+
+				s.dispatch(batch)
+				batch = make([]*LogEvent, 0, batchSize)
+				correlations.reset()
+			} else if s.cfg.BatchMaxCorrelations > 0 && correlations.len() >= s.cfg.BatchMaxCorrelations {
+				// Bound the grouping state by flushing the oldest half of the
+				// correlation groups; newer groups keep accumulating.
+				groups := (correlations.len() + 1) / 2
+				oldest, rest := correlations.splitOldest(batch, groups)
+				s.logger.Info("Distinct correlation limit reached. Flushing oldest groups.",
+					zap.Int("groups", groups),
+					zap.Int("batch_size", len(oldest)),
+					zap.Int("remaining", len(rest)))
+
+				metrics.BatchSizeOptimized.Observe(float64(len(oldest)))
+				metrics.BatchFlushes.WithLabelValues("max_correlations").Inc()
+
+				s.dispatch(oldest)
+				batch = rest
+			}
+			metrics.BatchDistinctCorrelations.Set(float64(correlations.len()))
+		}
+	}
+}
+
+// BatchProcessorHealth reports an error when the batch processor has not
+// completed a loop iteration within the configured stall threshold.
+func (s *DBStorage) BatchProcessorHealth() error {
+	if age := time.Since(time.Unix(0, s.heartbeat.Load())); age > s.cfg.BatchStallThreshold {
+		return fmt.Errorf("batch processor stalled for %s", age.Round(time.Second))
+	}
+	return nil
+}
+
+// LastFlush returns when a batch was last flushed successfully, or the zero
+// time if none was yet.
+func (s *DBStorage) LastFlush() time.Time {
+	if ns := s.lastFlush.Load(); ns != 0 {
+		return time.Unix(0, ns)
+	}
+	return time.Time{}
+}
+
+// FlushHealth reports an error when events have been waiting for a
+// successful flush for longer than the flush staleness window: the
+// collector is consuming but not persisting. Idle periods without events
+// are healthy however long ago the last flush was.
+func (s *DBStorage) FlushHealth() error {
+	if s.cfg.FlushStalenessWindow <= 0 {
+		return nil
+	}
+	since := s.pendingSince.Load()
+	if since == 0 {
+		return nil
+	}
+	if age := time.Since(time.Unix(0, since)); age > s.cfg.FlushStalenessWindow {
+		return fmt.Errorf("no successful flush for %s with events pending", age.Round(time.Second))
+	}
+	return nil
+}
 
-				s.flushWithRetry(batch)
-				batch = make([]*LogEvent, 0, s.cfg.BatchSize)
+// watchBatchProcessor publishes the heartbeat age and logs when the batch
+// processor stalls or recovers. Workers keep filling the buffer while the
+// processor is stuck, so this is the earliest sign of stopped ingestion.
+func (s *DBStorage) watchBatchProcessor() {
+	ticker := time.NewTicker(s.cfg.BatchStallThreshold / 2)
+	defer ticker.Stop()
+
+	stalled := false
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			age := time.Since(time.Unix(0, s.heartbeat.Load()))
+			metrics.BatchProcessorHeartbeatAge.Set(age.Seconds())
+
+			switch {
+			case age > s.cfg.BatchStallThreshold && !stalled:
+				stalled = true
+				metrics.BatchProcessorStalls.Inc()
+				s.logger.Error("Batch processor stalled",
+					zap.Duration("since_heartbeat", age),
+					zap.Int("buffer_depth", s.BufferDepth()))
+			case age <= s.cfg.BatchStallThreshold && stalled:
+				stalled = false
+				s.logger.Info("Batch processor recovered", zap.Duration("since_heartbeat", age))
 			}
 		}
 	}
 }
 
+// dispatch flushes a batch. With concurrent flushes configured it runs in
+// the background once a flush slot is free, so that the batch processor
+// keeps accumulating the next batch meanwhile.
+func (s *DBStorage) dispatch(batch []*LogEvent) {
+	if cap(s.flushSlots) <= 1 {
+		s.flushWithRetry(batch)
+		return
+	}
+
+	s.flushSlots <- struct{}{}
+	s.flushes.Add(1)
+	go func() {
+		defer s.flushes.Done()
+		defer func() { <-s.flushSlots }()
+		s.flushWithRetry(batch)
+	}()
+}
+
 func (s *DBStorage) flushWithRetry(batch []*LogEvent) {
 	if len(batch) == 0 {
 		return
@@ -240,23 +820,44 @@ func (s *DBStorage) flushWithRetry(batch []*LogEvent) {
 
 	timer := time.Now()
 	operation := func() error {
-		return s.flush(batch)
+		ctx := context.Background()
+		if s.cfg.PostgresFlushTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, s.cfg.PostgresFlushTimeout)
+			defer cancel()
+		}
+		return s.flush(ctx, batch)
 	}
 
+	metrics.DestinationWritesInFlight.WithLabelValues(DestinationPostgres).Inc()
 	err := s.retryWithBackoff(operation)
+	metrics.DestinationWritesInFlight.WithLabelValues(DestinationPostgres).Dec()
+	observeDestinationWrite(DestinationPostgres, timer, err)
 	if err != nil {
 		s.logger.Error("Failed to flush batch after multiple retries",
 			zap.Error(err),
 			zap.Int("batch_size", len(batch)),
 		)
 		metrics.DBFlushErrors.Inc()
+		if s.shard != "" {
+			metrics.ShardFlushes.WithLabelValues(s.shard, "error").Inc()
+		}
 	} else {
+		s.lastFlush.Store(time.Now().UnixNano())
+		s.pendingSince.Store(0)
+		if s.BufferDepth() > 0 {
+			s.pendingSince.CompareAndSwap(0, time.Now().UnixNano())
+		}
 		metrics.DBFlushSuccess.Inc()
-		metrics.DBFlushDuration.Observe(time.Since(timer).Seconds())
+		metrics.ObserveWithTraceID(metrics.DBFlushDuration, time.Since(timer).Seconds(), batchTraceID(batch))
+		if s.shard != "" {
+			metrics.ShardFlushes.WithLabelValues(s.shard, "success").Inc()
+			metrics.ShardFlushDuration.WithLabelValues(s.shard).Observe(time.Since(timer).Seconds())
+		}
 	}
 }
 
-func (s *DBStorage) flush(batch []*LogEvent) error {
+func (s *DBStorage) flush(ctx context.Context, batch []*LogEvent) error {
 	if len(batch) == 0 {
 		return nil
 	}
@@ -268,105 +869,377 @@ func (s *DBStorage) flush(batch []*LogEvent) error {
 	}()
 
 	// Process metadata caching before database operations
+	if s.redis != nil && s.cfg.MetadataCachePolicy != config.MetadataCacheDisabled {
+		if err := s.processMetadataCache(batch); err != nil {
+			if s.cfg.MetadataCachePolicy == config.MetadataCacheRequired {
+				metrics.MetadataCacheFailures.WithLabelValues("failed").Inc()
+				return fmt.Errorf("failed to cache metadata: %w", err)
+			}
+			metrics.MetadataCacheFailures.WithLabelValues("degraded").Inc()
+		}
+	}
+
+	orderBatch(batch, s.cfg.FlushOrder)
+	for _, event := range batch {
+		stampPipelineVersion(event, s.cfg.PipelineVersion)
+	}
+	write := s.copyIn
+	if s.cfg.PostgresDedupOnInsert {
+		write = s.insertIn
+	} else if s.cfg.PostgresDriver == config.PostgresDriverPGX {
+		write = s.copyFrom
+	}
+	parts := splitByPartition(batch, s.cfg.PostgresPartitioning)
+	err := write(ctx, parts)
+	if err != nil && s.cfg.PostgresPartitioning == config.PostgresPartitioningMonthly && isUndefinedTable(err) {
+		// A month without a partition yet; the whole transaction was
+		// rolled back, so create the missing ones and write it again
+		s.logger.Info("Creating missing logs partitions", zap.String("partitions", partitionTables(parts)))
+		if err := s.createPartitions(ctx, parts); err != nil {
+			return err
+		}
+		err = write(ctx, parts)
+	}
+	if err != nil {
+		return err
+	}
+	if s.cfg.GoldenSampleRate > 0 {
+		s.sampleGolden(ctx, batch)
+	}
+
+	// Update batch counters
 	if s.redis != nil {
-		s.processMetadataCache(batch)
+		serviceCounters := make(map[string]int)
+		for _, event := range batch {
+			serviceCounters[event.Source.Service]++
+		}
+
+		for service, count := range serviceCounters {
+			for i := 0; i < count; i++ {
+				s.redis.IncrementBatchCounter(service)
+			}
+		}
 	}
 
-	txn, err := s.db.Begin()
+	s.observeLag(batch)
+
+	s.logger.Info("Successfully flushed logs to the database.", zap.Int("count", len(batch)))
+	return nil
+}
+
+// copyIn writes a batch in one transaction with lib/pq's COPY FROM STDIN,
+// sending each row as a separate statement execution, one COPY per table.
+func (s *DBStorage) copyIn(ctx context.Context, parts []partitionBatch) error {
+	return s.writeIn(ctx, parts, s.copyInTable)
+}
+
+// writeIn writes a batch in one database/sql transaction, each part with
+// writeTable, followed by the outbox rows.
+func (s *DBStorage) writeIn(ctx context.Context, parts []partitionBatch, writeTable func(context.Context, *sql.Tx, partitionBatch) error) error {
+	txn, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
 	defer txn.Rollback() // Rollback is a no-op if Commit succeeds.
 
-	stmt, err := txn.Prepare(pq.CopyIn("logs",
-		"event_id", "correlation_id", "timestamp", "level", "service", "message", "context", "error", "structured", "metadata",
-	))
+	// The transaction ID lets a failed COMMIT be checked afterwards
+	var txid int64
+	if s.cfg.FlushCommitVerify {
+		if err := txn.QueryRowContext(ctx, "SELECT txid_current()").Scan(&txid); err != nil {
+			return fmt.Errorf("failed to get transaction id: %w", err)
+		}
+	}
+
+	for _, part := range parts {
+		if err := writeTable(ctx, txn, part); err != nil {
+			return err
+		}
+	}
+
+	if s.cfg.OutboxEnabled {
+		if err := writeOutbox(ctx, txn, flattenParts(parts)); err != nil {
+			return err
+		}
+	}
+
+	if err := txn.Commit(); err != nil {
+		// The connection may have failed after the server committed, in
+		// which case retrying the batch would insert it twice.
+		if !s.cfg.FlushCommitVerify || !s.committed(txid, err) {
+			return fmt.Errorf("failed to commit transaction: %w", err)
+		}
+	}
+	return nil
+}
+
+// copyInTable copies the events of part into its table within txn.
+func (s *DBStorage) copyInTable(ctx context.Context, txn *sql.Tx, part partitionBatch) error {
+	stmt, err := txn.PrepareContext(ctx, pq.CopyIn(part.table, s.copyColumns()...))
 	if err != nil {
 		return fmt.Errorf("failed to prepare copy in statement: %w", err)
 	}
 
-	for _, event := range batch {
-		// Use cached metadata if available
-		contextJSON, errorJSON, structuredJSON, metadataJSON := s.prepareEventData(event)
-
-		_, err = stmt.Exec(
-			event.EventID,
-			event.CorrelationID,
-			event.Timestamp,
-			event.Data.Level,
-			event.Source.Service,
-			event.Data.Message,
-			contextJSON,
-			errorJSON,
-			structuredJSON,
-			metadataJSON,
-		)
+	for _, event := range part.events {
+		_, err = stmt.ExecContext(ctx, s.copyRow(event)...)
 		if err != nil {
 			// The entire COPY operation will be rolled back.
 			return fmt.Errorf("failed to exec copy in statement: %w", err)
 		}
 	}
 
-	if _, err := stmt.Exec(); err != nil {
+	if _, err := stmt.ExecContext(ctx); err != nil {
 		return fmt.Errorf("failed to finalize copy in: %w", err)
 	}
 
 	if err := stmt.Close(); err != nil {
 		return fmt.Errorf("failed to close statement: %w", err)
 	}
+	return nil
+}
 
-	if err := txn.Commit(); err != nil {
-		return fmt.Errorf("failed to commit transaction: %w", err)
+// orderBatch sorts a batch by event timestamp for the oldest-first and
+// newest-first flush orders, so that when recovering from a backlog the
+// outbox and the rows follow the chosen order. FIFO batches are left as
+// received without paying for a sort.
+func orderBatch(batch []*LogEvent, order string) {
+	switch order {
+	case config.FlushOrderOldestFirst:
+		sort.SliceStable(batch, func(i, j int) bool {
+			return batch[i].Timestamp.Before(batch[j].Timestamp)
+		})
+	case config.FlushOrderNewestFirst:
+		sort.SliceStable(batch, func(i, j int) bool {
+			return batch[i].Timestamp.After(batch[j].Timestamp)
+		})
 	}
+}
 
-	// Update batch counters
-	if s.redis != nil {
-		serviceCounters := make(map[string]int)
-		for _, event := range batch {
-			serviceCounters[event.Source.Service]++
+// copyColumns returns the logs columns written by flush, in COPY order.
+// Optional columns are only included when the feature filling them is
+// enabled, so deployments that have not migrated keep working.
+func (s *DBStorage) copyColumns() []string {
+	columns := []string{
+		"event_id", "correlation_id", "timestamp", "level", "service", "message", "context", "error", "structured", "metadata",
+	}
+	if s.templater != nil {
+		columns = append(columns, "message_template")
+	}
+	if s.repeats != nil {
+		columns = append(columns, "repeat_count", "repeat_last_timestamp")
+	}
+	if s.cfg.RawPayloadEnabled {
+		columns = append(columns, "raw_payload", "raw_payload_encoding")
+	}
+	if s.cfg.InstanceColumnEnabled {
+		columns = append(columns, "collector_instance")
+	}
+	if s.cfg.MetricsColumnsEnabled {
+		columns = append(columns, "duration_ms", "memory_bytes", "cpu_pct")
+	}
+	if s.cfg.RetentionEnabled {
+		columns = append(columns, "expires_at")
+	}
+	if s.cfg.TracingColumnEnabled {
+		columns = append(columns, "tracing")
+	}
+	return columns
+}
+
+// copyRow returns the values of event matching copyColumns.
+func (s *DBStorage) copyRow(event *LogEvent) []interface{} {
+	// Use cached metadata if available
+	contextJSON, errorJSON, structuredJSON, metadataJSON := s.prepareEventData(event)
+
+	row := []interface{}{
+		event.EventID,
+		event.CorrelationID,
+		event.Timestamp,
+		event.Data.Level,
+		event.Source.Service,
+		event.Data.Message,
+		jsonColumn(contextJSON),
+		jsonColumn(errorJSON),
+		jsonColumn(structuredJSON),
+		jsonColumn(metadataJSON),
+	}
+	if s.templater != nil {
+		row = append(row, event.Data.MessageTemplate)
+	}
+	if s.repeats != nil {
+		if event.Data.Repeat != nil {
+			row = append(row, event.Data.Repeat.Count, event.Data.Repeat.LastTimestamp)
+		} else {
+			row = append(row, nil, nil)
+		}
+	}
+	if s.cfg.RawPayloadEnabled {
+		if event.RawPayload != nil {
+			row = append(row, event.RawPayload.Data, event.RawPayload.Encoding)
+		} else {
+			row = append(row, nil, nil)
+		}
+	}
+	if s.cfg.InstanceColumnEnabled {
+		// Events are flushed by the instance that received them
+		row = append(row, s.cfg.InstanceID)
+	}
+	if s.cfg.MetricsColumnsEnabled {
+		// Taken from the untrimmed payload, so truncation cannot lose them
+		duration, memory, cpu := perfMetrics(event.Data.Structured)
+		row = append(row, duration, memory, cpu)
+	}
+	if s.cfg.RetentionEnabled {
+		row = append(row, expiresAt(s.cfg, event))
+	}
+	if s.cfg.TracingColumnEnabled {
+		tracingJSON, _ := json.Marshal(event.Tracing)
+		row = append(row, jsonColumn(tracingJSON))
+	}
+	return row
+}
+
+// observeLag records the end-to-end lag from production to storage of a
+// flushed batch. Producer and collector clocks are compared only once, at
+// receive time, and the time spent inside the collector is measured on the
+// monotonic clock, so a wall clock jump during batching cannot distort it.
+// Negative lags (producer clock ahead) are counted separately and clamped.
+func (s *DBStorage) observeLag(batch []*LogEvent) {
+	now := time.Now()
+	for _, event := range batch {
+		var lag time.Duration
+		if s.cfg.LagMonotonic && !event.ReceivedAt.IsZero() {
+			lag = event.ReceivedAt.Round(0).Sub(event.Timestamp) + now.Sub(event.ReceivedAt)
+		} else {
+			lag = now.Round(0).Sub(event.Timestamp)
 		}
 
-		for service, count := range serviceCounters {
-			for i := 0; i < count; i++ {
-				s.redis.IncrementBatchCounter(service)
-			}
+		if lag < 0 {
+			metrics.EventNegativeLag.Inc()
+			lag = 0
 		}
+		metrics.EventLag.Observe(lag.Seconds())
 	}
+}
 
-	s.logger.Info("Successfully flushed logs to the database.", zap.Int("count", len(batch)))
-	return nil
+// jsonColumn converts marshaled JSON into a COPY value. A JSON null, which is
+// what an absent optional field marshals to, becomes SQL NULL so that
+// "IS NULL" filters work. Documents are passed as text because pq encodes
+// []byte values as bytea.
+func jsonColumn(data []byte) interface{} {
+	if len(data) == 0 || bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+	return string(data)
 }
 
 func (s *DBStorage) retryWithBackoff(operation func() error) error {
 	var err error
-	backoff := s.cfg.RetryInterval
-	for i := 0; i < s.cfg.RetryMax; i++ {
+	t := s.currentTuning()
+	backoff := t.retryInterval
+	for i := 0; i < t.retryMax; i++ {
 		err = operation()
 		if err == nil {
 			return nil
 		}
+
+		wait := backoff
+		switch ClassifyError(err) {
+		case ErrorClassPermanent:
+			// The same batch fails the same way however often it is
+			// written, so retrying would only hold up the flush
+			s.logger.Warn("Operation failed permanently, not retrying",
+				zap.Int("attempt", i+1),
+				zap.Error(err),
+			)
+			return fmt.Errorf("operation failed permanently: %w", err)
+		case ErrorClassConnectionLimit:
+			// Every instance retrying on the same schedule would keep the
+			// server saturated, so back off longer, with jitter, and give
+			// connections back while the server recovers.
+			metrics.DBConnectionLimitErrors.Inc()
+			wait = jitter(max(backoff, s.cfg.DBConnLimitBackoff))
+			s.shrinkPool()
+		case ErrorClassDeadlock:
+			// Retried almost at once, jittered so that the transactions
+			// that collided do not collide again; the regular backoff
+			// does not grow
+			metrics.DBDeadlocks.Inc()
+			s.logger.Info("Flush aborted by a concurrent transaction, retrying",
+				zap.Int("attempt", i+1),
+				zap.Int("max_attempts", t.retryMax),
+				zap.Error(err),
+			)
+			time.Sleep(jitter(deadlockBackoff))
+			continue
+		}
+
 		s.logger.Warn("Operation failed, retrying...",
 			zap.Int("attempt", i+1),
-			zap.Int("max_attempts", s.cfg.RetryMax),
-			zap.Duration("backoff", backoff),
+			zap.Int("max_attempts", t.retryMax),
+			zap.Duration("backoff", wait),
 			zap.Error(err),
 		)
-		time.Sleep(backoff)
+		time.Sleep(wait)
 		backoff *= 2 // Exponential backoff
 	}
-	return fmt.Errorf("operation failed after %d attempts: %w", s.cfg.RetryMax, err)
+	return fmt.Errorf("operation failed after %d attempts: %w", t.retryMax, err)
+}
+
+// shrinkPool limits the connection pool to a single connection for the
+// configured cooldown after the server ran out of connection slots. Idle
+// connections are closed, returning their slots to other clients.
+func (s *DBStorage) shrinkPool() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.poolRestore != nil {
+		s.poolRestore.Reset(s.cfg.DBConnLimitCooldown)
+		return
+	}
+
+	s.logger.Warn("Postgres connection limit reached, shrinking connection pool",
+		zap.Duration("cooldown", s.cfg.DBConnLimitCooldown))
+	s.db.SetMaxOpenConns(1)
+	s.db.SetMaxIdleConns(0)
+	s.poolRestore = time.AfterFunc(s.cfg.DBConnLimitCooldown, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.db.SetMaxOpenConns(maxOpenConns)
+		s.db.SetMaxIdleConns(maxIdleConns)
+		s.poolRestore = nil
+		s.logger.Info("Restored Postgres connection pool")
+	})
+}
+
+// jitter spreads d uniformly over [d/2, 3d/2).
+func jitter(d time.Duration) time.Duration {
+	return d/2 + time.Duration(rand.Int63n(int64(d)+1))
 }
 
 // Close gracefully shuts down the storage.
 func (s *DBStorage) Close() {
+	// Cancelling first unblocks the AddBatch calls waiting on a full buffer;
+	// once they return, later calls are rejected and nothing sends to the
+	// buffer any more.
 	s.cancel()
+	s.closing.Lock()
+	s.closed = true
+	s.closing.Unlock()
+
 	s.wg.Wait()
-	close(s.buffer)
+	s.flushes.Wait()
+	buffer := s.buffer.Load()
+	buffer.retire()
 
 	// Flush any remaining items in the channel buffer
-	finalBatch := make([]*LogEvent, 0, len(s.buffer))
-	for event := range s.buffer {
-		finalBatch = append(finalBatch, event)
+	finalBatch := make([]*LogEvent, 0, s.BufferDepth())
+	for events := range buffer.ch {
+		s.depth.Add(-int64(len(events)))
+		finalBatch = append(finalBatch, events...)
+	}
+	finalBatch = append(finalBatch, s.late...)
+	if s.repeats != nil {
+		finalBatch = append(finalBatch, s.repeats.drain()...)
 	}
 	s.flushWithRetry(finalBatch)
 
@@ -374,69 +1247,100 @@ func (s *DBStorage) Close() {
 	s.logger.Info("Database connection closed.")
 }
 
-// processMetadataCache handles metadata caching for a batch of events
-func (s *DBStorage) processMetadataCache(batch []*LogEvent) {
-	processed := make(map[string]bool)
+// processMetadataCache handles metadata caching for a batch of events.
+// All lookups share one pipelined round-trip; cache misses are then written
+// concurrently, bounded by the configured concurrency. Redis failures are
+// logged and returned; the metadata cache policy decides whether they fail
+// the flush.
+func (s *DBStorage) processMetadataCache(batch []*LogEvent) error {
+	var keys []MetadataKey
+	events := make(map[MetadataKey]*LogEvent)
 
 	for _, event := range batch {
-		key := fmt.Sprintf("%s:%s:%s",
-			event.Source.Service,
-			event.Source.Version,
-			getEnvironmentFromMetadata(&event.Metadata))
-
-		if processed[key] {
+		key := MetadataKey{
+			Service:     event.Source.Service,
+			Version:     event.Source.Version,
+			Environment: getEnvironmentFromMetadata(&event.Metadata),
+		}
+		if _, ok := events[key]; ok {
 			continue
 		}
-		processed[key] = true
+		events[key] = event
+		keys = append(keys, key)
+	}
 
-		// Check if metadata is already cached
-		cachedMetadata, err := s.redis.GetCachedMetadata(
-			event.Source.Service,
-			event.Source.Version,
-			getEnvironmentFromMetadata(&event.Metadata),
-		)
+	cached, err := s.redis.GetCachedMetadataMulti(keys)
+	if err != nil {
+		metrics.RedisErrors.Inc()
+		s.logger.Warn("Failed to get cached metadata",
+			zap.Error(err),
+			zap.Int("keys", len(keys)))
+		return err
+	}
 
-		if err != nil {
-			metrics.RedisErrors.Inc()
-			s.logger.Warn("Failed to get cached metadata",
-				zap.Error(err),
-				zap.String("service", event.Source.Service))
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	sem := make(chan struct{}, s.cfg.MetadataCacheConcurrency)
+	for i, key := range keys {
+		if cached[i] != nil {
+			// Cache hit - store in local map for faster access
+			metrics.RedisCacheHits.Inc()
+			s.cacheStats.record(key.Service, true)
+			s.metadataMap.Store(metadataMapKey(key), cached[i])
 			continue
 		}
 
-		if cachedMetadata == nil {
-			// Cache miss - create and store metadata
-			metadata := &CachedMetadata{
-				ServiceID:   event.Source.Service,
-				Environment: getEnvironmentFromMetadata(&event.Metadata),
-				Version:     event.Source.Version,
-				Attributes: map[string]interface{}{
-					"region":   event.Source.Region,
-					"instance": event.Source.Instance,
-				},
-				CachedAt: time.Now(),
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(key MetadataKey, event *LogEvent) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if err := s.cacheMetadata(key, event); err != nil {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
 			}
+		}(key, events[key])
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
 
-			if err := s.redis.CacheMetadata(
-				event.Source.Service,
-				event.Source.Version,
-				getEnvironmentFromMetadata(&event.Metadata),
-				metadata,
-			); err != nil {
-				metrics.RedisErrors.Inc()
-				s.logger.Warn("Failed to cache metadata",
-					zap.Error(err),
-					zap.String("service", event.Source.Service))
-			} else {
-				metrics.RedisCacheMisses.Inc()
-				s.metadataMap.Store(key, metadata)
-			}
-		} else {
-			// Cache hit - store in local map for faster access
-			metrics.RedisCacheHits.Inc()
-			s.metadataMap.Store(key, cachedMetadata)
-		}
+// cacheMetadata creates and stores the metadata for a cache miss.
+func (s *DBStorage) cacheMetadata(key MetadataKey, event *LogEvent) error {
+	metadata := &CachedMetadata{
+		ServiceID:   key.Service,
+		Environment: key.Environment,
+		Version:     key.Version,
+		Attributes: map[string]interface{}{
+			"region":   event.Source.Region,
+			"instance": event.Source.Instance,
+		},
+		CachedAt: time.Now(),
+	}
+
+	if err := s.redis.CacheMetadata(key.Service, key.Version, key.Environment, metadata); err != nil {
+		metrics.RedisErrors.Inc()
+		s.logger.Warn("Failed to cache metadata",
+			zap.Error(err),
+			zap.String("service", key.Service))
+		return err
 	}
+
+	metrics.RedisCacheMisses.Inc()
+	s.cacheStats.record(key.Service, false)
+	s.metadataMap.Store(metadataMapKey(key), metadata)
+	return nil
+}
+
+// metadataMapKey returns the key of the in-memory metadata map.
+func metadataMapKey(key MetadataKey) string {
+	return fmt.Sprintf("%s:%s:%s", key.Service, key.Version, key.Environment)
 }
 
 // prepareEventData prepares JSON data for database insertion with optimized metadata handling
@@ -444,7 +1348,23 @@ func (s *DBStorage) prepareEventData(event *LogEvent) ([]byte, []byte, []byte, [
 	// Use cached serialization for frequently accessed data
 	contextJSON, _ := json.Marshal(event.Data.Context)
 	errorJSON, _ := json.Marshal(event.Data.Error)
-	structuredJSON, _ := json.Marshal(event.Data.Structured)
+	structured := event.Data.Structured
+	structuredJSON := []byte(event.Data.StructuredRaw)
+	if structuredJSON == nil {
+		structuredJSON, _ = json.Marshal(structured)
+	}
+	if s.cfg.StructuredMaxBytes > 0 && len(structuredJSON) > s.cfg.StructuredMaxBytes {
+		if structured == nil && event.Data.StructuredRaw != nil {
+			// Kept raw by the streaming decoder; only decoded to be trimmed
+			json.Unmarshal(event.Data.StructuredRaw, &structured)
+		}
+		if structured != nil {
+			if trimmed, dropped := trimStructured(*structured, s.cfg.StructuredMaxBytes, s.cfg.StructuredImportantFields); dropped {
+				metrics.StructuredTruncated.Inc()
+				structuredJSON, _ = json.Marshal(trimmed)
+			}
+		}
+	}
 
 	// Try to use cached metadata JSON if available
 	metadataKey := fmt.Sprintf("%s:%s:%s",
@@ -462,17 +1382,92 @@ func (s *DBStorage) prepareEventData(event *LogEvent) ([]byte, []byte, []byte, [
 				"retry_count":       event.Metadata.RetryCount,
 				"schema_url":        event.Metadata.SchemaURL,
 				"cached_attributes": metadata.Attributes,
+				"pipeline_version":  event.Metadata.PipelineVersion,
+			}
+			if event.Metadata.Collector != nil {
+				optimizedMetadata["_collector"] = event.Metadata.Collector
+			}
+			if event.Metadata.OriginalTimestamp != nil {
+				optimizedMetadata["originalTimestamp"] = event.Metadata.OriginalTimestamp
 			}
 			metadataJSON, _ := json.Marshal(optimizedMetadata)
+			observeColumnBytes(contextJSON, errorJSON, structuredJSON, metadataJSON)
 			return contextJSON, errorJSON, structuredJSON, metadataJSON
 		}
 	}
 
 	// Fallback to normal metadata marshaling
 	metadataJSON, _ := json.Marshal(event.Metadata)
+	observeColumnBytes(contextJSON, errorJSON, structuredJSON, metadataJSON)
 	return contextJSON, errorJSON, structuredJSON, metadataJSON
 }
 
+// observeColumnBytes records the size of the JSONB columns of one event,
+// as already serialized for the COPY.
+func observeColumnBytes(contextJSON, errorJSON, structuredJSON, metadataJSON []byte) {
+	metrics.ColumnBytes.WithLabelValues("context").Observe(float64(len(contextJSON)))
+	metrics.ColumnBytes.WithLabelValues("error").Observe(float64(len(errorJSON)))
+	metrics.ColumnBytes.WithLabelValues("structured").Observe(float64(len(structuredJSON)))
+	metrics.ColumnBytes.WithLabelValues("metadata").Observe(float64(len(metadataJSON)))
+}
+
+// committed reports whether the transaction txid committed although its
+// COMMIT failed with commitErr. It asks the server over another connection
+// and reports false whenever the outcome cannot be established, so that
+// the batch is retried.
+func (s *DBStorage) committed(txid int64, commitErr error) bool {
+	for attempt := 0; attempt < commitVerifyAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(commitVerifyInterval)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), commitVerifyTimeout)
+		var status sql.NullString
+		err := s.db.QueryRowContext(ctx, "SELECT txid_status($1)", txid).Scan(&status)
+		cancel()
+		if err != nil {
+			s.logger.Warn("Failed to check the outcome of a failed commit", zap.Error(err), zap.Int64("txid", txid))
+			continue
+		}
+
+		switch status.String {
+		case "committed":
+			metrics.DBCommitVerifications.WithLabelValues("committed").Inc()
+			s.logger.Warn("Commit reported an error but took effect; not retrying the batch",
+				zap.Error(commitErr), zap.Int64("txid", txid))
+			return true
+		case "aborted":
+			metrics.DBCommitVerifications.WithLabelValues("aborted").Inc()
+			return false
+		}
+		// "in progress": the server has not finished the commit yet
+	}
+	metrics.DBCommitVerifications.WithLabelValues("unknown").Inc()
+	s.logger.Error("Outcome of a failed commit is unknown; retrying may duplicate rows",
+		zap.Error(commitErr), zap.Int64("txid", txid))
+	return false
+}
+
+// stampPipelineVersion records the pipeline version on an event that has
+// none yet. Events re-written from storage, e.g. by a reindex, keep the
+// version that originally processed them.
+func stampPipelineVersion(event *LogEvent, version string) {
+	if event.Metadata.PipelineVersion == "" {
+		event.Metadata.PipelineVersion = version
+	}
+}
+
+// batchTraceID returns the trace ID of the first traced event in the batch,
+// used as an exemplar linking flush metrics to a concrete trace.
+func batchTraceID(batch []*LogEvent) string {
+	for _, event := range batch {
+		if event.Tracing != nil && event.Tracing.TraceID != "" {
+			return event.Tracing.TraceID
+		}
+	}
+	return ""
+}
+
 // getEnvironmentFromMetadata extracts environment from metadata
 func getEnvironmentFromMetadata(metadata *Metadata) string {
 	if metadata.Environment != nil {
@@ -483,11 +1478,13 @@ func getEnvironmentFromMetadata(metadata *Metadata) string {
 
 // BatchOptimizer helps optimize batch sizes based on Redis cache performance
 type BatchOptimizer struct {
-	baseBatchSize     int
-	maxBatchSize      int
-	cacheHitRatio     float64
-	lastOptimization  time.Time
-	serviceCacheStats map[string]*ServiceCacheStats
+	baseBatchSize    int
+	maxBatchSize     int
+	cacheHitRatio    float64
+	lastOptimization time.Time
+	cacheStats       *cacheStats
+	lastHits         int64 // Totals of cacheStats at the last update
+	lastMisses       int64
 }
 
 // ServiceCacheStats tracks cache performance per service
@@ -497,14 +1494,54 @@ type ServiceCacheStats struct {
 	LastUpdated time.Time
 }
 
+// cacheStats counts the metadata cache lookups of the flushes per
+// service. Flushes record them concurrently; the batch optimizer reads
+// the totals.
+type cacheStats struct {
+	mu       sync.Mutex
+	services map[string]*ServiceCacheStats
+}
+
+func newCacheStats() *cacheStats {
+	return &cacheStats{services: make(map[string]*ServiceCacheStats)}
+}
+
+// record counts one lookup of the metadata of service.
+func (c *cacheStats) record(service string, hit bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats, ok := c.services[service]
+	if !ok {
+		stats = &ServiceCacheStats{}
+		c.services[service] = stats
+	}
+	if hit {
+		stats.CacheHits++
+	} else {
+		stats.CacheMisses++
+	}
+	stats.LastUpdated = time.Now()
+}
+
+// totals returns the hits and misses of all services so far.
+func (c *cacheStats) totals() (hits, misses int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, stats := range c.services {
+		hits += stats.CacheHits
+		misses += stats.CacheMisses
+	}
+	return hits, misses
+}
+
 // createBatchOptimizer creates a new batch optimizer
-func (s *DBStorage) createBatchOptimizer() *BatchOptimizer {
+func (s *DBStorage) createBatchOptimizer(batchSize int) *BatchOptimizer {
 	return &BatchOptimizer{
-		baseBatchSize:     s.cfg.BatchSize,
-		maxBatchSize:      s.cfg.BatchSize * 2, // Allow up to 2x base size
-		cacheHitRatio:     0.5,                 // Start with 50% assumption
-		lastOptimization:  time.Now(),
-		serviceCacheStats: make(map[string]*ServiceCacheStats),
+		baseBatchSize:    batchSize,
+		maxBatchSize:     batchSize * 2, // Allow up to 2x base size
+		cacheHitRatio:    0.5,           // Start with 50% assumption
+		lastOptimization: time.Now(),
+		cacheStats:       s.cacheStats,
 	}
 }
 
@@ -512,45 +1549,34 @@ func (s *DBStorage) createBatchOptimizer() *BatchOptimizer {
 func (bo *BatchOptimizer) getOptimalBatchSize(batch []*LogEvent) int {
 	// Update cache statistics if enough time has passed
 	if time.Since(bo.lastOptimization) > 30*time.Second {
-		bo.updateCacheStats(batch)
+		bo.updateCacheStats()
 		bo.lastOptimization = time.Now()
 	}
 
+	// Medium efficiency - use base batch size
+	target := bo.baseBatchSize
 	// If cache hit ratio is high, we can process larger batches more efficiently
 	if bo.cacheHitRatio > 0.7 {
 		// High cache efficiency - use larger batches
-		return int(float64(bo.baseBatchSize) * 1.5)
+		target = int(float64(bo.baseBatchSize) * 1.5)
 	} else if bo.cacheHitRatio < 0.3 {
 		// Low cache efficiency - use smaller batches for faster processing
-		return int(float64(bo.baseBatchSize) * 0.8)
+		target = int(float64(bo.baseBatchSize) * 0.8)
 	}
 
-	// Medium efficiency - use base batch size
-	return bo.baseBatchSize
+	metrics.BatchTargetSize.Set(float64(target))
+	return target
 }
 
-// updateCacheStats updates cache statistics for optimization
-func (bo *BatchOptimizer) updateCacheStats(batch []*LogEvent) {
-	if len(batch) == 0 {
+// updateCacheStats sets the cache hit ratio to that of the metadata
+// cache lookups since the last update. Without lookups in between, e.g.
+// with the metadata cache disabled, the ratio is kept.
+func (bo *BatchOptimizer) updateCacheStats() {
+	hits, misses := bo.cacheStats.totals()
+	newHits, newMisses := hits-bo.lastHits, misses-bo.lastMisses
+	bo.lastHits, bo.lastMisses = hits, misses
+	if newHits+newMisses == 0 {
 		return
 	}
-
-	// Simulate cache effectiveness calculation
-	// In a real implementation, this would track actual Redis performance
-	serviceStats := make(map[string]int)
-	for _, event := range batch {
-		serviceStats[event.Source.Service]++
-	}
-
-	// Update cache hit ratio based on service diversity
-	// More diverse services typically mean lower cache hit ratios
-	diversity := float64(len(serviceStats)) / float64(len(batch))
-
-	// High diversity (many different services) = lower cache hit ratio
-	// Low diversity (few services repeated) = higher cache hit ratio
-	bo.cacheHitRatio = 1.0 - (diversity * 0.7) // Max reduction of 70%
-
-	if bo.cacheHitRatio < 0.1 {
-		bo.cacheHitRatio = 0.1 // Minimum 10% hit ratio
-	}
+	bo.cacheHitRatio = float64(newHits) / float64(newHits+newMisses)
 }
@@ -0,0 +1,318 @@
+//go:build integration
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"observability_hub/golang/internal/collector/config"
+	"observability_hub/golang/internal/integration"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// integrationConfig returns the settings of a storage on a new, migrated
+// database.
+func integrationConfig(t testing.TB) *config.Config {
+	t.Helper()
+	cfg := testConfig()
+	cfg.PostgresURL = integration.Postgres(t)
+	cfg.PostgresAutoMigrate = true
+	cfg.MigrationLockTimeout = 10 * time.Second
+	return cfg
+}
+
+// newIntegrationStorage connects a storage as the collector does and
+// closes it when the test ends. Tests write with flush, bypassing the
+// batch processor, whose batch timeout testConfig sets to an hour.
+func newIntegrationStorage(t testing.TB, cfg *config.Config) *DBStorage {
+	t.Helper()
+	s, err := NewDBStorage(context.Background(), cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("NewDBStorage: %v", err)
+	}
+	t.Cleanup(s.Close)
+	return s
+}
+
+// integrationEvent returns a log event of the service at the given time.
+func integrationEvent(service string, at time.Time) *LogEvent {
+	return &LogEvent{
+		EventID:       uuid.NewString(),
+		EventType:     "log.message.created",
+		Version:       "1.0.0",
+		Timestamp:     at,
+		CorrelationID: uuid.NewString(),
+		Source:        Source{Service: service, Version: "1.2.3"},
+		Data:          LogData{Level: "INFO", Message: "Order placed", Timestamp: at},
+		Metadata:      Metadata{Priority: "normal"},
+	}
+}
+
+// countRows returns the result of a SELECT count(*) query.
+func countRows(t testing.TB, s *DBStorage, query string, args ...any) int {
+	t.Helper()
+	var n int
+	if err := s.db.QueryRow(query, args...).Scan(&n); err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	return n
+}
+
+// writePaths are the ways flush writes a batch, by the settings selecting
+// them.
+var writePaths = []struct {
+	name string
+	set  func(cfg *config.Config)
+}{
+	{"pq COPY", func(cfg *config.Config) { cfg.PostgresDriver = config.PostgresDriverPQ }},
+	{"pgx COPY", func(cfg *config.Config) { cfg.PostgresDriver = config.PostgresDriverPGX }},
+	{"deduplicating INSERT", func(cfg *config.Config) { cfg.PostgresDedupOnInsert = true }},
+}
+
+func TestFlushStoresAbsentOptionalFieldsAsNull(t *testing.T) {
+	for _, path := range writePaths {
+		t.Run(path.name, func(t *testing.T) {
+			cfg := integrationConfig(t)
+			path.set(cfg)
+			s := newIntegrationStorage(t, cfg)
+
+			bare := integrationEvent("checkout", time.Now())
+			failed := integrationEvent("checkout", time.Now())
+			code := "ECONNRESET"
+			failed.Data.Error = &LogError{Code: &code}
+			if err := s.flush(context.Background(), []*LogEvent{bare, failed}); err != nil {
+				t.Fatalf("flush: %v", err)
+			}
+
+			if n := countRows(t, s, "SELECT count(*) FROM logs WHERE error IS NULL AND context IS NULL AND structured IS NULL AND event_id = $1", bare.EventID); n != 1 {
+				t.Errorf("the event without optional fields was not stored with SQL NULLs")
+			}
+			if n := countRows(t, s, "SELECT count(*) FROM logs WHERE error IS NOT NULL"); n != 1 {
+				t.Errorf("%d rows have an error, want 1", n)
+			}
+			if n := countRows(t, s, "SELECT count(*) FROM logs WHERE error->>'code' = $1 AND event_id = $2", code, failed.EventID); n != 1 {
+				t.Errorf("the error of the failed event was not stored")
+			}
+			// Neither the JSON null nor an empty document stands in for an
+			// absent field
+			if n := countRows(t, s, `SELECT count(*) FROM logs WHERE jsonb_typeof(error) = 'null'
+				OR jsonb_typeof(context) = 'null' OR jsonb_typeof(structured) = 'null'
+				OR error = '{}'::jsonb OR context = '{}'::jsonb OR structured = '{}'::jsonb`); n != 0 {
+				t.Errorf("%d rows store an absent field as a JSON document", n)
+			}
+		})
+	}
+}
+
+func TestFlushStoresSanitizedText(t *testing.T) {
+	for _, path := range writePaths {
+		t.Run(path.name, func(t *testing.T) {
+			cfg := integrationConfig(t)
+			path.set(cfg)
+			s := newIntegrationStorage(t, cfg)
+
+			var batch []*LogEvent
+			for _, tt := range invalidTexts {
+				event := integrationEvent("checkout", time.Now())
+				event.Data.Message = tt.text
+				event.Source.Service = tt.text
+				structured := JSONB{"note": tt.text, tt.text: []interface{}{tt.text}}
+				event.Data.Structured = &structured
+				if SanitizeText(event, true) == 0 {
+					t.Fatalf("%s: nothing sanitized", tt.name)
+				}
+				batch = append(batch, event)
+			}
+			if err := s.flush(context.Background(), batch); err != nil {
+				t.Fatalf("flush of the sanitized batch: %v", err)
+			}
+
+			rows, err := s.db.Query("SELECT message, service, structured::text FROM logs")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rows.Close()
+			stored := 0
+			for rows.Next() {
+				var message, service, structured string
+				if err := rows.Scan(&message, &service, &structured); err != nil {
+					t.Fatal(err)
+				}
+				assertStorable(t, "message", message)
+				assertStorable(t, "service", service)
+				if !strings.Contains(message, replacementChar) || !strings.Contains(structured, replacementChar) {
+					t.Errorf("stored %q with %s, want U+FFFD in place of the invalid bytes", message, structured)
+				}
+				stored++
+			}
+			if err := rows.Err(); err != nil {
+				t.Fatal(err)
+			}
+			if stored != len(invalidTexts) {
+				t.Errorf("stored %d rows, want %d", stored, len(invalidTexts))
+			}
+		})
+	}
+}
+
+func TestFlushRejectsUnsanitizedText(t *testing.T) {
+	// What the sanitizing prevents: one such event fails its whole batch
+	s := newIntegrationStorage(t, integrationConfig(t))
+	for _, text := range []string{"caf\xe9", "order\x00placed"} {
+		valid := integrationEvent("checkout", time.Now())
+		invalid := integrationEvent("checkout", time.Now())
+		invalid.Data.Message = text
+		if err := s.flush(context.Background(), []*LogEvent{valid, invalid}); err == nil {
+			t.Errorf("flush of %q succeeded", text)
+		}
+	}
+	if n := countRows(t, s, "SELECT count(*) FROM logs"); n != 0 {
+		t.Errorf("%d rows stored, want the batches rolled back", n)
+	}
+}
+
+// partitions returns the partitions of logs, sorted.
+func partitions(t testing.TB, s *DBStorage) []string {
+	t.Helper()
+	rows, err := s.db.Query("SELECT inhrelid::regclass::text FROM pg_inherits WHERE inhparent = 'logs'::regclass ORDER BY 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var tables []string
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			t.Fatal(err)
+		}
+		tables = append(tables, table)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return tables
+}
+
+// partitionedConfig returns the settings of a storage writing to monthly
+// partitions of a new database.
+func partitionedConfig(t testing.TB) *config.Config {
+	cfg := integrationConfig(t)
+	cfg.PostgresPartitioning = config.PostgresPartitioningMonthly
+	return cfg
+}
+
+func TestCreatePartitionsIsIdempotent(t *testing.T) {
+	s := newIntegrationStorage(t, partitionedConfig(t))
+	batch := []*LogEvent{
+		integrationEvent("checkout", time.Date(2024, 7, 10, 0, 0, 0, 0, time.UTC)),
+		integrationEvent("checkout", time.Date(2024, 8, 10, 0, 0, 0, 0, time.UTC)),
+	}
+	parts := splitByPartition(batch, config.PostgresPartitioningMonthly)
+	want := "[logs_2024_07 logs_2024_08]"
+
+	for i := 0; i < 2; i++ {
+		if err := s.createPartitions(context.Background(), parts); err != nil {
+			t.Fatalf("createPartitions, call %d: %v", i+1, err)
+		}
+	}
+	if got := fmt.Sprint(partitions(t, s)); got != want {
+		t.Errorf("partitions = %s, want %s", got, want)
+	}
+
+	// Collectors racing to create the same partition all succeed
+	parts = splitByPartition([]*LogEvent{integrationEvent("checkout", time.Date(2024, 9, 10, 0, 0, 0, 0, time.UTC))}, config.PostgresPartitioningMonthly)
+	errs := make(chan error, 8)
+	var wg sync.WaitGroup
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- s.createPartitions(context.Background(), parts)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("concurrent createPartitions: %v", err)
+		}
+	}
+	if got := fmt.Sprint(partitions(t, s)); got != "[logs_2024_07 logs_2024_08 logs_2024_09]" {
+		t.Errorf("partitions = %s", got)
+	}
+}
+
+func TestFlushCreatesMissingPartitions(t *testing.T) {
+	// The deduplicating INSERT is left out: a partitioned logs has no
+	// unique index on event_id for it to conflict on
+	for _, path := range writePaths[:2] {
+		t.Run(path.name, func(t *testing.T) {
+			cfg := partitionedConfig(t)
+			path.set(cfg)
+			s := newIntegrationStorage(t, cfg)
+
+			// Neither partition exists: the first write fails with 42P01,
+			// flush creates them and writes the batch again
+			batch := []*LogEvent{
+				integrationEvent("checkout", time.Date(2024, 7, 31, 23, 0, 0, 0, time.UTC)),
+				integrationEvent("checkout", time.Date(2024, 8, 1, 1, 0, 0, 0, time.FixedZone("CEST", 2*60*60))),
+				integrationEvent("checkout", time.Date(2024, 8, 1, 0, 0, 0, 0, time.UTC)),
+			}
+			if err := s.flush(context.Background(), batch); err != nil {
+				t.Fatalf("flush: %v", err)
+			}
+			if got := fmt.Sprint(partitions(t, s)); got != "[logs_2024_07 logs_2024_08]" {
+				t.Errorf("partitions = %s", got)
+			}
+			if n := countRows(t, s, "SELECT count(*) FROM logs_2024_07"); n != 2 {
+				t.Errorf("logs_2024_07 holds %d rows, want 2", n)
+			}
+			if n := countRows(t, s, "SELECT count(*) FROM logs_2024_08"); n != 1 {
+				t.Errorf("logs_2024_08 holds %d rows, want 1", n)
+			}
+
+			// The partitions are used as they are from then on
+			if err := s.flush(context.Background(), []*LogEvent{integrationEvent("checkout", time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC))}); err != nil {
+				t.Fatalf("second flush: %v", err)
+			}
+			if n := countRows(t, s, "SELECT count(*) FROM logs"); n != 4 {
+				t.Errorf("logs holds %d rows, want 4", n)
+			}
+		})
+	}
+}
+
+func TestFlushSkipsStoredEventIDs(t *testing.T) {
+	cfg := integrationConfig(t)
+	cfg.PostgresDedupOnInsert = true
+	s := newIntegrationStorage(t, cfg)
+
+	first := integrationEvent("checkout", time.Now())
+	if err := s.flush(context.Background(), []*LogEvent{first}); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+
+	// A redelivery of the stored event, outliving the Redis dedup window,
+	// and an event duplicated within the batch
+	redelivered := *first
+	redelivered.Data.Message = "Order placed again"
+	other := integrationEvent("checkout", time.Now())
+	duplicate := *other
+	if err := s.flush(context.Background(), []*LogEvent{&redelivered, other, &duplicate}); err != nil {
+		t.Fatalf("flush of the duplicates: %v", err)
+	}
+
+	if n := countRows(t, s, "SELECT count(*) FROM logs"); n != 2 {
+		t.Errorf("logs holds %d rows, want 2", n)
+	}
+	if n := countRows(t, s, "SELECT count(*) FROM logs WHERE event_id = $1 AND message = $2", first.EventID, first.Data.Message); n != 1 {
+		t.Error("the stored event was overwritten")
+	}
+}
@@ -0,0 +1,485 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"observability_hub/golang/internal/collector/config"
+
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+)
+
+// unreachablePostgres is a connection string nothing listens on, so writes
+// fail at once with a connection error.
+const unreachablePostgres = "postgres://collector@127.0.0.1:1/collector?sslmode=disable&connect_timeout=1"
+
+// testConfig returns the settings of a small storage that retries quickly.
+func testConfig() *config.Config {
+	return &config.Config{
+		BatchSize:                2,
+		BatchTimeout:             time.Hour,
+		WorkerBatchSize:          1,
+		RetryMax:                 1,
+		RetryInterval:            time.Millisecond,
+		PostgresFlushConcurrency: 1,
+	}
+}
+
+// newTestStorage builds a DBStorage on db the way NewDBStorageWithRedis
+// does, without pinging the database or starting the batch processor.
+func newTestStorage(t testing.TB, db *sql.DB, cfg *config.Config) *DBStorage {
+	t.Helper()
+	if db == nil {
+		var err error
+		if db, err = sql.Open("postgres", unreachablePostgres); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	s := &DBStorage{
+		db:             db,
+		cfg:            cfg,
+		tuning:         newTuning(cfg),
+		reconfigured:   make(chan struct{}, 1),
+		ticker:         time.NewTicker(cfg.BatchTimeout),
+		ctx:            ctx,
+		cancel:         cancel,
+		logger:         zap.NewNop(),
+		ids:            UUIDGenerator{},
+		flushSlots:     make(chan struct{}, max(1, cfg.PostgresFlushConcurrency)),
+		shed:           make(chan struct{}, 1),
+		cacheStats:     newCacheStats(),
+		enqueueTimeout: cfg.BufferEnqueueTimeout,
+	}
+	s.buffer.Store(&eventBuffer{ch: make(chan []*LogEvent, bufferCapacity(cfg.BatchSize, cfg.WorkerBatchSize))})
+	t.Cleanup(s.ticker.Stop)
+	return s
+}
+
+func TestRetryWithBackoffStopsOnPermanentError(t *testing.T) {
+	cfg := testConfig()
+	cfg.RetryMax = 5
+	cfg.RetryInterval = time.Second
+	s := newTestStorage(t, nil, cfg)
+
+	permanent := &pq.Error{Code: "23502"} // not_null_violation
+	attempts := 0
+	start := time.Now()
+	err := s.retryWithBackoff(func() error {
+		attempts++
+		return permanent
+	})
+	if !errors.Is(err, permanent) {
+		t.Fatalf("error = %v, want the permanent error", err)
+	}
+	if attempts != 1 {
+		t.Errorf("operation ran %d times, want 1", attempts)
+	}
+	if elapsed := time.Since(start); elapsed >= cfg.RetryInterval {
+		t.Errorf("returned after %s, without waiting out a backoff", elapsed)
+	}
+}
+
+func TestRetryWithBackoffRetriesTransientErrors(t *testing.T) {
+	cfg := testConfig()
+	cfg.RetryMax = 3
+	s := newTestStorage(t, nil, cfg)
+
+	attempts := 0
+	err := s.retryWithBackoff(func() error {
+		attempts++
+		if attempts < 3 {
+			return &pq.Error{Code: "08006"} // connection_failure
+		}
+		return nil
+	})
+	if err != nil || attempts != 3 {
+		t.Errorf("error = %v after %d attempts, want success on the third", err, attempts)
+	}
+}
+
+func TestRetryWithBackoffRetriesDeadlocksAtOnce(t *testing.T) {
+	cfg := testConfig()
+	cfg.RetryMax = 5
+	cfg.RetryInterval = 200 * time.Millisecond
+	s := newTestStorage(t, nil, cfg)
+
+	// A deadlock is retried after about deadlockBackoff, well within the
+	// regular backoff
+	attempts := 0
+	start := time.Now()
+	err := s.retryWithBackoff(func() error {
+		attempts++
+		if attempts == 1 {
+			return &pq.Error{Code: "40P01"}
+		}
+		return nil
+	})
+	if err != nil || attempts != 2 {
+		t.Fatalf("error = %v after %d attempts, want success on the second", err, attempts)
+	}
+	if elapsed := time.Since(start); elapsed >= cfg.RetryInterval {
+		t.Errorf("deadlock retry waited %s, want about %s", elapsed, deadlockBackoff)
+	}
+
+	// Nor does it grow the backoff: a transient error after a deadlock
+	// waits the first interval, not twice it
+	attempts = 0
+	start = time.Now()
+	err = s.retryWithBackoff(func() error {
+		attempts++
+		switch attempts {
+		case 1:
+			return &pq.Error{Code: "40P01"}
+		case 2:
+			return &pq.Error{Code: "08006"}
+		}
+		return nil
+	})
+	if err != nil || attempts != 3 {
+		t.Fatalf("error = %v after %d attempts, want success on the third", err, attempts)
+	}
+	elapsed := time.Since(start)
+	if limit := cfg.RetryInterval + 3*deadlockBackoff/2 + 100*time.Millisecond; elapsed < cfg.RetryInterval || elapsed > limit {
+		t.Errorf("retries took %s, want between %s and %s", elapsed, cfg.RetryInterval, limit)
+	}
+}
+
+func TestAddBatchRacingClose(t *testing.T) {
+	s := newTestStorage(t, nil, testConfig())
+	s.wg.Add(1)
+	go s.batchProcessor()
+
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for g := 0; g < 20; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			for i := 0; i < 200; i++ {
+				event := &LogEvent{}
+				err := s.AddToBatch(event)
+				var rejected *RejectedError
+				if err != nil && (!errors.As(err, &rejected) || len(rejected.Events) != 1 || rejected.Events[0] != event) {
+					t.Errorf("AddToBatch error = %v, want a *RejectedError with the event", err)
+					return
+				}
+			}
+		}()
+	}
+
+	close(start)
+	time.Sleep(5 * time.Millisecond)
+	closed := make(chan struct{})
+	go func() {
+		s.Close()
+		close(closed)
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		<-closed
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("AddToBatch or Close did not return")
+	}
+
+	err := s.AddToBatch(&LogEvent{})
+	var rejected *RejectedError
+	if !errors.As(err, &rejected) || !errors.Is(err, ErrStorageClosed) {
+		t.Errorf("AddToBatch after Close: error = %v, want ErrStorageClosed", err)
+	}
+}
+
+func TestAddBatchRejectsAfterEnqueueTimeout(t *testing.T) {
+	cfg := testConfig()
+	cfg.BufferEnqueueTimeout = 50 * time.Millisecond
+	s := newTestStorage(t, nil, cfg)
+
+	// Without a batch processor nothing drains the buffer
+	for i := 0; i < cap(s.buffer.Load().ch); i++ {
+		if err := s.AddToBatch(&LogEvent{}); err != nil {
+			t.Fatalf("AddToBatch %d: %v", i, err)
+		}
+	}
+
+	events := []*LogEvent{{EventID: "a"}, {EventID: "b"}}
+	start := time.Now()
+	err := s.AddBatch(events)
+	elapsed := time.Since(start)
+
+	var rejected *RejectedError
+	if !errors.As(err, &rejected) || !errors.Is(err, ErrBufferFull) {
+		t.Fatalf("AddBatch on a full buffer: error = %v, want ErrBufferFull", err)
+	}
+	if len(rejected.Events) != len(events) || rejected.Events[0] != events[0] || rejected.Events[1] != events[1] {
+		t.Errorf("rejected events = %v, want %v", rejected.Events, events)
+	}
+	if elapsed < cfg.BufferEnqueueTimeout {
+		t.Errorf("rejected after %s, before the enqueue timeout", elapsed)
+	}
+	if depth, want := s.BufferDepth(), cap(s.buffer.Load().ch); depth != want {
+		t.Errorf("buffer depth = %d, want %d", depth, want)
+	}
+}
+
+func TestBatchOptimizerTiers(t *testing.T) {
+	tests := []struct {
+		name       string
+		hits, miss int
+		wantRatio  float64
+		wantSize   int
+	}{
+		{"no lookups", 0, 0, 0.5, 100},
+		{"all hits", 10, 0, 1, 150},
+		{"mostly hits", 8, 2, 0.8, 150},
+		{"at the upper bound", 7, 3, 0.7, 100},
+		{"even", 5, 5, 0.5, 100},
+		{"at the lower bound", 3, 7, 0.3, 100},
+		{"mostly misses", 1, 9, 0.1, 80},
+		{"all misses", 0, 10, 0, 80},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStorage(t, nil, testConfig())
+			bo := s.createBatchOptimizer(100)
+			for i := 0; i < tt.hits; i++ {
+				s.cacheStats.record("checkout", true)
+			}
+			for i := 0; i < tt.miss; i++ {
+				s.cacheStats.record("payments", false)
+			}
+
+			// Statistics are picked up at most every 30 seconds
+			bo.lastOptimization = time.Now().Add(-time.Minute)
+			size := bo.getOptimalBatchSize(nil)
+			if bo.cacheHitRatio != tt.wantRatio {
+				t.Errorf("hit ratio = %g, want %g", bo.cacheHitRatio, tt.wantRatio)
+			}
+			if size != tt.wantSize {
+				t.Errorf("batch size = %d, want %d", size, tt.wantSize)
+			}
+		})
+	}
+}
+
+func TestBatchOptimizerCountsLookupsSinceLastUpdate(t *testing.T) {
+	s := newTestStorage(t, nil, testConfig())
+	bo := s.createBatchOptimizer(100)
+
+	for i := 0; i < 9; i++ {
+		s.cacheStats.record("checkout", true)
+	}
+	s.cacheStats.record("checkout", false)
+	bo.updateCacheStats()
+	if bo.cacheHitRatio != 0.9 {
+		t.Fatalf("hit ratio = %g, want 0.9", bo.cacheHitRatio)
+	}
+
+	// The earlier hits do not mask a run of misses
+	for i := 0; i < 4; i++ {
+		s.cacheStats.record("checkout", false)
+	}
+	bo.updateCacheStats()
+	if bo.cacheHitRatio != 0 {
+		t.Fatalf("hit ratio = %g, want 0", bo.cacheHitRatio)
+	}
+
+	// Without lookups in between, e.g. with the cache disabled, the ratio
+	// is kept
+	bo.updateCacheStats()
+	if bo.cacheHitRatio != 0 {
+		t.Errorf("hit ratio = %g after no lookups, want 0", bo.cacheHitRatio)
+	}
+
+	// Within 30 seconds of the last update new lookups are not picked up
+	s.cacheStats.record("checkout", true)
+	bo.lastOptimization = time.Now()
+	if size := bo.getOptimalBatchSize(nil); size != 80 {
+		t.Errorf("batch size = %d, want 80", size)
+	}
+}
+
+// BenchmarkEnqueue compares handing events to the storage buffer one by
+// one with handing over worker-local batches of 50, from 8 workers at once.
+// chanops/event is the number of buffer sends per event.
+func BenchmarkEnqueue(b *testing.B) {
+	for _, batchSize := range []int{1, 50} {
+		name := "AddToBatch"
+		if batchSize > 1 {
+			name = fmt.Sprintf("AddBatch/%d", batchSize)
+		}
+		b.Run(name, func(b *testing.B) {
+			cfg := testConfig()
+			cfg.BatchSize = 1000
+			cfg.WorkerBatchSize = batchSize
+			s := newTestStorage(b, nil, cfg)
+			buffer := s.buffer.Load().ch
+
+			var sends atomic.Int64
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for range buffer {
+					sends.Add(1)
+				}
+			}()
+
+			b.SetParallelism(8)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				batch := make([]*LogEvent, 0, batchSize)
+				for pb.Next() {
+					batch = append(batch, &LogEvent{EventID: "8d0c2cf4-5a2f-4f43-9a8b-3f0a3a7b2f10"})
+					if len(batch) < batchSize {
+						continue
+					}
+					if err := s.AddBatch(batch); err != nil {
+						b.Error(err)
+						return
+					}
+					batch = make([]*LogEvent, 0, batchSize)
+				}
+				if len(batch) > 0 {
+					s.AddBatch(batch)
+				}
+			})
+			b.StopTimer()
+			close(buffer)
+			<-done
+			b.ReportMetric(float64(sends.Load())/float64(b.N), "chanops/event")
+		})
+	}
+}
+
+func TestJSONColumn(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want interface{}
+	}{
+		{"nil", nil, nil},
+		{"empty", []byte{}, nil},
+		{"JSON null", []byte("null"), nil},
+		{"object", []byte(`{"code":"E42"}`), `{"code":"E42"}`},
+		{"empty object", []byte(`{}`), `{}`},
+		{"null string", []byte(`"null"`), `"null"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := jsonColumn(tt.data); got != tt.want {
+				t.Errorf("jsonColumn(%q) = %#v, want %#v", tt.data, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCopyRowStoresAbsentOptionalFieldsAsNull(t *testing.T) {
+	s := newTestStorage(t, nil, testConfig())
+	columns := s.copyColumns()
+	column := func(row []interface{}, name string) interface{} {
+		for i, c := range columns {
+			if c == name {
+				return row[i]
+			}
+		}
+		t.Fatalf("no column %s", name)
+		return nil
+	}
+
+	event := &LogEvent{EventID: "e-1", Metadata: Metadata{Priority: "normal"}}
+	row := s.copyRow(event)
+	for _, name := range []string{"context", "error", "structured"} {
+		if value := column(row, name); value != nil {
+			t.Errorf("%s = %#v, want SQL NULL", name, value)
+		}
+	}
+	if column(row, "metadata") == nil {
+		t.Error("metadata is NULL, want the document")
+	}
+
+	code := "ECONNRESET"
+	event.Data.Error = &LogError{Code: &code}
+	if value, _ := column(s.copyRow(event), "error").(string); !strings.Contains(value, code) {
+		t.Errorf("error = %#v, want the document", value)
+	}
+}
+
+func TestFlushVerifiesFailedCommit(t *testing.T) {
+	tests := []struct {
+		status  string
+		commits int // Transactions written, one per attempt
+	}{
+		// The commit took effect but its acknowledgement was lost: the
+		// batch is stored and must not be written again
+		{"committed", 1},
+		{"aborted", 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.status, func(t *testing.T) {
+			fake := &fakeDB{
+				affected:   func(exec fakeExec) (int64, error) { return int64(len(exec.args) / 10), nil },
+				commitErr:  errors.New("read tcp 10.0.0.5:51234->10.0.0.9:5432: read: connection reset by peer"),
+				txidStatus: tt.status,
+			}
+			cfg := testConfig()
+			cfg.PostgresDedupOnInsert = true
+			cfg.FlushCommitVerify = true
+			cfg.RetryMax = 3
+			s := newTestStorage(t, fake.open(t), cfg)
+
+			s.flushWithRetry([]*LogEvent{{EventID: "e-1", Timestamp: time.Now()}, {EventID: "e-2", Timestamp: time.Now()}})
+
+			if fake.commits != tt.commits {
+				t.Errorf("%d transactions written, want %d", fake.commits, tt.commits)
+			}
+			if inserts := len(fake.committed()); inserts != tt.commits {
+				t.Errorf("%d INSERT statements, want %d", inserts, tt.commits)
+			}
+		})
+	}
+}
+
+func TestCopyRowKeepsOriginalTimestamp(t *testing.T) {
+	original := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	event := &LogEvent{EventID: "e-1", Timestamp: time.Now(), Source: Source{Service: "checkout", Version: "1.2.3"}}
+	event.Metadata = Metadata{Priority: "normal", OriginalTimestamp: &original}
+
+	for _, cached := range []bool{false, true} {
+		s := newTestStorage(t, nil, testConfig())
+		if cached {
+			// As processMetadataCache leaves it before nearly every flush
+			s.metadataMap.Store(metadataMapKey(MetadataKey{Service: "checkout", Version: "1.2.3", Environment: "unknown"}),
+				&CachedMetadata{ServiceID: "checkout", Environment: "unknown", Version: "1.2.3"})
+		}
+		metadataJSON, _ := s.copyRow(event)[9].(string)
+
+		if strings.Contains(metadataJSON, `"cached_attributes"`) != cached {
+			t.Fatalf("cached %t: metadata %s did not take the expected path", cached, metadataJSON)
+		}
+		var metadata struct {
+			OriginalTimestamp *time.Time `json:"originalTimestamp"`
+		}
+		if err := json.Unmarshal([]byte(metadataJSON), &metadata); err != nil {
+			t.Fatalf("cached %t: metadata %q: %v", cached, metadataJSON, err)
+		}
+		if metadata.OriginalTimestamp == nil || !metadata.OriginalTimestamp.Equal(original) {
+			t.Errorf("cached %t: metadata %s, want originalTimestamp %s", cached, metadataJSON, original)
+		}
+	}
+}
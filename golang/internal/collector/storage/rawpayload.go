@@ -0,0 +1,39 @@
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+)
+
+// Raw payload encodings, stored in raw_payload_encoding.
+const (
+	RawPayloadIdentity = "identity"
+	RawPayloadGzip     = "gzip"
+)
+
+// RawPayload is the exact message body a producer sent, kept for audit and
+// replay. Unlike the parsed event it retains fields the collector does not
+// know about.
+type RawPayload struct {
+	Data     []byte
+	Encoding string
+}
+
+// NewRawPayload captures body, gzip-compressed when compress is set. The
+// body is copied, so the delivery buffer may be reused afterwards.
+func NewRawPayload(body []byte, compress bool) (*RawPayload, error) {
+	if !compress {
+		return &RawPayload{Data: bytes.Clone(body), Encoding: RawPayloadIdentity}, nil
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(body); err != nil {
+		return nil, fmt.Errorf("failed to compress raw payload: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress raw payload: %w", err)
+	}
+	return &RawPayload{Data: buf.Bytes(), Encoding: RawPayloadGzip}, nil
+}
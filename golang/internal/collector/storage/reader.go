@@ -0,0 +1,174 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"observability_hub/golang/internal/collector/config"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// LogFilter selects stored log rows. Zero values leave a bound open.
+type LogFilter struct {
+	From    time.Time
+	To      time.Time
+	Service string
+	// Baggage entries the tracing context must carry; needs the tracing
+	// column
+	Baggage map[string]string
+}
+
+// LogCursor is a position in the (timestamp, event_id) order of the logs
+// table. Reads resume strictly after it, so it can be persisted as a
+// checkpoint.
+type LogCursor struct {
+	Timestamp time.Time `json:"timestamp"`
+	EventID   string    `json:"eventId"`
+}
+
+// LogReader reads stored events back from Postgres, the source of truth,
+// e.g. to rebuild the Elasticsearch indices.
+type LogReader struct {
+	db      *sql.DB
+	tracing bool // Whether logs has the tracing column
+	logger  *zap.Logger
+}
+
+// NewLogReader opens a read connection to the logs database.
+func NewLogReader(cfg *config.Config, logger *zap.Logger) (*LogReader, error) {
+	db, err := sql.Open("postgres", cfg.PostgresURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to postgres: %w", err)
+	}
+
+	if err = db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to ping postgres: %w", err)
+	}
+
+	return &LogReader{
+		db:      db,
+		tracing: cfg.TracingColumnEnabled,
+		logger:  logger.Named("log_reader"),
+	}, nil
+}
+
+// ReadPage returns up to limit events matching filter that come after the
+// cursor, ordered by timestamp and event ID. A nil cursor starts at the
+// beginning of the range.
+func (r *LogReader) ReadPage(ctx context.Context, filter LogFilter, after *LogCursor, limit int) ([]*LogEvent, error) {
+	var (
+		conditions []string
+		args       []interface{}
+	)
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if !filter.From.IsZero() {
+		conditions = append(conditions, "timestamp >= "+arg(filter.From))
+	}
+	if !filter.To.IsZero() {
+		conditions = append(conditions, "timestamp < "+arg(filter.To))
+	}
+	if filter.Service != "" {
+		conditions = append(conditions, "service = "+arg(filter.Service))
+	}
+	if len(filter.Baggage) > 0 {
+		if !r.tracing {
+			return nil, errors.New("filtering by baggage needs TRACING_COLUMN")
+		}
+		baggage, err := json.Marshal(filter.Baggage)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode baggage filter: %w", err)
+		}
+		conditions = append(conditions, "tracing->'baggage' @> "+arg(string(baggage))+"::jsonb")
+	}
+	if after != nil {
+		conditions = append(conditions, fmt.Sprintf("(timestamp, event_id) > (%s, %s)", arg(after.Timestamp), arg(after.EventID)))
+	}
+
+	query := `SELECT event_id, correlation_id, timestamp, level, service, message, context, error, structured, metadata`
+	if r.tracing {
+		query += `, tracing`
+	}
+	query += ` FROM logs`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY timestamp, event_id LIMIT " + arg(limit)
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query logs: %w", err)
+	}
+	defer rows.Close()
+
+	var events []*LogEvent
+	for rows.Next() {
+		event, err := scanLogEvent(rows, r.tracing)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read logs: %w", err)
+	}
+	return events, nil
+}
+
+// scanLogEvent reconstructs a LogEvent from a logs row, read with the
+// tracing column when tracing is set. Fields that are not persisted (event
+// type, schema version, source version) stay empty.
+func scanLogEvent(rows *sql.Rows, tracing bool) (*LogEvent, error) {
+	var (
+		event                                                     LogEvent
+		correlationID, level, service, message                    sql.NullString
+		contextJSON, errorJSON, structured, metadata, tracingJSON []byte
+	)
+	dest := []interface{}{&event.EventID, &correlationID, &event.Timestamp, &level, &service, &message,
+		&contextJSON, &errorJSON, &structured, &metadata}
+	if tracing {
+		dest = append(dest, &tracingJSON)
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return nil, fmt.Errorf("failed to scan log row: %w", err)
+	}
+
+	event.CorrelationID = correlationID.String
+	event.Source.Service = service.String
+	event.Data.Level = level.String
+	event.Data.Message = message.String
+	event.Data.Timestamp = event.Timestamp
+
+	for _, field := range []struct {
+		raw  []byte
+		dest interface{}
+	}{
+		{contextJSON, &event.Data.Context},
+		{errorJSON, &event.Data.Error},
+		{structured, &event.Data.Structured},
+		{metadata, &event.Metadata},
+		{tracingJSON, &event.Tracing},
+	} {
+		if len(field.raw) == 0 {
+			continue
+		}
+		if err := json.Unmarshal(field.raw, field.dest); err != nil {
+			return nil, fmt.Errorf("failed to decode stored event %s: %w", event.EventID, err)
+		}
+	}
+	return &event, nil
+}
+
+// Close closes the database connection.
+func (r *LogReader) Close() {
+	r.db.Close()
+}
@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"observability_hub/golang/internal/collector/config"
+	"sync"
+	"time"
+)
+
+// tuning holds the settings Reconfigure can change while the storage runs.
+type tuning struct {
+	batchSize     int
+	batchTimeout  time.Duration
+	retryMax      int
+	retryInterval time.Duration
+}
+
+func newTuning(cfg *config.Config) tuning {
+	return tuning{
+		batchSize:     cfg.BatchSize,
+		batchTimeout:  cfg.BatchTimeout,
+		retryMax:      cfg.RetryMax,
+		retryInterval: cfg.RetryInterval,
+	}
+}
+
+// eventBuffer is the channel from AddBatch to the batch processor. Its
+// capacity follows the batch size, so a reconfiguration replaces it.
+// Senders hold the read lock while sending; retiring a buffer takes the
+// write lock, which waits for the sends in progress, and closes it.
+type eventBuffer struct {
+	ch      chan []*LogEvent
+	mu      sync.RWMutex
+	retired bool
+}
+
+// bufferCapacity returns the buffer capacity, in worker batches, for about
+// two batches of events.
+func bufferCapacity(batchSize, workerBatchSize int) int {
+	return max(1, batchSize*2/max(1, workerBatchSize))
+}
+
+// retire closes the buffer once the sends in progress are done. Later
+// senders see it retired and move to the current buffer.
+func (b *eventBuffer) retire() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.retired {
+		b.retired = true
+		close(b.ch)
+	}
+}
+
+// sendBuffer returns the current buffer, read-locked so that it stays open
+// until the caller's send is done; the caller unlocks it.
+func (s *DBStorage) sendBuffer() *eventBuffer {
+	for {
+		b := s.buffer.Load()
+		b.mu.RLock()
+		if !b.retired {
+			return b
+		}
+		// Replaced meanwhile
+		b.mu.RUnlock()
+	}
+}
+
+// Reconfigure applies the batching and retry settings of cfg: BatchSize,
+// BatchTimeout, RetryMax and RetryInterval. The other settings, the
+// connection settings among them, keep their values until a restart. The
+// batch processor picks the change up between batches: the batch being
+// collected is kept, and the buffer is resized without losing events.
+// Flushes already retrying finish with the retry settings they began with.
+func (s *DBStorage) Reconfigure(cfg *config.Config) {
+	s.mu.Lock()
+	s.tuning = newTuning(cfg)
+	s.mu.Unlock()
+
+	select {
+	case s.reconfigured <- struct{}{}:
+	default:
+		// The batch processor has yet to pick up an earlier change and
+		// will read the latest settings
+	}
+}
+
+// currentTuning returns the settings in effect.
+func (s *DBStorage) currentTuning() tuning {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tuning
+}
+
+// swapBuffer replaces the buffer with one of the given capacity, returning
+// the events still in the old buffer or being sent to it. Senders move to
+// the new buffer at once. Only the batch processor calls it, since it
+// must not receive from the old buffer meanwhile.
+func (s *DBStorage) swapBuffer(capacity int) []*LogEvent {
+	old := s.buffer.Load()
+	if cap(old.ch) == capacity {
+		return nil
+	}
+	s.buffer.Store(&eventBuffer{ch: make(chan []*LogEvent, capacity)})
+
+	// Retiring waits for the senders blocked on the old buffer, which are
+	// unblocked by draining it here.
+	go old.retire()
+	var drained []*LogEvent
+	for events := range old.ch {
+		s.depth.Add(-int64(len(events)))
+		drained = append(drained, events...)
+	}
+	return drained
+}
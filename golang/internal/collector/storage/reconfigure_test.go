@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReconfigureUnderLoad(t *testing.T) {
+	fake := &fakeDB{affected: func(exec fakeExec) (int64, error) { return int64(len(exec.args) / 10), nil }}
+	cfg := insertDedupConfig()
+	cfg.BatchTimeout = 5 * time.Millisecond
+	s := newTestStorage(t, fake.open(t), cfg)
+	s.wg.Add(1)
+	go s.batchProcessor()
+
+	const senders, batches = 8, 300
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for g := 0; g < senders; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			for i := 0; i < batches; i++ {
+				events := make([]*LogEvent, 1+i%3)
+				for j := range events {
+					events[j] = &LogEvent{EventID: fmt.Sprintf("%d-%d-%d", g, i, j)}
+				}
+				if err := s.AddBatch(events); err != nil {
+					t.Errorf("AddBatch: %v", err)
+					return
+				}
+			}
+		}()
+	}
+
+	// Resize the buffer up and down while the senders fill it
+	close(start)
+	reconfigured := make(chan struct{})
+	go func() {
+		defer close(reconfigured)
+		for i, size := range []int{1, 64, 2, 500, 3, 17, 1, 256, 2, 8} {
+			next := *cfg
+			next.BatchSize = size
+			s.Reconfigure(&next)
+			time.Sleep(time.Duration(i%3) * time.Millisecond)
+		}
+	}()
+
+	sent := make(chan struct{})
+	go func() {
+		wg.Wait()
+		<-reconfigured
+		close(sent)
+	}()
+	select {
+	case <-sent:
+	case <-time.After(20 * time.Second):
+		t.Fatal("senders blocked while the buffer was reconfigured")
+	}
+	s.Close()
+
+	columns := len(s.copyColumns())
+	flushed := make(map[string]int)
+	for _, exec := range fake.committed() {
+		for row := 0; row < len(exec.args)/columns; row++ {
+			flushed[exec.args[row*columns].Value.(string)]++
+		}
+	}
+	want := 0
+	for g := 0; g < senders; g++ {
+		for i := 0; i < batches; i++ {
+			for j := 0; j < 1+i%3; j++ {
+				id := fmt.Sprintf("%d-%d-%d", g, i, j)
+				if n := flushed[id]; n != 1 {
+					t.Errorf("event %s flushed %d times, want once", id, n)
+				}
+				want++
+			}
+		}
+	}
+	if len(flushed) != want {
+		t.Errorf("flushed %d events, want %d", len(flushed), want)
+	}
+	if depth := s.BufferDepth(); depth != 0 {
+		t.Errorf("buffer depth = %d after Close, want 0", depth)
+	}
+}
@@ -3,8 +3,10 @@ package storage
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"observability_hub/golang/internal/collector/config"
+	"strings"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -14,6 +16,7 @@ import (
 // RedisClient wraps the Redis client with additional functionality for the collector
 type RedisClient struct {
 	client *redis.Client
+	dedup  *redis.Client // Holds the dedup keys; client unless DedupRedisURL is set
 	cfg    *config.Config
 	logger *zap.Logger
 	ctx    context.Context
@@ -48,42 +51,130 @@ func NewRedisClient(ctx context.Context, cfg *config.Config, logger *zap.Logger)
 	opts.PoolSize = cfg.RedisPoolSize
 	opts.MinIdleConns = cfg.RedisMinIdle
 	opts.MaxRetries = cfg.RedisMaxRetries
+	opts.ConnMaxIdleTime = cfg.RedisIdleTimeout
+	opts.ConnMaxLifetime = cfg.RedisConnMaxLifetime
+	// go-redis only drops expired connections when it takes them from the
+	// pool. Taking the oldest first lets reapIdleConns reach them.
+	opts.PoolFIFO = cfg.RedisIdleTimeout > 0
 
 	client := redis.NewClient(opts)
 
 	// Test connection
 	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
 		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
 	}
 
+	dedup := client
+	if cfg.DedupRedisURL != "" {
+		dedupOpts, err := redis.ParseURL(cfg.DedupRedisURL)
+		if err != nil {
+			client.Close()
+			return nil, fmt.Errorf("failed to parse dedup Redis URL: %w", err)
+		}
+		dedupOpts.PoolSize = cfg.RedisPoolSize
+		dedupOpts.MaxRetries = cfg.RedisMaxRetries
+		dedupOpts.ConnMaxIdleTime = cfg.RedisIdleTimeout
+		dedupOpts.ConnMaxLifetime = cfg.RedisConnMaxLifetime
+		dedup = redis.NewClient(dedupOpts)
+		if err := dedup.Ping(ctx).Err(); err != nil {
+			dedup.Close()
+			client.Close()
+			return nil, fmt.Errorf("failed to connect to dedup Redis: %w", err)
+		}
+	}
+
 	redisClient := &RedisClient{
 		client: client,
+		dedup:  dedup,
 		cfg:    cfg,
 		logger: logger.Named("redis"),
 		ctx:    ctx,
 	}
 
+	if cfg.RedisIdleTimeout > 0 {
+		go redisClient.reapIdleConns()
+	}
+
 	logger.Info("Redis client connected successfully",
 		zap.String("url", cfg.RedisURL),
 		zap.Int("db", cfg.RedisDB),
-		zap.Int("pool_size", cfg.RedisPoolSize))
+		zap.Int("pool_size", cfg.RedisPoolSize),
+		zap.Duration("idle_timeout", cfg.RedisIdleTimeout),
+		zap.Duration("conn_max_lifetime", cfg.RedisConnMaxLifetime),
+		zap.String("key_prefix", cfg.RedisKeyPrefix))
+	if cfg.DedupRedisURL != "" || cfg.DedupNamespace != "" || cfg.DedupRegion != "" {
+		logger.Info("Deduplicating across collectors",
+			zap.Bool("separate_redis", cfg.DedupRedisURL != ""),
+			zap.String("namespace", cfg.DedupNamespace),
+			zap.String("region", cfg.DedupRegion))
+	}
 
 	return redisClient, nil
 }
 
 // HealthCheck checks Redis connection health
 func (r *RedisClient) HealthCheck() error {
-	return r.client.Ping(r.ctx).Err()
+	if err := r.client.Ping(r.ctx).Err(); err != nil {
+		return err
+	}
+	if r.dedup != r.client {
+		if err := r.dedup.Ping(r.ctx).Err(); err != nil {
+			return fmt.Errorf("dedup Redis: %w", err)
+		}
+	}
+	return nil
+}
+
+// reapIdleConns periodically takes a connection from the pool so that
+// go-redis closes the idle ones that expired in front of it, even while
+// traffic is too low to cycle through the pool. The pool never shrinks
+// below RedisMinIdle.
+func (r *RedisClient) reapIdleConns() {
+	ticker := time.NewTicker(max(r.cfg.RedisIdleTimeout/2, time.Second))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-ticker.C:
+			before := r.client.PoolStats().TotalConns
+			if err := r.client.Ping(r.ctx).Err(); err != nil {
+				r.logger.Debug("Idle connection reaper ping failed", zap.Error(err))
+				continue
+			}
+			if after := r.client.PoolStats().TotalConns; after < before {
+				r.logger.Debug("Reaped idle Redis connections",
+					zap.Uint32("closed", before-after),
+					zap.Uint32("open", after))
+			}
+		}
+	}
 }
 
 // Close closes the Redis connection
 func (r *RedisClient) Close() error {
+	if r.dedup != r.client {
+		return errors.Join(r.dedup.Close(), r.client.Close())
+	}
 	return r.client.Close()
 }
 
+// buildKey joins parts into a collector key. When a key prefix is configured
+// every key is namespaced with it, so deployments sharing one Redis instance
+// (e.g. staging and production) never see each other's keys.
+func (r *RedisClient) buildKey(parts ...string) string {
+	key := "collector:" + strings.Join(parts, ":")
+	if r.cfg.RedisKeyPrefix != "" {
+		return r.cfg.RedisKeyPrefix + ":" + key
+	}
+	return key
+}
+
 // generateMetadataKey creates a Redis key for metadata caching
 func (r *RedisClient) generateMetadataKey(service, version, environment string) string {
-	return fmt.Sprintf("collector:metadata:%s:%s:%s", service, version, environment)
+	return r.buildKey("metadata", service, version, environment)
 }
 
 // CacheMetadata stores service metadata in Redis
@@ -95,7 +186,7 @@ func (r *RedisClient) CacheMetadata(service, version, environment string, metada
 		return fmt.Errorf("failed to marshal metadata: %w", err)
 	}
 
-	err = r.client.Set(r.ctx, key, data, r.cfg.RedisTTL).Err()
+	err = r.client.Set(r.ctx, key, data, r.cfg.RedisMetadataTTL).Err()
 	if err != nil {
 		return fmt.Errorf("failed to cache metadata: %w", err)
 	}
@@ -134,20 +225,60 @@ func (r *RedisClient) GetCachedMetadata(service, version, environment string) (*
 	return &metadata, nil
 }
 
+// GetCachedMetadataMulti retrieves the metadata of several services in one
+// pipelined round-trip. The result has one entry per key; nil marks a cache
+// miss. Entries that cannot be decoded are reported as misses so that they
+// get overwritten with fresh metadata.
+func (r *RedisClient) GetCachedMetadataMulti(keys []MetadataKey) ([]*CachedMetadata, error) {
+	pipe := r.client.Pipeline()
+	cmds := make([]*redis.StringCmd, len(keys))
+	for i, key := range keys {
+		cmds[i] = pipe.Get(r.ctx, r.generateMetadataKey(key.Service, key.Version, key.Environment))
+	}
+	// Per-command errors, including misses, are inspected below.
+	pipe.Exec(r.ctx)
+
+	results := make([]*CachedMetadata, len(keys))
+	for i, cmd := range cmds {
+		data, err := cmd.Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get cached metadata: %w", err)
+		}
+
+		var metadata CachedMetadata
+		if err := json.Unmarshal([]byte(data), &metadata); err != nil {
+			r.logger.Warn("Discarding undecodable cached metadata",
+				zap.String("service", keys[i].Service),
+				zap.Error(err))
+			continue
+		}
+		results[i] = &metadata
+	}
+
+	return results, nil
+}
+
 // generateDeduplicationKey creates a Redis key for message deduplication
 // Only checks for exact duplicate events (same EventID + CorrelationID)
 func (r *RedisClient) generateDeduplicationKey(event *LogEvent) string {
 	// Use only EventID and CorrelationID for true duplicate detection
 	// Different requests should have different EventID/CorrelationID
 	// even if message content is similar
-	return fmt.Sprintf("collector:dedup:%s:%s", event.EventID, event.CorrelationID)
+	if r.cfg.DedupNamespace != "" {
+		// Shared by collectors whose other keys are prefixed differently
+		return r.cfg.DedupNamespace + ":collector:dedup:" + event.EventID + ":" + event.CorrelationID
+	}
+	return r.buildKey("dedup", event.EventID, event.CorrelationID)
 }
 
 // CheckDuplication checks if a message has already been processed
 func (r *RedisClient) CheckDuplication(event *LogEvent) (bool, error) {
 	key := r.generateDeduplicationKey(event)
 
-	exists, err := r.client.Exists(r.ctx, key).Result()
+	exists, err := r.dedup.Exists(r.ctx, key).Result()
 	if err != nil {
 		return false, fmt.Errorf("failed to check duplication: %w", err)
 	}
@@ -159,10 +290,8 @@ func (r *RedisClient) CheckDuplication(event *LogEvent) (bool, error) {
 func (r *RedisClient) MarkAsProcessed(event *LogEvent) error {
 	key := r.generateDeduplicationKey(event)
 
-	// Store with a shorter TTL for deduplication (e.g., 24 hours)
-	deduplicationTTL := 24 * time.Hour
-
-	err := r.client.Set(r.ctx, key, event.EventID, deduplicationTTL).Err()
+	// The dedup TTL is the window in which redeliveries are skipped
+	err := r.dedup.Set(r.ctx, key, event.EventID, r.cfg.RedisDedupTTL).Err()
 	if err != nil {
 		return fmt.Errorf("failed to mark as processed: %w", err)
 	}
@@ -174,9 +303,102 @@ func (r *RedisClient) MarkAsProcessed(event *LogEvent) error {
 	return nil
 }
 
+// CheckDuplicationMulti checks several events in one pipelined round-trip.
+// The result has one entry per event.
+func (r *RedisClient) CheckDuplicationMulti(events []*LogEvent) ([]bool, error) {
+	pipe := r.dedup.Pipeline()
+	cmds := make([]*redis.IntCmd, len(events))
+	for i, event := range events {
+		cmds[i] = pipe.Exists(r.ctx, r.generateDeduplicationKey(event))
+	}
+	if _, err := pipe.Exec(r.ctx); err != nil {
+		return nil, fmt.Errorf("failed to check duplication: %w", err)
+	}
+
+	duplicates := make([]bool, len(events))
+	for i, cmd := range cmds {
+		duplicates[i] = cmd.Val() > 0
+	}
+	return duplicates, nil
+}
+
+// MarkAsProcessedMulti marks several events as processed in one pipelined
+// round-trip.
+func (r *RedisClient) MarkAsProcessedMulti(events []*LogEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	pipe := r.dedup.Pipeline()
+	for _, event := range events {
+		pipe.Set(r.ctx, r.generateDeduplicationKey(event), event.EventID, r.cfg.RedisDedupTTL)
+	}
+	if _, err := pipe.Exec(r.ctx); err != nil {
+		return fmt.Errorf("failed to mark as processed: %w", err)
+	}
+	return nil
+}
+
+// ClaimMulti claims several events for this region in pipelined
+// round-trips. Claiming is atomic (SET NX), so of the collectors racing for
+// an event exactly one stores it. The result has one entry per event: empty
+// when this call claimed it, otherwise the region holding the claim.
+//
+// This assumes every collector claims against the same Redis primary. With
+// replicas written asynchronously, or an active-active setup replicating
+// between regions, two regions can both claim an event within the
+// replication lag, and claims not yet replicated are lost on failover; the
+// event is then stored twice.
+func (r *RedisClient) ClaimMulti(events []*LogEvent) ([]string, error) {
+	pipe := r.dedup.Pipeline()
+	cmds := make([]*redis.BoolCmd, len(events))
+	for i, event := range events {
+		cmds[i] = pipe.SetNX(r.ctx, r.generateDeduplicationKey(event), r.cfg.DedupRegion, r.cfg.RedisDedupTTL)
+	}
+	if _, err := pipe.Exec(r.ctx); err != nil {
+		return nil, fmt.Errorf("failed to claim events: %w", err)
+	}
+
+	claimedBy := make([]string, len(events))
+	var taken []int
+	for i, cmd := range cmds {
+		if !cmd.Val() {
+			taken = append(taken, i)
+		}
+	}
+	if len(taken) == 0 {
+		return claimedBy, nil
+	}
+
+	// Look up who holds the claims this call lost
+	pipe = r.dedup.Pipeline()
+	gets := make([]*redis.StringCmd, len(taken))
+	for j, i := range taken {
+		gets[j] = pipe.Get(r.ctx, r.generateDeduplicationKey(events[i]))
+	}
+	// Per-command errors, including expired claims, are inspected below.
+	pipe.Exec(r.ctx)
+	for j, i := range taken {
+		region, err := gets[j].Result()
+		if err != nil || region == "" {
+			region = "unknown"
+		}
+		claimedBy[i] = region
+	}
+	return claimedBy, nil
+}
+
+// UnmarkProcessed forgets that an event was processed, so that its
+// redelivery is not skipped as a duplicate.
+func (r *RedisClient) UnmarkProcessed(event *LogEvent) error {
+	if err := r.dedup.Del(r.ctx, r.generateDeduplicationKey(event)).Err(); err != nil {
+		return fmt.Errorf("failed to unmark as processed: %w", err)
+	}
+	return nil
+}
+
 // IncrementBatchCounter increments the batch processing counter
 func (r *RedisClient) IncrementBatchCounter(service string) error {
-	key := fmt.Sprintf("collector:batch_count:%s", service)
+	key := r.buildKey("batch_count", service)
 
 	err := r.client.Incr(r.ctx, key).Err()
 	if err != nil {
@@ -191,7 +413,7 @@ func (r *RedisClient) IncrementBatchCounter(service string) error {
 
 // GetBatchCounter gets the current batch processing count for a service
 func (r *RedisClient) GetBatchCounter(service string) (int64, error) {
-	key := fmt.Sprintf("collector:batch_count:%s", service)
+	key := r.buildKey("batch_count", service)
 
 	count, err := r.client.Get(r.ctx, key).Int64()
 	if err != nil {
@@ -211,7 +433,7 @@ func (r *RedisClient) CacheConfiguration(key string, value interface{}) error {
 		return fmt.Errorf("failed to marshal configuration: %w", err)
 	}
 
-	configKey := fmt.Sprintf("collector:config:%s", key)
+	configKey := r.buildKey("config", key)
 	err = r.client.Set(r.ctx, configKey, data, r.cfg.RedisTTL).Err()
 	if err != nil {
 		return fmt.Errorf("failed to cache configuration: %w", err)
@@ -222,7 +444,7 @@ func (r *RedisClient) CacheConfiguration(key string, value interface{}) error {
 
 // GetCachedConfiguration retrieves runtime configuration from Redis
 func (r *RedisClient) GetCachedConfiguration(key string, dest interface{}) error {
-	configKey := fmt.Sprintf("collector:config:%s", key)
+	configKey := r.buildKey("config", key)
 
 	data, err := r.client.Get(r.ctx, configKey).Result()
 	if err != nil {
@@ -248,6 +470,10 @@ func (r *RedisClient) GetConnectionInfo() map[string]interface{} {
 		"min_idle":     r.cfg.RedisMinIdle,
 		"db":           r.cfg.RedisDB,
 		"ttl":          r.cfg.RedisTTL.String(),
+		"dedup_ttl":    r.cfg.RedisDedupTTL.String(),
+		"metadata_ttl": r.cfg.RedisMetadataTTL.String(),
+		"idle_timeout": r.cfg.RedisIdleTimeout.String(),
+		"max_lifetime": r.cfg.RedisConnMaxLifetime.String(),
 		"active_conns": stats.TotalConns,
 		"idle_conns":   stats.IdleConns,
 		"stale_conns":  stats.StaleConns,
@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"strings"
+	"testing"
+
+	"observability_hub/golang/internal/collector/config"
+)
+
+// redisKeys returns every kind of key a client with cfg builds for the
+// same service and event.
+func redisKeys(cfg *config.Config) []string {
+	r := &RedisClient{cfg: cfg}
+	event := &LogEvent{EventID: "8d0c2cf4-5a2f-4f43-9a8b-3f0a3a7b2f10", CorrelationID: "0b7e1d0e-9c47-4a43-8a57-2b0c7c9f7f21"}
+	return []string{
+		r.generateMetadataKey("checkout", "1.2.3", "production"),
+		r.generateDeduplicationKey(event),
+		r.buildKey("batch_count", "checkout"),
+		r.buildKey("config", "batch_size"),
+	}
+}
+
+func TestRedisKeyPrefixesDoNotCollide(t *testing.T) {
+	prefixes := []string{"", "staging", "production", "staging:eu"}
+	owner := make(map[string]string)
+	for _, prefix := range prefixes {
+		for _, key := range redisKeys(&config.Config{RedisKeyPrefix: prefix}) {
+			if other, ok := owner[key]; ok {
+				t.Errorf("key %s is built under prefix %q and %q", key, other, prefix)
+			}
+			owner[key] = prefix
+			if prefix != "" && !strings.HasPrefix(key, prefix+":collector:") {
+				t.Errorf("key %s is not namespaced with %q", key, prefix)
+			}
+		}
+	}
+}
+
+func TestRedisDedupNamespaceIsShared(t *testing.T) {
+	// Collectors with their own key prefix share the dedup keys of the
+	// namespace, and only those
+	staging := redisKeys(&config.Config{RedisKeyPrefix: "staging", DedupNamespace: "global"})
+	production := redisKeys(&config.Config{RedisKeyPrefix: "production", DedupNamespace: "global"})
+	for i := range staging {
+		shared := staging[i] == production[i]
+		if dedup := i == 1; shared != dedup {
+			t.Errorf("keys %s and %s: shared = %t, want %t", staging[i], production[i], shared, dedup)
+		}
+	}
+	if !strings.HasPrefix(staging[1], "global:collector:dedup:") {
+		t.Errorf("dedup key = %s, want it in the global namespace", staging[1])
+	}
+}
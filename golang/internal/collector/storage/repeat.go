@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"observability_hub/golang/internal/collector/metrics"
+	"sync"
+	"time"
+)
+
+// Repeat suppression keys.
+const (
+	RepeatKeyTemplate = "template"
+	RepeatKeyMessage  = "message"
+)
+
+// RepeatInfo describes a run of identical consecutive messages collapsed
+// into one stored event. The event keeps the first occurrence's timestamp.
+type RepeatInfo struct {
+	Count          int       `json:"count"`
+	FirstTimestamp time.Time `json:"firstTimestamp"`
+	LastTimestamp  time.Time `json:"lastTimestamp"`
+}
+
+// pendingRepeat is the held first event of a run and the run's state.
+type pendingRepeat struct {
+	key      string
+	event    *LogEvent
+	count    int
+	last     time.Time
+	deadline time.Time
+}
+
+// repeatSuppressor collapses identical consecutive messages of a service,
+// like syslog's "message repeated N times". The first event of a run is
+// held until a different message arrives for the service or the window
+// since the first occurrence elapses; later repeats only update the count.
+type repeatSuppressor struct {
+	mu      sync.Mutex
+	window  time.Duration
+	keyBy   string
+	pending map[string]*pendingRepeat // by service
+}
+
+func newRepeatSuppressor(window time.Duration, keyBy string) *repeatSuppressor {
+	return &repeatSuppressor{
+		window:  window,
+		keyBy:   keyBy,
+		pending: make(map[string]*pendingRepeat),
+	}
+}
+
+// add takes ownership of an event: it either extends the service's current
+// run or starts a new one. The run the event ended, if any, is returned
+// ready to be stored.
+func (r *repeatSuppressor) add(event *LogEvent, now time.Time) (completed *LogEvent) {
+	key := r.key(event)
+	service := event.Source.Service
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if p, ok := r.pending[service]; ok {
+		if p.key == key && now.Before(p.deadline) {
+			p.count++
+			p.last = event.Timestamp
+			metrics.RepeatsSuppressed.Inc()
+			return nil
+		}
+		completed = p.complete()
+	}
+
+	r.pending[service] = &pendingRepeat{
+		key:      key,
+		event:    event,
+		count:    1,
+		last:     event.Timestamp,
+		deadline: now.Add(r.window),
+	}
+	return completed
+}
+
+// expired returns the runs whose window has elapsed.
+func (r *repeatSuppressor) expired(now time.Time) []*LogEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var events []*LogEvent
+	for service, p := range r.pending {
+		if !now.Before(p.deadline) {
+			events = append(events, p.complete())
+			delete(r.pending, service)
+		}
+	}
+	return events
+}
+
+// drain returns all held runs, e.g. on shutdown.
+func (r *repeatSuppressor) drain() []*LogEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	events := make([]*LogEvent, 0, len(r.pending))
+	for service, p := range r.pending {
+		events = append(events, p.complete())
+		delete(r.pending, service)
+	}
+	return events
+}
+
+func (r *repeatSuppressor) key(event *LogEvent) string {
+	text := event.Data.Message
+	if r.keyBy == RepeatKeyTemplate && event.Data.MessageTemplate != "" {
+		text = event.Data.MessageTemplate
+	}
+	return event.Data.Level + "\x00" + text
+}
+
+// complete returns the held event, annotated when it stands for a run.
+func (p *pendingRepeat) complete() *LogEvent {
+	if p.count > 1 {
+		p.event.Data.Repeat = &RepeatInfo{
+			Count:          p.count,
+			FirstTimestamp: p.event.Timestamp,
+			LastTimestamp:  p.last,
+		}
+	}
+	return p.event
+}
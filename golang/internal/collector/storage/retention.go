@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"observability_hub/golang/internal/collector/config"
+	"observability_hub/golang/internal/collector/metrics"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// expiresAt returns the logs.expires_at of an event: its timestamp plus
+// the retention of the first matching rule, or of RETENTION_DEFAULT. It
+// is nil, never expiring, when neither applies.
+func expiresAt(cfg *config.Config, event *LogEvent) interface{} {
+	retain := cfg.RetentionDefault
+	for _, rule := range cfg.RetentionRules {
+		var value string
+		switch rule.Field {
+		case config.RetentionFieldLevel:
+			value = event.Data.Level
+		case config.RetentionFieldService:
+			value = event.Source.Service
+		case config.RetentionFieldPriority:
+			value = event.Metadata.Priority
+		}
+		if value == rule.Value {
+			retain = rule.Retain
+			break
+		}
+	}
+	if retain <= 0 {
+		return nil
+	}
+	return event.Timestamp.Add(retain)
+}
+
+// sweepExpired deletes expired events every RETENTION_INTERVAL until the
+// storage is closed. Expiry is decided at ingest, so the sweep only reads
+// the expires_at index; rules changed later apply to new events only.
+func (s *DBStorage) sweepExpired() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.cfg.RetentionInterval)
+	defer ticker.Stop()
+
+	for {
+		deleted := 0
+		for {
+			n, err := s.deleteExpired(s.ctx)
+			if err != nil {
+				if s.ctx.Err() == nil {
+					s.logger.Warn("Failed to delete expired events", zap.Error(err))
+				}
+				break
+			}
+			deleted += n
+			if n < s.cfg.RetentionDeleteBatch {
+				break
+			}
+		}
+		if deleted > 0 {
+			s.logger.Info("Deleted expired events", zap.Int("count", deleted))
+		}
+
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// deleteExpired deletes up to RETENTION_DELETE_BATCH expired events,
+// keeping each statement short. Rows locked by another collector's sweep
+// are skipped. The row is identified by its table as well as its ctid,
+// which is unique only within one partition.
+func (s *DBStorage) deleteExpired(ctx context.Context) (int, error) {
+	res, err := s.db.ExecContext(ctx,
+		`DELETE FROM logs WHERE (tableoid, ctid) IN (
+			SELECT tableoid, ctid FROM logs WHERE expires_at < now() LIMIT $1 FOR UPDATE SKIP LOCKED
+		)`, s.cfg.RetentionDeleteBatch)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired events: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	metrics.RetentionDeleted.Add(float64(n))
+	return int(n), nil
+}
@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// replacementChar replaces invalid text.
+const replacementChar = "�"
+
+// validText reports whether Postgres accepts s in text and jsonb columns:
+// valid UTF-8 without NUL characters. JSON decoding already replaces
+// invalid UTF-8, but "\u0000" escapes decode to NUL, which Postgres
+// rejects, failing the whole COPY batch.
+func validText(s string) bool {
+	return utf8.ValidString(s) && !strings.Contains(s, "\x00")
+}
+
+// cleanText replaces invalid UTF-8 sequences and NUL characters in s.
+func cleanText(s string) string {
+	return strings.ReplaceAll(strings.ToValidUTF8(s, replacementChar), "\x00", replacementChar)
+}
+
+// SanitizeText counts the string fields of event that Postgres would
+// reject. With fix set, those fields are repaired in place by replacing the
+// offending bytes with U+FFFD.
+func SanitizeText(event *LogEvent, fix bool) int {
+	invalid := 0
+	check := func(s *string) {
+		if s == nil || validText(*s) {
+			return
+		}
+		invalid++
+		if fix {
+			*s = cleanText(*s)
+		}
+	}
+
+	check(&event.EventID)
+	check(&event.EventType)
+	check(&event.CorrelationID)
+	check(&event.Source.Service)
+	check(&event.Source.Version)
+	check(&event.Data.Level)
+	check(&event.Data.Message)
+	if c := event.Data.Context; c != nil {
+		check(c.UserID)
+		check(c.SessionID)
+		check(c.RequestID)
+		check(c.Operation)
+		check(c.Component)
+	}
+	if e := event.Data.Error; e != nil {
+		check(e.Type)
+		check(e.Code)
+		check(e.Stack)
+		check(e.Cause)
+		check(e.Fingerprint)
+	}
+	for i := range event.Metadata.Tags {
+		check(&event.Metadata.Tags[i])
+	}
+	if event.Data.Structured != nil {
+		invalid += sanitizeValue(map[string]interface{}(*event.Data.Structured), fix)
+	}
+	return invalid
+}
+
+// sanitizeValue counts, and with fix repairs, invalid strings in a decoded
+// JSON value, including object keys.
+func sanitizeValue(v interface{}, fix bool) int {
+	invalid := 0
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			if s, ok := item.(string); ok {
+				if !validText(s) {
+					invalid++
+					if fix {
+						v[key] = cleanText(s)
+					}
+				}
+			} else {
+				invalid += sanitizeValue(item, fix)
+			}
+			if !validText(key) {
+				invalid++
+				if fix {
+					v[cleanText(key)] = v[key]
+					delete(v, key)
+				}
+			}
+		}
+	case []interface{}:
+		for i, item := range v {
+			if s, ok := item.(string); ok {
+				if !validText(s) {
+					invalid++
+					if fix {
+						v[i] = cleanText(s)
+					}
+				}
+			} else {
+				invalid += sanitizeValue(item, fix)
+			}
+		}
+	}
+	return invalid
+}
@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// invalidTexts are strings Postgres rejects in text and jsonb columns.
+var invalidTexts = []struct {
+	name string
+	text string
+}{
+	{"stray continuation byte", "order \x80 placed"},
+	{"invalid byte", "order \xff placed"},
+	{"truncated two-byte sequence", "order \xc3"},
+	{"truncated three-byte sequence", "total \xe2\x82 due"},
+	{"overlong encoding", "path \xc0\xaf etc"},
+	{"UTF-16 surrogate", "emoji \xed\xa0\x80 half"},
+	{"five-byte sequence", "\xf8\x88\x80\x80\x80"},
+	{"Latin-1", "caf\xe9"},
+	{"NUL", "order\x00placed"},
+}
+
+// assertStorable fails the test unless Postgres accepts s.
+func assertStorable(t *testing.T, what, s string) {
+	t.Helper()
+	if !utf8.ValidString(s) || strings.Contains(s, "\x00") {
+		t.Errorf("%s = %q, still invalid", what, s)
+	}
+}
+
+func TestSanitizeTextRepairsInvalidText(t *testing.T) {
+	for _, tt := range invalidTexts {
+		t.Run(tt.name, func(t *testing.T) {
+			event := &LogEvent{EventID: "e-1"}
+			event.Data.Message = tt.text
+			event.Source.Service = tt.text
+			event.Metadata.Tags = []string{"valid", tt.text}
+
+			if n := SanitizeText(event, true); n != 3 {
+				t.Errorf("SanitizeText = %d, want the 3 invalid fields", n)
+			}
+			for what, s := range map[string]string{"message": event.Data.Message, "service": event.Source.Service, "tag": event.Metadata.Tags[1]} {
+				assertStorable(t, what, s)
+				if !strings.Contains(s, replacementChar) {
+					t.Errorf("%s = %q, want the invalid bytes replaced by U+FFFD", what, s)
+				}
+			}
+			if event.EventID != "e-1" || event.Metadata.Tags[0] != "valid" {
+				t.Error("valid fields were changed")
+			}
+			// The repaired event is valid
+			if n := SanitizeText(event, true); n != 0 {
+				t.Errorf("SanitizeText of the repaired event = %d, want 0", n)
+			}
+		})
+	}
+}
+
+func TestSanitizeTextRejectOnlyCounts(t *testing.T) {
+	event := &LogEvent{}
+	event.Data.Message = "caf\xe9"
+	if n := SanitizeText(event, false); n != 1 {
+		t.Errorf("SanitizeText = %d, want 1", n)
+	}
+	if event.Data.Message != "caf\xe9" {
+		t.Errorf("message = %q, want it left as it was", event.Data.Message)
+	}
+}
+
+func TestSanitizeTextRepairsStructured(t *testing.T) {
+	structured := JSONB{
+		"user":       "caf\xe9",
+		"bad\xffkey": "value",
+		"nested": map[string]interface{}{
+			"items": []interface{}{"ok", "order\x00placed", 3.0},
+		},
+		"count": 2.0,
+	}
+	event := &LogEvent{}
+	event.Data.Structured = &structured
+
+	if n := SanitizeText(event, true); n != 3 {
+		t.Errorf("SanitizeText = %d, want 3", n)
+	}
+	for key, value := range structured {
+		assertStorable(t, "key", key)
+		if s, ok := value.(string); ok {
+			assertStorable(t, key, s)
+		}
+	}
+	if _, ok := structured["bad"+replacementChar+"key"]; !ok {
+		t.Errorf("structured = %v, want the key repaired", structured)
+	}
+	items := structured["nested"].(map[string]interface{})["items"].([]interface{})
+	assertStorable(t, "nested item", items[1].(string))
+	if items[0] != "ok" || items[2] != 3.0 || structured["count"] != 2.0 {
+		t.Errorf("structured = %v, want valid values left alone", structured)
+	}
+}
+
+func TestSanitizeTextLeavesValidText(t *testing.T) {
+	event := &LogEvent{EventID: "e-1"}
+	event.Data.Message = "Sipariş alındı ✓ 🚀"
+	if n := SanitizeText(event, true); n != 0 || event.Data.Message != "Sipariş alındı ✓ 🚀" {
+		t.Errorf("SanitizeText = %d, message %q, want valid text left alone", n, event.Data.Message)
+	}
+}
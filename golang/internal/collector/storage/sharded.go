@@ -0,0 +1,422 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"observability_hub/golang/internal/collector/config"
+	"observability_hub/golang/internal/collector/metrics"
+	"observability_hub/golang/internal/types"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ShardedDBStorage spreads events over several Postgres databases. Every
+// shard has its own buffer and batch processor, so a shard that is down
+// only holds up the events routed to it.
+type ShardedDBStorage struct {
+	shards  []*shard
+	key     string
+	ids     IDGenerator
+	connect func(ctx context.Context, cfg *config.Config, logger *zap.Logger) (*DBStorage, error)
+	logger  *zap.Logger
+
+	// Settings applied after startup, passed on to shards connecting later
+	mu     sync.Mutex
+	outbox WriteFunc
+	tuned  *config.Config
+	closed bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// shard is one database of a ShardedDBStorage. It has no storage while
+// the database is unavailable; the events routed to it are then rejected
+// until a reconnect succeeds.
+type shard struct {
+	name string
+	cfg  *config.Config
+
+	mu    sync.RWMutex
+	store *DBStorage
+	err   error // Why the last connection attempt failed
+}
+
+// storage returns the shard's storage, nil while it is unavailable.
+func (sh *shard) storage() *DBStorage {
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+	return sh.store
+}
+
+// NewEventStore returns a ShardedDBStorage when shard URLs are configured
+// and a plain DBStorage otherwise.
+func NewEventStore(ctx context.Context, cfg *config.Config, logger *zap.Logger, redis *RedisClient) (EventStore, error) {
+	if len(cfg.PostgresShardURLs) == 0 {
+		return NewDBStorageWithRedis(ctx, cfg, logger, redis)
+	}
+	return NewShardedDBStorage(ctx, cfg, logger, redis)
+}
+
+// NewShardedDBStorage connects to every shard in cfg.PostgresShardURLs.
+// Shards are numbered by their position in the list, so the order must not
+// change while data is routed to them. A shard that cannot be reached is
+// reported unhealthy and reconnected every SHARD_RECONNECT_INTERVAL; only
+// if none can be reached does it fail.
+func NewShardedDBStorage(ctx context.Context, cfg *config.Config, logger *zap.Logger, redis *RedisClient) (*ShardedDBStorage, error) {
+	return newShardedDBStorage(ctx, cfg, logger, func(ctx context.Context, cfg *config.Config, logger *zap.Logger) (*DBStorage, error) {
+		return NewDBStorageWithRedis(ctx, cfg, logger, redis)
+	})
+}
+
+func newShardedDBStorage(ctx context.Context, cfg *config.Config, logger *zap.Logger,
+	connect func(ctx context.Context, cfg *config.Config, logger *zap.Logger) (*DBStorage, error)) (*ShardedDBStorage, error) {
+	s := &ShardedDBStorage{
+		key:     cfg.ShardKey,
+		ids:     UUIDGenerator{},
+		connect: connect,
+		logger:  logger.Named("shards"),
+	}
+	s.ctx, s.cancel = context.WithCancel(ctx)
+
+	var errs []error
+	for i, url := range cfg.PostgresShardURLs {
+		shardCfg := *cfg
+		shardCfg.PostgresURL = url
+		sh := &shard{name: strconv.Itoa(i), cfg: &shardCfg}
+		s.shards = append(s.shards, sh)
+		if err := s.connectShard(sh); err != nil {
+			errs = append(errs, fmt.Errorf("shard %s: %w", sh.name, err))
+		}
+	}
+	if len(errs) == len(s.shards) {
+		s.Close()
+		return nil, errors.Join(errs...)
+	}
+
+	for _, sh := range s.shards {
+		if sh.storage() == nil {
+			s.logger.Warn("Shard unavailable, reconnecting in the background",
+				zap.String("shard", sh.name),
+				zap.Error(sh.err),
+				zap.Duration("interval", cfg.ShardReconnectInterval))
+			s.wg.Add(1)
+			go s.reconnect(sh, cfg.ShardReconnectInterval)
+		}
+	}
+
+	s.logger.Info("Sharded storage initialized",
+		zap.Int("shards", len(s.shards)),
+		zap.Int("unavailable", len(errs)),
+		zap.String("shard_key", s.key))
+
+	return s, nil
+}
+
+// connectShard connects a shard and applies the settings changed since
+// startup.
+func (s *ShardedDBStorage) connectShard(sh *shard) error {
+	store, err := s.connect(s.ctx, sh.cfg, s.logger.With(zap.String("shard", sh.name)))
+	if err != nil {
+		metrics.ShardUp.WithLabelValues(sh.name).Set(0)
+		sh.mu.Lock()
+		sh.err = err
+		sh.mu.Unlock()
+		return err
+	}
+	store.shard = sh.name
+	store.enqueueTimeout = sh.cfg.ShardEnqueueTimeout
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		store.Close()
+		return ErrStorageClosed
+	}
+	store.SetIDGenerator(s.ids)
+	if s.tuned != nil {
+		store.Reconfigure(s.tuned)
+	}
+	if s.outbox != nil {
+		store.StartOutboxRelay(s.outbox)
+	}
+	sh.mu.Lock()
+	sh.store, sh.err = store, nil
+	sh.mu.Unlock()
+	metrics.ShardUp.WithLabelValues(sh.name).Set(1)
+	return nil
+}
+
+// reconnect retries connecting an unavailable shard until it succeeds or
+// the storage is closed.
+func (s *ShardedDBStorage) reconnect(sh *shard, interval time.Duration) {
+	defer s.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.connectShard(sh); err != nil {
+				s.logger.Debug("Shard still unavailable", zap.String("shard", sh.name), zap.Error(err))
+				continue
+			}
+			s.logger.Info("Shard reconnected", zap.String("shard", sh.name))
+			return
+		}
+	}
+}
+
+// connected returns the storages of the shards that are connected.
+func (s *ShardedDBStorage) connected() []*DBStorage {
+	stores := make([]*DBStorage, 0, len(s.shards))
+	for _, sh := range s.shards {
+		if store := sh.storage(); store != nil {
+			stores = append(stores, store)
+		}
+	}
+	return stores
+}
+
+// AddToBatch routes an event to its shard.
+func (s *ShardedDBStorage) AddToBatch(event *LogEvent) error {
+	return s.AddBatch([]*LogEvent{event})
+}
+
+// AddBatch routes events to their shards. Synthetic IDs are assigned first
+// because the correlation ID may be the routing key. The shards are handed
+// their events at once, so that one waiting out a full buffer does not
+// hold up the others. Events of a shard that stayed full or is unavailable
+// are reported in a *RejectedError; the other shards still accept theirs.
+func (s *ShardedDBStorage) AddBatch(events []*LogEvent) error {
+	byShard := make(map[*shard][]*LogEvent)
+	for _, event := range events {
+		assignSyntheticIDs(s.ids, event)
+		sh := s.route(event)
+		byShard[sh] = append(byShard[sh], event)
+	}
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		rejected []*LogEvent
+		errs     []error
+	)
+	reject := func(sh *shard, shardEvents []*LogEvent, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		var rejectedErr *RejectedError
+		if errors.As(err, &rejectedErr) {
+			rejected = append(rejected, rejectedErr.Events...)
+		} else {
+			rejected = append(rejected, shardEvents...)
+		}
+		errs = append(errs, fmt.Errorf("shard %s: %w", sh.name, err))
+	}
+	for sh, shardEvents := range byShard {
+		store := sh.storage()
+		if store == nil {
+			reject(sh, shardEvents, ErrShardUnavailable)
+			continue
+		}
+		wg.Add(1)
+		go func(sh *shard, shardEvents []*LogEvent) {
+			defer wg.Done()
+			if err := store.AddBatch(shardEvents); err != nil {
+				reject(sh, shardEvents, err)
+			}
+		}(sh, shardEvents)
+	}
+	wg.Wait()
+	if len(rejected) > 0 {
+		return &RejectedError{Events: rejected, Err: errors.Join(errs...)}
+	}
+	return nil
+}
+
+// AddMetrics writes metrics events to the shards their key routes them to.
+// On error the events of the other shards may have been committed; their
+// redeliveries are skipped as already stored.
+func (s *ShardedDBStorage) AddMetrics(ctx context.Context, events []*types.MetricsEvent) error {
+	byShard := make(map[*shard][]*types.MetricsEvent)
+	for _, event := range events {
+		sh := s.routeKey(event.Source.Service, event.CorrelationID)
+		byShard[sh] = append(byShard[sh], event)
+	}
+
+	var errs []error
+	for sh, shardEvents := range byShard {
+		store := sh.storage()
+		if store == nil {
+			errs = append(errs, fmt.Errorf("shard %s: %w", sh.name, ErrShardUnavailable))
+			continue
+		}
+		if err := store.AddMetrics(ctx, shardEvents); err != nil {
+			errs = append(errs, fmt.Errorf("shard %s: %w", sh.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// route picks the shard of an event by hashing the configured key.
+func (s *ShardedDBStorage) route(event *LogEvent) *shard {
+	return s.routeKey(event.Source.Service, event.CorrelationID)
+}
+
+// routeKey picks the shard of an event of the given service and
+// correlation ID.
+func (s *ShardedDBStorage) routeKey(service, correlationID string) *shard {
+	value := service
+	if s.key == "correlation_id" {
+		value = correlationID
+	}
+	h := fnv.New32a()
+	h.Write([]byte(value))
+	return s.shards[h.Sum32()%uint32(len(s.shards))]
+}
+
+// SetIDGenerator replaces the generator used for synthetic IDs.
+func (s *ShardedDBStorage) SetIDGenerator(ids IDGenerator) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ids = ids
+	for _, store := range s.connected() {
+		store.SetIDGenerator(ids)
+	}
+}
+
+// StartOutboxRelay starts relaying the outbox of every shard, including
+// those connecting later.
+func (s *ShardedDBStorage) StartOutboxRelay(write WriteFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.outbox = write
+	for _, store := range s.connected() {
+		store.StartOutboxRelay(write)
+	}
+}
+
+// Shed releases memory on all shards.
+func (s *ShardedDBStorage) Shed() {
+	for _, store := range s.connected() {
+		store.Shed()
+	}
+}
+
+// BufferDepth returns the number of events waiting to be batched across
+// all shards.
+func (s *ShardedDBStorage) BufferDepth() int {
+	depth := 0
+	for _, store := range s.connected() {
+		depth += store.BufferDepth()
+	}
+	return depth
+}
+
+// BufferCapacity returns the buffer capacity summed over all shards. An
+// unavailable shard counts with the capacity it will have, so that it
+// does not make the others look fuller.
+func (s *ShardedDBStorage) BufferCapacity() int {
+	capacity := 0
+	for _, sh := range s.shards {
+		if store := sh.storage(); store != nil {
+			capacity += store.BufferCapacity()
+		} else {
+			capacity += bufferCapacity(sh.cfg.BatchSize, sh.cfg.WorkerBatchSize)
+		}
+	}
+	return capacity
+}
+
+// ServerVersion returns the distinct Postgres versions of the shards, in
+// shard order and separated by commas.
+func (s *ShardedDBStorage) ServerVersion() string {
+	var versions []string
+	for _, store := range s.connected() {
+		if version := store.ServerVersion(); version != "" && !slices.Contains(versions, version) {
+			versions = append(versions, version)
+		}
+	}
+	return strings.Join(versions, ",")
+}
+
+// BatchProcessorHealth reports every shard that is unavailable or whose
+// batch processor stalled.
+func (s *ShardedDBStorage) BatchProcessorHealth() error {
+	var unhealthy []string
+	for _, sh := range s.shards {
+		sh.mu.RLock()
+		store, connectErr := sh.store, sh.err
+		sh.mu.RUnlock()
+		if store == nil {
+			unhealthy = append(unhealthy, fmt.Sprintf("shard %s: not connected: %v", sh.name, connectErr))
+		} else if err := store.BatchProcessorHealth(); err != nil {
+			unhealthy = append(unhealthy, fmt.Sprintf("shard %s: %v", sh.name, err))
+		}
+	}
+	if len(unhealthy) > 0 {
+		return fmt.Errorf("%s", strings.Join(unhealthy, "; "))
+	}
+	return nil
+}
+
+// LastFlush returns the oldest of the shards' last successful flushes, so
+// that a shard that stopped flushing is not hidden by the others.
+func (s *ShardedDBStorage) LastFlush() time.Time {
+	var oldest time.Time
+	for i, store := range s.connected() {
+		if last := store.LastFlush(); i == 0 || last.Before(oldest) {
+			oldest = last
+		}
+	}
+	return oldest
+}
+
+// FlushHealth reports every shard with events stuck waiting for a flush.
+// Unavailable shards hold no events; they are reported by
+// BatchProcessorHealth, so that they do not pause the intake of the others.
+func (s *ShardedDBStorage) FlushHealth() error {
+	var stale []string
+	for _, store := range s.connected() {
+		if err := store.FlushHealth(); err != nil {
+			stale = append(stale, fmt.Sprintf("shard %s: %v", store.shard, err))
+		}
+	}
+	if len(stale) > 0 {
+		return fmt.Errorf("%s", strings.Join(stale, "; "))
+	}
+	return nil
+}
+
+// Reconfigure applies the batching and retry settings of cfg to every
+// shard, including those connecting later.
+func (s *ShardedDBStorage) Reconfigure(cfg *config.Config) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tuned = cfg
+	for _, store := range s.connected() {
+		store.Reconfigure(cfg)
+	}
+}
+
+// Close stops reconnecting unavailable shards, then flushes and closes the
+// connected ones.
+func (s *ShardedDBStorage) Close() {
+	s.cancel()
+	s.wg.Wait()
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	for _, store := range s.connected() {
+		store.Close()
+	}
+}
@@ -0,0 +1,164 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"observability_hub/golang/internal/collector/config"
+
+	"go.uber.org/zap"
+)
+
+// shardedTestConfig returns the settings of a storage with the given
+// number of shards.
+func shardedTestConfig(shards int) *config.Config {
+	cfg := testConfig()
+	cfg.ShardKey = "service"
+	cfg.ShardEnqueueTimeout = 100 * time.Millisecond
+	cfg.ShardReconnectInterval = 10 * time.Millisecond
+	cfg.BatchStallThreshold = time.Hour
+	for i := 0; i < shards; i++ {
+		cfg.PostgresShardURLs = append(cfg.PostgresShardURLs, fmt.Sprintf("postgres://shard%d", i))
+	}
+	return cfg
+}
+
+// fakeShards connects shards as test storages, failing for the URLs in
+// down until they are brought up.
+type fakeShards struct {
+	t    *testing.T
+	mu   sync.Mutex
+	down map[string]bool
+}
+
+func (f *fakeShards) connect(ctx context.Context, cfg *config.Config, logger *zap.Logger) (*DBStorage, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.down[cfg.PostgresURL] {
+		return nil, errors.New("failed to ping postgres: connection refused")
+	}
+	s := newTestStorage(f.t, nil, cfg)
+	s.heartbeat.Store(time.Now().UnixNano())
+	return s, nil
+}
+
+func (f *fakeShards) up(url string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.down, url)
+}
+
+// serviceOf returns a service name routed to the shard.
+func serviceOf(t *testing.T, s *ShardedDBStorage, shard string) string {
+	t.Helper()
+	for i := 0; i < 1000; i++ {
+		service := fmt.Sprintf("service-%d", i)
+		if s.route(&LogEvent{Source: Source{Service: service}}).name == shard {
+			return service
+		}
+	}
+	t.Fatalf("no service routed to shard %s", shard)
+	return ""
+}
+
+func TestShardedStorageStartsWithAShardDown(t *testing.T) {
+	cfg := shardedTestConfig(3)
+	shards := &fakeShards{t: t, down: map[string]bool{"postgres://shard1": true}}
+	s, err := newShardedDBStorage(context.Background(), cfg, zap.NewNop(), shards.connect)
+	if err != nil {
+		t.Fatalf("newShardedDBStorage with one of three shards down: %v", err)
+	}
+	t.Cleanup(s.Close)
+
+	if err := s.BatchProcessorHealth(); err == nil || !strings.Contains(err.Error(), "shard 1: not connected") {
+		t.Errorf("BatchProcessorHealth = %v, want shard 1 reported", err)
+	}
+	if err := s.FlushHealth(); err != nil {
+		t.Errorf("FlushHealth = %v, want the down shard not to pause the intake", err)
+	}
+
+	// The events of the down shard are rejected, the others accepted
+	up := &LogEvent{Source: Source{Service: serviceOf(t, s, "0")}}
+	down := &LogEvent{Source: Source{Service: serviceOf(t, s, "1")}}
+	err = s.AddBatch([]*LogEvent{up, down})
+	var rejected *RejectedError
+	if !errors.As(err, &rejected) || !errors.Is(err, ErrShardUnavailable) || len(rejected.Events) != 1 || rejected.Events[0] != down {
+		t.Fatalf("AddBatch error = %v, want the event of shard 1 rejected", err)
+	}
+	if depth := s.BufferDepth(); depth != 1 {
+		t.Errorf("buffer depth = %d, want 1", depth)
+	}
+
+	// Settings changed meanwhile apply once the shard connects
+	tuned := *cfg
+	tuned.BatchSize = 7
+	s.Reconfigure(&tuned)
+	shards.up("postgres://shard1")
+	deadline := time.Now().Add(5 * time.Second)
+	for s.BatchProcessorHealth() != nil {
+		if time.Now().After(deadline) {
+			t.Fatalf("shard 1 did not reconnect: %v", s.BatchProcessorHealth())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if err := s.AddBatch([]*LogEvent{down}); err != nil {
+		t.Errorf("AddBatch after the reconnect: %v", err)
+	}
+	if size := s.shards[1].storage().currentTuning().batchSize; size != 7 {
+		t.Errorf("reconnected shard batch size = %d, want 7", size)
+	}
+}
+
+func TestShardedStorageFailsWithAllShardsDown(t *testing.T) {
+	shards := &fakeShards{t: t, down: map[string]bool{"postgres://shard0": true, "postgres://shard1": true}}
+	if _, err := newShardedDBStorage(context.Background(), shardedTestConfig(2), zap.NewNop(), shards.connect); err == nil {
+		t.Fatal("newShardedDBStorage succeeded without any shard")
+	}
+}
+
+func TestShardedStorageEnqueuesToShardsConcurrently(t *testing.T) {
+	cfg := shardedTestConfig(3)
+	shards := &fakeShards{t: t}
+	s, err := newShardedDBStorage(context.Background(), cfg, zap.NewNop(), shards.connect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+
+	// Without batch processors nothing drains the buffers; fill those of
+	// shards 0 and 1
+	for _, sh := range s.shards[:2] {
+		store := sh.storage()
+		for i := 0; i < store.BufferCapacity(); i++ {
+			if err := store.AddToBatch(&LogEvent{}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	full0 := &LogEvent{Source: Source{Service: serviceOf(t, s, "0")}}
+	full1 := &LogEvent{Source: Source{Service: serviceOf(t, s, "1")}}
+	free := &LogEvent{Source: Source{Service: serviceOf(t, s, "2")}}
+	start := time.Now()
+	err = s.AddBatch([]*LogEvent{full0, full1, free})
+	elapsed := time.Since(start)
+
+	var rejected *RejectedError
+	if !errors.As(err, &rejected) || !errors.Is(err, ErrBufferFull) || len(rejected.Events) != 2 {
+		t.Fatalf("AddBatch error = %v, want the events of the full shards rejected", err)
+	}
+	for _, event := range rejected.Events {
+		if event == free {
+			t.Error("event of the free shard was rejected")
+		}
+	}
+	// The two full shards wait out their enqueue timeouts side by side
+	if elapsed < cfg.ShardEnqueueTimeout || elapsed >= 2*cfg.ShardEnqueueTimeout {
+		t.Errorf("AddBatch took %s, want about one enqueue timeout of %s", elapsed, cfg.ShardEnqueueTimeout)
+	}
+}
@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"observability_hub/golang/internal/collector/config"
+	"observability_hub/golang/internal/types"
+	"time"
+)
+
+// ErrBufferFull is the cause of a RejectedError when events could not be
+// enqueued in time.
+var ErrBufferFull = errors.New("batch buffer is full")
+
+// ErrStorageClosed is the cause of a RejectedError when events arrived
+// after the storage began shutting down.
+var ErrStorageClosed = errors.New("storage is shutting down")
+
+// ErrShardUnavailable is the cause of a RejectedError when events were
+// routed to a Postgres shard that is not connected.
+var ErrShardUnavailable = errors.New("shard is unavailable")
+
+// RejectedError reports the events of an AddBatch call that were not
+// stored and should be redelivered. The other events were accepted.
+type RejectedError struct {
+	Events []*LogEvent
+	Err    error
+}
+
+func (e *RejectedError) Error() string {
+	return fmt.Sprintf("%d events rejected: %v", len(e.Events), e.Err)
+}
+
+func (e *RejectedError) Unwrap() error {
+	return e.Err
+}
+
+// Storage is the primary storage backend, whose acceptance of an event
+// acknowledges its delivery: Postgres, as an EventStore, or ESStorage when
+// Elasticsearch is the only backend.
+type Storage interface {
+	AddToBatch(event *LogEvent) error
+	// AddBatch stores events, or accepts them into a batch that will be.
+	// The events it did not accept are listed in a *RejectedError or a
+	// *BulkError; any other error means none was accepted.
+	AddBatch(events []*LogEvent) error
+	// Close stores the accepted events still pending and releases the
+	// backend.
+	Close()
+}
+
+// MetricsStore stores metrics events. Only Postgres does.
+type MetricsStore interface {
+	AddMetrics(ctx context.Context, events []*types.MetricsEvent) error
+}
+
+// EventStore is the Postgres side of the pipeline: a single DBStorage or a
+// ShardedDBStorage spreading events over several databases.
+type EventStore interface {
+	Storage
+	MetricsStore
+	BufferDepth() int
+	BufferCapacity() int
+	ServerVersion() string
+	BatchProcessorHealth() error
+	LastFlush() time.Time
+	FlushHealth() error
+	SetIDGenerator(ids IDGenerator)
+	Shed()
+	StartOutboxRelay(write WriteFunc)
+	Reconfigure(cfg *config.Config)
+}
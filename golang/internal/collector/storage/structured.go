@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// truncatedFieldsKey lists the structured keys that were dropped by
+// trimStructured. When the whole list does not fit, it holds the first of
+// them in key order and truncatedCountKey holds how many were dropped.
+const (
+	truncatedFieldsKey = "_truncated_fields"
+	truncatedCountKey  = "_truncated_count"
+)
+
+// structuredField is a single key of a structured payload with its serialized cost.
+type structuredField struct {
+	key  string
+	size int
+}
+
+// fieldSize returns the serialized size of key and value as an object
+// member: "key":value plus the separating comma. Keys are measured as
+// encoded, with their escapes.
+func fieldSize(key string, value interface{}) (int, error) {
+	encodedKey, err := json.Marshal(key)
+	if err != nil {
+		return 0, err
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return 0, err
+	}
+	return len(encodedKey) + 1 + len(encoded) + 1, nil
+}
+
+// trimStructured reduces a structured payload so that it fits into maxBytes
+// of serialized JSON, including the record of what was dropped. Important
+// fields are kept first, in the configured order, then the remaining budget
+// is filled with the other fields, smallest first so that as many of them
+// as possible survive. The names of the dropped fields are recorded under
+// _truncated_fields, for which room is reserved up front. The boolean
+// result reports whether anything was dropped.
+func trimStructured(structured JSONB, maxBytes int, important []string) (JSONB, bool) {
+	sizes := make(map[string]int, len(structured))
+	total := 2 // surrounding braces
+	for key, value := range structured {
+		size, err := fieldSize(key, value)
+		if err != nil {
+			// Unserializable values are dropped the same way the full payload would fail.
+			size = maxBytes + 1
+		}
+		sizes[key] = size
+		total += size
+	}
+	if total-1 <= maxBytes { // the last member has no comma
+		return structured, false
+	}
+
+	// The count of dropped fields always fits in the reserve; the list
+	// takes what is left of the budget on top of it
+	reserve, _ := fieldSize(truncatedCountKey, len(structured))
+	budget := maxBytes - 2 - reserve
+	if budget < 0 {
+		// Too small for anything but an empty object
+		return JSONB{}, true
+	}
+
+	trimmed := make(JSONB, len(structured))
+	keep := func(key string) {
+		if size := sizes[key]; size <= budget {
+			trimmed[key] = structured[key]
+			budget -= size
+		}
+	}
+
+	isImportant := make(map[string]bool, len(important))
+	for _, key := range important {
+		isImportant[key] = true
+		if _, ok := structured[key]; ok {
+			keep(key)
+		}
+	}
+
+	others := make([]structuredField, 0, len(structured))
+	for key, size := range sizes {
+		if !isImportant[key] {
+			others = append(others, structuredField{key: key, size: size})
+		}
+	}
+	sort.Slice(others, func(i, j int) bool {
+		if others[i].size != others[j].size {
+			return others[i].size < others[j].size
+		}
+		return others[i].key < others[j].key
+	})
+	for _, field := range others {
+		keep(field.key)
+	}
+
+	var dropped []string
+	for key := range structured {
+		if _, ok := trimmed[key]; !ok {
+			dropped = append(dropped, key)
+		}
+	}
+	sort.Strings(dropped)
+	recordDropped(trimmed, dropped, budget+reserve)
+	return trimmed, true
+}
+
+// recordDropped adds the list of dropped keys to trimmed within room bytes,
+// which hold at least their count. A list that does not fit is cut short
+// and followed by the count.
+func recordDropped(trimmed JSONB, dropped []string, room int) {
+	if size, err := fieldSize(truncatedFieldsKey, dropped); err == nil && size <= room {
+		trimmed[truncatedFieldsKey] = dropped
+		return
+	}
+
+	countSize, _ := fieldSize(truncatedCountKey, len(dropped))
+	trimmed[truncatedCountKey] = len(dropped)
+	room -= countSize
+
+	// "_truncated_fields":[...], grown one name at a time
+	size, _ := fieldSize(truncatedFieldsKey, []string{})
+	listed := 0
+	for _, key := range dropped {
+		encoded, err := json.Marshal(key)
+		if err != nil {
+			break
+		}
+		next := size + len(encoded)
+		if listed > 0 {
+			next++ // comma
+		}
+		if next > room {
+			break
+		}
+		size = next
+		listed++
+	}
+	if listed > 0 {
+		trimmed[truncatedFieldsKey] = dropped[:listed]
+	}
+}
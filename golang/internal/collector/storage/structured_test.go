@@ -0,0 +1,176 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// assertFits fails the test unless trimmed, with the record of the dropped
+// fields, serializes to at most maxBytes.
+func assertFits(t *testing.T, trimmed JSONB, maxBytes int) {
+	t.Helper()
+	encoded, err := json.Marshal(trimmed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(encoded) > maxBytes {
+		t.Errorf("trimmed payload takes %d bytes, beyond the limit of %d: %s", len(encoded), maxBytes, encoded)
+	}
+}
+
+func TestTrimStructuredKeepsImportantFields(t *testing.T) {
+	structured := JSONB{
+		"user_id":  "u-1842",
+		"order_id": "o-99812",
+		"payload":  strings.Repeat("x", 500),
+		"headers":  strings.Repeat("h", 300),
+		"attempt":  3,
+		"region":   "eu-west-1",
+	}
+	trimmed, dropped := trimStructured(structured, 120, []string{"order_id", "user_id"})
+	if !dropped {
+		t.Fatal("nothing was dropped from an oversized payload")
+	}
+	for _, key := range []string{"order_id", "user_id"} {
+		if trimmed[key] != structured[key] {
+			t.Errorf("important field %s = %v, want %v", key, trimmed[key], structured[key])
+		}
+	}
+	// The remaining budget goes to the small fields
+	if trimmed["attempt"] != 3 || trimmed["region"] != "eu-west-1" {
+		t.Errorf("small fields not kept: %v", trimmed)
+	}
+	if want := []string{"headers", "payload"}; !reflect.DeepEqual(trimmed[truncatedFieldsKey], want) {
+		t.Errorf("%s = %v, want %v", truncatedFieldsKey, trimmed[truncatedFieldsKey], want)
+	}
+	assertFits(t, trimmed, 120)
+}
+
+func TestTrimStructuredImportantFieldsWinOverSmallerOnes(t *testing.T) {
+	structured := JSONB{
+		"trace": strings.Repeat("t", 60),
+		"a":     strings.Repeat("a", 10),
+		"b":     strings.Repeat("b", 10),
+		"c":     strings.Repeat("c", 10),
+	}
+	// Being important, trace takes the budget before the smaller fields,
+	// leaving room for one of them
+	trimmed, dropped := trimStructured(structured, 120, []string{"trace"})
+	if !dropped || trimmed["trace"] != structured["trace"] {
+		t.Fatalf("trimmed = %v, want trace kept", trimmed)
+	}
+	if want := []string{"b", "c"}; !reflect.DeepEqual(trimmed[truncatedFieldsKey], want) {
+		t.Errorf("%s = %v, want %v", truncatedFieldsKey, trimmed[truncatedFieldsKey], want)
+	}
+	assertFits(t, trimmed, 120)
+}
+
+func TestTrimStructuredImportantFieldsInOrder(t *testing.T) {
+	structured := JSONB{
+		"first":  strings.Repeat("1", 40),
+		"second": strings.Repeat("2", 40),
+	}
+	// Room for one of them: the one listed first
+	trimmed, _ := trimStructured(structured, 100, []string{"second", "first", "absent"})
+	if _, ok := trimmed["second"]; !ok {
+		t.Errorf("trimmed = %v, want the first listed important field", trimmed)
+	}
+	if want := []string{"first"}; !reflect.DeepEqual(trimmed[truncatedFieldsKey], want) {
+		t.Errorf("%s = %v, want %v", truncatedFieldsKey, trimmed[truncatedFieldsKey], want)
+	}
+	if _, ok := trimmed["absent"]; ok {
+		t.Error("missing important field was added")
+	}
+	assertFits(t, trimmed, 100)
+}
+
+func TestTrimStructuredCapsTheDroppedFieldList(t *testing.T) {
+	structured := JSONB{"order_id": "o-99812"}
+	for i := 0; i < 200; i++ {
+		structured[fmt.Sprintf("attribute_%03d", i)] = strings.Repeat("v", 50)
+	}
+	trimmed, dropped := trimStructured(structured, 300, []string{"order_id"})
+	if !dropped || trimmed["order_id"] != "o-99812" {
+		t.Fatalf("trimmed = %v, want order_id kept", trimmed)
+	}
+	assertFits(t, trimmed, 300)
+
+	// The list is cut short; the count tells how many were dropped
+	kept := len(trimmed) - 2 - 1 // order_id and the two records
+	listed, _ := trimmed[truncatedFieldsKey].([]string)
+	if count := trimmed[truncatedCountKey]; count != len(structured)-1-kept {
+		t.Errorf("%s = %v, want %d", truncatedCountKey, count, len(structured)-1-kept)
+	}
+	if len(listed) == 0 || len(listed) >= len(structured)-1-kept || listed[0] > listed[len(listed)-1] {
+		t.Errorf("%s = %v, want the first of the dropped keys", truncatedFieldsKey, listed)
+	}
+	for _, key := range listed {
+		if _, ok := trimmed[key]; ok {
+			t.Errorf("%s lists kept field %s", truncatedFieldsKey, key)
+		}
+	}
+}
+
+func TestTrimStructuredCountsEscapedKeys(t *testing.T) {
+	// Quotes, control characters and HTML characters take more room
+	// escaped than their length
+	structured := JSONB{
+		"\"quoted\"":       strings.Repeat("q", 20),
+		"<tag>&amp;":       strings.Repeat("h", 20),
+		"line\nbreak\ttab": strings.Repeat("l", 20),
+		"\x01\x02\x03\x04": strings.Repeat("c", 20),
+		"plain":            strings.Repeat("p", 20),
+	}
+	for maxBytes := 2; maxBytes <= 200; maxBytes++ {
+		trimmed, _ := trimStructured(structured, maxBytes, []string{"<tag>&amp;"})
+		assertFits(t, trimmed, maxBytes)
+	}
+}
+
+func TestTrimStructuredLeavesFittingPayloads(t *testing.T) {
+	structured := JSONB{"user_id": "u-1842", "attempt": 3}
+	trimmed, dropped := trimStructured(structured, 1024, []string{"user_id"})
+	if dropped || !reflect.DeepEqual(trimmed, structured) {
+		t.Errorf("trimStructured = %v, %t, want the payload unchanged", trimmed, dropped)
+	}
+}
+
+func TestPrepareEventDataTrimsStructured(t *testing.T) {
+	cfg := testConfig()
+	cfg.StructuredMaxBytes = 100
+	cfg.StructuredImportantFields = []string{"order_id"}
+	s := newTestStorage(t, nil, cfg)
+
+	structured := JSONB{"order_id": "o-99812", "payload": strings.Repeat("x", 200)}
+	event := &LogEvent{}
+	event.Data.Structured = &structured
+	_, _, structuredJSON, _ := s.prepareEventData(event)
+
+	var stored JSONB
+	if err := json.Unmarshal(structuredJSON, &stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored["order_id"] != "o-99812" {
+		t.Errorf("stored %s, want order_id kept", structuredJSON)
+	}
+	if _, ok := stored["payload"]; ok {
+		t.Errorf("stored %s, want payload dropped", structuredJSON)
+	}
+	if dropped, _ := stored[truncatedFieldsKey].([]any); len(dropped) != 1 || dropped[0] != "payload" {
+		t.Errorf("%s = %v, want [payload]", truncatedFieldsKey, stored[truncatedFieldsKey])
+	}
+
+	// Raw payloads kept by the streaming decoder are trimmed alike
+	event = &LogEvent{}
+	event.Data.StructuredRaw = json.RawMessage(`{"order_id":"o-1","payload":"` + strings.Repeat("y", 200) + `"}`)
+	_, _, structuredJSON, _ = s.prepareEventData(event)
+	if !strings.Contains(string(structuredJSON), `"order_id":"o-1"`) || strings.Contains(string(structuredJSON), "yyyy") {
+		t.Errorf("stored %s, want order_id kept and payload dropped", structuredJSON)
+	}
+	if len(structuredJSON) > cfg.StructuredMaxBytes {
+		t.Errorf("stored %d bytes, beyond the limit of %d", len(structuredJSON), cfg.StructuredMaxBytes)
+	}
+}
@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// templatePlaceholder replaces every variable token in a message template.
+const templatePlaceholder = "{}"
+
+// templateRules are the built-in tokenization rules, selectable by name.
+// Numbers swallow a trailing unit so that "12ms" and "3.5s" mask as one token.
+// More specific patterns must run before the generic ones they overlap with,
+// so the configured order is preserved when masking.
+var templateRules = map[string]*regexp.Regexp{
+	"uuid":   regexp.MustCompile(`\b[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}\b`),
+	"email":  regexp.MustCompile(`\b[\w.+-]+@[\w-]+(?:\.[\w-]+)+\b`),
+	"ip":     regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}(?::\d+)?\b`),
+	"hex":    regexp.MustCompile(`\b(?:0x[0-9a-fA-F]+|[0-9a-fA-F]{8,})\b`),
+	"quoted": regexp.MustCompile(`"[^"]*"|'[^']*'`),
+	"number": regexp.MustCompile(`\b\d+(?:\.\d+)?[a-zA-Z%]*`),
+}
+
+// MessageTemplater derives a stable template from a log message by masking
+// its variable tokens, e.g. "user 123 failed login" -> "user {} failed login".
+type MessageTemplater struct {
+	rules []*regexp.Regexp
+}
+
+// NewMessageTemplater creates a templater applying the named rules in order.
+func NewMessageTemplater(rules []string) (*MessageTemplater, error) {
+	t := &MessageTemplater{}
+	for _, name := range rules {
+		re, ok := templateRules[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown message template rule %q", name)
+		}
+		t.rules = append(t.rules, re)
+	}
+	return t, nil
+}
+
+// Template returns the message with all variable tokens replaced by {}.
+func (t *MessageTemplater) Template(message string) string {
+	for _, re := range t.rules {
+		message = re.ReplaceAllLiteralString(message, templatePlaceholder)
+	}
+	return message
+}
@@ -0,0 +1,68 @@
+package storage
+
+import "testing"
+
+func TestMessageTemplater(t *testing.T) {
+	templater, err := NewMessageTemplater([]string{"uuid", "email", "ip", "hex", "quoted", "number"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		message string
+		want    string
+	}{
+		{"user 123 failed login", "user {} failed login"},
+		{"request took 12ms, retried after 3.5s", "request took {}, retried after {}"},
+		{"disk at 97% on node 4", "disk at {} on node {}"},
+		{"order 8d0c2cf4-5a2f-4f43-9a8b-3f0a3a7b2f10 shipped", "order {} shipped"},
+		{"password reset sent to jane.doe+test@example.co.uk", "password reset sent to {}"},
+		{"connection from 10.0.12.7:54321 refused", "connection from {} refused"},
+		{"upstream 192.168.1.20 timed out", "upstream {} timed out"},
+		{"commit 3f9a2b7c1d deployed at 0xdeadbeef", "commit {} deployed at {}"},
+		{`unknown field "customer_ref" in 'orders'`, "unknown field {} in {}"},
+		{"payment declined", "payment declined"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := templater.Template(tt.message); got != tt.want {
+			t.Errorf("Template(%q) = %q, want %q", tt.message, got, tt.want)
+		}
+	}
+}
+
+func TestMessageTemplaterGroupsMessages(t *testing.T) {
+	templater, err := NewMessageTemplater([]string{"uuid", "email", "ip", "hex", "quoted", "number"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := templater.Template("user 17 from 10.1.1.1 bought 3 items in 250ms")
+	second := templater.Template("user 90210 from 172.16.0.4 bought 12 items in 1.2s")
+	if first != second {
+		t.Errorf("templates differ: %q and %q", first, second)
+	}
+}
+
+func TestMessageTemplaterRuleOrder(t *testing.T) {
+	// Run before the uuid rule, the number rule splits the UUID apart
+	message := "job 8d0c2cf4-5a2f-4f43-9a8b-3f0a3a7b2f10 failed"
+	numbersFirst, err := NewMessageTemplater([]string{"number", "uuid"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := numbersFirst.Template(message); got == "job {} failed" {
+		t.Errorf("Template(%q) = %q with number before uuid", message, got)
+	}
+	uuidFirst, err := NewMessageTemplater([]string{"uuid", "number"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := uuidFirst.Template(message); got != "job {} failed" {
+		t.Errorf("Template(%q) = %q, want %q", message, got, "job {} failed")
+	}
+}
+
+func TestNewMessageTemplaterRejectsUnknownRules(t *testing.T) {
+	if _, err := NewMessageTemplater([]string{"uuid", "phone"}); err == nil {
+		t.Error("NewMessageTemplater accepted an unknown rule")
+	}
+}
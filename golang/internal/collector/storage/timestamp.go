@@ -0,0 +1,21 @@
+package storage
+
+import "time"
+
+// clampTimestamp limits ts to the window [now-maxPast, now+maxFuture].
+// A zero bound leaves that side of the window open. The second result
+// reports which side was clamped ("past" or "future"), or "" if ts was
+// already inside the window.
+func clampTimestamp(ts, now time.Time, maxPast, maxFuture time.Duration) (time.Time, string) {
+	if maxPast > 0 {
+		if earliest := now.Add(-maxPast); ts.Before(earliest) {
+			return earliest, "past"
+		}
+	}
+	if maxFuture > 0 {
+		if latest := now.Add(maxFuture); ts.After(latest) {
+			return latest, "future"
+		}
+	}
+	return ts, ""
+}
@@ -0,0 +1,35 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClampTimestamp(t *testing.T) {
+	now := time.Date(2026, 10, 31, 23, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		ts        time.Time
+		maxPast   time.Duration
+		maxFuture time.Duration
+		want      time.Time
+		clamped   string
+	}{
+		{"inside the window", now.Add(-time.Hour), 24 * time.Hour, time.Hour, now.Add(-time.Hour), ""},
+		{"far future", time.Date(2099, 1, 1, 0, 0, 0, 0, time.UTC), 24 * time.Hour, time.Minute, now.Add(time.Minute), "future"},
+		{"far past", time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC), 24 * time.Hour, time.Minute, now.Add(-24 * time.Hour), "past"},
+		{"at the future bound", now.Add(time.Minute), 24 * time.Hour, time.Minute, now.Add(time.Minute), ""},
+		{"open future", time.Date(2099, 1, 1, 0, 0, 0, 0, time.UTC), 24 * time.Hour, 0, time.Date(2099, 1, 1, 0, 0, 0, 0, time.UTC), ""},
+		{"open past", time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC), 0, time.Minute, time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC), ""},
+		// The future window can reach into the next month
+		{"across the month boundary", time.Date(2099, 1, 1, 0, 0, 0, 0, time.UTC), 0, 2 * time.Hour, time.Date(2026, 11, 1, 1, 0, 0, 0, time.UTC), "future"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, clamped := clampTimestamp(tt.ts, now, tt.maxPast, tt.maxFuture)
+			if !got.Equal(tt.want) || clamped != tt.clamped {
+				t.Errorf("clampTimestamp = %s, %q, want %s, %q", got, clamped, tt.want, tt.clamped)
+			}
+		})
+	}
+}
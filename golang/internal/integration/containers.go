@@ -0,0 +1,110 @@
+//go:build integration
+
+// Package integration starts the services the collector depends on in
+// containers, for the tests built with the integration tag:
+//
+//	go test -tags integration ./...
+//
+// Docker must be available. Each service is started once per test binary
+// and shared by its tests; the containers are removed when the binary
+// exits.
+package integration
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	_ "github.com/lib/pq"
+	"github.com/testcontainers/testcontainers-go/modules/postgres"
+	"github.com/testcontainers/testcontainers-go/modules/rabbitmq"
+	"github.com/testcontainers/testcontainers-go/modules/redis"
+)
+
+// Images of the services, as in docker-compose.yml.
+const (
+	postgresImage = "postgres:15-alpine"
+	redisImage    = "redis:7-alpine"
+	rabbitMQImage = "rabbitmq:3.13-management-alpine"
+)
+
+// started starts a service once and returns its URL to every caller.
+type started struct {
+	once sync.Once
+	url  string
+	err  error
+}
+
+func (s *started) get(t testing.TB, name string, start func(ctx context.Context) (string, error)) string {
+	t.Helper()
+	s.once.Do(func() { s.url, s.err = start(context.Background()) })
+	if s.err != nil {
+		t.Fatalf("failed to start %s: %v", name, s.err)
+	}
+	return s.url
+}
+
+var (
+	postgresServer, redisServer, rabbitMQServer started
+	databases                                   atomic.Int64
+)
+
+// Postgres returns the URL of a new, empty database, so that tests do not
+// see each other's tables.
+func Postgres(t testing.TB) string {
+	t.Helper()
+	server := postgresServer.get(t, "postgres", func(ctx context.Context) (string, error) {
+		container, err := postgres.Run(ctx, postgresImage,
+			postgres.WithDatabase("logs"),
+			postgres.BasicWaitStrategies())
+		if err != nil {
+			return "", err
+		}
+		return container.ConnectionString(ctx, "sslmode=disable")
+	})
+
+	db, err := sql.Open("postgres", server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	name := fmt.Sprintf("test_%d", databases.Add(1))
+	if _, err := db.Exec("CREATE DATABASE " + name); err != nil {
+		t.Fatalf("failed to create database %s: %v", name, err)
+	}
+
+	u, err := url.Parse(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.Path = "/" + name
+	return u.String()
+}
+
+// Redis returns the URL of the Redis server.
+func Redis(t testing.TB) string {
+	t.Helper()
+	return redisServer.get(t, "redis", func(ctx context.Context) (string, error) {
+		container, err := redis.Run(ctx, redisImage)
+		if err != nil {
+			return "", err
+		}
+		return container.ConnectionString(ctx)
+	})
+}
+
+// RabbitMQ returns the AMQP URL of the RabbitMQ broker.
+func RabbitMQ(t testing.TB) string {
+	t.Helper()
+	return rabbitMQServer.get(t, "rabbitmq", func(ctx context.Context) (string, error) {
+		container, err := rabbitmq.Run(ctx, rabbitMQImage)
+		if err != nil {
+			return "", err
+		}
+		return container.AmqpURL(ctx)
+	})
+}
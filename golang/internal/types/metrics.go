@@ -0,0 +1,203 @@
+// Package types defines metrics event structures for the observability hub
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// MetricType represents the kind of a metric
+type MetricType string
+
+const (
+	MetricTypeCounter   MetricType = "counter"
+	MetricTypeGauge     MetricType = "gauge"
+	MetricTypeHistogram MetricType = "histogram"
+	MetricTypeSummary   MetricType = "summary"
+)
+
+// MetricsEventType represents the specific type of metrics event
+type MetricsEventType string
+
+const (
+	MetricsEventCounterCreated   MetricsEventType = "metrics.counter.created"
+	MetricsEventCounterUpdated   MetricsEventType = "metrics.counter.updated"
+	MetricsEventGaugeCreated     MetricsEventType = "metrics.gauge.created"
+	MetricsEventGaugeUpdated     MetricsEventType = "metrics.gauge.updated"
+	MetricsEventHistogramCreated MetricsEventType = "metrics.histogram.created"
+	MetricsEventHistogramUpdated MetricsEventType = "metrics.histogram.updated"
+	MetricsEventSummaryCreated   MetricsEventType = "metrics.summary.created"
+	MetricsEventSummaryUpdated   MetricsEventType = "metrics.summary.updated"
+)
+
+// HistogramBucket is a cumulative histogram bucket: Count observations were
+// at most UpperBound. The +Inf bucket is implied by the histogram's Count.
+type HistogramBucket struct {
+	UpperBound float64 `json:"upperBound" bson:"upperBound"`
+	Count      int64   `json:"count" validate:"min=0" bson:"count"`
+}
+
+// SummaryQuantile is a precomputed quantile of a summary
+type SummaryQuantile struct {
+	Quantile float64 `json:"quantile" validate:"min=0,max=1" bson:"quantile"`
+	Value    float64 `json:"value" bson:"value"`
+}
+
+// MetricsEventData contains the payload specific to metrics events. Value
+// is set for counters and gauges; Count and Sum for histograms and
+// summaries, with Buckets or Quantiles respectively.
+type MetricsEventData struct {
+	Name        string            `json:"name" validate:"required,min=1,max=255" bson:"name"`
+	Type        MetricType        `json:"type" validate:"required,oneof=counter gauge histogram summary" bson:"type"`
+	Unit        string            `json:"unit,omitempty" validate:"omitempty" bson:"unit,omitempty"`
+	Description string            `json:"description,omitempty" validate:"omitempty" bson:"description,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty" validate:"omitempty" bson:"labels,omitempty"`
+	Timestamp   time.Time         `json:"timestamp" validate:"required" bson:"timestamp"`
+	Value       *float64          `json:"value,omitempty" validate:"omitempty" bson:"value,omitempty"`
+	Count       *int64            `json:"count,omitempty" validate:"omitempty,min=0" bson:"count,omitempty"`
+	Sum         *float64          `json:"sum,omitempty" validate:"omitempty" bson:"sum,omitempty"`
+	Buckets     []HistogramBucket `json:"buckets,omitempty" validate:"omitempty,dive" bson:"buckets,omitempty"`
+	Quantiles   []SummaryQuantile `json:"quantiles,omitempty" validate:"omitempty,dive" bson:"quantiles,omitempty"`
+}
+
+// MetricsEvent represents a complete metrics event
+type MetricsEvent struct {
+	BaseEvent `bson:",inline"`
+	Data      MetricsEventData `json:"data" validate:"required" bson:"data"`
+}
+
+// MarshalJSON implements custom JSON marshaling for MetricsEvent. Without
+// it the promoted BaseEvent method would leave out the data.
+func (e *MetricsEvent) MarshalJSON() ([]byte, error) {
+	type Alias BaseEvent
+	return json.Marshal(&struct {
+		Timestamp string `json:"timestamp"`
+		*Alias
+		Data MetricsEventData `json:"data"`
+	}{
+		Timestamp: e.Timestamp.Format(time.RFC3339Nano),
+		Alias:     (*Alias)(&e.BaseEvent),
+		Data:      e.Data,
+	})
+}
+
+// UnmarshalJSON implements custom JSON unmarshaling for MetricsEvent
+func (e *MetricsEvent) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &e.BaseEvent); err != nil {
+		return err
+	}
+	aux := &struct {
+		Data *MetricsEventData `json:"data"`
+	}{
+		Data: &e.Data,
+	}
+	return json.Unmarshal(data, aux)
+}
+
+// IsMetricsEvent checks if the base event is a metrics event
+func IsMetricsEvent(event *BaseEvent) bool {
+	return isMetricsEvent(event.EventType)
+}
+
+// IsMetricsEventType checks if an event type names a metrics event
+func IsMetricsEventType(eventType string) bool {
+	return isMetricsEvent(eventType)
+}
+
+// GetMetricType returns the metric type named by a metrics event type, e.g.
+// "gauge" for "metrics.gauge.updated", or "" for other event types
+func GetMetricType(eventType string) MetricType {
+	if !isMetricsEvent(eventType) {
+		return ""
+	}
+	metricType, _, _ := strings.Cut(eventType[len("metrics."):], ".")
+	return MetricType(metricType)
+}
+
+// CheckValues verifies what the validate tags cannot: that the data type
+// matches the event type and carries the values of its kind. Counters need
+// a finite non-negative value and gauges a finite one; histograms need a
+// count and sum with ascending bucket bounds and cumulative counts, and
+// summaries a count and sum with ascending quantiles.
+func (e *MetricsEvent) CheckValues() error {
+	d := &e.Data
+	if eventMetricType := GetMetricType(e.EventType); eventMetricType != d.Type {
+		return fmt.Errorf("data type %q does not match event type %q", d.Type, e.EventType)
+	}
+
+	switch d.Type {
+	case MetricTypeCounter, MetricTypeGauge:
+		if d.Value == nil {
+			return fmt.Errorf("%s %s has no value", d.Type, d.Name)
+		}
+		if math.IsNaN(*d.Value) || math.IsInf(*d.Value, 0) {
+			return fmt.Errorf("%s %s value %v is not finite", d.Type, d.Name, *d.Value)
+		}
+		if d.Type == MetricTypeCounter && *d.Value < 0 {
+			return fmt.Errorf("counter %s value %v is negative", d.Name, *d.Value)
+		}
+	case MetricTypeHistogram:
+		if err := d.checkCountAndSum(); err != nil {
+			return err
+		}
+		for i, bucket := range d.Buckets {
+			if i > 0 && bucket.UpperBound <= d.Buckets[i-1].UpperBound {
+				return fmt.Errorf("histogram %s bucket bounds are not ascending", d.Name)
+			}
+			if i > 0 && bucket.Count < d.Buckets[i-1].Count {
+				return fmt.Errorf("histogram %s bucket counts are not cumulative", d.Name)
+			}
+		}
+		if n := len(d.Buckets); n > 0 && d.Buckets[n-1].Count > *d.Count {
+			return fmt.Errorf("histogram %s bucket counts exceed the count %d", d.Name, *d.Count)
+		}
+	case MetricTypeSummary:
+		if err := d.checkCountAndSum(); err != nil {
+			return err
+		}
+		for i, quantile := range d.Quantiles {
+			if i > 0 && quantile.Quantile <= d.Quantiles[i-1].Quantile {
+				return fmt.Errorf("summary %s quantiles are not ascending", d.Name)
+			}
+		}
+	}
+	return nil
+}
+
+func (d *MetricsEventData) checkCountAndSum() error {
+	if d.Count == nil || d.Sum == nil {
+		return fmt.Errorf("%s %s needs a count and a sum", d.Type, d.Name)
+	}
+	if math.IsNaN(*d.Sum) || math.IsInf(*d.Sum, 0) {
+		return fmt.Errorf("%s %s sum %v is not finite", d.Type, d.Name, *d.Sum)
+	}
+	return nil
+}
+
+// NewMetricsEvent creates a new metrics event with the required fields.
+// The caller sets the values of the metric type.
+func NewMetricsEvent(metricType MetricType, name string, correlationID string, source EventSource) *MetricsEvent {
+	eventType := "metrics." + string(metricType) + ".created"
+	baseEvent := NewBaseEvent(eventType, correlationID, source)
+	baseEvent.Version = GetSchemaVersion("metrics-event")
+
+	return &MetricsEvent{
+		BaseEvent: *baseEvent,
+		Data: MetricsEventData{
+			Name:      name,
+			Type:      metricType,
+			Timestamp: time.Now().UTC(),
+		},
+	}
+}
+
+// SetLabel sets a label of the metric
+func (e *MetricsEvent) SetLabel(name, value string) {
+	if e.Data.Labels == nil {
+		e.Data.Labels = make(map[string]string)
+	}
+	e.Data.Labels[name] = value
+}
@@ -0,0 +1,167 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// Built-in custom validators required by the validate tags of the event
+// types. They are registered automatically before the first validation:
+//
+//   - semver:     semantic version, e.g. "1.2.3" or "1.2.3-rc.1+build.5";
+//     any non-empty version in relaxed version mode
+//   - trace_id:   W3C trace ID, 32 lowercase hex characters, not all zero
+//   - span_id:    W3C span ID, 16 lowercase hex characters, not all zero
+//   - event_type: one of the patterns in DefaultEventTypePatterns
+var (
+	semverPattern     = regexp.MustCompile(`^(0|[1-9]\d*)\.(0|[1-9]\d*)\.(0|[1-9]\d*)(?:-[0-9A-Za-z.-]+)?(?:\+[0-9A-Za-z.-]+)?$`)
+	traceIDPattern    = regexp.MustCompile(`^[0-9a-f]{32}$`)
+	spanIDPattern     = regexp.MustCompile(`^[0-9a-f]{16}$`)
+	eventTypePatterns = []*regexp.Regexp{
+		regexp.MustCompile(DefaultEventTypePatterns.Log),
+		regexp.MustCompile(DefaultEventTypePatterns.Metrics),
+		regexp.MustCompile(DefaultEventTypePatterns.Trace),
+	}
+)
+
+// Version modes for the semver validator. Relaxed mode accepts versions
+// such as git SHAs or "latest" from producers that do not follow semver.
+const (
+	VersionModeStrict  = "strict"
+	VersionModeRelaxed = "relaxed"
+)
+
+var (
+	validate        *validator.Validate
+	validateOnce    sync.Once
+	relaxedVersions atomic.Bool
+	// registered holds the names of the built-in and custom validators
+	registered   = make(map[string]bool)
+	registeredMu sync.Mutex
+)
+
+// getValidator returns the shared validator with the built-in validators registered.
+func getValidator() *validator.Validate {
+	validateOnce.Do(func() {
+		validate = validator.New()
+		// Report fields by their JSON names, as producers know them
+		validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+			name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "-" {
+				return ""
+			}
+			return name
+		})
+		validate.RegisterValidation("semver", func(fl validator.FieldLevel) bool {
+			version := fl.Field().String()
+			if relaxedVersions.Load() {
+				return version != ""
+			}
+			return semverPattern.MatchString(version)
+		})
+		validate.RegisterValidation("trace_id", hexID(traceIDPattern))
+		validate.RegisterValidation("span_id", hexID(spanIDPattern))
+		validate.RegisterValidation("event_type", func(fl validator.FieldLevel) bool {
+			for _, re := range eventTypePatterns {
+				if re.MatchString(fl.Field().String()) {
+					return true
+				}
+			}
+			return false
+		})
+		for _, name := range []string{"semver", "trace_id", "span_id", "event_type"} {
+			registered[name] = true
+		}
+	})
+	return validate
+}
+
+// RegisterValidator adds a custom validator usable as a validate tag, e.g.
+// an organization-specific service name format. A name can be registered
+// once: the validator caches the checks of a type when first validating
+// it, so a replacement would not apply to every type alike. Validators
+// must be registered during initialization, before events are validated
+// concurrently.
+func RegisterValidator(name string, fn validator.Func) error {
+	v := getValidator()
+	registeredMu.Lock()
+	defer registeredMu.Unlock()
+	if registered[name] {
+		return fmt.Errorf("validator %q is already registered", name)
+	}
+	if err := v.RegisterValidation(name, fn); err != nil {
+		return err
+	}
+	registered[name] = true
+	return nil
+}
+
+// SetVersionMode selects how the semver validator treats versions.
+func SetVersionMode(mode string) error {
+	switch mode {
+	case VersionModeStrict:
+		relaxedVersions.Store(false)
+	case VersionModeRelaxed:
+		relaxedVersions.Store(true)
+	default:
+		return fmt.Errorf("unknown version mode %q", mode)
+	}
+	return nil
+}
+
+// IsSemver reports whether version is a semantic version, regardless of the
+// version mode.
+func IsSemver(version string) bool {
+	return semverPattern.MatchString(version)
+}
+
+// Validate checks an event against its validate tags.
+func Validate(event interface{}) error {
+	return getValidator().Struct(event)
+}
+
+// ValidateEvent checks the envelope of an event against its validate tags
+// and reports every violation by the field's JSON path, e.g.
+// "source.version", and the failed tag.
+func ValidateEvent(event *BaseEvent) ValidationResult {
+	err := Validate(event)
+	if err == nil {
+		return ValidationResult{Valid: true}
+	}
+
+	var fieldErrs validator.ValidationErrors
+	if !errors.As(err, &fieldErrs) {
+		return ValidationResult{Errors: []ValidationError{{Message: err.Error()}}}
+	}
+	result := ValidationResult{Errors: make([]ValidationError, 0, len(fieldErrs))}
+	for _, fe := range fieldErrs {
+		// The namespace starts with the struct name
+		_, field, _ := strings.Cut(fe.Namespace(), ".")
+		message := fmt.Sprintf("failed the %s check", fe.Tag())
+		if fe.Param() != "" {
+			message = fmt.Sprintf("failed the %s=%s check", fe.Tag(), fe.Param())
+		}
+		result.Errors = append(result.Errors, ValidationError{
+			Field:   field,
+			Message: message,
+			Value:   fe.Value(),
+			Code:    fe.Tag(),
+		})
+	}
+	return result
+}
+
+// hexID matches W3C trace context IDs, which must not be all zeros.
+func hexID(re *regexp.Regexp) validator.Func {
+	return func(fl validator.FieldLevel) bool {
+		id := fl.Field().String()
+		return re.MatchString(id) && strings.Trim(id, "0") != ""
+	}
+}
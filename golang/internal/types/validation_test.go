@@ -0,0 +1,101 @@
+package types
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// deployment is an organization-specific type using a custom validator.
+type deployment struct {
+	Service string `json:"service" validate:"required,service_name"`
+	Version string `json:"version" validate:"semver"`
+}
+
+// registerServiceName registers the service_name validator once per test
+// binary, as a name can only be registered once.
+var registerServiceName = sync.OnceValue(func() error {
+	serviceName := regexp.MustCompile(`^[a-z][a-z0-9-]*-svc$`)
+	return RegisterValidator("service_name", func(fl validator.FieldLevel) bool {
+		return serviceName.MatchString(fl.Field().String())
+	})
+})
+
+func TestRegisterValidator(t *testing.T) {
+	if err := registerServiceName(); err != nil {
+		t.Fatalf("RegisterValidator: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		value deployment
+		field string
+	}{
+		{"valid", deployment{Service: "checkout-svc", Version: "1.2.3"}, ""},
+		{"custom check fails", deployment{Service: "Checkout", Version: "1.2.3"}, "service_name"},
+		{"built-in check still applies", deployment{Service: "checkout-svc", Version: "latest"}, "semver"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(tt.value)
+			if tt.field == "" {
+				if err != nil {
+					t.Errorf("Validate: %v", err)
+				}
+				return
+			}
+			var fieldErrs validator.ValidationErrors
+			if !errors.As(err, &fieldErrs) || len(fieldErrs) != 1 || fieldErrs[0].Tag() != tt.field {
+				t.Errorf("Validate error = %v, want the %s check to fail", err, tt.field)
+			}
+		})
+	}
+}
+
+func TestRegisterValidatorRejectsRegisteredNames(t *testing.T) {
+	if err := registerServiceName(); err != nil {
+		t.Fatal(err)
+	}
+	accept := func(fl validator.FieldLevel) bool { return true }
+	for _, name := range []string{"service_name", "semver", "trace_id", "span_id", "event_type"} {
+		if err := RegisterValidator(name, accept); err == nil {
+			t.Errorf("RegisterValidator replaced the %s validator", name)
+		}
+	}
+	// The built-in one still applies
+	if err := Validate(deployment{Service: "checkout-svc", Version: "latest"}); err == nil {
+		t.Error("semver check passed a non-semver version")
+	}
+}
+
+func TestRegisterValidatorRejectsEmptyName(t *testing.T) {
+	if err := RegisterValidator("", func(fl validator.FieldLevel) bool { return true }); err == nil {
+		t.Error("RegisterValidator accepted an empty name")
+	}
+}
+
+func TestValidateEventBuiltInValidators(t *testing.T) {
+	event := NewBaseEvent("log.message.created", "0b7e1d0e-9c47-4a43-8a57-2b0c7c9f7f21", EventSource{Service: "checkout", Version: "1.2.3"})
+	event.EventID = "8d0c2cf4-5a2f-4f43-9a8b-3f0a3a7b2f10"
+	if result := ValidateEvent(event); !result.Valid {
+		t.Fatalf("ValidateEvent: %v", result.Errors)
+	}
+
+	event.EventType = "audit.entry.created"
+	event.Source.Version = "v1"
+	event.SetTracing(strings.Repeat("0", 32), "00f067aa0ba902b7")
+	result := ValidateEvent(event)
+	failed := make(map[string]string)
+	for _, e := range result.Errors {
+		failed[e.Field] = e.Code
+	}
+	for field, code := range map[string]string{"eventType": "event_type", "source.version": "semver", "tracing.traceId": "trace_id"} {
+		if failed[field] != code {
+			t.Errorf("%s failed %q, want %q (errors %v)", field, failed[field], code, result.Errors)
+		}
+	}
+}